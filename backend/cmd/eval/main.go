@@ -0,0 +1,173 @@
+// Command eval runs a golden question set through the chat pipeline and
+// reports retrieval and answer metrics. By default it runs offline, with the
+// fake model over a local corpus and an optional catalog snapshot:
+//
+//	go run ./cmd/eval -golden eval/golden.yaml -corpus eval/corpus.jsonl -out report.json
+//	go run ./cmd/eval -golden eval/golden.yaml -corpus eval/corpus.jsonl -baseline report.json
+//
+// With -model azure the answers and judgements come from the configured Azure
+// OpenAI deployment. Prompts are read from PROMPT_REGISTRY_PATH when set, so
+// that a candidate prompt version can be compared with the active one.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/eval"
+	"github.com/One-Frequency/MusicRAG/backend/internal/graph"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	golden := flag.String("golden", "", "golden set, .yaml or .jsonl (required)")
+	corpus := flag.String("corpus", "", "JSONL chunks to search in place of the Azure index")
+	snapshot := flag.String("catalog", "", "catalog snapshot to search and link entities against")
+	k := flag.Int("k", eval.DefaultK, "chunks retrieved and scored per question")
+	model := flag.String("model", "fake", "model answering and judging: fake or azure")
+	format := flag.String("format", "json", "report format: json or text")
+	out := flag.String("out", "", "file to write the report to instead of stdout")
+	baseline := flag.String("baseline", "", "JSON report to compare the run with")
+	flag.Parse()
+	if *golden == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cases, err := eval.LoadCases(*golden)
+	if err != nil {
+		log.Fatalf("Failed to load golden set: %v", err)
+	}
+	pipeline, judge, err := newPipeline(*model, *corpus, *snapshot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runner := &eval.Runner{Pipeline: pipeline, Judge: judge, K: *k, Model: *model}
+	report := runner.Run(context.Background(), cases)
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	case "text":
+		err = report.WriteText(w)
+	default:
+		log.Fatalf("Unknown report format %q", *format)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+
+	if *baseline != "" {
+		previous, err := readReport(*baseline)
+		if err != nil {
+			log.Fatalf("Failed to read baseline: %v", err)
+		}
+		// The comparison goes to stdout, or stderr when stdout holds the report
+		diff := io.Writer(os.Stdout)
+		if *out == "" {
+			diff = os.Stderr
+		}
+		if err := report.WriteDiff(diff, previous); err != nil {
+			log.Fatalf("Failed to write comparison: %v", err)
+		}
+	}
+}
+
+// newPipeline builds the chat pipeline over the local corpus and catalog,
+// answering with the chosen model, and a judge using the same model
+func newPipeline(model, corpus, snapshot string) (*rag.Pipeline, *eval.Judge, error) {
+	var chat, jsonChat rag.ChatFunc
+	var verifier *rag.Verifier
+	switch model {
+	case "fake":
+		chat, jsonChat = eval.FakeModel, eval.FakeModel
+		verifier = &rag.Verifier{Threshold: 0.5}
+	case "azure":
+		if err := godotenv.Load(); err != nil {
+			log.Println("No .env file found")
+		}
+		azure.Init()
+		chat, jsonChat = azure.Chat, azure.ChatJSON
+		verifier = rag.NewVerifierFromEnv(azure.Chat)
+	default:
+		return nil, nil, fmt.Errorf("unknown model %q, want fake or azure", model)
+	}
+	prompt.Init()
+
+	store := catalog.NewMemoryStore()
+	if snapshot != "" {
+		f, err := os.Open(snapshot)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open catalog snapshot: %w", err)
+		}
+		defer f.Close()
+		if err := store.Load(f); err != nil {
+			return nil, nil, err
+		}
+	}
+	catalogIndex := retrieval.NewLocalIndex("catalog")
+	entities, _ := store.ListEntities(catalog.ListFilter{})
+	for _, e := range entities {
+		catalogIndex.Add(retrieval.EntityChunk(e))
+	}
+	retrievers := []retrieval.Retriever{catalogIndex}
+	if corpus != "" {
+		// The corpus stands in for the Azure index, so it keeps its collection name
+		index, err := eval.LoadCorpus(corpus, "azure")
+		if err != nil {
+			return nil, nil, err
+		}
+		retrievers = append([]retrieval.Retriever{index}, retrievers...)
+	}
+
+	pipeline := &rag.Pipeline{
+		Retriever:        &retrieval.EntityRetriever{Retriever: retrieval.NewFusion(retrievers...), Store: store},
+		CatalogRetriever: &retrieval.EntityRetriever{Retriever: catalogIndex, Store: store},
+		Rewriter:         &rag.Rewriter{Chat: chat},
+		// Routing by rules alone keeps the run deterministic
+		Router:   &rag.Router{},
+		Linker:   linking.New(store),
+		Graph:    graph.New(store),
+		Store:    store,
+		Chat:     chat,
+		JSONChat: jsonChat,
+		Verifier: verifier,
+		Prompts:  prompt.RegistryInstance,
+	}
+	return pipeline, &eval.Judge{Chat: chat}, nil
+}
+
+// readReport reads a report written with -format json
+func readReport(path string) (*eval.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report eval.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
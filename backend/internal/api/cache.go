@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/gin-gonic/gin"
+)
+
+// CacheStatsHandler returns the size, settings and hit rate of the answer
+// cache
+func CacheStatsHandler(c *gin.Context) {
+	cache := rag.PipelineInstance.Cache
+	if cache == nil {
+		c.JSON(http.StatusOK, rag.CacheStats{})
+		return
+	}
+	c.JSON(http.StatusOK, cache.Stats())
+}
+
+// InvalidateCacheHandler drops the cached answers grounded on re-indexed
+// documents, so that the next such question is answered from the new
+// content
+func InvalidateCacheHandler(c *gin.Context) {
+	var req InvalidateCacheRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cache := rag.PipelineInstance.Cache
+	if cache == nil {
+		c.JSON(http.StatusOK, InvalidateCacheResponse{})
+		return
+	}
+	var n int
+	if req.Collection == "" {
+		n = cache.Clear()
+	} else {
+		n = cache.InvalidateDocuments(req.Collection, req.DocumentIDs)
+	}
+	c.JSON(http.StatusOK, InvalidateCacheResponse{Invalidated: n})
+}
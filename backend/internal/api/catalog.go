@@ -0,0 +1,222 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+	"github.com/gin-gonic/gin"
+)
+
+// catalogError maps catalog errors to HTTP responses
+func catalogError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, catalog.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, catalog.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, catalog.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// persistCatalog snapshots the catalog after a mutation. Failures are logged
+// rather than returned since the in-memory change has already been applied.
+func persistCatalog() {
+	if err := catalog.Persist(); err != nil {
+		log.Printf("Failed to persist catalog: %v", err)
+	}
+}
+
+// queryInt reads a non-negative integer query parameter
+func queryInt(c *gin.Context, key string, def int) int {
+	v, err := strconv.Atoi(c.Query(key))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+func queryFloat(c *gin.Context, key string) float64 {
+	v, err := strconv.ParseFloat(c.Query(key), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+// ListEntitiesHandler lists catalog entities by type and name. The minBpm,
+// maxBpm, key and minRating parameters narrow the listing to recordings with
+// those DJ attributes, e.g. ?minBpm=124&maxBpm=128&key=8A&minRating=4.
+func ListEntitiesHandler(c *gin.Context) {
+	filter := catalog.ListFilter{
+		Type:      catalog.EntityType(c.Query("type")),
+		Query:     c.Query("q"),
+		MinBPM:    queryFloat(c, "minBpm"),
+		MaxBPM:    queryFloat(c, "maxBpm"),
+		Key:       c.Query("key"),
+		MinRating: queryInt(c, "minRating", 0),
+		Limit:     min(queryInt(c, "limit", 50), 500),
+		Offset:    queryInt(c, "offset", 0),
+	}
+	if filter.Key != "" {
+		if _, err := catalog.ParseKey(filter.Key); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	entities, total := catalog.StoreInstance.ListEntities(filter)
+	c.JSON(http.StatusOK, EntityListResponse{
+		Entities: entities,
+		Total:    total,
+		Limit:    filter.Limit,
+		Offset:   filter.Offset,
+	})
+}
+
+func GetEntityHandler(c *gin.Context) {
+	entity, err := catalog.StoreInstance.GetEntity(c.Param("id"))
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, EntityResponse{
+		Entity:    entity,
+		Relations: catalog.StoreInstance.ListRelations(entity.ID),
+	})
+}
+
+func CreateEntityHandler(c *gin.Context) {
+	var entity catalog.Entity
+	if err := c.ShouldBindJSON(&entity); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entity.ID = ""
+
+	created, err := catalog.StoreInstance.CreateEntity(&entity)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	persistCatalog()
+	c.JSON(http.StatusCreated, created)
+}
+
+func UpdateEntityHandler(c *gin.Context) {
+	var entity catalog.Entity
+	if err := c.ShouldBindJSON(&entity); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entity.ID = c.Param("id")
+
+	updated, err := catalog.StoreInstance.UpdateEntity(&entity)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	persistCatalog()
+	c.JSON(http.StatusOK, updated)
+}
+
+func DeleteEntityHandler(c *gin.Context) {
+	if err := catalog.StoreInstance.DeleteEntity(c.Param("id")); err != nil {
+		catalogError(c, err)
+		return
+	}
+	persistCatalog()
+	c.Status(http.StatusNoContent)
+}
+
+// LookupEntityHandler resolves an industry identifier, e.g. ?scheme=isrc&value=USRC17607839
+func LookupEntityHandler(c *gin.Context) {
+	entity, err := catalog.StoreInstance.FindByIdentifier(c.Query("scheme"), c.Query("value"))
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entity)
+}
+
+func ListRelationsHandler(c *gin.Context) {
+	if _, err := catalog.StoreInstance.GetEntity(c.Param("id")); err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"relations": catalog.StoreInstance.ListRelations(c.Param("id"))})
+}
+
+func CreateRelationHandler(c *gin.Context) {
+	var relation catalog.Relation
+	if err := c.ShouldBindJSON(&relation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	relation.ID = ""
+
+	created, err := catalog.StoreInstance.CreateRelation(&relation)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	persistCatalog()
+	c.JSON(http.StatusCreated, created)
+}
+
+func DeleteRelationHandler(c *gin.Context) {
+	if err := catalog.StoreInstance.DeleteRelation(c.Param("id")); err != nil {
+		catalogError(c, err)
+		return
+	}
+	persistCatalog()
+	c.Status(http.StatusNoContent)
+}
+
+func GetDocumentLinksHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"documentId": c.Param("documentId"),
+		"entityIds":  catalog.StoreInstance.DocumentEntities(c.Param("documentId")),
+	})
+}
+
+func LinkDocumentHandler(c *gin.Context) {
+	var req LinkDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := catalog.StoreInstance.LinkDocument(c.Param("documentId"), req.EntityIDs); err != nil {
+		catalogError(c, err)
+		return
+	}
+	persistCatalog()
+	c.JSON(http.StatusOK, gin.H{
+		"documentId": c.Param("documentId"),
+		"entityIds":  catalog.StoreInstance.DocumentEntities(c.Param("documentId")),
+	})
+}
+
+func ListEntityDocumentsHandler(c *gin.Context) {
+	if _, err := catalog.StoreInstance.GetEntity(c.Param("id")); err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"documentIds": catalog.StoreInstance.EntityDocuments(c.Param("id"))})
+}
+
+// LinkQueryHandler returns the catalog entities mentioned in free text
+func LinkQueryHandler(c *gin.Context) {
+	q := c.Query("q")
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"mentions": linking.LinkerInstance.Link(q)})
+}
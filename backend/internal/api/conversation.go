@@ -0,0 +1,453 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/gin-gonic/gin"
+)
+
+// indexTimeout bounds the embedding of a stored exchange for search
+const indexTimeout = 30 * time.Second
+
+func conversationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, conversation.ErrNotFound), errors.Is(err, conversation.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, conversation.ErrInvalid), errors.Is(err, conversation.ErrInvalidSearch),
+		errors.Is(err, conversation.ErrUnsupportedFormat), errors.Is(err, conversation.ErrInvalidFeedback):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, conversation.ErrUnavailable), errors.Is(err, conversation.ErrNoEmbeddings):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// conversationStore returns the conversation store, or responds with 503
+// and returns nil when conversations are disabled
+func conversationStore(c *gin.Context) conversation.Store {
+	if conversation.StoreInstance == nil {
+		conversationError(c, conversation.ErrUnavailable)
+		return nil
+	}
+	return conversation.StoreInstance
+}
+
+// CreateConversationHandler starts a conversation for the current user. The
+// title is optional; without one it is generated from the first exchange.
+// The profile, if any, must be one the user may use.
+func CreateConversationHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	var req CreateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := useProfile(c, req.ProfileID); !ok {
+		return
+	}
+	created, err := store.Create(c, &conversation.Conversation{OwnerID: userID, Title: req.Title, ProfileID: req.ProfileID})
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListConversationsHandler returns a page of the current user's
+// conversations, most recently active first. ?archived=true lists the
+// archived ones instead.
+func ListConversationsHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	opts := conversation.ListOptions{
+		Archived: c.Query("archived") == "true",
+		Limit:    min(queryInt(c, "limit", conversation.DefaultLimit), conversation.MaxLimit),
+		Offset:   queryInt(c, "offset", 0),
+	}
+	conversations, total, err := store.List(c, userID, opts)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ConversationListResponse{Conversations: conversations, Total: total, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// GetConversationHandler returns one of the current user's conversations with
+// the messages of its active branch
+func GetConversationHandler(c *gin.Context) {
+	conv, messages, ok := loadTree(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, conversationResponse(conv, messages))
+}
+
+// UpdateConversationHandler renames, archives, unarchives or changes the
+// assistant profile of one of the current user's conversations
+func UpdateConversationHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	var req conversation.Update
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ProfileID != nil {
+		if _, ok := useProfile(c, *req.ProfileID); !ok {
+			return
+		}
+	}
+	updated, err := store.Update(c, userID, c.Param("id"), req)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteConversationHandler deletes one of the current user's conversations
+// with its messages
+func DeleteConversationHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	if err := store.Delete(c, userID, c.Param("id")); err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ExportConversationHandler downloads the active branch of one of the current
+// user's conversations with its citations and sources, as Markdown
+// (?format=md, the default), JSON or PDF
+func ExportConversationHandler(c *gin.Context) {
+	conv, messages, ok := loadTree(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	data, ext, contentType, err := conversation.Export(c.DefaultQuery("format", conversation.FormatMarkdown), conv, conversation.ActiveBranch(conv, messages), time.Now())
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	sendFile(c, conv.Title, "conversation", ext, contentType, data)
+}
+
+// SearchConversationsHandler searches the messages of the current user's
+// conversations, e.g. ?q=modal+interchange&mode=hybrid&from=2024-05-01.
+// mode is keyword (the default), semantic or hybrid; conversationId, which
+// may be repeated, and from and to, to being exclusive, narrow the search.
+func SearchConversationsHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	if conversationStore(c) == nil {
+		return
+	}
+	q := conversation.SearchQuery{
+		Text:            c.Query("q"),
+		Mode:            c.Query("mode"),
+		ConversationIDs: c.QueryArray("conversationId"),
+		Limit:           queryInt(c, "limit", conversation.DefaultSearchLimit),
+	}
+	var err error
+	if q.From, err = queryDate(c, "from"); err != nil {
+		conversationError(c, fmt.Errorf("%w: %v", conversation.ErrInvalidSearch, err))
+		return
+	}
+	if q.To, err = queryDate(c, "to"); err != nil {
+		conversationError(c, fmt.Errorf("%w: %v", conversation.ErrInvalidSearch, err))
+		return
+	}
+	hits, err := conversation.SearcherInstance.Search(c, userID, q)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ConversationSearchResponse{Hits: hits})
+}
+
+// SwitchBranchHandler makes the branch through a message of one of the
+// current user's conversations the active one, e.g. to show an earlier
+// version of an edited question. It returns the conversation with the
+// messages of that branch.
+func SwitchBranchHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	var req SwitchBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := store.SetActive(c, userID, c.Param("id"), req.MessageID); err != nil {
+		conversationError(c, err)
+		return
+	}
+	conv, messages, ok := loadTree(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, conversationResponse(conv, messages))
+}
+
+// EditMessageHandler asks a question of one of the current user's
+// conversations again with new text. The edited question and its answer
+// start a branch beside the original, which is kept, and become the active
+// branch.
+func EditMessageHandler(c *gin.Context) {
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	conv, messages, ok := loadTree(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	edited := findMessage(messages, c.Param("messageId"))
+	if edited == nil {
+		conversationError(c, conversation.ErrMessageNotFound)
+		return
+	}
+	if edited.Role != conversation.RoleUser {
+		conversationError(c, fmt.Errorf("%w: only questions can be edited; regenerate answers instead", conversation.ErrInvalid))
+		return
+	}
+	p, ok := conversationProfile(c, conv)
+	if !ok {
+		return
+	}
+	request := withProfile(req.request(req.Content, conversation.Branch(messages, edited.ParentID)), p)
+	result, latency, ok := answer(c, request)
+	if !ok {
+		return
+	}
+	question := &conversation.Message{Role: conversation.RoleUser, Content: req.Content}
+	response := ragResponse(result, req.Debug)
+	response.Conversation, response.MessageID = saveMessages(c, conv, &edited.ParentID, question, answerMessage(request, result, latency))
+	logExposure(c, result, latency, conv, response.MessageID)
+	c.JSON(http.StatusOK, response)
+}
+
+// RegenerateMessageHandler answers the question of an answer in one of the
+// current user's conversations again, optionally with other retrieval or
+// model settings. The new answer is added beside the original, which is
+// kept, and becomes the active branch.
+func RegenerateMessageHandler(c *gin.Context) {
+	var req AnswerSettings
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	conv, messages, ok := loadTree(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	previous := findMessage(messages, c.Param("messageId"))
+	if previous == nil {
+		conversationError(c, conversation.ErrMessageNotFound)
+		return
+	}
+	question := findMessage(messages, previous.ParentID)
+	if previous.Role != conversation.RoleAssistant || question == nil {
+		conversationError(c, fmt.Errorf("%w: only answers can be regenerated; edit questions instead", conversation.ErrInvalid))
+		return
+	}
+	p, ok := conversationProfile(c, conv)
+	if !ok {
+		return
+	}
+	request := withProfile(req.request(question.Content, conversation.Branch(messages, question.ParentID)), p)
+	result, latency, ok := answer(c, request)
+	if !ok {
+		return
+	}
+	response := ragResponse(result, req.Debug)
+	response.Conversation, response.MessageID = saveMessages(c, conv, &question.ID, answerMessage(request, result, latency))
+	logExposure(c, result, latency, conv, response.MessageID)
+	c.JSON(http.StatusOK, response)
+}
+
+// loadTree returns one of the current user's conversations and all its
+// messages, or responds with an error and reports false
+func loadTree(c *gin.Context, id string) (*conversation.Conversation, []*conversation.Message, bool) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return nil, nil, false
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return nil, nil, false
+	}
+	conv, err := store.Get(c, userID, id)
+	if err != nil {
+		conversationError(c, err)
+		return nil, nil, false
+	}
+	messages, err := store.Messages(c, userID, id)
+	if err != nil {
+		conversationError(c, err)
+		return nil, nil, false
+	}
+	return conv, messages, true
+}
+
+// loadConversation returns one of the current user's conversations and its
+// active branch as chat history, or responds with an error and reports false
+func loadConversation(c *gin.Context, id string) (*conversation.Conversation, []rag.Turn, bool) {
+	conv, messages, ok := loadTree(c, id)
+	if !ok {
+		return nil, nil, false
+	}
+	return conv, turns(conversation.ActiveBranch(conv, messages)), true
+}
+
+// conversationResponse shows a conversation with its active branch and the
+// versions of its edited and regenerated messages
+func conversationResponse(conv *conversation.Conversation, messages []*conversation.Message) ConversationResponse {
+	branch := conversation.ActiveBranch(conv, messages)
+	return ConversationResponse{Conversation: conv, Messages: branch, Alternatives: conversation.Alternatives(messages, branch)}
+}
+
+// turns converts messages to chat history
+func turns(messages []*conversation.Message) []rag.Turn {
+	history := make([]rag.Turn, 0, len(messages))
+	for _, m := range messages {
+		history = append(history, rag.Turn{Role: m.Role, Content: m.Content})
+	}
+	return history
+}
+
+// request is the pipeline request asking query with the settings after the
+// messages of a branch
+func (s AnswerSettings) request(query string, branch []*conversation.Message) rag.Request {
+	return rag.Request{
+		Query:      query,
+		History:    turns(branch),
+		Expand:     s.Expand,
+		Schema:     s.Schema,
+		OutputType: s.OutputType,
+		Top:        s.Top,
+		Model:      s.Model,
+		Locale:     s.Locale,
+	}
+}
+
+func findMessage(messages []*conversation.Message, id string) *conversation.Message {
+	for _, m := range messages {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// answerMessage is the stored form of the answer to a request
+func answerMessage(req rag.Request, result *rag.Result, latency time.Duration) *conversation.Message {
+	metadata := &conversation.Metadata{
+		Model:           result.Model,
+		Route:           &result.Route,
+		StandaloneQuery: result.Debug.StandaloneQuery,
+		LatencyMs:       latency.Milliseconds(),
+		Top:             req.Top,
+		Expand:          req.Expand,
+		Prompts:         result.Prompts,
+		Profile:         result.Profile,
+		Experiment:      result.Experiment,
+		Variant:         result.Variant,
+		Cached:          result.Cache != nil,
+	}
+	if result.Grounding != nil {
+		score := result.Grounding.Score
+		metadata.Groundedness = &score
+	}
+	return &conversation.Message{
+		Role:      conversation.RoleAssistant,
+		Content:   result.Content,
+		Sources:   result.Sources,
+		Citations: result.Citations,
+		Metadata:  metadata,
+	}
+}
+
+// saveMessages stores messages in the conversation, after the parent message
+// when parentID is set and else on the active branch, and titles the
+// conversation after its first exchange. It returns the conversation and the
+// ID of the last message stored. Failures are logged rather than failing the
+// request, whose answer is already complete.
+func saveMessages(c *gin.Context, conv *conversation.Conversation, parentID *string, messages ...*conversation.Message) (*conversation.Conversation, string) {
+	store := conversation.StoreInstance
+	var err error
+	if parentID != nil {
+		err = store.AddBranch(c, conv.OwnerID, conv.ID, *parentID, messages...)
+	} else {
+		err = store.AddMessages(c, conv.OwnerID, conv.ID, messages...)
+	}
+	if err != nil {
+		log.Printf("Failed to save messages in conversation %s: %v", conv.ID, err)
+		return conv, ""
+	}
+	last := messages[len(messages)-1]
+	conv.UpdatedAt, conv.ActiveMessageID = last.CreatedAt, last.ID
+
+	// Embedding the messages for search is not worth delaying the answer for
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+		defer cancel()
+		if err := conversation.SearcherInstance.Index(ctx, messages...); err != nil {
+			log.Printf("Failed to index messages of conversation %s for search: %v", conv.ID, err)
+		}
+	}()
+
+	if conv.Title == "" && len(messages) > 1 {
+		title := conversation.GenerateTitle(c, rag.PipelineInstance.Chat, messages[0].Content, last.Content)
+		updated, err := store.Update(c, conv.OwnerID, conv.ID, conversation.Update{Title: &title})
+		if err != nil {
+			log.Printf("Failed to title conversation %s: %v", conv.ID, err)
+		} else {
+			conv = updated
+		}
+	}
+	return conv, last.ID
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/auth"
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/One-Frequency/MusicRAG/backend/internal/experiment"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/gin-gonic/gin"
+)
+
+func experimentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, experiment.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, experiment.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, experiment.ErrRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// checkExperiment checks that the retrievers, prompt versions and models of
+// an experiment's variants are offered by this deployment
+func checkExperiment(e *experiment.Experiment) error {
+	for _, v := range e.Variants {
+		if r := v.Config.Retriever; r != "" {
+			if _, ok := rag.PipelineInstance.Retrievers[r]; !ok {
+				return fmt.Errorf("%w: variant %s: unknown retriever %q", experiment.ErrInvalid, v.Name, r)
+			}
+		}
+		for name, version := range v.Config.Prompts {
+			if name != prompt.AnswerSystem && name != prompt.AnswerUser {
+				return fmt.Errorf("%w: variant %s: %s is not an answer prompt", experiment.ErrInvalid, v.Name, name)
+			}
+			if _, err := prompt.RegistryInstance.Version(name, version); err != nil {
+				return fmt.Errorf("%w: variant %s: %v", experiment.ErrInvalid, v.Name, err)
+			}
+		}
+		if m := v.Config.Model; m != "" && !slices.Contains(azure.ChatDeployments(), m) {
+			return fmt.Errorf("%w: variant %s: unknown model %q, expected one of %v", experiment.ErrInvalid, v.Name, m, azure.ChatDeployments())
+		}
+	}
+	return nil
+}
+
+// logExposure records that an answer was produced by an experiment's
+// variant, with the message it was stored as, if any. Failures are logged
+// rather than failing the request, whose answer is already complete.
+func logExposure(c *gin.Context, result *rag.Result, latency time.Duration, conv *conversation.Conversation, messageID string) {
+	user := auth.GetUserFromContext(c)
+	if result.Experiment == "" || user == nil || conversation.StoreInstance == nil {
+		return
+	}
+	exposure := &conversation.Exposure{
+		ExperimentID: result.Experiment,
+		Variant:      result.Variant,
+		UserID:       user.UserID,
+		MessageID:    messageID,
+		LatencyMs:    latency.Milliseconds(),
+	}
+	if conv != nil {
+		exposure.ConversationID = conv.ID
+	}
+	if err := conversation.StoreInstance.LogExposure(c, exposure); err != nil {
+		log.Printf("Failed to log exposure to experiment %s: %v", result.Experiment, err)
+	}
+}
+
+// ListExperimentsHandler returns every experiment with its state
+func ListExperimentsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, ExperimentListResponse{Experiments: experiment.RegistryInstance.List()})
+}
+
+func GetExperimentHandler(c *gin.Context) {
+	e, err := experiment.RegistryInstance.Get(c.Param("id"))
+	if err != nil {
+		experimentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// PutExperimentHandler creates an experiment as a draft or replaces the
+// definition of one that is not running
+func PutExperimentHandler(c *gin.Context) {
+	var e experiment.Experiment
+	if err := c.ShouldBindJSON(&e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	e.ID = c.Param("id")
+	if err := checkExperiment(&e); err != nil {
+		experimentError(c, err)
+		return
+	}
+	saved, created, err := experiment.RegistryInstance.Put(&e, adminID(c))
+	if err != nil {
+		experimentError(c, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, saved)
+}
+
+// StartExperimentHandler starts or resumes an experiment, enrolling users
+// from the next request
+func StartExperimentHandler(c *gin.Context) {
+	e, err := experiment.RegistryInstance.Get(c.Param("id"))
+	if err == nil {
+		// Prompt versions or deployments may have gone since it was defined
+		if err = checkExperiment(e); err == nil {
+			e, err = experiment.RegistryInstance.Start(e.ID, adminID(c))
+		}
+	}
+	if err != nil {
+		experimentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// StopExperimentHandler stops an experiment; its users get the default
+// configuration again
+func StopExperimentHandler(c *gin.Context) {
+	e, err := experiment.RegistryInstance.Stop(c.Param("id"), adminID(c))
+	if err != nil {
+		experimentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// DeleteExperimentHandler deletes an experiment that is not running
+func DeleteExperimentHandler(c *gin.Context) {
+	if err := experiment.RegistryInstance.Delete(c.Param("id")); err != nil {
+		experimentError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ExperimentResultsHandler compares the variants of an experiment by
+// latency and by the ratings of their answers, with 95% confidence intervals
+func ExperimentResultsHandler(c *gin.Context) {
+	e, err := experiment.RegistryInstance.Get(c.Param("id"))
+	if err != nil {
+		experimentError(c, err)
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	exposures, err := store.ListExposures(c, e.ID)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	var f conversation.FeedbackFilter
+	if e.StartedAt != nil {
+		f.From = *e.StartedAt
+	}
+	feedback, err := store.ListFeedback(c, f)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, experiment.Compute(e, exposures, feedback))
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/gin-gonic/gin"
+)
+
+// SubmitFeedbackHandler rates one of the answers in the current user's
+// conversations up or down, replacing their earlier rating of it. The
+// rating is stored with the question, the rewritten query, the retrieved
+// passages, the prompt versions and the model of the answer.
+func SubmitFeedbackHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	feedback, err := store.SetFeedback(c, userID, c.Param("id"), &conversation.Feedback{
+		Rating:  req.Rating,
+		Reasons: req.Reasons,
+		Comment: req.Comment,
+	})
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, feedback)
+}
+
+// FeedbackReportHandler returns the passages and documents of the worst
+// rated answers, e.g. ?from=2024-05-01&limit=50, so that bad content can be
+// found and fixed
+func FeedbackReportHandler(c *gin.Context) {
+	store := conversationStore(c)
+	if store == nil {
+		return
+	}
+	var f conversation.FeedbackFilter
+	var err error
+	if f.From, err = queryDate(c, "from"); err != nil {
+		conversationError(c, fmt.Errorf("%w: %v", conversation.ErrInvalidFeedback, err))
+		return
+	}
+	if f.To, err = queryDate(c, "to"); err != nil {
+		conversationError(c, fmt.Errorf("%w: %v", conversation.ErrInvalidFeedback, err))
+		return
+	}
+	feedback, err := store.ListFeedback(c, f)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, conversation.Report(feedback, f, queryInt(c, "limit", conversation.DefaultReportLimit)))
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/graph"
+	"github.com/gin-gonic/gin"
+)
+
+// ListNeighborsHandler returns the direct neighbors of an entity. The relation
+// query parameter may be repeated; direction is out, in or both.
+func ListNeighborsHandler(c *gin.Context) {
+	step := graph.Step{
+		Direction:  graph.Direction(c.Query("direction")),
+		EntityType: catalog.EntityType(c.Query("type")),
+	}
+	for _, r := range c.QueryArray("relation") {
+		step.Relations = append(step.Relations, catalog.RelationType(r))
+	}
+	edges, err := graph.GraphInstance.Neighbors(c.Param("id"), step, min(queryInt(c, "limit", 50), 500))
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"neighbors": edges})
+}
+
+// GraphQueryHandler runs a multi-step traversal such as "musicians who played
+// on both of these albums" or "recordings that sampled this track"
+func GraphQueryHandler(c *gin.Context) {
+	var query graph.Query
+	if err := c.ShouldBindJSON(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query.Limit = min(query.Limit, 500)
+
+	results, err := graph.GraphInstance.Traverse(query)
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -1,10 +1,18 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/auth"
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/One-Frequency/MusicRAG/backend/internal/experiment"
+	"github.com/One-Frequency/MusicRAG/backend/internal/playlist"
+	"github.com/One-Frequency/MusicRAG/backend/internal/profile"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
 	"github.com/gin-gonic/gin"
 )
 
@@ -23,17 +31,116 @@ func ChatHandler(c *gin.Context) {
 		return
 	}
 
-	// Get a completion from the language model
-	completion, err := azure.GetCompletion(c, req.Query, []string{})
+	history := make([]rag.Turn, 0, len(req.ConversationHistory))
+	for _, m := range req.ConversationHistory {
+		history = append(history, rag.Turn{Role: m.Type, Content: m.Content})
+	}
+	// A stored conversation's history is its active branch rather than what
+	// the client sent
+	var conv *conversation.Conversation
+	var p *profile.Profile
+	var ok bool
+	if req.ConversationID != "" {
+		if conv, history, ok = loadConversation(c, req.ConversationID); !ok {
+			return
+		}
+		p, ok = conversationProfile(c, conv)
+	} else {
+		p, ok = useProfile(c, req.ProfileID)
+	}
+	if !ok {
+		return
+	}
+	request := withProfile(rag.Request{
+		Query:      req.Query,
+		History:    history,
+		Expand:     req.Expand,
+		Schema:     req.Schema,
+		OutputType: req.OutputType,
+		Locale:     req.Locale,
+	}, p)
+	result, latency, ok := answer(c, request)
+	if !ok {
+		return
+	}
+	response := ragResponse(result, req.Debug)
+	if conv != nil {
+		question := &conversation.Message{Role: conversation.RoleUser, Content: req.Query}
+		response.Conversation, response.MessageID = saveMessages(c, conv, nil, question, answerMessage(request, result, latency))
+	}
+	logExposure(c, result, latency, conv, response.MessageID)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// answer runs the pipeline for a request of the current user, if any, and
+// times it. It responds with an error and reports false when it fails.
+func answer(c *gin.Context, req rag.Request) (*rag.Result, time.Duration, bool) {
+	if user := auth.GetUserFromContext(c); user != nil {
+		req.UserID, req.Tier = user.UserID, user.UserTier
+		req.Playlists = playlist.StoreInstance.List(user.UserID)
+		// Users enrolled in an experiment are answered by their variant
+		if a := experiment.RegistryInstance.Assign(user.UserID); a != nil {
+			req = a.Apply(req)
+		}
+	}
+	if req.Locale == "" {
+		req.Locale = acceptedLocale(c.GetHeader("Accept-Language"))
+	}
+	started := time.Now()
+	result, err := rag.PipelineInstance.Answer(c, req)
+	if errors.Is(err, rag.ErrInvalidSchema) || errors.Is(err, rag.ErrUnknownModel) || errors.Is(err, rag.ErrUnknownRetriever) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
+	if errors.Is(err, rag.ErrSearch) || errors.Is(err, rag.ErrInvalidOutput) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, 0, false
+	}
+	return result, time.Since(started), true
+}
+
+// acceptedLocale returns the preferred locale of an Accept-Language header,
+// e.g. "de-DE" for "de-DE,de;q=0.9,en;q=0.8", or "" for none or any
+func acceptedLocale(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	locale, _, _ := strings.Cut(first, ";")
+	if locale = strings.TrimSpace(locale); locale == "*" {
+		return ""
 	}
+	return locale
+}
 
+// ragResponse is the response to a chat request answered by result
+func ragResponse(result *rag.Result, debug bool) RagResponse {
 	response := RagResponse{
-		Content: completion,
-		Sources: []string{},
+		Content:          result.Content,
+		Data:             result.Data,
+		Sources:          result.Sources,
+		Citations:        result.Citations,
+		Grounding:        result.Grounding,
+		InvalidCitations: result.InvalidCitations,
+		Entities:         result.Entities,
+		Facts:            result.Facts,
+		LinkedEntities:   result.Mentions,
+		Route:            result.Route,
+		Prompts:          result.Prompts,
+		Profile:          result.Profile,
+		Experiment:       result.Experiment,
+		Variant:          result.Variant,
+		Cache:            result.Cache,
 	}
+	if debug {
+		response.Debug = result.Debug
+	}
+	return response
+}
 
-	c.JSON(http.StatusOK, response)
+// ListOutputTypesHandler returns the named schemas ChatRequest.OutputType accepts
+func ListOutputTypesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, OutputTypesResponse{OutputTypes: rag.OutputTypes})
 }
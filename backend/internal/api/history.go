@@ -0,0 +1,255 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/history"
+	"github.com/gin-gonic/gin"
+)
+
+// maxHistoryUpload is the largest listening history upload accepted; a
+// Spotify extended history archive of a heavy listener runs to well over a
+// hundred megabytes
+const maxHistoryUpload = 512 << 20
+
+func historyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, history.ErrInvalid), errors.Is(err, history.ErrUnsupportedFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ImportHistoryHandler imports listening history exports for the current
+// user: Spotify streaming history and library JSON files, Last.fm scrobble
+// CSV files, or a Spotify data export ZIP archive holding them. Several files
+// may be uploaded at once under the "file" field. The format is taken from
+// the form, the file name or the content.
+func ImportHistoryHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a listening history file is required"})
+		return
+	}
+
+	response := HistoryImportResponse{Files: []HistoryFileImport{}}
+	for _, header := range form.File["file"] {
+		if header.Size > maxHistoryUpload {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("listening history files are limited to %d MB", maxHistoryUpload>>20)})
+			return
+		}
+		files, err := historyFiles(header)
+		if err != nil {
+			historyError(c, err)
+			return
+		}
+		for _, f := range files {
+			format := c.PostForm("format")
+			if format == "" {
+				if format, err = history.DetectFormat(f.name, f.data); err != nil {
+					historyError(c, err)
+					return
+				}
+			}
+			export, err := history.Parse(format, f.data)
+			if err != nil {
+				historyError(c, fmt.Errorf("%s: %w", f.name, err))
+				return
+			}
+			result, err := history.StoreInstance.Import(userID, export)
+			if err != nil {
+				historyError(c, err)
+				return
+			}
+			response.Files = append(response.Files, HistoryFileImport{Name: f.name, Format: format, ImportResult: result})
+			response.Plays += result.Plays
+			response.Duplicates += result.Duplicates
+			response.Saved += result.Saved
+			response.Skipped += result.Skipped
+		}
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// historyFile is a listening history file uploaded or inside an archive
+type historyFile struct {
+	name string
+	data []byte
+}
+
+// historyFiles returns an uploaded file, or the listening history files
+// inside an uploaded ZIP archive
+func historyFiles(header *multipart.FileHeader) ([]historyFile, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxHistoryUpload))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(path.Ext(header.Filename), ".zip") {
+		return []historyFile{{name: header.Filename, data: data}}, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", history.ErrInvalid, err)
+	}
+	var files []historyFile
+	var total int64
+	for _, f := range archive.File {
+		if !isHistoryFile(f.Name) {
+			continue
+		}
+		// Guard against archives that inflate far beyond the upload limit
+		total += int64(f.UncompressedSize64)
+		if total > 4*maxHistoryUpload {
+			return nil, fmt.Errorf("%w: the archive is too large once uncompressed", history.ErrInvalid)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", history.ErrInvalid, err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", history.ErrInvalid, err)
+		}
+		files = append(files, historyFile{name: path.Base(f.Name), data: content})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: the archive holds no streaming history, library or scrobble files", history.ErrInvalid)
+	}
+	return files, nil
+}
+
+// isHistoryFile reports whether a file of a data export archive holds music
+// listening history or saved tracks, rather than e.g. video or podcast
+// history or account data
+func isHistoryFile(name string) bool {
+	base := path.Base(name)
+	lower := strings.ToLower(base)
+	switch {
+	case strings.HasPrefix(base, "._"):
+		return false
+	case strings.HasSuffix(lower, ".csv"):
+		return true
+	case !strings.HasSuffix(lower, ".json"):
+		return false
+	}
+	return strings.HasPrefix(lower, "streaming_history_audio") ||
+		(strings.HasPrefix(lower, "streaminghistory") && !strings.HasPrefix(lower, "streaminghistory_podcast")) ||
+		lower == "yourlibrary.json"
+}
+
+// historyQuery reads the period, artist and unsaved parameters of a history
+// request. The period is given by year, or by from and to dates, to being
+// exclusive.
+func historyQuery(c *gin.Context) (history.Query, error) {
+	q := history.Query{
+		By:      c.DefaultQuery("by", history.ByTrack),
+		Artist:  c.Query("artist"),
+		Unsaved: c.Query("unsaved") == "true",
+		Limit:   min(queryInt(c, "limit", 50), 500),
+	}
+	if year := c.Query("year"); year != "" {
+		y, err := strconv.Atoi(year)
+		if err != nil {
+			return q, fmt.Errorf("%w: invalid year %q", history.ErrInvalid, year)
+		}
+		q.From = time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+		q.To = q.From.AddDate(1, 0, 0)
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		t, err := queryDate(c, bound.name)
+		if err != nil {
+			return q, fmt.Errorf("%w: %v", history.ErrInvalid, err)
+		}
+		if !t.IsZero() {
+			*bound.t = t
+		}
+	}
+	return q, nil
+}
+
+// queryDate reads a date or RFC 3339 time parameter, returning the zero time
+// when it is absent
+func queryDate(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, value); err != nil {
+			return time.Time{}, fmt.Errorf("%s must be a date such as 2023-01-31", name)
+		}
+	}
+	return t.UTC(), nil
+}
+
+// GetHistorySummaryHandler returns the totals of the current user's listening
+// history over a period
+func GetHistorySummaryHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	q, err := historyQuery(c)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, history.StoreInstance.Summarize(userID, q))
+}
+
+// GetHistoryTopHandler returns the current user's most played tracks,
+// artists or albums over a period, e.g. ?by=track&year=2023&unsaved=true for
+// the tracks played most in 2023 that were never saved
+func GetHistoryTopHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	q, err := historyQuery(c)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+	rows, err := history.StoreInstance.Top(userID, q)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, HistoryTopResponse{By: q.By, Rows: rows})
+}
+
+// DeleteHistoryHandler erases the current user's whole listening history
+func DeleteHistoryHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	history.StoreInstance.Delete(userID)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer/discogs"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer/musicbrainz"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer/rekordbox"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer/traktor"
+	"github.com/gin-gonic/gin"
+)
+
+// importRunner builds the job function for a dump source and kind
+func importRunner(req StartImportRequest) (importer.RunFunc, error) {
+	opts := importer.Options{Restart: req.Restart, Flush: catalog.Persist}
+	switch req.Source {
+	case musicbrainz.Source:
+		return musicbrainz.Run(req.Kind, catalog.StoreInstance, opts)
+	case discogs.Source:
+		return discogs.Run(req.Kind, catalog.StoreInstance, opts)
+	case rekordbox.Source:
+		return rekordbox.Run(req.Kind, catalog.StoreInstance, opts)
+	case traktor.Source:
+		return traktor.Run(req.Kind, catalog.StoreInstance, opts)
+	}
+	return nil, fmt.Errorf("unsupported import source %q", req.Source)
+}
+
+func StartImportHandler(c *gin.Context) {
+	var req StartImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	path, err := importer.ResolvePath(req.Path)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	run, err := importRunner(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := importer.ManagerInstance.Start(req.Source, req.Kind, path, run)
+	c.JSON(http.StatusAccepted, job.Status())
+}
+
+func ListImportsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"imports": importer.ManagerInstance.List()})
+}
+
+func GetImportHandler(c *gin.Context) {
+	job, err := importer.ManagerInstance.Get(c.Param("id"))
+	if errors.Is(err, importer.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job.Status())
+}
+
+func CancelImportHandler(c *gin.Context) {
+	job, err := importer.ManagerInstance.Get(c.Param("id"))
+	if errors.Is(err, importer.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	job.Cancel()
+	c.JSON(http.StatusAccepted, job.Status())
+}
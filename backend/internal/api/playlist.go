@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/auth"
+	"github.com/One-Frequency/MusicRAG/backend/internal/playlist"
+	"github.com/gin-gonic/gin"
+)
+
+// maxPlaylistUpload is the largest playlist file accepted; whole library
+// exports of large collections run to tens of megabytes
+const maxPlaylistUpload = 64 << 20
+
+func playlistError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, playlist.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, playlist.ErrInvalid), errors.Is(err, playlist.ErrUnsupportedFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// currentUserID returns the ID of the authenticated user, or responds with
+// 401 and returns "" when there is none
+func currentUserID(c *gin.Context) string {
+	user := auth.GetUserFromContext(c)
+	if user == nil || user.UserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found in context"})
+		return ""
+	}
+	return user.UserID
+}
+
+// ImportPlaylistHandler imports the playlists of an uploaded M3U, M3U8, XSPF
+// or library XML file for the current user, matching their tracks to the
+// catalog. The format is taken from the form, the file name or the content.
+func ImportPlaylistHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a playlist file is required: " + err.Error()})
+		return
+	}
+	if header.Size > maxPlaylistUpload {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("playlist files are limited to %d MB", maxPlaylistUpload>>20)})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxPlaylistUpload))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.PostForm("format")
+	if format == "" {
+		if format, err = playlist.DetectFormat(header.Filename, data); err != nil {
+			playlistError(c, err)
+			return
+		}
+	}
+	playlists, err := playlist.Parse(format, data)
+	if err != nil {
+		playlistError(c, err)
+		return
+	}
+
+	imported := []PlaylistSummary{}
+	for _, p := range playlists {
+		p.OwnerID = userID
+		if p.Name == "" {
+			p.Name = strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+		}
+		// A single playlist file may be renamed on import
+		if name := c.PostForm("name"); name != "" && len(playlists) == 1 {
+			p.Name = name
+		}
+		playlist.MatcherInstance.Match(p.Entries)
+		created, err := playlist.StoreInstance.Create(p)
+		if err != nil {
+			playlistError(c, err)
+			return
+		}
+		imported = append(imported, summarizePlaylist(created))
+	}
+	c.JSON(http.StatusCreated, PlaylistListResponse{Playlists: imported})
+}
+
+// ListPlaylistsHandler lists the current user's playlists without their tracks
+func ListPlaylistsHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	summaries := []PlaylistSummary{}
+	for _, p := range playlist.StoreInstance.List(userID) {
+		summaries = append(summaries, summarizePlaylist(p))
+	}
+	c.JSON(http.StatusOK, PlaylistListResponse{Playlists: summaries})
+}
+
+func GetPlaylistHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	p, err := playlist.StoreInstance.Get(userID, c.Param("id"))
+	if err != nil {
+		playlistError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func DeletePlaylistHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	if err := playlist.StoreInstance.Delete(userID, c.Param("id")); err != nil {
+		playlistError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ExportPlaylistHandler downloads one of the current user's playlists in the
+// format given by the format query parameter, M3U8 by default
+func ExportPlaylistHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	p, err := playlist.StoreInstance.Get(userID, c.Param("id"))
+	if err != nil {
+		playlistError(c, err)
+		return
+	}
+	playlist.MatcherInstance.Complete(p.Entries)
+	sendPlaylist(c, c.DefaultQuery("format", playlist.FormatM3U8), p)
+}
+
+// ExportAnswerHandler turns the tracks of a chat answer into a playlist file:
+// explicit tracks, the data of a structured tracks answer, or the tracks
+// listed in the answer text, in that order of preference. Tracks are matched
+// to the catalog to fill in durations and identifiers, and the playlist is
+// saved for the user when asked to.
+func ExportAnswerHandler(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		return
+	}
+	var req ExportAnswerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entries []playlist.Entry
+	switch {
+	case len(req.Tracks) > 0:
+		entries = req.Tracks
+	case len(req.Data) > 0:
+		var err error
+		if entries, err = playlist.FromTracks(req.Data); err != nil {
+			playlistError(c, err)
+			return
+		}
+	default:
+		entries = playlist.FromAnswer(req.Content)
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "the answer does not list any tracks"})
+		return
+	}
+
+	p := &playlist.Playlist{OwnerID: userID, Name: req.Name, Entries: entries}
+	if p.Name == "" {
+		p.Name = "Chat playlist"
+	}
+	playlist.MatcherInstance.Match(p.Entries)
+	playlist.MatcherInstance.Complete(p.Entries)
+	if req.Save {
+		created, err := playlist.StoreInstance.Create(p)
+		if err != nil {
+			playlistError(c, err)
+			return
+		}
+		c.Header("X-Playlist-Id", created.ID)
+	}
+	format := req.Format
+	if format == "" {
+		format = playlist.FormatM3U8
+	}
+	sendPlaylist(c, format, p)
+}
+
+// sendPlaylist responds with p encoded in format as a file download
+func sendPlaylist(c *gin.Context, format string, p *playlist.Playlist) {
+	data, ext, contentType, err := playlist.Encode(format, p)
+	if err != nil {
+		playlistError(c, err)
+		return
+	}
+	sendFile(c, p.Name, "playlist", ext, contentType, data)
+}
+
+// sendFile responds with data as a file download, named after name with
+// the characters file systems reject replaced, or fallback if name is empty
+func sendFile(c *gin.Context, name, fallback, ext, contentType string, data []byte) {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = fallback
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, ext))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func summarizePlaylist(p *playlist.Playlist) PlaylistSummary {
+	return PlaylistSummary{
+		ID:        p.ID,
+		Name:      p.Name,
+		Format:    p.Format,
+		Tracks:    len(p.Entries),
+		Matched:   p.Matched(),
+		CreatedAt: p.CreatedAt,
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/auth"
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/One-Frequency/MusicRAG/backend/internal/profile"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/gin-gonic/gin"
+)
+
+func profileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, profile.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, profile.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, profile.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// useProfile returns an assistant profile the current user may use, or nil
+// for an empty ID. Admins may use every profile. It responds with an error
+// and reports false otherwise.
+func useProfile(c *gin.Context, id string) (*profile.Profile, bool) {
+	if id == "" {
+		return nil, true
+	}
+	p, err := profile.RegistryInstance.Get(id)
+	if err == nil {
+		user := auth.GetUserFromContext(c)
+		if user == nil || (!user.HasPermission("admin") && !p.Allows(user.Groups)) {
+			err = fmt.Errorf("%w: %s", profile.ErrForbidden, id)
+		}
+	}
+	if err != nil {
+		profileError(c, err)
+		return nil, false
+	}
+	return p, true
+}
+
+// conversationProfile is useProfile for the profile of a conversation. A
+// conversation whose profile was deleted carries on with the plain
+// assistant.
+func conversationProfile(c *gin.Context, conv *conversation.Conversation) (*profile.Profile, bool) {
+	if _, err := profile.RegistryInstance.Get(conv.ProfileID); conv.ProfileID != "" && errors.Is(err, profile.ErrNotFound) {
+		log.Printf("Profile %s of conversation %s no longer exists, answering without it", conv.ProfileID, conv.ID)
+		return nil, true
+	}
+	return useProfile(c, conv.ProfileID)
+}
+
+// withProfile configures a request with an assistant profile, if any. A
+// model chosen for the request takes precedence over the profile's.
+func withProfile(req rag.Request, p *profile.Profile) rag.Request {
+	if p == nil {
+		return req
+	}
+	req.Profile = p.ID
+	req.Persona, req.Instructions, req.Style = p.Persona, p.Instructions, p.StyleInstruction()
+	req.Collections, req.Scope, req.Tools = p.Collections, p.Filters.EntityIDs, p.Tools
+	if req.Model == "" {
+		req.Model = p.Model
+	}
+	return req
+}
+
+// checkProfile checks that the model and tools of a profile are offered by
+// this deployment
+func checkProfile(p *profile.Profile) error {
+	if p.Model != "" && !slices.Contains(azure.ChatDeployments(), p.Model) {
+		return fmt.Errorf("%w: unknown model %q, expected one of %v", profile.ErrInvalid, p.Model, azure.ChatDeployments())
+	}
+	for _, name := range p.Tools {
+		if !slices.ContainsFunc(rag.PipelineInstance.Tools, func(t rag.Tool) bool { return t.Name == name }) {
+			return fmt.Errorf("%w: unknown tool %q", profile.ErrInvalid, name)
+		}
+	}
+	return nil
+}
+
+// ListProfilesHandler returns the assistant profiles the current user may
+// pick for a conversation
+func ListProfilesHandler(c *gin.Context) {
+	user := auth.GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found in context"})
+		return
+	}
+	if user.HasPermission("admin") {
+		c.JSON(http.StatusOK, ProfileListResponse{Profiles: profile.RegistryInstance.List()})
+		return
+	}
+	c.JSON(http.StatusOK, ProfileListResponse{Profiles: profile.RegistryInstance.Available(user.Groups)})
+}
+
+// ListAllProfilesHandler returns every assistant profile with the groups
+// that may use it
+func ListAllProfilesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, ProfileListResponse{Profiles: profile.RegistryInstance.List()})
+}
+
+func GetProfileHandler(c *gin.Context) {
+	p, err := profile.RegistryInstance.Get(c.Param("id"))
+	if err != nil {
+		profileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// PutProfileHandler creates an assistant profile or replaces the one with
+// the ID, built-in ones included
+func PutProfileHandler(c *gin.Context) {
+	var p profile.Profile
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = c.Param("id")
+	if err := checkProfile(&p); err != nil {
+		profileError(c, err)
+		return
+	}
+	saved, created, err := profile.RegistryInstance.Put(&p, adminID(c))
+	if err != nil {
+		profileError(c, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, saved)
+}
+
+// SetProfileGroupsHandler changes the groups that may use an assistant
+// profile
+func SetProfileGroupsHandler(c *gin.Context) {
+	var req SetProfileGroupsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := profile.RegistryInstance.SetGroups(c.Param("id"), req.Groups, adminID(c))
+	if err != nil {
+		profileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// DeleteProfileHandler deletes an assistant profile; conversations using it
+// carry on with the plain assistant
+func DeleteProfileHandler(c *gin.Context) {
+	if err := profile.RegistryInstance.Delete(c.Param("id")); err != nil {
+		profileError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
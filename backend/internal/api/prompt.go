@@ -0,0 +1,113 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/auth"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/gin-gonic/gin"
+)
+
+func promptError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, prompt.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, prompt.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// adminID names the admin making a change to prompts or profiles
+func adminID(c *gin.Context) string {
+	if user := auth.GetUserFromContext(c); user != nil {
+		return user.UserID
+	}
+	return ""
+}
+
+// ListPromptsHandler returns the prompts with their versions and the one in use
+func ListPromptsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, PromptListResponse{Prompts: prompt.RegistryInstance.List()})
+}
+
+func GetPromptHandler(c *gin.Context) {
+	p, err := prompt.RegistryInstance.Get(c.Param("name"))
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// AddPromptVersionHandler stores a new version of a prompt, rolling it out
+// at once if asked to
+func AddPromptVersionHandler(c *gin.Context) {
+	var req AddPromptVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	v, err := prompt.RegistryInstance.Add(c.Param("name"), req.Template, req.Description, adminID(c))
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	if req.Rollout {
+		if _, err := prompt.RegistryInstance.Rollout(v.Name, v.Version, adminID(c)); err != nil {
+			promptError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusCreated, v)
+}
+
+// RolloutPromptHandler puts a version of a prompt in use from the next
+// request on; rolling out an earlier version rolls the prompt back
+func RolloutPromptHandler(c *gin.Context) {
+	var req RolloutPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := prompt.RegistryInstance.Rollout(c.Param("name"), req.Version, adminID(c))
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// RollbackPromptHandler undoes the last rollout of a prompt not undone yet
+func RollbackPromptHandler(c *gin.Context) {
+	p, err := prompt.RegistryInstance.Rollback(c.Param("name"), adminID(c))
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// PreviewPromptHandler renders a version of a prompt, the one in use by
+// default, with the variables given
+func PreviewPromptHandler(c *gin.Context) {
+	var req PreviewPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := c.Param("name")
+	set, err := prompt.RegistryInstance.Version(name, req.Version)
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	text, err := set.Render(name, prompt.Vars(req.Vars))
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, PromptPreviewResponse{Prompt: set.Refs()[0], Text: text})
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/setlist"
+	"github.com/gin-gonic/gin"
+)
+
+// PlanSetlistHandler plans a set of catalog recordings to a target length,
+// energy curve and key rules, explaining every transition
+func PlanSetlistHandler(c *gin.Context) {
+	var req setlist.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := setlist.PlannerInstance.Plan(req)
+	if errors.Is(err, setlist.ErrNoPlan) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/similarity"
+	"github.com/gin-gonic/gin"
+)
+
+func similarityError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, similarity.ErrNotAnalyzed), errors.Is(err, similarity.ErrNoKey), errors.Is(err, similarity.ErrNoTempo):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// SimilarTracksHandler returns the recordings that sound most like a
+// recording by their analyzed audio features. compatibleKeys=true keeps only
+// harmonically compatible keys and bpmWindow keeps only tempos within that
+// many BPM, e.g. ?compatibleKeys=true&bpmWindow=4&limit=20.
+func SimilarTracksHandler(c *gin.Context) {
+	track, err := catalog.StoreInstance.GetEntity(c.Param("id"))
+	if err != nil {
+		catalogError(c, err)
+		return
+	}
+	if track.Type != catalog.TypeRecording {
+		c.JSON(http.StatusNotFound, gin.H{"error": "entity is not a recording"})
+		return
+	}
+	matches, err := similarity.IndexInstance.Similar(track.ID, similarity.Options{
+		Limit:          min(queryInt(c, "limit", similarity.DefaultLimit), 100),
+		CompatibleKeys: c.Query("compatibleKeys") == "true",
+		BPMWindow:      queryFloat(c, "bpmWindow"),
+	})
+	if err != nil {
+		similarityError(c, err)
+		return
+	}
+
+	similar := []SimilarTrack{}
+	for _, m := range matches {
+		e, err := catalog.StoreInstance.GetEntity(m.ID)
+		if err != nil {
+			continue
+		}
+		similar = append(similar, SimilarTrack{Entity: e, Score: m.Score})
+	}
+	c.JSON(http.StatusOK, SimilarTracksResponse{Track: track, Similar: similar})
+}
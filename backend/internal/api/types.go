@@ -1,5 +1,21 @@
 package api
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/One-Frequency/MusicRAG/backend/internal/experiment"
+	"github.com/One-Frequency/MusicRAG/backend/internal/graph"
+	"github.com/One-Frequency/MusicRAG/backend/internal/history"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+	"github.com/One-Frequency/MusicRAG/backend/internal/playlist"
+	"github.com/One-Frequency/MusicRAG/backend/internal/profile"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+)
+
 type Message struct {
 	Type    string `json:"type"`
 	Content string `json:"content"`
@@ -8,9 +24,272 @@ type Message struct {
 type ChatRequest struct {
 	Query               string    `json:"query"`
 	ConversationHistory []Message `json:"conversationHistory"`
+	Expand              bool      `json:"expand"` // also search with paraphrased sub-queries
+	Debug               bool      `json:"debug"`  // include the rewritten queries in the response
+	// Schema is a JSON Schema the answer must conform to; OutputType names a
+	// predefined schema instead. Either makes the answer JSON rather than prose.
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	OutputType string          `json:"outputType,omitempty"`
+	// ConversationID continues a stored conversation: its messages are the
+	// history, instead of ConversationHistory, and the exchange is added to it
+	ConversationID string `json:"conversationId,omitempty"`
+	// Locale is the language to answer in, e.g. de-DE; the Accept-Language
+	// header when empty
+	Locale string `json:"locale,omitempty"`
+	// ProfileID picks the assistant profile of a request without a stored
+	// conversation; a conversation answers with its own profile
+	ProfileID string `json:"profileId,omitempty"`
 }
 
 type RagResponse struct {
-	Content string   `json:"content"`
-	Sources []string `json:"sources"`
+	Content          string              `json:"content"`
+	Data             json.RawMessage     `json:"data,omitempty"` // the typed answer of a structured request
+	Sources          []rag.Source        `json:"sources"`
+	Citations        []rag.Citation      `json:"citations"`
+	Grounding        *rag.Grounding      `json:"grounding,omitempty"`        // per-claim support; absent for theory questions
+	InvalidCitations []int               `json:"invalidCitations,omitempty"` // cited numbers without a source, removed from the answer
+	Entities         []catalog.Aggregate `json:"entities,omitempty"`
+	Facts            []graph.Fact        `json:"facts,omitempty"`
+	LinkedEntities   []linking.Mention   `json:"linkedEntities,omitempty"` // catalog entities mentioned in the query
+	Route            rag.Route           `json:"route"`                    // how the request was routed and how confidently
+	Prompts          []prompt.Ref        `json:"prompts,omitempty"`        // the prompt versions the answer was asked with
+	Profile          string              `json:"profile,omitempty"`        // the assistant profile that answered
+	Experiment       string              `json:"experiment,omitempty"`     // the A/B experiment the user is enrolled in
+	Variant          string              `json:"variant,omitempty"`        // the experiment variant that answered
+	Cache            *rag.CacheHit       `json:"cache,omitempty"`          // set when the answer was reused from the answer cache
+	Debug            *rag.Debug          `json:"debug,omitempty"`
+	// Conversation and MessageID are set when the request continued a stored
+	// conversation: the conversation, titled after its first exchange, and
+	// the stored answer
+	Conversation *conversation.Conversation `json:"conversation,omitempty"`
+	MessageID    string                     `json:"messageId,omitempty"`
+}
+
+// OutputTypesResponse lists the predefined structured output schemas by name
+type OutputTypesResponse struct {
+	OutputTypes map[string]json.RawMessage `json:"outputTypes"`
+}
+
+// LinkDocumentRequest sets the catalog entities an ingested document is about
+type LinkDocumentRequest struct {
+	EntityIDs []string `json:"entityIds"`
+}
+
+// EntityListResponse is a page of catalog entities
+type EntityListResponse struct {
+	Entities []*catalog.Entity `json:"entities"`
+	Total    int               `json:"total"`
+	Limit    int               `json:"limit"`
+	Offset   int               `json:"offset"`
+}
+
+// EntityResponse is a catalog entity together with its relations
+type EntityResponse struct {
+	*catalog.Entity
+	Relations []*catalog.Relation `json:"relations"`
+}
+
+// StartImportRequest starts an offline import of a dump file. Path is relative
+// to the server's import data directory.
+type StartImportRequest struct {
+	Source  string `json:"source" binding:"required"`
+	Kind    string `json:"kind" binding:"required"`
+	Path    string `json:"path" binding:"required"`
+	Restart bool   `json:"restart"`
+}
+
+// PlaylistSummary describes a playlist without its tracks
+type PlaylistSummary struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Format    string    `json:"format,omitempty"`
+	Tracks    int       `json:"tracks"`
+	Matched   int       `json:"matched"` // tracks matched to catalog recordings
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PlaylistListResponse lists the current user's playlists
+type PlaylistListResponse struct {
+	Playlists []PlaylistSummary `json:"playlists"`
+}
+
+// ExportAnswerRequest turns the tracks of a chat answer into a playlist. Give
+// Tracks, the Data of a structured tracks answer or the answer Content.
+type ExportAnswerRequest struct {
+	Name    string           `json:"name"`
+	Format  string           `json:"format"` // m3u, m3u8 (default), xspf or itunes
+	Content string           `json:"content"`
+	Data    json.RawMessage  `json:"data,omitempty"`
+	Tracks  []playlist.Entry `json:"tracks,omitempty"`
+	Save    bool             `json:"save"` // also keep the playlist in the user's library
+}
+
+// HistoryFileImport is what the import of one listening history file added
+type HistoryFileImport struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	history.ImportResult
+}
+
+// HistoryImportResponse reports a listening history upload file by file,
+// with the totals of all files
+type HistoryImportResponse struct {
+	Files []HistoryFileImport `json:"files"`
+	history.ImportResult
+}
+
+// HistoryTopResponse lists the most played tracks, artists or albums
+type HistoryTopResponse struct {
+	By   string        `json:"by"`
+	Rows []history.Row `json:"rows"`
+}
+
+// SimilarTrack is a recording that sounds like another, with a similarity
+// score from 0 to 1
+type SimilarTrack struct {
+	Entity *catalog.Entity `json:"entity"`
+	Score  float64         `json:"score"`
+}
+
+// SimilarTracksResponse lists the recordings most similar to a track
+type SimilarTracksResponse struct {
+	Track   *catalog.Entity `json:"track"`
+	Similar []SimilarTrack  `json:"similar"`
+}
+
+// CreateConversationRequest starts a conversation; without a title one is
+// generated from the first exchange
+type CreateConversationRequest struct {
+	Title string `json:"title"`
+	// ProfileID is the assistant profile answering in the conversation;
+	// empty for the plain assistant
+	ProfileID string `json:"profileId"`
+}
+
+// ConversationListResponse is a page of the current user's conversations
+type ConversationListResponse struct {
+	Conversations []*conversation.Conversation `json:"conversations"`
+	Total         int                          `json:"total"`
+	Limit         int                          `json:"limit"`
+	Offset        int                          `json:"offset"`
+}
+
+// ConversationResponse is a conversation with the messages of its active
+// branch, oldest first
+type ConversationResponse struct {
+	*conversation.Conversation
+	Messages []*conversation.Message `json:"messages"`
+	// Alternatives maps each message of the branch that was edited or
+	// regenerated to the IDs of all its versions, oldest first
+	Alternatives map[string][]string `json:"alternatives,omitempty"`
+}
+
+// AnswerSettings are the retrieval and model settings an answer is
+// regenerated with
+type AnswerSettings struct {
+	Expand bool `json:"expand"`
+	Top    int  `json:"top,omitempty"` // passages retrieved; 0 for the default
+	// Model is one of the chat deployments offered; empty for the default
+	Model      string          `json:"model,omitempty"`
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	OutputType string          `json:"outputType,omitempty"`
+	Locale     string          `json:"locale,omitempty"`
+	Debug      bool            `json:"debug"`
+}
+
+// EditMessageRequest asks an edited question with the settings given
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+	AnswerSettings
+}
+
+// SwitchBranchRequest makes the branch through a message the active one
+type SwitchBranchRequest struct {
+	MessageID string `json:"messageId" binding:"required"`
+}
+
+// ConversationSearchResponse lists the messages matching a search, best first
+type ConversationSearchResponse struct {
+	Hits []conversation.SearchHit `json:"hits"`
+}
+
+// PromptListResponse lists the prompts of the registry by name
+type PromptListResponse struct {
+	Prompts []*prompt.Prompt `json:"prompts"`
+}
+
+// AddPromptVersionRequest adds a version of a prompt; Template is a Go
+// template over the variables of prompt.Vars, e.g. {{.Persona}}
+type AddPromptVersionRequest struct {
+	Template    string `json:"template" binding:"required"`
+	Description string `json:"description"`
+	// Rollout puts the version in use at once
+	Rollout bool `json:"rollout"`
+}
+
+// RolloutPromptRequest names the version of a prompt to put in use
+type RolloutPromptRequest struct {
+	Version int `json:"version" binding:"required"`
+}
+
+// PreviewPromptRequest renders a version of a prompt, 0 being the one in
+// use, with sample variables
+type PreviewPromptRequest struct {
+	Version int        `json:"version"`
+	Vars    PromptVars `json:"vars"`
+}
+
+// PromptVars are the variables of a prompt preview
+type PromptVars struct {
+	Tier         string `json:"tier"`
+	Persona      string `json:"persona"`
+	Locale       string `json:"locale"`
+	Style        string `json:"style"`
+	Instructions string `json:"instructions"`
+	Context      string `json:"context"`
+	Query        string `json:"query"`
+}
+
+// PromptPreviewResponse is a rendered prompt
+type PromptPreviewResponse struct {
+	Prompt prompt.Ref `json:"prompt"`
+	Text   string     `json:"text"`
+}
+
+// ProfileListResponse lists assistant profiles by name
+type ProfileListResponse struct {
+	Profiles []*profile.Profile `json:"profiles"`
+}
+
+// SetProfileGroupsRequest names the groups that may use a profile; none
+// opens it to everyone
+type SetProfileGroupsRequest struct {
+	Groups []string `json:"groups"`
+}
+
+// ExperimentListResponse lists A/B experiments by ID
+type ExperimentListResponse struct {
+	Experiments []*experiment.Experiment `json:"experiments"`
+}
+
+// InvalidateCacheRequest names re-indexed documents of a collection, e.g.
+// azure, whose cached answers are dropped; without document IDs every answer
+// that searched the collection is dropped, and without a collection every
+// answer
+type InvalidateCacheRequest struct {
+	Collection  string   `json:"collection"`
+	DocumentIDs []string `json:"documentIds"`
+}
+
+// InvalidateCacheResponse reports how many cached answers were dropped
+type InvalidateCacheResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// FeedbackRequest rates an answer up or down, with any of
+// conversation.Reasons and a comment
+type FeedbackRequest struct {
+	Rating  string   `json:"rating" binding:"required"`
+	Reasons []string `json:"reasons"`
+	Comment string   `json:"comment"`
 }
@@ -10,49 +10,102 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"slices"
+	"strings"
 )
 
 // API-specific request and response structures
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the tools an assistant message asks to call
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the call a tool message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
 type ChatRequest struct {
-	Messages []ChatMessage `json:"messages"`
+	Messages       []ChatMessage   `json:"messages"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Tools          []Tool          `json:"tools,omitempty"`
+}
+
+// Tool is a function the model may call instead of answering
+type Tool struct {
+	Type     string       `json:"type"` // always function
+	Function FunctionSpec `json:"function"`
+}
+
+// FunctionSpec describes a callable function; Parameters is a JSON schema
+type FunctionSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is the model's request to call a function with JSON arguments
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ResponseFormat constrains the reply; type json_object makes the model
+// reply with a single valid JSON object
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type ChatResponse struct {
 	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
+		Message ChatMessage `json:"message"`
 	} `json:"choices"`
 }
 
-func GetCompletion(ctx context.Context, query string, documents []string) (string, error) {
-	deployment := getOpenAIDeploymentName()
-	url := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=2023-05-15", openaiEndpoint, deployment)
+// Chat sends a list of messages to the chat deployment and returns the reply
+func Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	return complete(ctx, ChatRequest{Messages: messages}, "2023-05-15")
+}
 
-	// Build the chat messages
-	messages := []ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant."},
-		{Role: "user", Content: query},
-	}
-	for _, doc := range documents {
-		messages = append(messages, ChatMessage{Role: "assistant", Content: doc})
-	}
+// ChatJSON is Chat in JSON mode: the reply is a single JSON object. The
+// messages must ask for JSON, or the service rejects the request.
+func ChatJSON(ctx context.Context, messages []ChatMessage) (string, error) {
+	// JSON mode needs a newer API version than plain chat
+	return complete(ctx, ChatRequest{Messages: messages, ResponseFormat: &ResponseFormat{Type: "json_object"}}, "2024-02-01")
+}
+
+// ChatWithTools is Chat with functions the model may call. The reply is
+// either an answer or a message asking for tool calls, whose results are
+// sent back as tool messages in a further request.
+func ChatWithTools(ctx context.Context, messages []ChatMessage, tools []Tool) (ChatMessage, error) {
+	// Tool calling needs a newer API version than plain chat
+	return completeMessage(ctx, ChatRequest{Messages: messages, Tools: tools}, "2024-02-01")
+}
+
+func complete(ctx context.Context, chatReq ChatRequest, apiVersion string) (string, error) {
+	message, err := completeMessage(ctx, chatReq, apiVersion)
+	return message.Content, err
+}
+
+func completeMessage(ctx context.Context, chatReq ChatRequest, apiVersion string) (ChatMessage, error) {
+	deployment := ChatDeploymentFor(ctx)
+	url := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", openaiEndpoint, deployment, apiVersion)
 
 	// Create the request body
-	reqBody, err := json.Marshal(ChatRequest{Messages: messages})
+	reqBody, err := json.Marshal(chatReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
 	// Create the HTTP request
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("api-key", openaiAPIKey)
@@ -67,7 +120,7 @@ func GetCompletion(ctx context.Context, query string, documents []string) (strin
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 	dump, err = httputil.DumpResponse(resp, true)
@@ -80,23 +133,126 @@ func GetCompletion(ctx context.Context, query string, documents []string) (strin
 	// Read and parse the response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-200 status code: %d - %s", resp.StatusCode, string(respBody))
+		return ChatMessage{}, fmt.Errorf("received non-200 status code: %d - %s", resp.StatusCode, string(respBody))
 	}
 
 	var chatResp ChatResponse
 	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response body: %w", err)
+		return ChatMessage{}, fmt.Errorf("failed to unmarshal response body: %w", err)
 	}
 
 	if len(chatResp.Choices) == 0 {
-		return "", fmt.Errorf("no choices in response")
+		return ChatMessage{}, fmt.Errorf("no choices in response")
 	}
 
-	return chatResp.Choices[0].Message.Content, nil
+	return chatResp.Choices[0].Message, nil
+}
+
+// EmbeddingRequest asks for the embeddings of several inputs at once
+type EmbeddingRequest struct {
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns the embedding of each input from the embedding deployment,
+// in the order of the inputs
+func Embed(ctx context.Context, inputs []string) ([][]float64, error) {
+	deployment := getEmbeddingDeploymentName()
+	if deployment == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_DEPLOYMENT_EMBEDDING is not set")
+	}
+	url := fmt.Sprintf("%s/openai/deployments/%s/embeddings?api-version=2023-05-15", openaiEndpoint, deployment)
+	reqBody, err := json.Marshal(EmbeddingRequest{Input: inputs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", openaiAPIKey)
+
+	// Embeddings are not dumped to the log, being long lists of numbers
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status code: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	var embResp EmbeddingResponse
+	if err := json.Unmarshal(respBody, &embResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+	embeddings := make([][]float64, len(inputs))
+	for _, d := range embResp.Data {
+		if d.Index >= 0 && d.Index < len(embeddings) {
+			embeddings[d.Index] = d.Embedding
+		}
+	}
+	for i, e := range embeddings {
+		if e == nil {
+			return nil, fmt.Errorf("no embedding for input %d in response", i)
+		}
+	}
+	return embeddings, nil
+}
+
+// EmbeddingDeployment returns the name of the embedding model deployment, or
+// "" when none is configured
+func EmbeddingDeployment() string {
+	return getEmbeddingDeploymentName()
+}
+
+// ChatDeployment returns the name of the chat model deployment, recorded
+// with the answers it produces
+func ChatDeployment() string {
+	return getOpenAIDeploymentName()
+}
+
+// ChatDeployments returns the chat deployments requests may choose from: the
+// default one first, then those listed in AZURE_OPENAI_DEPLOYMENTS_GPT
+func ChatDeployments() []string {
+	deployments := []string{getOpenAIDeploymentName()}
+	for _, d := range strings.Split(os.Getenv("AZURE_OPENAI_DEPLOYMENTS_GPT"), ",") {
+		if d = strings.TrimSpace(d); d != "" && !slices.Contains(deployments, d) {
+			deployments = append(deployments, d)
+		}
+	}
+	return deployments
+}
+
+type deploymentKey struct{}
+
+// WithChatDeployment makes the chat calls made with the returned context use
+// deployment instead of the default one
+func WithChatDeployment(ctx context.Context, deployment string) context.Context {
+	return context.WithValue(ctx, deploymentKey{}, deployment)
+}
+
+// ChatDeploymentFor returns the chat deployment that calls made with ctx use
+func ChatDeploymentFor(ctx context.Context) string {
+	if d, ok := ctx.Value(deploymentKey{}).(string); ok && d != "" {
+		return d
+	}
+	return getOpenAIDeploymentName()
 }
 
 func getOpenAIDeploymentName() string {
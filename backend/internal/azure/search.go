@@ -52,6 +52,70 @@ type SearchClient struct {
 	client *azsearchindex.DocumentsClient
 }
 
+// SearchResult is a single passage returned by the search index
+type SearchResult struct {
+	ID         string   `json:"id"`
+	DocumentID string   `json:"documentId"`
+	Title      string   `json:"title,omitempty"`
+	Content    string   `json:"content"`
+	EntityIDs  []string `json:"entityIds,omitempty"`
+	Page       int      `json:"page,omitempty"`
+	Measures   string   `json:"measures,omitempty"`  // e.g. "17-24" for a passage of a score
+	Timestamp  string   `json:"timestamp,omitempty"` // e.g. "03:15" for a transcript or recording
+	Score      float64  `json:"score"`
+}
+
+// Search runs a keyword query and returns the top matching passages.
+// Passages are expected to carry `id` and `content` fields; `documentId`, `title` and
+// `entityIds` are optional and link a passage to its source document and catalog entities.
+// The optional `page`, `measures` and `timestamp` fields locate a passage within its document.
+func (c *SearchClient) Search(ctx context.Context, query string, top int) ([]SearchResult, error) {
+	options := &azsearchindex.SearchOptions{}
+	if top > 0 {
+		t := int32(top)
+		options.Top = &t
+	}
+	results, err := c.client.SearchGet(ctx, &azsearchindex.DocumentsClientSearchGetOptions{
+		SearchText: &query,
+	}, options, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var passages []SearchResult
+	for _, result := range results.Results {
+		content, ok := result.AdditionalProperties["content"].(string)
+		if !ok {
+			continue
+		}
+		passage := SearchResult{Content: content}
+		passage.ID, _ = result.AdditionalProperties["id"].(string)
+		passage.DocumentID, _ = result.AdditionalProperties["documentId"].(string)
+		if passage.DocumentID == "" {
+			passage.DocumentID = passage.ID
+		}
+		passage.Title, _ = result.AdditionalProperties["title"].(string)
+		if ids, ok := result.AdditionalProperties["entityIds"].([]any); ok {
+			for _, id := range ids {
+				if s, ok := id.(string); ok {
+					passage.EntityIDs = append(passage.EntityIDs, s)
+				}
+			}
+		}
+		if page, ok := result.AdditionalProperties["page"].(float64); ok {
+			passage.Page = int(page)
+		}
+		passage.Measures, _ = result.AdditionalProperties["measures"].(string)
+		passage.Timestamp, _ = result.AdditionalProperties["timestamp"].(string)
+		if result.Score != nil {
+			passage.Score = *result.Score
+		}
+		passages = append(passages, passage)
+	}
+
+	return passages, nil
+}
+
 func (c *SearchClient) Query(ctx context.Context, query string) ([]string, error) {
 	results, err := c.client.SearchGet(ctx, &azsearchindex.DocumentsClientSearchGetOptions{
 		SearchText: &query,
@@ -0,0 +1,242 @@
+package catalog
+
+import (
+	"log"
+	"os"
+	"slices"
+	"sort"
+)
+
+var (
+	// StoreInstance is the process-wide catalog store
+	StoreInstance *MemoryStore
+
+	// snapshotPath is where the catalog is persisted, empty when persistence is disabled
+	snapshotPath string
+)
+
+// Init creates the catalog store and restores it from CATALOG_SNAPSHOT_PATH if set
+func Init() {
+	StoreInstance = NewMemoryStore()
+	snapshotPath = os.Getenv("CATALOG_SNAPSHOT_PATH")
+	if snapshotPath == "" {
+		log.Println("CATALOG_SNAPSHOT_PATH not set, catalog will not be persisted")
+		return
+	}
+
+	f, err := os.Open(snapshotPath)
+	if os.IsNotExist(err) {
+		log.Printf("No catalog snapshot at %s, starting empty", snapshotPath)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to open catalog snapshot: %v", err)
+	}
+	defer f.Close()
+	if err := StoreInstance.Load(f); err != nil {
+		log.Fatalf("Failed to load catalog snapshot: %v", err)
+	}
+	_, total := StoreInstance.ListEntities(ListFilter{Limit: 1})
+	log.Printf("Catalog loaded with %d entities", total)
+}
+
+// Persist writes the catalog snapshot if persistence is enabled
+func Persist() error {
+	if snapshotPath == "" || StoreInstance == nil {
+		return nil
+	}
+	return StoreInstance.Save(snapshotPath)
+}
+
+// AggregateHits groups retrieved documents by the catalog entities they are
+// linked to. Recordings that are a performance of a known work are rolled up
+// to that work so results are grouped per song rather than per recording.
+// Aggregates are ordered by the summed score of their documents.
+func AggregateHits(store Store, hits []DocumentHit) []Aggregate {
+	type group struct {
+		aggregate Aggregate
+		seen      map[string]bool
+	}
+	groups := make(map[string]*group)
+	var order []string
+
+	for _, hit := range hits {
+		entityIDs := append(store.DocumentEntities(hit.DocumentID), hit.EntityIDs...)
+		counted := make(map[string]bool)
+		for _, id := range entityIDs {
+			id = songOf(store, id)
+			if counted[id] {
+				continue
+			}
+			counted[id] = true
+
+			g, ok := groups[id]
+			if !ok {
+				entity, err := store.GetEntity(id)
+				if err != nil {
+					continue
+				}
+				g = &group{aggregate: Aggregate{Entity: entity}, seen: make(map[string]bool)}
+				groups[id] = g
+				order = append(order, id)
+			}
+			g.aggregate.Score += hit.Score
+			if !g.seen[hit.DocumentID] {
+				g.seen[hit.DocumentID] = true
+				g.aggregate.DocumentIDs = append(g.aggregate.DocumentIDs, hit.DocumentID)
+			}
+		}
+	}
+
+	aggregates := make([]Aggregate, 0, len(order))
+	for _, id := range order {
+		aggregates = append(aggregates, groups[id].aggregate)
+	}
+	sort.SliceStable(aggregates, func(i, j int) bool {
+		return aggregates[i].Score > aggregates[j].Score
+	})
+	return aggregates
+}
+
+// songOf maps a recording to the work it performs, leaving other entities unchanged
+func songOf(store Store, entityID string) string {
+	for _, r := range store.ListRelations(entityID) {
+		if r.Type == RelRecordingOf && r.SourceID == entityID {
+			return r.TargetID
+		}
+	}
+	return entityID
+}
+
+// MergeInto folds incoming into existing: non-empty scalar fields of incoming
+// win, while aliases and identifiers are unioned so data from other sources is
+// kept. The entity types must match.
+func MergeInto(existing, incoming *Entity) {
+	merge(existing, incoming, true)
+}
+
+// FillMissing folds incoming into existing like MergeInto, but never
+// overwrites a value that is already set on existing. It is used when a less
+// authoritative source is matched to an existing entity.
+func FillMissing(existing, incoming *Entity) {
+	merge(existing, incoming, false)
+}
+
+func merge(existing, incoming *Entity, overwrite bool) {
+	pick := func(in, ex string) string {
+		if overwrite {
+			return firstNonEmpty(in, ex)
+		}
+		return firstNonEmpty(ex, in)
+	}
+
+	if name := pick(incoming.Name, existing.Name); name != existing.Name {
+		existing.Aliases = appendUnique(existing.Aliases, existing.Name)
+		existing.Name = name
+	}
+	existing.SortName = pick(incoming.SortName, existing.SortName)
+	existing.Disambiguation = pick(incoming.Disambiguation, existing.Disambiguation)
+	for _, alias := range append([]string{incoming.Name}, incoming.Aliases...) {
+		if alias != existing.Name {
+			existing.Aliases = appendUnique(existing.Aliases, alias)
+		}
+	}
+
+	ids := &existing.Identifiers
+	ids.MBID = firstNonEmpty(ids.MBID, incoming.Identifiers.MBID)
+	ids.Discogs = firstNonEmpty(ids.Discogs, incoming.Identifiers.Discogs)
+	for _, v := range incoming.Identifiers.ISRCs {
+		ids.ISRCs = appendUnique(ids.ISRCs, v)
+	}
+	for _, v := range incoming.Identifiers.ISWCs {
+		ids.ISWCs = appendUnique(ids.ISWCs, v)
+	}
+	for _, v := range incoming.Identifiers.Barcodes {
+		ids.Barcodes = appendUnique(ids.Barcodes, v)
+	}
+
+	switch {
+	case incoming.Artist != nil:
+		if existing.Artist == nil {
+			existing.Artist = &ArtistInfo{}
+		}
+		a, in := existing.Artist, incoming.Artist
+		a.Kind = pick(in.Kind, a.Kind)
+		a.Country = pick(in.Country, a.Country)
+		a.BeginDate = pick(in.BeginDate, a.BeginDate)
+		a.EndDate = pick(in.EndDate, a.EndDate)
+	case incoming.Work != nil:
+		if existing.Work == nil {
+			existing.Work = &WorkInfo{}
+		}
+		w, in := existing.Work, incoming.Work
+		w.Kind = pick(in.Kind, w.Kind)
+		w.Language = pick(in.Language, w.Language)
+	case incoming.Recording != nil:
+		if existing.Recording == nil {
+			existing.Recording = &RecordingInfo{}
+		}
+		r, in := existing.Recording, incoming.Recording
+		if in.DurationMs > 0 && (overwrite || r.DurationMs == 0) {
+			r.DurationMs = in.DurationMs
+		}
+		r.Key = pick(in.Key, r.Key)
+		if in.BPM > 0 && (overwrite || r.BPM == 0) {
+			r.BPM = in.BPM
+		}
+		if in.Energy > 0 && (overwrite || r.Energy == 0) {
+			r.Energy = in.Energy
+		}
+		if in.Rating > 0 && (overwrite || r.Rating == 0) {
+			r.Rating = in.Rating
+		}
+		if len(in.BeatGrid) > 0 && (overwrite || len(r.BeatGrid) == 0) {
+			r.BeatGrid = append([]BeatMarker(nil), in.BeatGrid...)
+		}
+		if len(in.Cues) > 0 && (overwrite || len(r.Cues) == 0) {
+			r.Cues = append([]CuePoint(nil), in.Cues...)
+		}
+		if in.Loudness != 0 && (overwrite || r.Loudness == 0) {
+			r.Loudness = in.Loudness
+		}
+		if in.Spectral != nil && (overwrite || r.Spectral == nil) {
+			spectral := *in.Spectral
+			spectral.MFCC = append([]float64(nil), in.Spectral.MFCC...)
+			r.Spectral = &spectral
+		}
+	case incoming.Release != nil:
+		if existing.Release == nil {
+			existing.Release = &ReleaseInfo{}
+		}
+		r, in := existing.Release, incoming.Release
+		r.Kind = pick(in.Kind, r.Kind)
+		r.Date = pick(in.Date, r.Date)
+		r.Country = pick(in.Country, r.Country)
+		r.Label = pick(in.Label, r.Label)
+		r.CatalogNumber = pick(in.CatalogNumber, r.CatalogNumber)
+		r.Format = pick(in.Format, r.Format)
+	case incoming.Label != nil:
+		if existing.Label == nil {
+			existing.Label = &LabelInfo{}
+		}
+		l, in := existing.Label, incoming.Label
+		l.Profile = pick(in.Profile, l.Profile)
+		l.ContactInfo = pick(in.ContactInfo, l.ContactInfo)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func appendUnique(values []string, v string) []string {
+	if v == "" || slices.Contains(values, v) {
+		return values
+	}
+	return append(values, v)
+}
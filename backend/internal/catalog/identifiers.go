@@ -0,0 +1,198 @@
+package catalog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Identifier schemes accepted by FindByIdentifier
+const (
+	SchemeMBID    = "mbid"
+	SchemeISRC    = "isrc"
+	SchemeISWC    = "iswc"
+	SchemeBarcode = "barcode"
+	SchemeDiscogs = "discogs"
+)
+
+var (
+	isrcPattern    = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+	iswcPattern    = regexp.MustCompile(`^T[0-9]{10}$`)
+	mbidPattern    = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	discogsPattern = regexp.MustCompile(`^(artist|label|master|release)/[1-9][0-9]*$`)
+)
+
+// NormalizeISRC validates an ISRC and returns it in compact form (e.g. USRC17607839)
+func NormalizeISRC(s string) (string, error) {
+	v := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+	if !isrcPattern.MatchString(v) {
+		return "", fmt.Errorf("%w: malformed ISRC %q", ErrInvalid, s)
+	}
+	return v, nil
+}
+
+// NormalizeISWC validates an ISWC including its check digit and returns it in
+// compact form (e.g. T0345246801)
+func NormalizeISWC(s string) (string, error) {
+	v := strings.ToUpper(strings.NewReplacer("-", "", ".", "", " ", "").Replace(strings.TrimSpace(s)))
+	if !iswcPattern.MatchString(v) {
+		return "", fmt.Errorf("%w: malformed ISWC %q", ErrInvalid, s)
+	}
+	sum := 1
+	for i := 1; i <= 9; i++ {
+		sum += int(v[i]-'0') * i
+	}
+	if check := (10 - sum%10) % 10; int(v[10]-'0') != check {
+		return "", fmt.Errorf("%w: bad ISWC check digit in %q", ErrInvalid, s)
+	}
+	return v, nil
+}
+
+// NormalizeBarcode validates a UPC-A, EAN-13 or EAN-8 barcode including its
+// GTIN check digit and returns the bare digits
+func NormalizeBarcode(s string) (string, error) {
+	v := strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s))
+	if len(v) != 8 && len(v) != 12 && len(v) != 13 {
+		return "", fmt.Errorf("%w: barcode %q must have 8, 12 or 13 digits", ErrInvalid, s)
+	}
+	sum := 0
+	for i := 0; i < len(v); i++ {
+		d := v[i]
+		if d < '0' || d > '9' {
+			return "", fmt.Errorf("%w: barcode %q must be numeric", ErrInvalid, s)
+		}
+		if i == len(v)-1 {
+			break
+		}
+		// Weights alternate 3,1,3,... counting from the digit left of the check digit
+		weight := 1
+		if (len(v)-2-i)%2 == 0 {
+			weight = 3
+		}
+		sum += int(d-'0') * weight
+	}
+	if check := (10 - sum%10) % 10; int(v[len(v)-1]-'0') != check {
+		return "", fmt.Errorf("%w: bad barcode check digit in %q", ErrInvalid, s)
+	}
+	return v, nil
+}
+
+// NormalizeMBID validates a MusicBrainz identifier and returns it lower-cased
+func NormalizeMBID(s string) (string, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if !mbidPattern.MatchString(v) {
+		return "", fmt.Errorf("%w: malformed MBID %q", ErrInvalid, s)
+	}
+	return v, nil
+}
+
+// NormalizeDiscogs validates a Discogs identifier of the form <type>/<id>, e.g. master/5427
+func NormalizeDiscogs(s string) (string, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if !discogsPattern.MatchString(v) {
+		return "", fmt.Errorf("%w: malformed Discogs identifier %q", ErrInvalid, s)
+	}
+	return v, nil
+}
+
+// NormalizeIdentifier normalizes a value for the given scheme
+func NormalizeIdentifier(scheme, value string) (string, error) {
+	switch strings.ToLower(scheme) {
+	case SchemeMBID:
+		return NormalizeMBID(value)
+	case SchemeISRC:
+		return NormalizeISRC(value)
+	case SchemeISWC:
+		return NormalizeISWC(value)
+	case SchemeBarcode, "upc", "ean":
+		return NormalizeBarcode(value)
+	case SchemeDiscogs:
+		return NormalizeDiscogs(value)
+	}
+	return "", fmt.Errorf("%w: unknown identifier scheme %q", ErrInvalid, scheme)
+}
+
+// identifierKey builds the lookup key used by stores for a normalized identifier
+func identifierKey(scheme, value string) string {
+	if scheme == "upc" || scheme == "ean" {
+		scheme = SchemeBarcode
+	}
+	return strings.ToLower(scheme) + ":" + value
+}
+
+// identifierKeys returns the lookup keys of every identifier on the entity
+func (e *Entity) identifierKeys() []string {
+	var keys []string
+	if e.Identifiers.MBID != "" {
+		keys = append(keys, identifierKey(SchemeMBID, e.Identifiers.MBID))
+	}
+	for _, v := range e.Identifiers.ISRCs {
+		keys = append(keys, identifierKey(SchemeISRC, v))
+	}
+	for _, v := range e.Identifiers.ISWCs {
+		keys = append(keys, identifierKey(SchemeISWC, v))
+	}
+	for _, v := range e.Identifiers.Barcodes {
+		keys = append(keys, identifierKey(SchemeBarcode, v))
+	}
+	if e.Identifiers.Discogs != "" {
+		keys = append(keys, identifierKey(SchemeDiscogs, e.Identifiers.Discogs))
+	}
+	return keys
+}
+
+// normalizeIdentifiers validates and normalizes all identifiers in place and
+// checks that each scheme is allowed for the entity type
+func (e *Entity) normalizeIdentifiers() error {
+	ids := &e.Identifiers
+	if ids.MBID != "" {
+		v, err := NormalizeMBID(ids.MBID)
+		if err != nil {
+			return err
+		}
+		ids.MBID = v
+	}
+	if ids.Discogs != "" {
+		v, err := NormalizeDiscogs(ids.Discogs)
+		if err != nil {
+			return err
+		}
+		ids.Discogs = v
+	}
+	if len(ids.ISRCs) > 0 && e.Type != TypeRecording {
+		return fmt.Errorf("%w: ISRCs are only allowed on recordings", ErrInvalid)
+	}
+	if len(ids.ISWCs) > 0 && e.Type != TypeWork {
+		return fmt.Errorf("%w: ISWCs are only allowed on works", ErrInvalid)
+	}
+	if len(ids.Barcodes) > 0 && e.Type != TypeRelease {
+		return fmt.Errorf("%w: barcodes are only allowed on releases", ErrInvalid)
+	}
+	var err error
+	if ids.ISRCs, err = normalizeAll(ids.ISRCs, NormalizeISRC); err != nil {
+		return err
+	}
+	if ids.ISWCs, err = normalizeAll(ids.ISWCs, NormalizeISWC); err != nil {
+		return err
+	}
+	if ids.Barcodes, err = normalizeAll(ids.Barcodes, NormalizeBarcode); err != nil {
+		return err
+	}
+	return nil
+}
+
+func normalizeAll(values []string, normalize func(string) (string, error)) ([]string, error) {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		n, err := normalize(v)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package catalog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	camelotPattern = regexp.MustCompile(`^(1[0-2]|[1-9])([AB])$`)
+	openKeyPattern = regexp.MustCompile(`^(1[0-2]|[1-9])([MD])$`)
+	notePattern    = regexp.MustCompile(`^([A-G])([#♯B♭]?)\s*(M|MIN|MINOR|MAJ|MAJOR)?$`)
+
+	pitchClasses = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+	// majorNames and minorNames are the conventional names of the Camelot
+	// keys, indexed by number - 1
+	majorNames = [12]string{"B", "F#", "Db", "Ab", "Eb", "Bb", "F", "C", "G", "D", "A", "E"}
+	minorNames = [12]string{"G#m", "Ebm", "Bbm", "Fm", "Cm", "Gm", "Dm", "Am", "Em", "Bm", "F#m", "C#m"}
+)
+
+// Key is a position on the Camelot wheel: numbers 1 to 12 follow the circle
+// of fifths, minor keys are A and major keys B
+type Key struct {
+	Number int
+	Minor  bool
+}
+
+// ParseKey reads a key written as a Camelot code ("8A"), an Open Key code
+// ("1m") or a note name with an optional mode ("A minor", "F#m", "Bb")
+func ParseKey(s string) (Key, error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	if m := camelotPattern.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return Key{Number: n, Minor: m[2] == "A"}, nil
+	}
+	if m := openKeyPattern.FindStringSubmatch(text); m != nil {
+		// Open Key 1d is C major, which is Camelot 8B
+		n, _ := strconv.Atoi(m[1])
+		return Key{Number: (n+6)%12 + 1, Minor: m[2] == "M"}, nil
+	}
+
+	// "m" alone means minor and "M" major, so the mode is read before upper-casing
+	raw := strings.TrimSpace(s)
+	minor := strings.HasSuffix(raw, "m")
+	m := notePattern.FindStringSubmatch(text)
+	if m == nil {
+		return Key{}, fmt.Errorf("unrecognized key %q", s)
+	}
+	switch m[3] {
+	case "MIN", "MINOR":
+		minor = true
+	case "MAJ", "MAJOR":
+		minor = false
+	}
+	pc := pitchClasses[m[1][0]]
+	switch m[2] {
+	case "#", "♯":
+		pc++
+	case "B", "♭":
+		pc--
+	}
+	pc = (pc + 12) % 12
+	if minor {
+		// A minor key shares its number with its relative major, three semitones up
+		pc = (pc + 3) % 12
+	}
+	return Key{Number: (pc*7%12+7)%12 + 1, Minor: minor}, nil
+}
+
+// Camelot returns the Camelot code, e.g. 8A
+func (k Key) Camelot() string {
+	if k.Minor {
+		return strconv.Itoa(k.Number) + "A"
+	}
+	return strconv.Itoa(k.Number) + "B"
+}
+
+// Name returns the conventional key name, e.g. Am
+func (k Key) Name() string {
+	if k.Minor {
+		return minorNames[k.Number-1]
+	}
+	return majorNames[k.Number-1]
+}
+
+// Compatible reports whether k mixes harmonically with o: the same key, its
+// relative major or minor, or a step either way around the Camelot wheel
+func (k Key) Compatible(o Key) bool {
+	step := (o.Number - k.Number + 12) % 12
+	return step == 0 || (k.Minor == o.Minor && (step == 1 || step == 11))
+}
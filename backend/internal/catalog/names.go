@@ -0,0 +1,129 @@
+package catalog
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// NameMatch is a candidate entity for a name lookup with its similarity in [0,1]
+type NameMatch struct {
+	Entity *Entity `json:"entity"`
+	Score  float64 `json:"score"`
+}
+
+var (
+	// discogsSuffix matches the numeric disambiguation Discogs appends to duplicate names, e.g. "Nirvana (2)"
+	discogsSuffix = regexp.MustCompile(`\s+\(\d+\)$`)
+
+	// foldReplacer maps common accented Latin letters to their ASCII base letter
+	foldReplacer = strings.NewReplacer(
+		"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ā", "a",
+		"ç", "c", "č", "c", "ć", "c",
+		"è", "e", "é", "e", "ê", "e", "ë", "e", "ē", "e", "ě", "e",
+		"ì", "i", "í", "i", "î", "i", "ï", "i", "ī", "i",
+		"ñ", "n", "ń", "n", "ň", "n",
+		"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "ō", "o",
+		"ù", "u", "ú", "u", "û", "u", "ü", "u", "ū", "u", "ů", "u",
+		"ý", "y", "ÿ", "y",
+		"š", "s", "ś", "s", "ß", "ss",
+		"ž", "z", "ź", "z", "ż", "z",
+		"ł", "l", "ř", "r", "đ", "d", "æ", "ae", "œ", "oe",
+		"&", " and ", "+", " and ",
+	)
+)
+
+// NameKey reduces a name to a canonical form for matching: lower case,
+// accents folded, punctuation dropped, a leading or trailing "the" removed and
+// Discogs duplicate suffixes stripped. "The Beatles", "Beatles, The" and
+// "beatles" all share the key "beatles".
+func NameKey(name string) string {
+	s := discogsSuffix.ReplaceAllString(strings.TrimSpace(name), "")
+	s = foldReplacer.Replace(strings.ToLower(s))
+	tokens := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(tokens) > 1 && tokens[0] == "the" {
+		tokens = tokens[1:]
+	} else if len(tokens) > 1 && tokens[len(tokens)-1] == "the" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return strings.Join(tokens, " ")
+}
+
+// NameSimilarity returns the Jaro-Winkler similarity of the name keys of a and b
+func NameSimilarity(a, b string) float64 {
+	return jaroWinkler(NameKey(a), NameKey(b))
+}
+
+// jaroWinkler computes the Jaro-Winkler similarity of two strings
+func jaroWinkler(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	window := max(len(ra), len(rb))/2 - 1
+	window = max(window, 0)
+
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		lo, hi := max(0, i-window), min(len(rb), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && ra[i] == rb[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// nameBlock returns the blocking key used to limit fuzzy comparisons to names
+// sharing their first two characters
+func nameBlock(key string) string {
+	r := []rune(key)
+	return string(r[:min(2, len(r))])
+}
+
+// nameKeys returns the distinct name keys of an entity's name and aliases
+func (e *Entity) nameKeys() []string {
+	var keys []string
+	for _, n := range append([]string{e.Name}, e.Aliases...) {
+		if k := NameKey(n); k != "" {
+			keys = appendUnique(keys, k)
+		}
+	}
+	return keys
+}
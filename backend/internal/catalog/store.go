@@ -0,0 +1,636 @@
+package catalog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists catalog entities, their relations and document links
+type Store interface {
+	CreateEntity(e *Entity) (*Entity, error)
+	GetEntity(id string) (*Entity, error)
+	UpdateEntity(e *Entity) (*Entity, error)
+	DeleteEntity(id string) error
+	ListEntities(filter ListFilter) ([]*Entity, int)
+	CountEntities() map[EntityType]int
+	FindByIdentifier(scheme, value string) (*Entity, error)
+	FindByName(typ EntityType, name string, minScore float64) []NameMatch
+
+	CreateRelation(r *Relation) (*Relation, error)
+	DeleteRelation(id string) error
+	ListRelations(entityID string) []*Relation
+
+	LinkDocument(documentID string, entityIDs []string) error
+	DocumentEntities(documentID string) []string
+	EntityDocuments(entityID string) []string
+}
+
+// MemoryStore is an in-memory Store that can be snapshotted to a JSON file
+type MemoryStore struct {
+	mu          sync.RWMutex
+	entities    map[string]*Entity
+	identifiers map[string]string          // identifier key -> entity ID
+	names       map[string][]string        // name key -> entity IDs
+	nameBlocks  map[string]map[string]bool // block -> name keys
+	relations   map[string]*Relation
+	byEntity    map[string][]string // entity ID -> relation IDs
+	docEntities map[string][]string // document ID -> entity IDs
+	entityDocs  map[string][]string // entity ID -> document IDs
+
+	subscribers []ChangeFunc
+}
+
+// ChangeFunc is notified after an entity is created, updated or deleted, or
+// gains or loses a relation or document link
+type ChangeFunc func(e *Entity, deleted bool)
+
+// snapshot is the on-disk representation of a MemoryStore
+type snapshot struct {
+	Entities  []*Entity           `json:"entities"`
+	Relations []*Relation         `json:"relations"`
+	Documents map[string][]string `json:"documents"`
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entities:    make(map[string]*Entity),
+		identifiers: make(map[string]string),
+		names:       make(map[string][]string),
+		nameBlocks:  make(map[string]map[string]bool),
+		relations:   make(map[string]*Relation),
+		byEntity:    make(map[string][]string),
+		docEntities: make(map[string][]string),
+		entityDocs:  make(map[string][]string),
+	}
+}
+
+// newID returns a random identifier with the given prefix
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate id: %v", err))
+	}
+	return prefix + "_" + hex.EncodeToString(b)
+}
+
+var idPrefixes = map[EntityType]string{
+	TypeArtist:    "art",
+	TypeWork:      "wrk",
+	TypeRecording: "rec",
+	TypeRelease:   "rel",
+	TypeLabel:     "lbl",
+}
+
+// validate checks the entity shape and normalizes its identifiers
+func (e *Entity) validate() error {
+	if _, ok := idPrefixes[e.Type]; !ok {
+		return fmt.Errorf("%w: unknown entity type %q", ErrInvalid, e.Type)
+	}
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	details := map[EntityType]bool{
+		TypeArtist:    e.Artist != nil,
+		TypeWork:      e.Work != nil,
+		TypeRecording: e.Recording != nil,
+		TypeRelease:   e.Release != nil,
+		TypeLabel:     e.Label != nil,
+	}
+	for t, set := range details {
+		if set && t != e.Type {
+			return fmt.Errorf("%w: %s details are not allowed on a %s", ErrInvalid, t, e.Type)
+		}
+	}
+	if r := e.Recording; r != nil && (r.BPM < 0 || r.Energy < 0 || r.Energy > 1) {
+		return fmt.Errorf("%w: bpm must be positive and energy between 0 and 1", ErrInvalid)
+	}
+	if r := e.Recording; r != nil && (r.Rating < 0 || r.Rating > 5) {
+		return fmt.Errorf("%w: rating must be between 0 and 5 stars", ErrInvalid)
+	}
+	if r := e.Recording; r != nil && r.Spectral != nil {
+		if f := r.Spectral; f.CentroidHz < 0 || f.RolloffHz < 0 || f.Flatness < 0 || f.Flatness > 1 {
+			return fmt.Errorf("%w: spectral frequencies must be positive and flatness between 0 and 1", ErrInvalid)
+		}
+	}
+	return e.normalizeIdentifiers()
+}
+
+func (s *MemoryStore) CreateEntity(e *Entity) (*Entity, error) {
+	e = e.Clone()
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.ID == "" {
+		e.ID = newID(idPrefixes[e.Type])
+	} else if _, exists := s.entities[e.ID]; exists {
+		return nil, fmt.Errorf("%w: entity %s already exists", ErrConflict, e.ID)
+	}
+	if err := s.checkIdentifiers(e); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = now
+	}
+	e.UpdatedAt = now
+	s.put(e)
+	s.notify(e, false)
+	return e.Clone(), nil
+}
+
+func (s *MemoryStore) GetEntity(id string) (*Entity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.entities[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e.Clone(), nil
+}
+
+func (s *MemoryStore) UpdateEntity(e *Entity) (*Entity, error) {
+	e = e.Clone()
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.entities[e.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if existing.Type != e.Type {
+		return nil, fmt.Errorf("%w: entity type cannot change", ErrInvalid)
+	}
+	if err := s.checkIdentifiers(e); err != nil {
+		return nil, err
+	}
+	s.remove(existing)
+	e.CreatedAt = existing.CreatedAt
+	e.UpdatedAt = time.Now().UTC()
+	s.put(e)
+	s.notify(e, false)
+	return e.Clone(), nil
+}
+
+// DeleteEntity removes an entity together with its relations and document links
+func (s *MemoryStore) DeleteEntity(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entities[id]
+	if !ok {
+		return ErrNotFound
+	}
+	var neighbors []string
+	for _, relID := range slices.Clone(s.byEntity[id]) {
+		r := s.relations[relID]
+		neighbors = append(neighbors, r.SourceID, r.TargetID)
+		s.removeRelation(relID)
+	}
+	for _, docID := range s.entityDocs[id] {
+		s.docEntities[docID] = slices.DeleteFunc(s.docEntities[docID], func(v string) bool { return v == id })
+		if len(s.docEntities[docID]) == 0 {
+			delete(s.docEntities, docID)
+		}
+	}
+	delete(s.entityDocs, id)
+	s.remove(e)
+	s.notify(e, true)
+	s.notifyIDs(slices.DeleteFunc(neighbors, func(v string) bool { return v == id }))
+	return nil
+}
+
+// ListEntities returns a page of entities sorted by name along with the total match count
+func (s *MemoryStore) ListEntities(filter ListFilter) ([]*Entity, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := strings.ToLower(strings.TrimSpace(filter.Query))
+	var key *Key
+	if filter.Key != "" {
+		// An unrecognized key matches nothing rather than everything
+		parsed, err := ParseKey(filter.Key)
+		if err != nil {
+			return []*Entity{}, 0
+		}
+		key = &parsed
+	}
+	var matches []*Entity
+	for _, e := range s.entities {
+		if filter.Type != "" && e.Type != filter.Type {
+			continue
+		}
+		if query != "" && !e.matchesName(query) {
+			continue
+		}
+		if filter.recordingsOnly() && !e.matchesRecording(filter, key) {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Name != matches[j].Name {
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	total := len(matches)
+	start := min(max(filter.Offset, 0), total)
+	end := total
+	if filter.Limit > 0 {
+		end = min(start+filter.Limit, total)
+	}
+	page := make([]*Entity, 0, end-start)
+	for _, e := range matches[start:end] {
+		page = append(page, e.Clone())
+	}
+	return page, total
+}
+
+// CountEntities returns the number of entities of each type
+func (s *MemoryStore) CountEntities() map[EntityType]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[EntityType]int)
+	for _, e := range s.entities {
+		counts[e.Type]++
+	}
+	return counts
+}
+
+// matchesRecording reports whether e is a recording within the tempo, key
+// and rating bounds of filter
+func (e *Entity) matchesRecording(filter ListFilter, key *Key) bool {
+	r := e.Recording
+	if e.Type != TypeRecording || r == nil {
+		return false
+	}
+	if (filter.MinBPM > 0 && r.BPM < filter.MinBPM) || (filter.MaxBPM > 0 && (r.BPM == 0 || r.BPM > filter.MaxBPM)) {
+		return false
+	}
+	if r.Rating < filter.MinRating {
+		return false
+	}
+	if key != nil {
+		k, err := ParseKey(r.Key)
+		return err == nil && k == *key
+	}
+	return true
+}
+
+func (e *Entity) matchesName(query string) bool {
+	if strings.Contains(strings.ToLower(e.Name), query) {
+		return true
+	}
+	for _, alias := range e.Aliases {
+		if strings.Contains(strings.ToLower(alias), query) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) FindByIdentifier(scheme, value string) (*Entity, error) {
+	normalized, err := NormalizeIdentifier(scheme, value)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.identifiers[identifierKey(scheme, normalized)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.entities[id].Clone(), nil
+}
+
+// FindByName returns entities whose name or an alias is similar to name,
+// best match first. An empty typ matches every entity type.
+func (s *MemoryStore) FindByName(typ EntityType, name string, minScore float64) []NameMatch {
+	key := NameKey(name)
+	if key == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	best := make(map[string]float64)
+	for candidate := range s.nameBlocks[nameBlock(key)] {
+		score := 1.0
+		if candidate != key {
+			score = jaroWinkler(key, candidate)
+		}
+		if score < minScore {
+			continue
+		}
+		for _, id := range s.names[candidate] {
+			if typ != "" && s.entities[id].Type != typ {
+				continue
+			}
+			best[id] = max(best[id], score)
+		}
+	}
+
+	matches := make([]NameMatch, 0, len(best))
+	for id, score := range best {
+		matches = append(matches, NameMatch{Entity: s.entities[id].Clone(), Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Entity.ID < matches[j].Entity.ID
+	})
+	return matches
+}
+
+func (s *MemoryStore) CreateRelation(r *Relation) (*Relation, error) {
+	r = r.Clone()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints, ok := relationEndpoints[r.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown relation type %q", ErrInvalid, r.Type)
+	}
+	source, ok := s.entities[r.SourceID]
+	if !ok {
+		return nil, fmt.Errorf("%w: source %s", ErrNotFound, r.SourceID)
+	}
+	target, ok := s.entities[r.TargetID]
+	if !ok {
+		return nil, fmt.Errorf("%w: target %s", ErrNotFound, r.TargetID)
+	}
+	if !slices.Contains(endpoints.sources, source.Type) || !slices.Contains(endpoints.targets, target.Type) {
+		return nil, fmt.Errorf("%w: %s cannot link a %s to a %s", ErrInvalid, r.Type, source.Type, target.Type)
+	}
+	// Relations are idempotent on (type, source, target)
+	for _, relID := range s.byEntity[r.SourceID] {
+		if existing := s.relations[relID]; existing.Type == r.Type && existing.SourceID == r.SourceID && existing.TargetID == r.TargetID {
+			return existing.Clone(), nil
+		}
+	}
+
+	if r.ID == "" {
+		r.ID = newID("rln")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	s.relations[r.ID] = r
+	s.byEntity[r.SourceID] = append(s.byEntity[r.SourceID], r.ID)
+	s.byEntity[r.TargetID] = append(s.byEntity[r.TargetID], r.ID)
+	s.notifyIDs([]string{r.SourceID, r.TargetID})
+	return r.Clone(), nil
+}
+
+func (s *MemoryStore) DeleteRelation(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.relations[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.removeRelation(id)
+	s.notifyIDs([]string{r.SourceID, r.TargetID})
+	return nil
+}
+
+// ListRelations returns every relation in which the entity is source or target
+func (s *MemoryStore) ListRelations(entityID string) []*Relation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	relations := make([]*Relation, 0, len(s.byEntity[entityID]))
+	for _, relID := range s.byEntity[entityID] {
+		relations = append(relations, s.relations[relID].Clone())
+	}
+	return relations
+}
+
+// LinkDocument replaces the set of entities an ingested document is linked to
+func (s *MemoryStore) LinkDocument(documentID string, entityIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range entityIDs {
+		if _, ok := s.entities[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+	}
+	// Entities that were linked before and those linked now both change
+	changed := slices.Concat(s.docEntities[documentID], entityIDs)
+	for _, id := range s.docEntities[documentID] {
+		s.entityDocs[id] = slices.DeleteFunc(s.entityDocs[id], func(v string) bool { return v == documentID })
+		if len(s.entityDocs[id]) == 0 {
+			delete(s.entityDocs, id)
+		}
+	}
+	delete(s.docEntities, documentID)
+
+	for _, id := range entityIDs {
+		if slices.Contains(s.docEntities[documentID], id) {
+			continue
+		}
+		s.docEntities[documentID] = append(s.docEntities[documentID], id)
+		s.entityDocs[id] = append(s.entityDocs[id], documentID)
+	}
+	s.notifyIDs(changed)
+	return nil
+}
+
+func (s *MemoryStore) DocumentEntities(documentID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.docEntities[documentID])
+}
+
+func (s *MemoryStore) EntityDocuments(entityID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.entityDocs[entityID])
+}
+
+// Save writes a JSON snapshot of the store to path, replacing it atomically
+func (s *MemoryStore) Save(path string) error {
+	s.mu.RLock()
+	snap := snapshot{Documents: make(map[string][]string, len(s.docEntities))}
+	for _, e := range s.entities {
+		snap.Entities = append(snap.Entities, e)
+	}
+	for _, r := range s.relations {
+		snap.Relations = append(snap.Relations, r)
+	}
+	for docID, ids := range s.docEntities {
+		snap.Documents[docID] = ids
+	}
+	data, err := json.Marshal(snap)
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal catalog snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".catalog-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create catalog snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write catalog snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write catalog snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load replaces the store contents with a snapshot previously written by Save
+func (s *MemoryStore) Load(r io.Reader) error {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to decode catalog snapshot: %w", err)
+	}
+
+	fresh := NewMemoryStore()
+	for _, e := range snap.Entities {
+		fresh.put(e)
+	}
+	for _, r := range snap.Relations {
+		fresh.relations[r.ID] = r
+		fresh.byEntity[r.SourceID] = append(fresh.byEntity[r.SourceID], r.ID)
+		fresh.byEntity[r.TargetID] = append(fresh.byEntity[r.TargetID], r.ID)
+	}
+	for docID, ids := range snap.Documents {
+		fresh.docEntities[docID] = ids
+		for _, id := range ids {
+			fresh.entityDocs[id] = append(fresh.entityDocs[id], docID)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entities = fresh.entities
+	s.identifiers = fresh.identifiers
+	s.names = fresh.names
+	s.nameBlocks = fresh.nameBlocks
+	s.relations = fresh.relations
+	s.byEntity = fresh.byEntity
+	s.docEntities = fresh.docEntities
+	s.entityDocs = fresh.entityDocs
+	return nil
+}
+
+// Subscribe registers fn to be called after every entity change, including
+// changes to its relations and document links. Callbacks run synchronously
+// while the store is locked and must not call back into the store.
+func (s *MemoryStore) Subscribe(fn ChangeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// notify passes a copy of e to every subscriber. Callers must hold the write lock.
+func (s *MemoryStore) notify(e *Entity, deleted bool) {
+	for _, fn := range s.subscribers {
+		fn(e.Clone(), deleted)
+	}
+}
+
+// notifyIDs notifies the subscribers of a change to each of the entities
+// once. Callers must hold the write lock.
+func (s *MemoryStore) notifyIDs(ids []string) {
+	slices.Sort(ids)
+	for _, id := range slices.Compact(ids) {
+		if e, ok := s.entities[id]; ok {
+			s.notify(e, false)
+		}
+	}
+}
+
+// checkIdentifiers fails if any identifier of e is owned by another entity.
+// Callers must hold the write lock.
+func (s *MemoryStore) checkIdentifiers(e *Entity) error {
+	for _, key := range e.identifierKeys() {
+		if owner, ok := s.identifiers[key]; ok && owner != e.ID {
+			return fmt.Errorf("%w: %s belongs to %s", ErrConflict, key, owner)
+		}
+	}
+	return nil
+}
+
+// put indexes an entity. Callers must hold the write lock.
+func (s *MemoryStore) put(e *Entity) {
+	s.entities[e.ID] = e
+	for _, key := range e.identifierKeys() {
+		s.identifiers[key] = e.ID
+	}
+	for _, key := range e.nameKeys() {
+		s.names[key] = append(s.names[key], e.ID)
+		block := nameBlock(key)
+		if s.nameBlocks[block] == nil {
+			s.nameBlocks[block] = make(map[string]bool)
+		}
+		s.nameBlocks[block][key] = true
+	}
+}
+
+// remove unindexes an entity. Callers must hold the write lock.
+func (s *MemoryStore) remove(e *Entity) {
+	for _, key := range e.identifierKeys() {
+		if s.identifiers[key] == e.ID {
+			delete(s.identifiers, key)
+		}
+	}
+	for _, key := range e.nameKeys() {
+		s.names[key] = slices.DeleteFunc(s.names[key], func(v string) bool { return v == e.ID })
+		if len(s.names[key]) == 0 {
+			delete(s.names, key)
+			block := nameBlock(key)
+			delete(s.nameBlocks[block], key)
+			if len(s.nameBlocks[block]) == 0 {
+				delete(s.nameBlocks, block)
+			}
+		}
+	}
+	delete(s.entities, e.ID)
+}
+
+// removeRelation deletes a relation and its adjacency entries. Callers must hold the write lock.
+func (s *MemoryStore) removeRelation(id string) {
+	r := s.relations[id]
+	for _, entityID := range []string{r.SourceID, r.TargetID} {
+		s.byEntity[entityID] = slices.DeleteFunc(s.byEntity[entityID], func(v string) bool { return v == id })
+		if len(s.byEntity[entityID]) == 0 {
+			delete(s.byEntity, entityID)
+		}
+	}
+	delete(s.relations, id)
+}
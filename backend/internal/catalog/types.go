@@ -0,0 +1,281 @@
+package catalog
+
+import (
+	"errors"
+	"time"
+)
+
+// EntityType identifies the kind of a catalog entity
+type EntityType string
+
+const (
+	TypeArtist    EntityType = "artist"
+	TypeWork      EntityType = "work"
+	TypeRecording EntityType = "recording"
+	TypeRelease   EntityType = "release"
+	TypeLabel     EntityType = "label"
+)
+
+// RelationType identifies the kind of a relation between two catalog entities
+type RelationType string
+
+const (
+	// RelPerformedBy links a recording or release to a performing artist
+	RelPerformedBy RelationType = "performed-by"
+	// RelComposedBy links a work to a composer or lyricist
+	RelComposedBy RelationType = "composed-by"
+	// RelAppearsOn links a recording to a release it appears on
+	RelAppearsOn RelationType = "appears-on"
+	// RelRecordingOf links a recording to the work it is a performance of
+	RelRecordingOf RelationType = "recording-of"
+	// RelReleasedBy links a release to its record label
+	RelReleasedBy RelationType = "released-by"
+	// RelCreditedTo links a recording or release to a non-performing
+	// contributor such as a producer or engineer; the role attribute names the credit
+	RelCreditedTo RelationType = "credited-to"
+	// RelEditionOf links a specific pressing of a release to its master release
+	RelEditionOf RelationType = "edition-of"
+	// RelMemberOf links an artist to a group it is or was a member of
+	RelMemberOf RelationType = "member-of"
+	// RelPlayedOn links a session or guest musician to a recording or release;
+	// the instrument attribute names what they played
+	RelPlayedOn RelationType = "played-on"
+	// RelProduced links a producer to a recording or release
+	RelProduced RelationType = "produced"
+	// RelSampled links a recording to a recording or work it samples
+	RelSampled RelationType = "sampled"
+	// RelCovered links a recording to the recording or work it is a cover of
+	RelCovered RelationType = "covered"
+)
+
+var (
+	ErrNotFound = errors.New("catalog entity not found")
+	ErrConflict = errors.New("catalog identifier already in use")
+	ErrInvalid  = errors.New("invalid catalog data")
+)
+
+// Identifiers holds the industry identifiers attached to an entity
+type Identifiers struct {
+	MBID     string   `json:"mbid,omitempty"`
+	ISRCs    []string `json:"isrcs,omitempty"`
+	ISWCs    []string `json:"iswcs,omitempty"`
+	Barcodes []string `json:"barcodes,omitempty"` // UPC-A, EAN-13 or EAN-8
+	Discogs  string   `json:"discogs,omitempty"`  // e.g. artist/1, master/5427
+}
+
+// Entity is a single artist, work, recording or release in the catalog.
+// Exactly one of the type-specific detail fields is set, matching Type.
+type Entity struct {
+	ID             string         `json:"id"`
+	Type           EntityType     `json:"type"`
+	Name           string         `json:"name"`
+	SortName       string         `json:"sortName,omitempty"`
+	Aliases        []string       `json:"aliases,omitempty"`
+	Disambiguation string         `json:"disambiguation,omitempty"`
+	Identifiers    Identifiers    `json:"identifiers"`
+	Artist         *ArtistInfo    `json:"artist,omitempty"`
+	Work           *WorkInfo      `json:"work,omitempty"`
+	Recording      *RecordingInfo `json:"recording,omitempty"`
+	Release        *ReleaseInfo   `json:"release,omitempty"`
+	Label          *LabelInfo     `json:"label,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+}
+
+// ArtistInfo holds artist-specific attributes
+type ArtistInfo struct {
+	Kind      string `json:"kind,omitempty"` // person, group, orchestra, choir...
+	Country   string `json:"country,omitempty"`
+	BeginDate string `json:"beginDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+}
+
+// WorkInfo holds attributes of a composition
+type WorkInfo struct {
+	Kind     string `json:"kind,omitempty"` // song, symphony, opera...
+	Language string `json:"language,omitempty"`
+}
+
+// RecordingInfo holds attributes of a specific recorded performance
+type RecordingInfo struct {
+	DurationMs int64   `json:"durationMs,omitempty"`
+	Key        string  `json:"key,omitempty"` // musical key such as "A minor", "F#m" or Camelot "8A"
+	BPM        float64 `json:"bpm,omitempty"`
+	Energy     float64 `json:"energy,omitempty"` // perceived intensity from 0 to 1
+	Rating     int     `json:"rating,omitempty"` // 1 to 5 stars, 0 when unrated
+	// BeatGrid and Cues come from DJ software analysis of the audio file
+	BeatGrid []BeatMarker `json:"beatGrid,omitempty"`
+	Cues     []CuePoint   `json:"cues,omitempty"`
+	// Loudness and Spectral come from audio analysis of the recording
+	Loudness float64           `json:"loudness,omitempty"` // integrated loudness in LUFS, e.g. -8.5
+	Spectral *SpectralFeatures `json:"spectral,omitempty"`
+}
+
+// SpectralFeatures summarize the timbre of a recording over its whole length
+type SpectralFeatures struct {
+	CentroidHz float64 `json:"centroidHz,omitempty"` // brightness
+	RolloffHz  float64 `json:"rolloffHz,omitempty"`  // frequency below which 85% of the energy lies
+	Flatness   float64 `json:"flatness,omitempty"`   // from 0 for tonal to 1 for noisy
+	// MFCC are the mean mel-frequency cepstral coefficients, usually 13
+	MFCC []float64 `json:"mfcc,omitempty"`
+}
+
+// BeatMarker anchors the beat grid: from PositionMs on, beats follow at BPM
+type BeatMarker struct {
+	PositionMs float64 `json:"positionMs"`
+	BPM        float64 `json:"bpm"`
+	Meter      string  `json:"meter,omitempty"` // e.g. 4/4
+	Beat       int     `json:"beat,omitempty"`  // beat of the bar at PositionMs, from 1
+}
+
+// Cue kinds as DJ software sets them
+const (
+	CueMemory  = "cue"
+	CueHot     = "hotcue"
+	CueLoop    = "loop"
+	CueFadeIn  = "fade-in"
+	CueFadeOut = "fade-out"
+	CueLoad    = "load"
+)
+
+// CuePoint is a marked position in a recording, e.g. a hot cue on the drop
+type CuePoint struct {
+	Name       string  `json:"name,omitempty"`
+	Kind       string  `json:"kind"`
+	PositionMs float64 `json:"positionMs"`
+	LengthMs   float64 `json:"lengthMs,omitempty"` // loop length
+	Hotcue     int     `json:"hotcue,omitempty"`   // pad number from 1 for hot cues
+}
+
+// ReleaseInfo holds attributes of a commercial release
+type ReleaseInfo struct {
+	Kind          string `json:"kind,omitempty"` // album, single, ep, compilation...
+	Date          string `json:"date,omitempty"`
+	Country       string `json:"country,omitempty"`
+	Label         string `json:"label,omitempty"`
+	CatalogNumber string `json:"catalogNumber,omitempty"`
+	Format        string `json:"format,omitempty"`
+}
+
+// LabelInfo holds attributes of a record label
+type LabelInfo struct {
+	Profile     string `json:"profile,omitempty"`
+	ContactInfo string `json:"contactInfo,omitempty"`
+}
+
+// Relation is a directed, typed edge between two catalog entities
+type Relation struct {
+	ID         string            `json:"id"`
+	Type       RelationType      `json:"type"`
+	SourceID   string            `json:"sourceId"`
+	TargetID   string            `json:"targetId"`
+	Attributes map[string]string `json:"attributes,omitempty"` // e.g. role, instrument, position
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+// ListFilter narrows down an entity listing
+type ListFilter struct {
+	Type  EntityType
+	Query string // case-insensitive match on name and aliases
+	// MinBPM, MaxBPM, Key and MinRating restrict the listing to recordings;
+	// zero values do not filter. Key matches the same key in any notation.
+	MinBPM    float64
+	MaxBPM    float64
+	Key       string
+	MinRating int
+	Limit     int
+	Offset    int
+}
+
+// recordingsOnly reports whether the filter uses recording attributes
+func (f ListFilter) recordingsOnly() bool {
+	return f.MinBPM > 0 || f.MaxBPM > 0 || f.Key != "" || f.MinRating > 0
+}
+
+// DocumentHit is a retrieved document together with its relevance score
+type DocumentHit struct {
+	DocumentID string
+	EntityIDs  []string // entity links carried by the search index itself
+	Score      float64
+}
+
+// Aggregate groups the retrieved documents that belong to the same entity
+type Aggregate struct {
+	Entity      *Entity  `json:"entity"`
+	Score       float64  `json:"score"`
+	DocumentIDs []string `json:"documentIds"`
+}
+
+// relationEndpoints lists the allowed source and target types of each relation
+var relationEndpoints = map[RelationType]struct {
+	sources []EntityType
+	targets []EntityType
+}{
+	RelPerformedBy: {sources: []EntityType{TypeRecording, TypeRelease}, targets: []EntityType{TypeArtist}},
+	RelComposedBy:  {sources: []EntityType{TypeWork}, targets: []EntityType{TypeArtist}},
+	RelAppearsOn:   {sources: []EntityType{TypeRecording}, targets: []EntityType{TypeRelease}},
+	RelRecordingOf: {sources: []EntityType{TypeRecording}, targets: []EntityType{TypeWork}},
+	RelReleasedBy:  {sources: []EntityType{TypeRelease}, targets: []EntityType{TypeLabel}},
+	RelCreditedTo:  {sources: []EntityType{TypeRecording, TypeRelease}, targets: []EntityType{TypeArtist}},
+	RelEditionOf:   {sources: []EntityType{TypeRelease}, targets: []EntityType{TypeRelease}},
+	RelMemberOf:    {sources: []EntityType{TypeArtist}, targets: []EntityType{TypeArtist}},
+	RelPlayedOn:    {sources: []EntityType{TypeArtist}, targets: []EntityType{TypeRecording, TypeRelease}},
+	RelProduced:    {sources: []EntityType{TypeArtist}, targets: []EntityType{TypeRecording, TypeRelease}},
+	RelSampled:     {sources: []EntityType{TypeRecording}, targets: []EntityType{TypeRecording, TypeWork}},
+	RelCovered:     {sources: []EntityType{TypeRecording}, targets: []EntityType{TypeRecording, TypeWork}},
+}
+
+// IsRelationType reports whether t is a known relation type
+func IsRelationType(t RelationType) bool {
+	_, ok := relationEndpoints[t]
+	return ok
+}
+
+// Clone returns a deep copy of the entity
+func (e *Entity) Clone() *Entity {
+	c := *e
+	c.Aliases = append([]string(nil), e.Aliases...)
+	c.Identifiers.ISRCs = append([]string(nil), e.Identifiers.ISRCs...)
+	c.Identifiers.ISWCs = append([]string(nil), e.Identifiers.ISWCs...)
+	c.Identifiers.Barcodes = append([]string(nil), e.Identifiers.Barcodes...)
+	if e.Artist != nil {
+		a := *e.Artist
+		c.Artist = &a
+	}
+	if e.Work != nil {
+		w := *e.Work
+		c.Work = &w
+	}
+	if e.Recording != nil {
+		r := *e.Recording
+		r.BeatGrid = append([]BeatMarker(nil), e.Recording.BeatGrid...)
+		r.Cues = append([]CuePoint(nil), e.Recording.Cues...)
+		if e.Recording.Spectral != nil {
+			spectral := *e.Recording.Spectral
+			spectral.MFCC = append([]float64(nil), spectral.MFCC...)
+			r.Spectral = &spectral
+		}
+		c.Recording = &r
+	}
+	if e.Release != nil {
+		r := *e.Release
+		c.Release = &r
+	}
+	if e.Label != nil {
+		l := *e.Label
+		c.Label = &l
+	}
+	return &c
+}
+
+// Clone returns a deep copy of the relation
+func (r *Relation) Clone() *Relation {
+	c := *r
+	if r.Attributes != nil {
+		c.Attributes = make(map[string]string, len(r.Attributes))
+		for k, v := range r.Attributes {
+			c.Attributes[k] = v
+		}
+	}
+	return &c
+}
@@ -0,0 +1,240 @@
+// Package conversation keeps users' chat conversations on the server, so that
+// they survive reloads and follow the user across devices. Every message is
+// stored with the sources it was grounded on and the metadata of the model
+// call that produced it.
+//
+// A conversation is a tree of messages: editing a question or regenerating
+// an answer adds a sibling rather than replacing it. One branch of the tree,
+// from the first question to the active message, is the active one.
+//
+// Conversations are kept in a SQL database: SQLite for development and
+// Postgres in production.
+package conversation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+)
+
+// Message roles
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+const (
+	// DefaultLimit is the number of conversations listed by default
+	DefaultLimit = 20
+	// MaxLimit is the largest page of conversations listed at once
+	MaxLimit = 100
+	// maxTitleChars is the longest title kept
+	maxTitleChars = 120
+)
+
+var (
+	ErrNotFound        = errors.New("conversation not found")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrInvalid         = errors.New("invalid conversation")
+	// ErrUnavailable is returned when no conversation database is configured
+	ErrUnavailable = errors.New("conversations are not available")
+)
+
+// Conversation is a chat thread owned by one user. UpdatedAt is the time of
+// its last message, or of its creation while it has none.
+type Conversation struct {
+	ID       string `json:"id"`
+	OwnerID  string `json:"ownerId"`
+	Title    string `json:"title"` // empty until the first exchange is titled
+	Archived bool   `json:"archived"`
+	// ActiveMessageID is the last message of the active branch; empty for
+	// the most recent message
+	ActiveMessageID string `json:"activeMessageId,omitempty"`
+	// ProfileID is the assistant profile answering in the conversation;
+	// empty for the plain assistant
+	ProfileID string    `json:"profileId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Message is a user question or an assistant answer. Answers carry the
+// sources and citations they were grounded on.
+type Message struct {
+	ID             string `json:"id"`
+	ConversationID string `json:"conversationId"`
+	// ParentID is the message this one follows; empty for a first question
+	ParentID  string         `json:"parentId,omitempty"`
+	Role      string         `json:"role"` // user or assistant
+	Content   string         `json:"content"`
+	Sources   []rag.Source   `json:"sources,omitempty"`
+	Citations []rag.Citation `json:"citations,omitempty"`
+	Metadata  *Metadata      `json:"metadata,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// Metadata describes how an answer was produced
+type Metadata struct {
+	Model           string     `json:"model,omitempty"` // the chat deployment
+	Route           *rag.Route `json:"route,omitempty"`
+	StandaloneQuery string     `json:"standaloneQuery,omitempty"`
+	// Groundedness is the share of the answer's claims its sources support;
+	// nil when the answer was not verified
+	Groundedness *float64 `json:"groundedness,omitempty"`
+	LatencyMs    int64    `json:"latencyMs"`
+	// Top and Expand are the retrieval settings the answer was asked with
+	Top    int  `json:"top,omitempty"`
+	Expand bool `json:"expand,omitempty"`
+	// Prompts are the versions of the prompts the answer was asked with
+	Prompts []prompt.Ref `json:"prompts,omitempty"`
+	// Profile is the assistant profile that answered; empty for the plain
+	// assistant
+	Profile string `json:"profile,omitempty"`
+	// Experiment and Variant are the A/B experiment variant that answered,
+	// if any
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+	// Cached reports that the answer was reused from the answer cache
+	Cached bool `json:"cached,omitempty"`
+}
+
+// ListOptions select a page of conversations, most recently active first.
+// Archived conversations are listed apart from the others.
+type ListOptions struct {
+	Archived bool
+	Limit    int
+	Offset   int
+}
+
+// Update changes the title, archived state or profile of a conversation; nil
+// fields are left unchanged
+type Update struct {
+	Title     *string `json:"title"`
+	Archived  *bool   `json:"archived"`
+	ProfileID *string `json:"profileId"`
+}
+
+// MessageFilter selects messages of an owner's conversations
+type MessageFilter struct {
+	// ConversationIDs limits the messages to these conversations
+	ConversationIDs []string
+	// From and To limit the messages to a period, To being exclusive
+	From, To time.Time
+	// Terms keeps the messages containing any of these words, in their text
+	// or their conversation's title
+	Terms []string
+	// EmbeddingModel keeps the messages embedded by this model, with their
+	// embedding
+	EmbeddingModel string
+	Limit          int
+}
+
+// Found is a message found by a filter, with the title of its conversation
+// and its embedding if asked for
+type Found struct {
+	*Message
+	Title  string
+	Vector []float64
+}
+
+// Store keeps conversations and their messages. Every method is scoped to
+// the owner, and other users' conversations are reported as not found.
+type Store interface {
+	Create(ctx context.Context, c *Conversation) (*Conversation, error)
+	Get(ctx context.Context, ownerID, id string) (*Conversation, error)
+	// List returns a page of the owner's conversations and their total number
+	List(ctx context.Context, ownerID string, opts ListOptions) ([]*Conversation, int, error)
+	Update(ctx context.Context, ownerID, id string, u Update) (*Conversation, error)
+	// Delete removes a conversation with its messages
+	Delete(ctx context.Context, ownerID, id string) error
+	// AddMessages appends messages to the active branch of a conversation,
+	// assigning their IDs, parents and times. The last becomes the active
+	// message.
+	AddMessages(ctx context.Context, ownerID, id string, messages ...*Message) error
+	// AddBranch is AddMessages starting a new branch after the parent
+	// message, or a new first question when parentID is empty
+	AddBranch(ctx context.Context, ownerID, id, parentID string, messages ...*Message) error
+	// Messages returns all the messages of a conversation's tree, oldest first
+	Messages(ctx context.Context, ownerID, id string) ([]*Message, error)
+	// SetActive makes the branch through a message the active one, up to its
+	// most recent descendant
+	SetActive(ctx context.Context, ownerID, id, messageID string) (*Conversation, error)
+	// FindMessages returns the owner's messages that pass the filter, most
+	// recent first
+	FindMessages(ctx context.Context, ownerID string, f MessageFilter) ([]*Found, error)
+	// SetEmbedding stores the embedding of a message for semantic search
+	SetEmbedding(ctx context.Context, messageID, model string, vector []float64) error
+	// SetFeedback stores the owner's rating of an answer, replacing any
+	// earlier one
+	SetFeedback(ctx context.Context, ownerID, messageID string, f *Feedback) (*Feedback, error)
+	// ListFeedback returns every user's feedback in a period, for reports
+	ListFeedback(ctx context.Context, f FeedbackFilter) ([]*Feedback, error)
+	// LogExposure records that an answer was produced by an experiment's
+	// variant
+	LogExposure(ctx context.Context, e *Exposure) error
+	// ListExposures returns the exposures of an experiment, oldest first
+	ListExposures(ctx context.Context, experimentID string) ([]*Exposure, error)
+	Close() error
+}
+
+// StoreInstance holds the conversations of this process; nil when no
+// database could be opened
+var StoreInstance Store
+
+// Init opens the conversation database named by CONVERSATION_DB_DRIVER,
+// sqlite (the default) or postgres, and CONVERSATION_DB_URL, and the search
+// over it. Without a URL conversations are kept in a local SQLite file, and
+// are disabled if that fails, e.g. in builds without cgo.
+func Init() {
+	driver := os.Getenv("CONVERSATION_DB_DRIVER")
+	if driver == "" {
+		driver = DriverSQLite
+	}
+	dsn := os.Getenv("CONVERSATION_DB_URL")
+	var store *SQLStore
+	var err error
+	if dsn == "" {
+		if driver != DriverSQLite {
+			log.Fatalf("CONVERSATION_DB_URL must be set for the %s conversation database", driver)
+		}
+		log.Printf("CONVERSATION_DB_URL not set, storing conversations in %s", defaultSQLitePath)
+		if store, err = OpenSQL(driver, defaultSQLitePath); err != nil {
+			log.Printf("Conversations are disabled: %v", err)
+			return
+		}
+	} else if store, err = OpenSQL(driver, dsn); err != nil {
+		log.Fatalf("Failed to open the conversation database: %v", err)
+	}
+	StoreInstance = store
+	SearcherInstance = &Searcher{Store: store}
+	if model := azure.EmbeddingDeployment(); model != "" {
+		SearcherInstance.Embed, SearcherInstance.Model = azure.Embed, model
+	} else {
+		log.Println("AZURE_OPENAI_DEPLOYMENT_EMBEDDING not set, conversations can only be searched by keyword")
+	}
+}
+
+// validTitle trims a title and checks its length
+func validTitle(title string) (string, error) {
+	title = trimTitle(title)
+	if len([]rune(title)) > maxTitleChars {
+		return "", fmt.Errorf("%w: titles are limited to %d characters", ErrInvalid, maxTitleChars)
+	}
+	return title, nil
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate id: %v", err))
+	}
+	return prefix + "_" + hex.EncodeToString(b)
+}
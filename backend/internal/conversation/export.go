@@ -0,0 +1,205 @@
+package conversation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/pdf"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+)
+
+// Formats a conversation can be exported to
+const (
+	FormatMarkdown = "md"
+	FormatJSON     = "json"
+	FormatPDF      = "pdf"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// exportTime is how message times are written in exports
+const exportTime = "2 January 2006 15:04 MST"
+
+// Transcript is an exported conversation
+type Transcript struct {
+	Conversation *Conversation       `json:"conversation"`
+	Messages     []TranscriptMessage `json:"messages"`
+	ExportedAt   time.Time           `json:"exportedAt"`
+}
+
+// TranscriptMessage is an exported message with the music notation found in
+// its text
+type TranscriptMessage struct {
+	*Message
+	Notation []Notation `json:"notation,omitempty"`
+}
+
+// Export writes a conversation and its messages in format, md (the default),
+// json or pdf. It returns the file's data, its extension and its content
+// type.
+func Export(format string, conv *Conversation, messages []*Message, exportedAt time.Time) (data []byte, ext, contentType string, err error) {
+	t := Transcript{Conversation: conv, Messages: make([]TranscriptMessage, 0, len(messages)), ExportedAt: exportedAt.UTC()}
+	for _, m := range messages {
+		t.Messages = append(t.Messages, TranscriptMessage{Message: m, Notation: notations(m.Content)})
+	}
+	switch format {
+	case "", FormatMarkdown:
+		return t.markdown(), ".md", "text/markdown; charset=utf-8", nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			return nil, "", "", err
+		}
+		return data, ".json", "application/json", nil
+	case FormatPDF:
+		var buf bytes.Buffer
+		if _, err := t.document().WriteTo(&buf); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), ".pdf", "application/pdf", nil
+	}
+	return nil, "", "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+}
+
+// title returns the conversation's title, or a placeholder while it has none
+func (t *Transcript) title() string {
+	if t.Conversation.Title == "" {
+		return "Untitled conversation"
+	}
+	return t.Conversation.Title
+}
+
+// byline describes the export under its title
+func (t *Transcript) byline() string {
+	return fmt.Sprintf("Started %s · %d messages · exported %s",
+		t.Conversation.CreatedAt.UTC().Format(exportTime), len(t.Messages), t.ExportedAt.Format(exportTime))
+}
+
+// heading names the author of a message and when it was sent
+func heading(m *Message) string {
+	who := "User"
+	if m.Role == RoleAssistant {
+		who = "Assistant"
+	}
+	return who + " · " + m.CreatedAt.UTC().Format(exportTime)
+}
+
+// sourceLine describes a source in a list of sources, e.g.
+// "[2] Real Book, Vol. 1 — page 12"
+func sourceLine(s rag.Source) string {
+	line := fmt.Sprintf("[%d] %s", s.Number, s.Title)
+	if s.Locator != nil {
+		if l := s.Locator.String(); l != "" {
+			line += " — " + l
+		}
+	}
+	if !s.Cited {
+		line += " (not cited)"
+	}
+	return line
+}
+
+// markdown renders the transcript as Markdown. Messages keep their own
+// Markdown, with code and music notation in fenced blocks so that other
+// tools can pick the notation out again.
+func (t *Transcript) markdown() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n_%s_\n", t.title(), t.byline())
+	for _, m := range t.Messages {
+		fmt.Fprintf(&b, "\n---\n\n### %s\n\n", heading(m.Message))
+		for i, s := range segments(m.Content) {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			if !s.verbatim {
+				b.WriteString(s.text + "\n")
+				continue
+			}
+			// The fence must be longer than any run of backticks in the block
+			fence := "```"
+			for strings.Contains(s.text, fence) {
+				fence += "`"
+			}
+			fmt.Fprintf(&b, "%s%s\n%s\n%s\n", fence, s.lang, s.text, fence)
+		}
+		if len(m.Sources) > 0 {
+			b.WriteString("\n**Sources**\n\n")
+			for _, s := range m.Sources {
+				fmt.Fprintf(&b, "- %s\n", sourceLine(s))
+				for _, q := range s.Quotes {
+					fmt.Fprintf(&b, "  > %s\n", strings.Join(strings.Fields(q), " "))
+				}
+			}
+		}
+	}
+	return []byte(b.String())
+}
+
+// Type for the PDF transcript
+var (
+	titleStyle   = pdf.Style{Font: pdf.Bold, Size: 18}
+	bylineStyle  = pdf.Style{Font: pdf.Regular, Size: 9, Gray: 0.4}
+	headingStyle = pdf.Style{Font: pdf.Bold, Size: 11}
+	bodyStyle    = pdf.Style{Font: pdf.Regular, Size: 10.5}
+	blockStyle   = pdf.Style{Font: pdf.Mono, Size: 9, Indent: 12}
+	labelStyle   = pdf.Style{Font: pdf.Regular, Size: 8, Indent: 12, Gray: 0.4}
+	sourceStyle  = pdf.Style{Font: pdf.Regular, Size: 9, Indent: 12}
+	quoteStyle   = pdf.Style{Font: pdf.Italic, Size: 9, Indent: 24, Gray: 0.3}
+)
+
+// markdownHeading and markdownEmphasis are the Markdown that is dropped
+// from prose set in the PDF
+var (
+	markdownHeading  = regexp.MustCompile(`^#{1,6}\s+`)
+	markdownEmphasis = strings.NewReplacer("**", "", "__", "", "`", "")
+)
+
+// document sets the transcript as a PDF. Prose is set as plain text, and
+// code and notation in a fixed-width font so that the columns of tunes and
+// chord charts line up.
+func (t *Transcript) document() *pdf.Document {
+	doc := pdf.New(t.title())
+	doc.Text(t.title(), titleStyle)
+	doc.Text(t.byline(), bylineStyle)
+	for _, m := range t.Messages {
+		doc.Space(6)
+		doc.Rule()
+		doc.Text(heading(m.Message), headingStyle)
+		doc.Space(4)
+		for _, s := range segments(m.Content) {
+			if s.verbatim {
+				if s.lang != "" {
+					doc.Text(s.lang, labelStyle)
+				}
+				doc.Text(s.text, blockStyle)
+			} else {
+				for _, paragraph := range strings.Split(s.text, "\n\n") {
+					for _, line := range strings.Split(paragraph, "\n") {
+						style := bodyStyle
+						if markdownHeading.MatchString(line) {
+							line, style.Font = markdownHeading.ReplaceAllString(line, ""), pdf.Bold
+						}
+						doc.Text(markdownEmphasis.Replace(line), style)
+					}
+					doc.Space(4)
+				}
+			}
+			doc.Space(4)
+		}
+		if len(m.Sources) > 0 {
+			doc.Text("Sources", pdf.Style{Font: pdf.Bold, Size: 9})
+			for _, s := range m.Sources {
+				doc.Text(sourceLine(s), sourceStyle)
+				for _, q := range s.Quotes {
+					doc.Text("“"+strings.Join(strings.Fields(q), " ")+"”", quoteStyle)
+				}
+			}
+		}
+	}
+	return doc
+}
@@ -0,0 +1,18 @@
+package conversation
+
+import "time"
+
+// Exposure records that an answer was produced by a variant of an A/B
+// experiment, so that the variants can be compared by latency and by the
+// feedback on their answers
+type Exposure struct {
+	ExperimentID string `json:"experimentId"`
+	Variant      string `json:"variant"`
+	UserID       string `json:"userId"`
+	// ConversationID and MessageID locate the answer; they are empty for
+	// answers outside a stored conversation, which cannot be rated
+	ConversationID string    `json:"conversationId,omitempty"`
+	MessageID      string    `json:"messageId,omitempty"`
+	LatencyMs      int64     `json:"latencyMs"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
@@ -0,0 +1,232 @@
+package conversation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+)
+
+// Ratings of an answer
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+)
+
+const (
+	// DefaultReportLimit is the number of chunks and documents reported by
+	// default
+	DefaultReportLimit = 20
+	// maxCommentChars is the longest feedback comment kept
+	maxCommentChars = 2000
+)
+
+// ErrInvalidFeedback is returned for feedback with an unknown rating or
+// reason, or on a message that is not an answer
+var ErrInvalidFeedback = errors.New("invalid feedback")
+
+// Reasons are the categories a user can give for their rating
+var Reasons = []string{
+	"inaccurate",   // states something wrong
+	"unsupported",  // the sources do not back it up
+	"irrelevant",   // does not answer the question
+	"incomplete",   // misses part of the answer
+	"outdated",     // the sources are out of date
+	"bad-citation", // cites the wrong source or passage
+	"formatting",   // hard to read
+	"helpful",
+	"well-sourced",
+	"other",
+}
+
+// Feedback is a user's rating of an answer. It keeps what produced the
+// answer, so that a rating can be traced to its query, passages, prompts and
+// model even after they change.
+type Feedback struct {
+	MessageID      string   `json:"messageId"`
+	ConversationID string   `json:"conversationId"`
+	UserID         string   `json:"userId"`
+	Rating         string   `json:"rating"` // up or down
+	Reasons        []string `json:"reasons"`
+	Comment        string   `json:"comment,omitempty"`
+	// Query is the question as asked and StandaloneQuery the question the
+	// passages were retrieved for
+	Query           string          `json:"query"`
+	StandaloneQuery string          `json:"standaloneQuery,omitempty"`
+	Chunks          []FeedbackChunk `json:"chunks"`
+	Prompts         []prompt.Ref    `json:"prompts,omitempty"`
+	Model           string          `json:"model,omitempty"`
+	CreatedAt       time.Time       `json:"createdAt"`
+}
+
+// FeedbackChunk is a passage retrieved for a rated answer
+type FeedbackChunk struct {
+	ID         string `json:"id"` // the source ID, e.g. azure:chunk-17
+	DocumentID string `json:"documentId"`
+	Title      string `json:"title"`
+	Cited      bool   `json:"cited"`
+}
+
+// FeedbackFilter selects the feedback of a report; zero times are unbounded
+// and To is exclusive
+type FeedbackFilter struct {
+	From, To time.Time
+}
+
+// validate checks the rating and reasons of new feedback and tidies them
+func (f *Feedback) validate() error {
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		return fmt.Errorf("%w: the rating must be %s or %s", ErrInvalidFeedback, RatingUp, RatingDown)
+	}
+	for _, r := range f.Reasons {
+		if !slices.Contains(Reasons, r) {
+			return fmt.Errorf("%w: unknown reason %q, expected any of %v", ErrInvalidFeedback, r, Reasons)
+		}
+	}
+	f.Reasons = slices.Compact(slices.Sorted(slices.Values(f.Reasons)))
+	f.Comment = strings.TrimSpace(f.Comment)
+	if runes := []rune(f.Comment); len(runes) > maxCommentChars {
+		f.Comment = string(runes[:maxCommentChars])
+	}
+	return nil
+}
+
+// snapshot fills in what produced a rated answer from the answer and its
+// question
+func (f *Feedback) snapshot(answer *Message, question string) {
+	f.MessageID, f.ConversationID, f.Query = answer.ID, answer.ConversationID, question
+	f.Chunks = []FeedbackChunk{}
+	for _, s := range answer.Sources {
+		// Passages have a document; facts, playlists and tool results do not
+		if s.DocumentID != "" {
+			f.Chunks = append(f.Chunks, FeedbackChunk{ID: s.ID, DocumentID: s.DocumentID, Title: s.Title, Cited: s.Cited})
+		}
+	}
+	if m := answer.Metadata; m != nil {
+		f.StandaloneQuery, f.Prompts, f.Model = m.StandaloneQuery, m.Prompts, m.Model
+	}
+}
+
+// FeedbackReport ranks the passages and documents of rated answers, worst
+// first
+type FeedbackReport struct {
+	From      *time.Time     `json:"from,omitempty"`
+	To        *time.Time     `json:"to,omitempty"`
+	Ratings   int            `json:"ratings"`
+	Up        int            `json:"up"`
+	Down      int            `json:"down"`
+	Reasons   map[string]int `json:"reasons"`
+	Chunks    []RatedItem    `json:"chunks"`
+	Documents []RatedItem    `json:"documents"`
+}
+
+// RatedItem is a passage or document with the ratings of the answers it was
+// retrieved for. CitedDown counts the down ratings of answers that cited
+// it, which point at it more surely than the others.
+type RatedItem struct {
+	ID         string         `json:"id"`
+	DocumentID string         `json:"documentId,omitempty"` // for passages
+	Title      string         `json:"title"`
+	Up         int            `json:"up"`
+	Down       int            `json:"down"`
+	CitedDown  int            `json:"citedDown"`
+	Reasons    map[string]int `json:"reasons"`
+	// Examples are the questions of some of the down-rated answers
+	Examples []string `json:"examples,omitempty"`
+}
+
+// maxExamples is the number of example questions reported per item
+const maxExamples = 3
+
+// Report ranks the passages and documents of rated answers by how often the
+// answers they were retrieved for were rated down rather than up, and
+// returns the limit worst of each. Items rated up more than down are left
+// out.
+func Report(feedback []*Feedback, f FeedbackFilter, limit int) *FeedbackReport {
+	if limit <= 0 {
+		limit = DefaultReportLimit
+	}
+	report := &FeedbackReport{Reasons: map[string]int{}, Chunks: []RatedItem{}, Documents: []RatedItem{}}
+	if !f.From.IsZero() {
+		report.From = &f.From
+	}
+	if !f.To.IsZero() {
+		report.To = &f.To
+	}
+	chunks := map[string]*RatedItem{}
+	documents := map[string]*RatedItem{}
+	for _, fb := range feedback {
+		report.Ratings++
+		if fb.Rating == RatingDown {
+			report.Down++
+		} else {
+			report.Up++
+		}
+		for _, r := range fb.Reasons {
+			report.Reasons[r]++
+		}
+		// A document counts once per answer however many of its passages
+		// were retrieved
+		seen := map[string]bool{}
+		for _, c := range fb.Chunks {
+			rate(chunks, c.ID, c.DocumentID, c.Title, fb, c.Cited)
+			if !seen[c.DocumentID] {
+				seen[c.DocumentID] = true
+				cited := slices.ContainsFunc(fb.Chunks, func(o FeedbackChunk) bool { return o.DocumentID == c.DocumentID && o.Cited })
+				rate(documents, c.DocumentID, "", c.Title, fb, cited)
+			}
+		}
+	}
+	report.Chunks = worst(chunks, limit)
+	report.Documents = worst(documents, limit)
+	return report
+}
+
+// rate counts a rating of an answer against one of its passages or documents
+func rate(items map[string]*RatedItem, id, documentID, title string, fb *Feedback, cited bool) {
+	item, ok := items[id]
+	if !ok {
+		item = &RatedItem{ID: id, DocumentID: documentID, Title: title, Reasons: map[string]int{}}
+		items[id] = item
+	}
+	if fb.Rating == RatingUp {
+		item.Up++
+		return
+	}
+	item.Down++
+	if cited {
+		item.CitedDown++
+	}
+	for _, r := range fb.Reasons {
+		item.Reasons[r]++
+	}
+	if len(item.Examples) < maxExamples && !slices.Contains(item.Examples, fb.Query) {
+		item.Examples = append(item.Examples, fb.Query)
+	}
+}
+
+// worst returns the limit items with the most down ratings net of up
+// ratings, then the most cited down ratings
+func worst(items map[string]*RatedItem, limit int) []RatedItem {
+	ranked := []RatedItem{}
+	for _, item := range items {
+		if item.Down > item.Up {
+			ranked = append(ranked, *item)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Down-a.Up != b.Down-b.Up {
+			return a.Down-a.Up > b.Down-b.Up
+		}
+		if a.CitedDown != b.CitedDown {
+			return a.CitedDown > b.CitedDown
+		}
+		return a.ID < b.ID
+	})
+	return ranked[:min(len(ranked), limit)]
+}
@@ -0,0 +1,163 @@
+package conversation
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Music notation formats recognized in messages
+const (
+	NotationABC      = "abc"
+	NotationChordPro = "chordpro"
+)
+
+// Notation is a block of music notation found in a message
+type Notation struct {
+	Format  string `json:"format"` // abc or chordpro
+	Content string `json:"content"`
+}
+
+var (
+	// abcReference is the X: line that starts an ABC tune
+	abcReference = regexp.MustCompile(`^X:\s*\d+\s*$`)
+	abcField     = regexp.MustCompile(`^[A-Za-z]:\s*\S`)
+	abcKey       = regexp.MustCompile(`^K:\s*\S`)
+	// chordProDirective is a line like {title: Wonderwall} or {start_of_chorus}
+	chordProDirective = regexp.MustCompile(`^\{\s*(title|t|subtitle|st|artist|key|tempo|time|capo|comment|c|ci|chorus|start_of_\w+|end_of_\w+|so[cvbt]|eo[cvbt])\s*(:[^}]*)?\}$`)
+	// inlineChord is a chord in brackets, e.g. [Am7] or [C/G], in a lyric line
+	inlineChord = regexp.MustCompile(`\[[A-G][#b♯♭]?(m|maj|min|dim|aug|sus|add|M)?\d*(sus\d|add\d|[#b]\d)*(/[A-G][#b♯♭]?)?\]`)
+)
+
+// segment is a part of a message's text: prose, or a block to keep verbatim
+// such as code or music notation
+type segment struct {
+	text     string
+	verbatim bool
+	lang     string // the language of a verbatim block, if known
+	detected bool   // whether the block was recognized rather than fenced
+}
+
+// segments splits message text into prose and verbatim blocks. Blocks fenced
+// with ``` or ~~~ are kept as they are; ABC tunes and ChordPro songs written
+// without a fence are recognized paragraph by paragraph, and a structured
+// answer, which is JSON, is a block of its own.
+func segments(content string) []segment {
+	trimmed := strings.TrimSpace(content)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return []segment{{text: trimmed, verbatim: true, lang: "json"}}
+	}
+
+	var out []segment
+	add := func(s segment) {
+		if len(out) > 0 {
+			last := &out[len(out)-1]
+			// Prose paragraphs, and recognized notation split by blank lines
+			// such as the verses of a song, are joined again
+			if !s.verbatim && !last.verbatim || s.detected && last.detected && s.lang == last.lang {
+				last.text += "\n\n" + s.text
+				return
+			}
+		}
+		out = append(out, s)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			i++
+			continue
+		}
+		if fence := fenceOf(line); fence != "" {
+			lang, _, _ := strings.Cut(strings.TrimSpace(line[len(fence):]), " ")
+			var body []string
+			for i++; i < len(lines); i++ {
+				if close := strings.TrimSpace(lines[i]); strings.HasPrefix(close, fence) && strings.Trim(close, fence[:1]) == "" {
+					i++
+					break
+				}
+				body = append(body, lines[i])
+			}
+			text := strings.Join(body, "\n")
+			if lang == "" {
+				lang = notationFormat(body)
+			}
+			out = append(out, segment{text: text, verbatim: true, lang: strings.ToLower(lang)})
+			continue
+		}
+
+		var paragraph []string
+		for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && fenceOf(strings.TrimSpace(lines[i])) == ""; i++ {
+			paragraph = append(paragraph, strings.TrimRight(lines[i], " \t"))
+		}
+		// A tune may follow its introduction without a blank line
+		for j, l := range paragraph {
+			if j > 0 && abcReference.MatchString(strings.TrimSpace(l)) {
+				add(segment{text: strings.Join(paragraph[:j], "\n")})
+				paragraph = paragraph[j:]
+				break
+			}
+		}
+		if format := notationFormat(paragraph); format != "" {
+			add(segment{text: strings.Join(paragraph, "\n"), verbatim: true, lang: format, detected: true})
+		} else {
+			add(segment{text: strings.Join(paragraph, "\n")})
+		}
+	}
+	return out
+}
+
+// notations returns the music notation of message text
+func notations(content string) []Notation {
+	var found []Notation
+	for _, s := range segments(content) {
+		if s.lang == NotationABC || s.lang == NotationChordPro {
+			found = append(found, Notation{Format: s.lang, Content: s.text})
+		}
+	}
+	return found
+}
+
+// notationFormat recognizes lines as an ABC tune, which starts with its X:
+// reference or has a K: key among its header fields, or as a ChordPro song,
+// which has directives or chords in its lyrics. It returns "" for anything
+// else.
+func notationFormat(lines []string) string {
+	var fields, chordLines int
+	var key bool
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		if i == 0 && abcReference.MatchString(l) {
+			return NotationABC
+		}
+		if chordProDirective.MatchString(l) {
+			return NotationChordPro
+		}
+		if abcField.MatchString(l) {
+			fields++
+			key = key || abcKey.MatchString(l)
+		}
+		if inlineChord.MatchString(l) {
+			chordLines++
+		}
+	}
+	switch {
+	case key && fields >= 3:
+		return NotationABC
+	case chordLines >= 2:
+		return NotationChordPro
+	}
+	return ""
+}
+
+// fenceOf returns the fence that opens a fenced block on line, or ""
+func fenceOf(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return line[:n]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,358 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+)
+
+// Search modes
+const (
+	SearchKeyword  = "keyword"
+	SearchSemantic = "semantic"
+	// SearchHybrid merges the keyword and semantic rankings
+	SearchHybrid = "hybrid"
+)
+
+const (
+	// DefaultSearchLimit is the number of messages found by default
+	DefaultSearchLimit = 20
+	// MaxSearchLimit is the largest number of messages found at once
+	MaxSearchLimit = 100
+	// maxCandidates is the number of most recent messages ranked per search
+	maxCandidates = 2000
+	// minSimilarity is the cosine similarity below which a message does not
+	// match a query by meaning
+	minSimilarity = 0.3
+	// maxEmbedChars truncates long answers before they are embedded
+	maxEmbedChars = 8000
+	// snippetChars is the length of a snippet, of which snippetLead comes
+	// before the first match it shows
+	snippetChars = 240
+	snippetLead  = 60
+	// searchSource names the rankings merged by hybrid search
+	searchSource = "conversations"
+)
+
+var (
+	ErrInvalidSearch = errors.New("invalid search")
+	// ErrNoEmbeddings is returned for semantic searches when no embedding
+	// model is configured
+	ErrNoEmbeddings = errors.New("semantic search needs an embedding model")
+)
+
+// EmbedFunc returns the embedding of each text
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float64, error)
+
+// Searcher finds messages in a user's conversations by keyword or by
+// meaning. Conversations are private, so only the owner's are searched.
+type Searcher struct {
+	Store Store
+	// Embed and Model embed messages and queries for semantic search;
+	// without them only keyword search is available
+	Embed EmbedFunc
+	Model string
+}
+
+// SearcherInstance searches the conversations of StoreInstance
+var SearcherInstance *Searcher
+
+// SearchQuery is a search of the owner's messages
+type SearchQuery struct {
+	Text string
+	Mode string // keyword (the default), semantic or hybrid
+	// ConversationIDs limits the search to these conversations
+	ConversationIDs []string
+	// From and To limit the search to a period, To being exclusive
+	From, To time.Time
+	Limit    int
+}
+
+// SearchHit is a message that matches a search, with a snippet of its text
+type SearchHit struct {
+	MessageID         string    `json:"messageId"`
+	ConversationID    string    `json:"conversationId"`
+	ConversationTitle string    `json:"conversationTitle"`
+	Role              string    `json:"role"`
+	CreatedAt         time.Time `json:"createdAt"`
+	Score             float64   `json:"score"`
+	Snippet           string    `json:"snippet"`
+	// Highlights are the byte ranges of the snippet that match the query
+	Highlights []Highlight `json:"highlights"`
+}
+
+// Highlight is a byte range of a snippet; End is exclusive
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Index embeds messages for semantic search. It does nothing when no
+// embedding model is configured.
+func (s *Searcher) Index(ctx context.Context, messages ...*Message) error {
+	if s.Embed == nil {
+		return nil
+	}
+	var indexed []*Message
+	var texts []string
+	for _, m := range messages {
+		text := strings.TrimSpace(m.Content)
+		if text == "" {
+			continue
+		}
+		if len(text) > maxEmbedChars {
+			text = strings.ToValidUTF8(text[:maxEmbedChars], "")
+		}
+		indexed = append(indexed, m)
+		texts = append(texts, text)
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	vectors, err := s.Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed messages: %w", err)
+	}
+	for i, m := range indexed {
+		if err := s.Store.SetEmbedding(ctx, m.ID, s.Model, vectors[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns the owner's messages that best match a query, best first.
+// Keyword search ranks the messages containing the query's words with BM25;
+// semantic search ranks the messages embedded by the current model by their
+// similarity to the query.
+func (s *Searcher) Search(ctx context.Context, ownerID string, q SearchQuery) ([]SearchHit, error) {
+	terms := uniqueTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%w: a search query is required", ErrInvalidSearch)
+	}
+	switch q.Mode {
+	case "":
+		q.Mode = SearchKeyword
+	case SearchKeyword, SearchSemantic, SearchHybrid:
+	default:
+		return nil, fmt.Errorf("%w: unknown search mode %q", ErrInvalidSearch, q.Mode)
+	}
+	if q.Mode != SearchKeyword && s.Embed == nil {
+		return nil, ErrNoEmbeddings
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	limit = min(limit, MaxSearchLimit)
+	filter := MessageFilter{ConversationIDs: q.ConversationIDs, From: q.From, To: q.To, Limit: maxCandidates}
+
+	found := make(map[string]*Found)
+	var rankings [][]retrieval.Chunk
+	if q.Mode != SearchSemantic {
+		f := filter
+		f.Terms = terms
+		candidates, err := s.Store.FindMessages(ctx, ownerID, f)
+		if err != nil {
+			return nil, err
+		}
+		index := retrieval.NewLocalIndex(searchSource)
+		for _, m := range candidates {
+			found[m.ID] = m
+			index.Add(retrieval.Chunk{ID: m.ID, Title: m.Title, Content: m.Content})
+		}
+		ranked, err := index.Retrieve(ctx, retrieval.Query{Text: q.Text, Top: limit})
+		if err != nil {
+			return nil, err
+		}
+		rankings = append(rankings, ranked)
+	}
+	if q.Mode != SearchKeyword {
+		vectors, err := s.Embed(ctx, []string{q.Text})
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed the search query: %w", err)
+		}
+		f := filter
+		f.EmbeddingModel = s.Model
+		candidates, err := s.Store.FindMessages(ctx, ownerID, f)
+		if err != nil {
+			return nil, err
+		}
+		var ranked []retrieval.Chunk
+		for _, m := range candidates {
+			if similarity := cosine(vectors[0], m.Vector); similarity >= minSimilarity {
+				found[m.ID] = m
+				ranked = append(ranked, retrieval.Chunk{ID: m.ID, Source: searchSource, Score: similarity})
+			}
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].Score != ranked[j].Score {
+				return ranked[i].Score > ranked[j].Score
+			}
+			return ranked[i].ID < ranked[j].ID
+		})
+		rankings = append(rankings, ranked[:min(len(ranked), limit)])
+	}
+
+	ranked := rankings[0]
+	if len(rankings) > 1 {
+		ranked = retrieval.FuseRankings(rankings, limit)
+	}
+	hits := make([]SearchHit, 0, len(ranked))
+	for _, r := range ranked {
+		m := found[r.ID]
+		text, highlights := snippet(m.Content, terms)
+		hits = append(hits, SearchHit{
+			MessageID:         m.ID,
+			ConversationID:    m.ConversationID,
+			ConversationTitle: m.Title,
+			Role:              m.Role,
+			CreatedAt:         m.CreatedAt,
+			Score:             math.Round(r.Score*10000) / 10000,
+			Snippet:           text,
+			Highlights:        highlights,
+		})
+	}
+	return hits, nil
+}
+
+// uniqueTerms returns the distinct words of a query
+func uniqueTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, t := range retrieval.Tokenize(query) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// snippet cuts the part of text that shows the most distinct query terms,
+// or its beginning if none occurs, and returns it with the ranges of the
+// terms in it
+func snippet(text string, terms []string) (string, []Highlight) {
+	wanted := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		wanted[t] = true
+	}
+	spans := termSpans(text, wanted)
+
+	start, end := 0, len(text)
+	if len(text) > snippetChars {
+		if len(spans) > 0 {
+			// Start shortly before the window with the most distinct terms
+			best, bestCount := 0, 0
+			for i, sp := range spans {
+				seen := make(map[string]bool)
+				for _, other := range spans[i:] {
+					if other[1] > sp[0]+snippetChars-snippetLead {
+						break
+					}
+					seen[strings.ToLower(text[other[0]:other[1]])] = true
+				}
+				if len(seen) > bestCount {
+					best, bestCount = i, len(seen)
+				}
+			}
+			start = max(0, spans[best][0]-snippetLead)
+		}
+		end = min(len(text), start+snippetChars)
+		start, end = wordBoundary(text, start, false), wordBoundary(text, end, true)
+	}
+
+	var prefix, suffix string
+	if start > 0 {
+		prefix = "…"
+	}
+	if end < len(text) {
+		suffix = "…"
+	}
+	// Line breaks become spaces, which keeps the byte offsets
+	cut := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, text[start:end])
+
+	highlights := []Highlight{}
+	for _, sp := range spans {
+		if sp[0] >= start && sp[1] <= end {
+			highlights = append(highlights, Highlight{Start: len(prefix) + sp[0] - start, End: len(prefix) + sp[1] - start})
+		}
+	}
+	return prefix + cut + suffix, highlights
+}
+
+// termSpans returns the byte ranges of the words of text that are wanted,
+// words being split as retrieval.Tokenize splits them
+func termSpans(text string, wanted map[string]bool) [][2]int {
+	var spans [][2]int
+	start := -1
+	flush := func(end int) {
+		if start >= 0 && wanted[strings.ToLower(text[start:end])] {
+			spans = append(spans, [2]int{start, end})
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return spans
+}
+
+// wordBoundary moves a cut of text back to a nearby space, so that words are
+// not split, or else to the start of a rune. An end moves to the space, a
+// start to just after it.
+func wordBoundary(text string, i int, isEnd bool) int {
+	if i <= 0 || i >= len(text) {
+		return i
+	}
+	const reach = 20
+	if isEnd {
+		if j := strings.LastIndexFunc(text[max(0, i-reach):i], unicode.IsSpace); j >= 0 {
+			return max(0, i-reach) + j
+		}
+	} else if j := strings.LastIndexFunc(text[max(0, i-reach):i], unicode.IsSpace); j >= 0 {
+		return max(0, i-reach) + j + 1
+	}
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	return i
+}
+
+// cosine is the cosine similarity of two vectors, 0 if either is empty or
+// their lengths differ
+func cosine(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,817 @@
+package conversation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+
+	// defaultSQLitePath is the development database used when no URL is set
+	defaultSQLitePath = "conversations.db"
+)
+
+// dialect holds the column types that differ between SQLite and Postgres
+type dialect struct {
+	driver    string
+	timestamp string
+	blob      string
+}
+
+var dialects = map[string]dialect{
+	DriverSQLite:   {driver: "sqlite3", timestamp: "TIMESTAMP", blob: "BLOB"},
+	DriverPostgres: {driver: "postgres", timestamp: "TIMESTAMPTZ", blob: "BYTEA"},
+}
+
+// schema creates the tables. The statements are valid in both SQLite and
+// Postgres apart from the column types of the dialect.
+func schema(d dialect) []string {
+	timestamp := d.timestamp
+	return []string{
+		`CREATE TABLE IF NOT EXISTS conversations (
+			id TEXT PRIMARY KEY,
+			owner_id TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			archived BOOLEAN NOT NULL DEFAULT FALSE,
+			active_message_id TEXT,
+			profile_id TEXT NOT NULL DEFAULT '',
+			created_at ` + timestamp + ` NOT NULL,
+			updated_at ` + timestamp + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS conversations_owner ON conversations (owner_id, archived, updated_at)`,
+		`CREATE TABLE IF NOT EXISTS messages (
+			id TEXT PRIMARY KEY,
+			conversation_id TEXT NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			parent_id TEXT,
+			role TEXT NOT NULL,
+			content TEXT NOT NULL,
+			sources TEXT NOT NULL DEFAULT '[]',
+			citations TEXT NOT NULL DEFAULT '[]',
+			metadata TEXT,
+			created_at ` + timestamp + ` NOT NULL,
+			UNIQUE (conversation_id, position)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_embeddings (
+			message_id TEXT PRIMARY KEY REFERENCES messages (id) ON DELETE CASCADE,
+			model TEXT NOT NULL,
+			vector ` + d.blob + ` NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS feedback (
+			message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+			user_id TEXT NOT NULL,
+			conversation_id TEXT NOT NULL,
+			rating TEXT NOT NULL,
+			reasons TEXT NOT NULL DEFAULT '[]',
+			comment TEXT NOT NULL DEFAULT '',
+			query TEXT NOT NULL,
+			standalone_query TEXT NOT NULL DEFAULT '',
+			chunks TEXT NOT NULL DEFAULT '[]',
+			prompts TEXT NOT NULL DEFAULT '[]',
+			model TEXT NOT NULL DEFAULT '',
+			created_at ` + timestamp + ` NOT NULL,
+			PRIMARY KEY (message_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS feedback_created ON feedback (created_at)`,
+		`CREATE TABLE IF NOT EXISTS exposures (
+			experiment_id TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			conversation_id TEXT NOT NULL DEFAULT '',
+			message_id TEXT NOT NULL DEFAULT '',
+			latency_ms INTEGER NOT NULL,
+			created_at ` + timestamp + ` NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS exposures_experiment ON exposures (experiment_id, created_at)`,
+	}
+}
+
+// migrations add the columns of later versions to tables created before
+// them. Each is applied when its probe fails.
+var migrations = []struct {
+	probe      string
+	statements []string
+}{
+	{
+		// Messages became a tree; existing conversations are a single branch
+		probe: `SELECT parent_id FROM messages LIMIT 1`,
+		statements: []string{
+			`ALTER TABLE messages ADD COLUMN parent_id TEXT`,
+			`UPDATE messages SET parent_id = (SELECT p.id FROM messages p
+				WHERE p.conversation_id = messages.conversation_id AND p.position = messages.position - 1)`,
+		},
+	},
+	{
+		probe:      `SELECT active_message_id FROM conversations LIMIT 1`,
+		statements: []string{`ALTER TABLE conversations ADD COLUMN active_message_id TEXT`},
+	},
+	{
+		probe:      `SELECT profile_id FROM conversations LIMIT 1`,
+		statements: []string{`ALTER TABLE conversations ADD COLUMN profile_id TEXT NOT NULL DEFAULT ''`},
+	},
+}
+
+// SQLStore keeps conversations in a SQLite or Postgres database. Queries use
+// $n placeholders, which both accept.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// OpenSQL opens the database and creates its tables if needed. driver is
+// sqlite, whose dsn is a file path or URI, or postgres, whose dsn is a
+// connection URL.
+func OpenSQL(driver, dsn string) (*SQLStore, error) {
+	switch driver {
+	case "sqlite3":
+		driver = DriverSQLite
+	case "postgresql":
+		driver = DriverPostgres
+	}
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported conversation database driver %q", driver)
+	}
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DriverSQLite {
+		// SQLite has a single writer, and an in-memory database lives in
+		// a single connection
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, stmt := range schema(d) {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create conversation tables: %w", err)
+		}
+	}
+	for _, m := range migrations {
+		if rows, err := db.Query(m.probe); err == nil {
+			rows.Close()
+			continue
+		}
+		for _, stmt := range m.statements {
+			if _, err := db.Exec(stmt); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to migrate conversation tables: %w", err)
+			}
+		}
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// Close closes the database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+const conversationColumns = `id, owner_id, title, archived, active_message_id, profile_id, created_at, updated_at`
+
+// Create stores a new conversation for c.OwnerID with c.Title, which may be
+// empty to be generated later, and c.ProfileID
+func (s *SQLStore) Create(ctx context.Context, c *Conversation) (*Conversation, error) {
+	if c.OwnerID == "" {
+		return nil, fmt.Errorf("%w: conversation has no owner", ErrInvalid)
+	}
+	title, err := validTitle(c.Title)
+	if err != nil {
+		return nil, err
+	}
+	now := now()
+	created := &Conversation{ID: newID("cnv"), OwnerID: c.OwnerID, Title: title, Archived: c.Archived, ProfileID: c.ProfileID, CreatedAt: now, UpdatedAt: now}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO conversations (`+conversationColumns+`) VALUES ($1, $2, $3, $4, NULL, $5, $6, $7)`,
+		created.ID, created.OwnerID, created.Title, created.Archived, created.ProfileID, created.CreatedAt, created.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return created, nil
+}
+
+// Get returns one of the owner's conversations
+func (s *SQLStore) Get(ctx context.Context, ownerID, id string) (*Conversation, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+conversationColumns+` FROM conversations WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	c, err := scanConversation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return c, nil
+}
+
+// List returns a page of the owner's archived or unarchived conversations,
+// most recently active first, and their total number
+func (s *SQLStore) List(ctx context.Context, ownerID string, opts ListOptions) ([]*Conversation, int, error) {
+	var total int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM conversations WHERE owner_id = $1 AND archived = $2`, ownerID, opts.Archived).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count conversations: %w", err)
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+conversationColumns+` FROM conversations WHERE owner_id = $1 AND archived = $2
+		ORDER BY updated_at DESC, id LIMIT $3 OFFSET $4`,
+		ownerID, opts.Archived, min(limit, MaxLimit), max(opts.Offset, 0))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	defer rows.Close()
+	conversations := []*Conversation{}
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to list conversations: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	return conversations, total, nil
+}
+
+// Update renames, archives, unarchives or changes the profile of one of the
+// owner's conversations.
+// It does not count as activity, so the conversation keeps its place in the
+// list.
+func (s *SQLStore) Update(ctx context.Context, ownerID, id string, u Update) (*Conversation, error) {
+	var sets []string
+	var args []any
+	if u.Title != nil {
+		title, err := validTitle(*u.Title)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, title)
+		sets = append(sets, "title = $"+strconv.Itoa(len(args)))
+	}
+	if u.Archived != nil {
+		args = append(args, *u.Archived)
+		sets = append(sets, "archived = $"+strconv.Itoa(len(args)))
+	}
+	if u.ProfileID != nil {
+		args = append(args, *u.ProfileID)
+		sets = append(sets, "profile_id = $"+strconv.Itoa(len(args)))
+	}
+	if len(sets) == 0 {
+		return s.Get(ctx, ownerID, id)
+	}
+	args = append(args, id, ownerID)
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE conversations SET %s WHERE id = $%d AND owner_id = $%d`, strings.Join(sets, ", "), len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Get(ctx, ownerID, id)
+}
+
+// Delete removes one of the owner's conversations and its messages
+func (s *SQLStore) Delete(ctx context.Context, ownerID, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	defer tx.Rollback()
+
+	// SQLite does not enforce the cascade unless asked to, so messages,
+	// their embeddings and their feedback are deleted explicitly
+	for _, table := range []string{"message_embeddings", "feedback"} {
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM `+table+` WHERE message_id IN (
+			SELECT m.id FROM messages m JOIN conversations c ON c.id = m.conversation_id WHERE c.id = $1 AND c.owner_id = $2)`, id, ownerID)
+		if err != nil {
+			return fmt.Errorf("failed to delete conversation: %w", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM messages WHERE conversation_id IN (SELECT id FROM conversations WHERE id = $1 AND owner_id = $2)`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM conversations WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// AddMessages appends messages to the active branch of one of the owner's
+// conversations in order, setting their IDs, conversation, parents and times
+func (s *SQLStore) AddMessages(ctx context.Context, ownerID, id string, messages ...*Message) error {
+	return s.addMessages(ctx, ownerID, id, nil, messages)
+}
+
+// AddBranch adds messages to one of the owner's conversations as a new branch
+// after the parent message, or as a new first question when parentID is
+// empty
+func (s *SQLStore) AddBranch(ctx context.Context, ownerID, id, parentID string, messages ...*Message) error {
+	return s.addMessages(ctx, ownerID, id, &parentID, messages)
+}
+
+// addMessages chains messages after the parent, or after the last message
+// of the active branch when parent is nil, and makes the last one active
+func (s *SQLStore) addMessages(ctx context.Context, ownerID, id string, parent *string, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	for _, m := range messages {
+		if m.Role != RoleUser && m.Role != RoleAssistant {
+			return fmt.Errorf("%w: unknown message role %q", ErrInvalid, m.Role)
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to add messages: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Touching the conversation first checks that the owner has it, and in
+	// Postgres locks it so that concurrent appends get distinct positions
+	now := now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE conversations SET updated_at = $1 WHERE id = $2 AND owner_id = $3`, now, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("failed to add messages: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	var position int
+	var lastID, activeID sql.NullString
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(position), 0), (SELECT id FROM messages WHERE conversation_id = $1 ORDER BY position DESC LIMIT 1),
+			(SELECT active_message_id FROM conversations WHERE id = $1)
+		FROM messages WHERE conversation_id = $1`, id).Scan(&position, &lastID, &activeID); err != nil {
+		return fmt.Errorf("failed to add messages: %w", err)
+	}
+	var parentID string
+	switch {
+	case parent != nil && *parent != "":
+		var n int
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM messages WHERE id = $1 AND conversation_id = $2`, *parent, id).Scan(&n); err != nil {
+			return fmt.Errorf("failed to add messages: %w", err)
+		}
+		if n == 0 {
+			return ErrMessageNotFound
+		}
+		parentID = *parent
+	case parent != nil:
+	case activeID.Valid:
+		parentID = activeID.String
+	default:
+		parentID = lastID.String
+	}
+
+	for _, m := range messages {
+		position++
+		m.ID, m.ConversationID, m.ParentID, m.CreatedAt = newID("msg"), id, parentID, now
+		parentID = m.ID
+		sources, err := json.Marshal(nonNil(m.Sources))
+		if err != nil {
+			return err
+		}
+		citations, err := json.Marshal(nonNil(m.Citations))
+		if err != nil {
+			return err
+		}
+		var metadata sql.NullString
+		if m.Metadata != nil {
+			data, err := json.Marshal(m.Metadata)
+			if err != nil {
+				return err
+			}
+			metadata = sql.NullString{String: string(data), Valid: true}
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO messages (id, conversation_id, position, parent_id, role, content, sources, citations, metadata, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			m.ID, id, position, nullString(m.ParentID), m.Role, m.Content, string(sources), string(citations), metadata, m.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to add messages: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE conversations SET active_message_id = $1 WHERE id = $2`, parentID, id); err != nil {
+		return fmt.Errorf("failed to add messages: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Messages returns the messages of one of the owner's conversations, oldest
+// first
+func (s *SQLStore) Messages(ctx context.Context, ownerID, id string) ([]*Message, error) {
+	if _, err := s.Get(ctx, ownerID, id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, conversation_id, parent_id, role, content, sources, citations, metadata, created_at
+		FROM messages WHERE conversation_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+	messages := []*Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get messages: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	return messages, nil
+}
+
+// FindMessages returns the owner's messages that pass the filter, most
+// recent first
+func (s *SQLStore) FindMessages(ctx context.Context, ownerID string, f MessageFilter) ([]*Found, error) {
+	args := []any{ownerID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	query := `SELECT m.id, m.conversation_id, m.parent_id, m.role, m.content, m.sources, m.citations, m.metadata, m.created_at, c.title`
+	from := ` FROM messages m JOIN conversations c ON c.id = m.conversation_id`
+	where := []string{"c.owner_id = $1"}
+	if f.EmbeddingModel != "" {
+		query += `, e.vector`
+		from += ` JOIN message_embeddings e ON e.message_id = m.id`
+		where = append(where, "e.model = "+arg(f.EmbeddingModel))
+	}
+	if len(f.ConversationIDs) > 0 {
+		var ids []string
+		for _, id := range f.ConversationIDs {
+			ids = append(ids, arg(id))
+		}
+		where = append(where, "c.id IN ("+strings.Join(ids, ", ")+")")
+	}
+	if !f.From.IsZero() {
+		where = append(where, "m.created_at >= "+arg(f.From.UTC()))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "m.created_at < "+arg(f.To.UTC()))
+	}
+	if len(f.Terms) > 0 {
+		// A cheap match on any term; the caller ranks what it finds
+		var matches []string
+		for _, term := range f.Terms {
+			pattern := arg("%" + likeEscaper.Replace(strings.ToLower(term)) + "%")
+			matches = append(matches, `LOWER(m.content) LIKE `+pattern+` ESCAPE '\'`, `LOWER(c.title) LIKE `+pattern+` ESCAPE '\'`)
+		}
+		where = append(where, "("+strings.Join(matches, " OR ")+")")
+	}
+	query += from + " WHERE " + strings.Join(where, " AND ") + " ORDER BY m.created_at DESC, m.position DESC"
+	if f.Limit > 0 {
+		query += " LIMIT " + arg(f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find messages: %w", err)
+	}
+	defer rows.Close()
+	var found []*Found
+	for rows.Next() {
+		fm := Found{Message: &Message{}}
+		var sources, citations string
+		var parentID, metadata sql.NullString
+		var vector []byte
+		dest := []any{&fm.Message.ID, &fm.Message.ConversationID, &parentID, &fm.Message.Role, &fm.Message.Content, &sources, &citations, &metadata, &fm.Message.CreatedAt, &fm.Title}
+		if f.EmbeddingModel != "" {
+			dest = append(dest, &vector)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to find messages: %w", err)
+		}
+		fm.Message.ParentID = parentID.String
+		if err := decodeMessage(fm.Message, sources, citations, metadata); err != nil {
+			return nil, err
+		}
+		fm.Vector = decodeVector(vector)
+		found = append(found, &fm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find messages: %w", err)
+	}
+	return found, nil
+}
+
+// SetActive makes the branch through one of the messages of one of the
+// owner's conversations the active one, following the most recent reply at
+// each step after it. It does not count as activity.
+func (s *SQLStore) SetActive(ctx context.Context, ownerID, id, messageID string) (*Conversation, error) {
+	messages, err := s.Messages(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(Branch(messages, messageID)) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE conversations SET active_message_id = $1 WHERE id = $2 AND owner_id = $3`,
+		latestDescendant(messages, messageID), id, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to switch branch: %w", err)
+	}
+	return s.Get(ctx, ownerID, id)
+}
+
+// SetEmbedding stores the embedding of a message by a model, replacing any
+// previous one
+func (s *SQLStore) SetEmbedding(ctx context.Context, messageID, model string, vector []float64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to store embedding: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_embeddings WHERE message_id = $1`, messageID); err != nil {
+		return fmt.Errorf("failed to store embedding: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO message_embeddings (message_id, model, vector) VALUES ($1, $2, $3)`, messageID, model, encodeVector(vector))
+	if err != nil {
+		return fmt.Errorf("failed to store embedding: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SetFeedback stores the owner's rating of one of the answers in their
+// conversations, replacing any earlier rating of it, together with the
+// question and what produced the answer
+func (s *SQLStore) SetFeedback(ctx context.Context, ownerID, messageID string, f *Feedback) (*Feedback, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		`SELECT m.id, m.conversation_id, m.parent_id, m.role, m.content, m.sources, m.citations, m.metadata, m.created_at
+		FROM messages m JOIN conversations c ON c.id = m.conversation_id WHERE m.id = $1 AND c.owner_id = $2`, messageID, ownerID)
+	answer, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store feedback: %w", err)
+	}
+	if answer.Role != RoleAssistant {
+		return nil, fmt.Errorf("%w: only answers can be rated", ErrInvalidFeedback)
+	}
+	var question string
+	if answer.ParentID != "" {
+		err := s.db.QueryRowContext(ctx, `SELECT content FROM messages WHERE id = $1`, answer.ParentID).Scan(&question)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to store feedback: %w", err)
+		}
+	}
+
+	stored := *f
+	stored.snapshot(answer, question)
+	stored.UserID, stored.CreatedAt = ownerID, now()
+	reasons, err := json.Marshal(nonNil(stored.Reasons))
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := json.Marshal(stored.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	prompts, err := json.Marshal(nonNil(stored.Prompts))
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store feedback: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM feedback WHERE message_id = $1 AND user_id = $2`, messageID, ownerID); err != nil {
+		return nil, fmt.Errorf("failed to store feedback: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO feedback (`+feedbackColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		stored.MessageID, stored.UserID, stored.ConversationID, stored.Rating, string(reasons), stored.Comment,
+		stored.Query, stored.StandaloneQuery, string(chunks), string(prompts), stored.Model, stored.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store feedback: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to store feedback: %w", err)
+	}
+	return &stored, nil
+}
+
+const feedbackColumns = `message_id, user_id, conversation_id, rating, reasons, comment, query, standalone_query, chunks, prompts, model, created_at`
+
+// ListFeedback returns every user's feedback given in a period, most recent
+// first
+func (s *SQLStore) ListFeedback(ctx context.Context, f FeedbackFilter) ([]*Feedback, error) {
+	var conditions []string
+	var args []any
+	if !f.From.IsZero() {
+		args = append(args, f.From.UTC())
+		conditions = append(conditions, "created_at >= $"+strconv.Itoa(len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To.UTC())
+		conditions = append(conditions, "created_at < $"+strconv.Itoa(len(args)))
+	}
+	query := `SELECT ` + feedbackColumns + ` FROM feedback`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list feedback: %w", err)
+	}
+	defer rows.Close()
+	feedback := []*Feedback{}
+	for rows.Next() {
+		var fb Feedback
+		var reasons, chunks, prompts string
+		if err := rows.Scan(&fb.MessageID, &fb.UserID, &fb.ConversationID, &fb.Rating, &reasons, &fb.Comment,
+			&fb.Query, &fb.StandaloneQuery, &chunks, &prompts, &fb.Model, &fb.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list feedback: %w", err)
+		}
+		fb.CreatedAt = fb.CreatedAt.UTC()
+		if err := json.Unmarshal([]byte(reasons), &fb.Reasons); err != nil {
+			return nil, fmt.Errorf("feedback on %s has invalid reasons: %w", fb.MessageID, err)
+		}
+		if err := json.Unmarshal([]byte(chunks), &fb.Chunks); err != nil {
+			return nil, fmt.Errorf("feedback on %s has invalid chunks: %w", fb.MessageID, err)
+		}
+		if err := json.Unmarshal([]byte(prompts), &fb.Prompts); err != nil {
+			return nil, fmt.Errorf("feedback on %s has invalid prompts: %w", fb.MessageID, err)
+		}
+		feedback = append(feedback, &fb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list feedback: %w", err)
+	}
+	return feedback, nil
+}
+
+// LogExposure records that an answer was produced by an experiment's variant
+func (s *SQLStore) LogExposure(ctx context.Context, e *Exposure) error {
+	created := e.CreatedAt
+	if created.IsZero() {
+		created = now()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO exposures (`+exposureColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.ExperimentID, e.Variant, e.UserID, e.ConversationID, e.MessageID, e.LatencyMs, created.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to log exposure: %w", err)
+	}
+	return nil
+}
+
+const exposureColumns = `experiment_id, variant, user_id, conversation_id, message_id, latency_ms, created_at`
+
+// ListExposures returns the exposures of an experiment, oldest first
+func (s *SQLStore) ListExposures(ctx context.Context, experimentID string) ([]*Exposure, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+exposureColumns+` FROM exposures WHERE experiment_id = $1 ORDER BY created_at`, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exposures: %w", err)
+	}
+	defer rows.Close()
+	exposures := []*Exposure{}
+	for rows.Next() {
+		var e Exposure
+		if err := rows.Scan(&e.ExperimentID, &e.Variant, &e.UserID, &e.ConversationID, &e.MessageID, &e.LatencyMs, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to list exposures: %w", err)
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		exposures = append(exposures, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list exposures: %w", err)
+	}
+	return exposures, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// encodeVector packs an embedding as little-endian float32s, which keeps
+// well within the precision embeddings are compared at
+func encodeVector(vector []float64) []byte {
+	b := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(v)))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float64 {
+	if len(b) == 0 {
+		return nil
+	}
+	vector := make([]float64, len(b)/4)
+	for i := range vector {
+		vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return vector
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanConversation(row scanner) (*Conversation, error) {
+	var c Conversation
+	var active sql.NullString
+	if err := row.Scan(&c.ID, &c.OwnerID, &c.Title, &c.Archived, &active, &c.ProfileID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	c.ActiveMessageID = active.String
+	c.CreatedAt, c.UpdatedAt = c.CreatedAt.UTC(), c.UpdatedAt.UTC()
+	return &c, nil
+}
+
+func scanMessage(row scanner) (*Message, error) {
+	var m Message
+	var sources, citations string
+	var parentID, metadata sql.NullString
+	if err := row.Scan(&m.ID, &m.ConversationID, &parentID, &m.Role, &m.Content, &sources, &citations, &metadata, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	m.ParentID = parentID.String
+	if err := decodeMessage(&m, sources, citations, metadata); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// decodeMessage fills in the JSON columns of a scanned message
+func decodeMessage(m *Message, sources, citations string, metadata sql.NullString) error {
+	m.CreatedAt = m.CreatedAt.UTC()
+	if err := json.Unmarshal([]byte(sources), &m.Sources); err != nil {
+		return fmt.Errorf("message %s has invalid sources: %w", m.ID, err)
+	}
+	if err := json.Unmarshal([]byte(citations), &m.Citations); err != nil {
+		return fmt.Errorf("message %s has invalid citations: %w", m.ID, err)
+	}
+	if metadata.Valid {
+		m.Metadata = &Metadata{}
+		if err := json.Unmarshal([]byte(metadata.String), m.Metadata); err != nil {
+			return fmt.Errorf("message %s has invalid metadata: %w", m.ID, err)
+		}
+	}
+	return nil
+}
+
+// now returns the current time at the precision both databases keep
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nonNil stores empty lists as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
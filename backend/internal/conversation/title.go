@@ -0,0 +1,75 @@
+package conversation
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+)
+
+const (
+	// fallbackTitleChars is the length a question is cut to when it is used
+	// as the title
+	fallbackTitleChars = 60
+	// titleAnswerChars is the number of characters long answers are cut to
+	// in the titling prompt
+	titleAnswerChars = 1000
+)
+
+const titlePrompt = `Write a title of at most six words for a conversation about music that starts with the exchange below.
+Name the artist, work or topic it is about. Reply with the title only, without quotes or a trailing period.`
+
+// GenerateTitle asks the model to title a conversation from its first
+// question and answer. The question itself, shortened, is the title if the
+// model fails or replies with nothing usable.
+func GenerateTitle(ctx context.Context, chat rag.ChatFunc, question, answer string) string {
+	fallback := fallbackTitle(question)
+	if chat == nil {
+		return fallback
+	}
+	if runes := []rune(answer); len(runes) > titleAnswerChars {
+		answer = string(runes[:titleAnswerChars]) + "..."
+	}
+	reply, err := chat(ctx, []azure.ChatMessage{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: "Question: " + question + "\n\nAnswer: " + answer},
+	})
+	if err != nil {
+		return fallback
+	}
+	// A reply of several lines is an explanation rather than a title
+	title := strings.TrimSpace(reply)
+	if strings.Contains(title, "\n") {
+		return fallback
+	}
+	// Models tend to quote or label the title despite the instructions
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.TrimRight(strings.Trim(strings.TrimSpace(title), `"'*`), ".")
+	title = trimTitle(title)
+	if title == "" || len([]rune(title)) > maxTitleChars {
+		return fallback
+	}
+	return title
+}
+
+// fallbackTitle shortens a question to a title, cutting it at a word
+// boundary
+func fallbackTitle(question string) string {
+	title := trimTitle(question)
+	runes := []rune(title)
+	if len(runes) <= fallbackTitleChars {
+		return title
+	}
+	cut := string(runes[:fallbackTitleChars])
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > fallbackTitleChars/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, unicode.IsPunct) + "…"
+}
+
+// trimTitle collapses the whitespace of a title
+func trimTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
@@ -0,0 +1,67 @@
+package conversation
+
+// Branch returns the messages from the first question to the message with
+// ID lastID, following parents. It is empty when lastID is empty or not
+// among messages.
+func Branch(messages []*Message, lastID string) []*Message {
+	byID := make(map[string]*Message, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	var branch []*Message
+	for m := byID[lastID]; m != nil && len(branch) < len(messages); m = byID[m.ParentID] {
+		branch = append(branch, m)
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+// ActiveBranch returns the active branch of a conversation's messages, which
+// ends at its active message or else at its most recent one
+func ActiveBranch(c *Conversation, messages []*Message) []*Message {
+	if len(messages) == 0 {
+		return []*Message{}
+	}
+	last := c.ActiveMessageID
+	if last == "" {
+		last = messages[len(messages)-1].ID
+	}
+	return Branch(messages, last)
+}
+
+// latestDescendant returns the ID of the most recent message in the subtree
+// of id, following the most recent child at each step
+func latestDescendant(messages []*Message, id string) string {
+	// Messages are oldest first, so a later child replaces an earlier one
+	latestChild := make(map[string]string)
+	for _, m := range messages {
+		latestChild[m.ParentID] = m.ID
+	}
+	for i := 0; i < len(messages); i++ {
+		child, ok := latestChild[id]
+		if !ok {
+			break
+		}
+		id = child
+	}
+	return id
+}
+
+// Alternatives returns, for every message of a branch that was edited or
+// regenerated, the IDs of all its versions, oldest first. Switching to one
+// of them makes its branch active.
+func Alternatives(messages, branch []*Message) map[string][]string {
+	siblings := make(map[string][]string)
+	for _, m := range messages {
+		siblings[m.ParentID] = append(siblings[m.ParentID], m.ID)
+	}
+	alternatives := make(map[string][]string)
+	for _, m := range branch {
+		if versions := siblings[m.ParentID]; len(versions) > 1 {
+			alternatives[m.ID] = versions
+		}
+	}
+	return alternatives
+}
@@ -0,0 +1,131 @@
+// Package eval measures the chat pipeline against a golden set of questions,
+// so that changes to retrieval and prompts can be compared before they ship.
+// It scores retrieval by recall@k, MRR and nDCG against labeled chunk IDs,
+// and answers by exact match on factual fields and by faithfulness and
+// relevance as judged by a model. With the fake model and a local index it
+// runs offline and gives the same report every time.
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+	"gopkg.in/yaml.v3"
+)
+
+// Case is a question of the golden set with what a good answer retrieves
+// and says
+type Case struct {
+	ID       string `json:"id" yaml:"id"`
+	Question string `json:"question" yaml:"question"`
+	// History is the conversation before the question, if any
+	History []Turn `json:"history,omitempty" yaml:"history"`
+	// Relevant are the IDs of the chunks that answer the question; without
+	// them retrieval is not scored
+	Relevant []string `json:"relevant,omitempty" yaml:"relevant"`
+	// OutputType asks for a structured answer, one of rag.OutputTypes
+	OutputType string `json:"outputType,omitempty" yaml:"outputType"`
+	// Expected maps the factual fields of the answer to their values. For a
+	// structured answer a field is a dotted path into its JSON, e.g.
+	// 0.title for the first of a list of tracks; for prose the value must
+	// appear in the answer.
+	Expected map[string]string `json:"expected,omitempty" yaml:"expected"`
+}
+
+// Turn is a previous message of a case's conversation
+type Turn struct {
+	Role    string `json:"role" yaml:"role"` // user or assistant
+	Content string `json:"content" yaml:"content"`
+}
+
+// LoadCases reads a golden set from a YAML file, a list of cases, or a JSONL
+// file with a case per line
+func LoadCases(path string) ([]Case, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cases []Case
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &cases); err != nil {
+			return nil, fmt.Errorf("failed to parse golden set %s: %w", path, err)
+		}
+	case ".jsonl":
+		if err := eachLine(data, func(line []byte) error {
+			var c Case
+			if err := json.Unmarshal(line, &c); err != nil {
+				return err
+			}
+			cases = append(cases, c)
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to parse golden set %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("golden set %s must be .yaml, .yml or .jsonl", path)
+	}
+
+	seen := make(map[string]bool, len(cases))
+	for i, c := range cases {
+		if c.ID == "" || strings.TrimSpace(c.Question) == "" {
+			return nil, fmt.Errorf("case %d of %s needs an id and a question", i+1, path)
+		}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("case %s appears twice in %s", c.ID, path)
+		}
+		seen[c.ID] = true
+	}
+	return cases, nil
+}
+
+// LoadCorpus indexes the chunks of a JSONL file, one retrieval.Chunk per
+// line, in a local index named name
+func LoadCorpus(path, name string) (*retrieval.LocalIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	index := retrieval.NewLocalIndex(name)
+	err = eachLine(data, func(line []byte) error {
+		var chunk retrieval.Chunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return err
+		}
+		if chunk.ID == "" {
+			return fmt.Errorf("chunk without an id")
+		}
+		if chunk.DocumentID == "" {
+			chunk.DocumentID = chunk.ID
+		}
+		index.Add(chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load corpus %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// eachLine calls fn with every non-blank line, reporting the line number of
+// the first failure
+func eachLine(data []byte, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
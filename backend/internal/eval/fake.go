@@ -0,0 +1,272 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+)
+
+// fakeRefusal is the fake model's answer when no source shares a word with
+// the question
+const fakeRefusal = "The sources do not contain the answer."
+
+// stopwords are left out when the fake model compares questions, answers and
+// sources
+var stopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "from": true, "that": true, "this": true,
+	"who": true, "what": true, "which": true, "when": true, "where": true, "how": true, "why": true,
+	"was": true, "were": true, "are": true, "is": true, "did": true, "does": true, "has": true, "have": true,
+	"his": true, "her": true, "their": true, "its": true, "about": true, "into": true, "of": true,
+}
+
+// sourceHeader matches the first line of a numbered source in an answer
+// prompt, e.g. "[2] Real Book, Vol. 1 (page 12)"
+var sourceHeader = regexp.MustCompile(`^\[(\d+)\] `)
+
+// sentenceEnd splits passages into sentences
+var sentenceEnd = regexp.MustCompile(`[.!?]\s+|\n+`)
+
+// structuredSchema precedes the schema in the instructions the pipeline adds
+// to the system prompt of structured answers
+const structuredSchema = "conforms to this JSON Schema:\n"
+
+// creditedTo matches the name after "by", as in "recorded by Miles Davis"
+var creditedTo = regexp.MustCompile(`\bby (?:the )?(\p{Lu}[\p{L}'.-]*(?: \p{Lu}[\p{L}'.-]*)*)`)
+
+// fieldPatterns find the value of a field of a structured answer in a
+// source, by the field's name
+var fieldPatterns = map[string]*regexp.Regexp{
+	"artist":        creditedTo,
+	"composer":      creditedTo,
+	"year":          regexp.MustCompile(`\b((?:18|19|20)\d\d)\b`),
+	"key":           regexp.MustCompile(`\b([A-G][#b]? (?:major|minor|Dorian|Phrygian|Lydian|Mixolydian|Aeolian|Locrian))\b`),
+	"timeSignature": regexp.MustCompile(`\b(\d{1,2}/\d{1,2})\b`),
+	"bpm":           regexp.MustCompile(`\b(\d+(?:\.\d+)?) ?BPM\b`),
+}
+
+// FakeModel stands in for the chat model so that evaluations run offline
+// and deterministically. It answers with the sentence of the numbered
+// sources that shares the most words with the question, citing it, condenses
+// follow-ups to the latest question, and judges by word overlap. Structured
+// answers fill the requested schema from the source of that sentence. It
+// reads the answer prompt in the layout of the built-in answer.user prompt.
+func FakeModel(ctx context.Context, messages []azure.ChatMessage) (string, error) {
+	if len(messages) == 0 {
+		return "", fmt.Errorf("fake model: no messages")
+	}
+	var system string
+	if messages[0].Role == "system" {
+		system = messages[0].Content
+	}
+	user := messages[len(messages)-1].Content
+	switch {
+	case system == FaithfulnessPrompt:
+		passages, answer, _ := strings.Cut(strings.TrimPrefix(user, "Passages:\n"), "\nAnswer:\n")
+		if strings.Contains(answer, fakeRefusal) {
+			return fakeJudgement(1, "the answer declines to answer"), nil
+		}
+		return fakeJudgement(overlap(answer, passages), "share of the answer's words found in the passages"), nil
+	case system == RelevancePrompt:
+		question, answer, _ := strings.Cut(strings.TrimPrefix(user, "Question:\n"), "\n\nAnswer:\n")
+		return fakeJudgement(overlap(question, answer), "share of the question's words found in the answer"), nil
+	case strings.Contains(user, "\nLatest question: "):
+		_, latest, _ := strings.Cut(user, "\nLatest question: ")
+		return latest, nil
+	case strings.Contains(system, structuredSchema):
+		_, schema, _ := strings.Cut(system, structuredSchema)
+		schema, _, _ = strings.Cut(schema, "\n")
+		return fakeStructured(schema, user)
+	}
+	return fakeAnswer(user), nil
+}
+
+// fakeSource is a numbered source of an answer prompt
+type fakeSource struct {
+	number  int
+	title   string
+	content string
+}
+
+// parsePrompt splits an answer prompt into its sources and question
+func parsePrompt(prompt string) ([]fakeSource, string, bool) {
+	text, question, ok := strings.Cut(prompt, "\n\nQuestion: ")
+	if !ok {
+		return nil, "", false
+	}
+	var sources []fakeSource
+	for _, line := range strings.Split(strings.TrimPrefix(text, "Sources:\n\n"), "\n") {
+		if m := sourceHeader.FindStringSubmatch(line); m != nil {
+			number, _ := strconv.Atoi(m[1])
+			sources = append(sources, fakeSource{number: number, title: strings.TrimPrefix(line, m[0])})
+		} else if len(sources) > 0 {
+			sources[len(sources)-1].content += line + "\n"
+		}
+	}
+	return sources, question, true
+}
+
+// bestSentence returns the sentence of the sources that shares the most
+// words with question, with its source, or false if none shares a word
+func bestSentence(sources []fakeSource, question string) (string, fakeSource, bool) {
+	var best string
+	var source fakeSource
+	bestScore := 0
+	for _, s := range sources {
+		for _, sentence := range sentenceEnd.Split(s.content, -1) {
+			if score := len(shared(question, sentence)); score > bestScore {
+				best, source, bestScore = strings.TrimSpace(sentence), s, score
+			}
+		}
+	}
+	return best, source, bestScore > 0
+}
+
+// fakeAnswer picks the sentence of the sources in an answer prompt that
+// shares the most words with its question
+func fakeAnswer(prompt string) string {
+	sources, question, ok := parsePrompt(prompt)
+	if !ok {
+		return fakeRefusal
+	}
+	best, source, ok := bestSentence(sources, question)
+	if !ok {
+		return fakeRefusal
+	}
+	return fmt.Sprintf("%s. [%d]", strings.TrimRight(best, "."), source.number)
+}
+
+// fakeStructured answers with a {"data": ...} envelope whose value follows
+// schema, filled from the source that best answers the question. Fields it
+// finds nothing for are null where the schema allows it.
+func fakeStructured(schema, prompt string) (string, error) {
+	var doc any
+	if err := json.Unmarshal([]byte(schema), &doc); err != nil {
+		return "", fmt.Errorf("fake model: invalid schema: %w", err)
+	}
+	var source fakeSource
+	if sources, question, ok := parsePrompt(prompt); ok {
+		_, source, _ = bestSentence(sources, question)
+	}
+	reply, err := json.Marshal(map[string]any{"data": fakeValue(doc, "", source)})
+	if err != nil {
+		return "", err
+	}
+	return string(reply), nil
+}
+
+// fakeValue builds a value following a schema for the named field
+func fakeValue(schema any, field string, source fakeSource) any {
+	s, _ := schema.(map[string]any)
+	if values, ok := s["enum"].([]any); ok && len(values) > 0 {
+		return values[0]
+	}
+	var types []string
+	switch t := s["type"].(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				types = append(types, name)
+			}
+		}
+	}
+	found := fakeField(field, source)
+	for _, t := range types {
+		switch t {
+		case "object":
+			properties, _ := s["properties"].(map[string]any)
+			value := make(map[string]any, len(properties))
+			for name, property := range properties {
+				value[name] = fakeValue(property, name, source)
+			}
+			return value
+		case "array":
+			n := 1
+			if m, ok := s["minItems"].(float64); ok && int(m) > n {
+				n = int(m)
+			}
+			items := make([]any, n)
+			for i := range items {
+				items[i] = fakeValue(s["items"], field, source)
+			}
+			return items
+		case "string":
+			if found != "" || !slices.Contains(types, "null") {
+				return found
+			}
+		case "integer", "number":
+			if v, err := strconv.ParseFloat(found, 64); err == nil {
+				if t == "integer" {
+					return int(v)
+				}
+				return v
+			}
+			if !slices.Contains(types, "null") {
+				return 0
+			}
+		case "boolean":
+			return false
+		}
+	}
+	return nil
+}
+
+// fakeField finds the value of a field in a source: its title for titles
+// and names, otherwise what the field's pattern matches in its content
+func fakeField(field string, source fakeSource) string {
+	if field == "title" || field == "name" {
+		return source.title
+	}
+	if pattern, ok := fieldPatterns[field]; ok {
+		if m := pattern.FindStringSubmatch(source.content); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// overlap returns the share of the words of a found in b
+func overlap(a, b string) float64 {
+	words := contentWords(a)
+	if len(words) == 0 {
+		return 1
+	}
+	return float64(len(shared(a, b))) / float64(len(words))
+}
+
+// shared returns the words of a that are also in b
+func shared(a, b string) []string {
+	in := contentWords(b)
+	var found []string
+	for _, w := range contentWords(a) {
+		if slices.Contains(in, w) {
+			found = append(found, w)
+		}
+	}
+	return found
+}
+
+// contentWords returns the distinct words of text that are not stopwords
+func contentWords(text string) []string {
+	var words []string
+	for _, w := range retrieval.Tokenize(citationMarker.ReplaceAllString(text, "")) {
+		if !stopwords[w] && !slices.Contains(words, w) {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// fakeJudgement grades a share from 0 to 1 on the judge's scale of 1 to 5
+func fakeJudgement(share float64, reason string) string {
+	return fmt.Sprintf(`{"score": %d, "reason": %q}`, 1+int(math.Round(4*share)), reason)
+}
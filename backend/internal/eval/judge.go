@@ -0,0 +1,78 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+)
+
+// The prompts of the judge. Both are answered with a grade from 1 to 5.
+const (
+	FaithfulnessPrompt = `You grade whether an answer is faithful to the passages it was given.
+5 means every statement of the answer is stated or directly implied by the passages; 1 means the answer is mostly unsupported or contradicts them.
+An answer saying that the passages do not contain the answer is faithful.
+Reply with JSON only: {"score": <1 to 5>, "reason": "<one sentence>"}`
+
+	RelevancePrompt = `You grade whether an answer addresses the question that was asked.
+5 means it answers the question directly and completely; 1 means it is about something else.
+Reply with JSON only: {"score": <1 to 5>, "reason": "<one sentence>"}`
+)
+
+// Grade is a judgement of an answer, scaled from 0 to 1
+type Grade struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// Judge grades answers with a language model
+type Judge struct {
+	Chat rag.ChatFunc
+}
+
+// Faithfulness grades how well an answer is supported by the passages it
+// was grounded on
+func (j *Judge) Faithfulness(ctx context.Context, answer string, chunks []retrieval.Chunk) (Grade, error) {
+	var b strings.Builder
+	b.WriteString("Passages:\n")
+	for i, c := range chunks {
+		fmt.Fprintf(&b, "\n[%d] %s\n%s\n", i+1, c.Title, c.Content)
+	}
+	fmt.Fprintf(&b, "\nAnswer:\n%s", answer)
+	return j.grade(ctx, FaithfulnessPrompt, b.String())
+}
+
+// Relevance grades how well an answer addresses the question
+func (j *Judge) Relevance(ctx context.Context, question, answer string) (Grade, error) {
+	return j.grade(ctx, RelevancePrompt, fmt.Sprintf("Question:\n%s\n\nAnswer:\n%s", question, answer))
+}
+
+func (j *Judge) grade(ctx context.Context, system, user string) (Grade, error) {
+	reply, err := j.Chat(ctx, []azure.ChatMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: user},
+	})
+	if err != nil {
+		return Grade{}, err
+	}
+	// Tolerate prose or code fences around the JSON object
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return Grade{}, fmt.Errorf("no JSON object in judgement %q", reply)
+	}
+	var judgement struct {
+		Score  float64 `json:"score"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &judgement); err != nil {
+		return Grade{}, fmt.Errorf("failed to parse judgement %q: %w", reply, err)
+	}
+	if judgement.Score < 1 || judgement.Score > 5 {
+		return Grade{}, fmt.Errorf("judgement score %v is not from 1 to 5", judgement.Score)
+	}
+	return Grade{Score: (judgement.Score - 1) / 4, Reason: judgement.Reason}, nil
+}
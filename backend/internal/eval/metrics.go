@@ -0,0 +1,117 @@
+package eval
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+)
+
+// RecallAt returns the share of the relevant chunks among the first k
+// retrieved
+func RecallAt(retrieved, relevant []string, k int) float64 {
+	if len(relevant) == 0 {
+		return 0
+	}
+	found := 0
+	for _, id := range retrieved[:min(k, len(retrieved))] {
+		if slices.Contains(relevant, id) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(relevant))
+}
+
+// ReciprocalRank returns 1/n for the rank n of the first relevant chunk
+// retrieved, or 0 if none was
+func ReciprocalRank(retrieved, relevant []string) float64 {
+	for i, id := range retrieved {
+		if slices.Contains(relevant, id) {
+			return 1 / float64(i+1)
+		}
+	}
+	return 0
+}
+
+// NDCGAt returns the normalized discounted cumulative gain of the first k
+// retrieved chunks, every relevant chunk having a gain of 1
+func NDCGAt(retrieved, relevant []string, k int) float64 {
+	var dcg, ideal float64
+	for i, id := range retrieved[:min(k, len(retrieved))] {
+		if slices.Contains(relevant, id) {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+	for i := range min(k, len(relevant)) {
+		ideal += 1 / math.Log2(float64(i+2))
+	}
+	if ideal == 0 {
+		return 0
+	}
+	return dcg / ideal
+}
+
+// citationMarker matches an inline citation such as [1] or [2, 3]
+var citationMarker = regexp.MustCompile(`\s*\[\d+(?:,\s*\d+)*\]`)
+
+// normalize lower-cases text, drops citations and collapses punctuation and
+// spacing, so that "Joseph Kosma [1]." matches "joseph kosma"
+func normalize(text string) string {
+	return strings.Join(retrieval.Tokenize(citationMarker.ReplaceAllString(text, "")), " ")
+}
+
+// MatchFields checks the expected fields of an answer. A structured answer
+// must hold the value at each field's path; a prose answer must contain
+// each value as a whole phrase.
+func MatchFields(expected map[string]string, content string, data json.RawMessage) map[string]bool {
+	matches := make(map[string]bool, len(expected))
+	var doc any
+	if len(data) > 0 && json.Unmarshal(data, &doc) != nil {
+		doc = nil
+	}
+	answer := " " + normalize(content) + " "
+	for field, want := range expected {
+		if doc != nil {
+			got, ok := lookup(doc, field)
+			matches[field] = ok && normalize(got) == normalize(want)
+		} else {
+			matches[field] = strings.Contains(answer, " "+normalize(want)+" ")
+		}
+	}
+	return matches
+}
+
+// lookup returns the value at a dotted path into a JSON document as text,
+// e.g. tracks.0.title
+func lookup(doc any, path string) (string, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			var ok bool
+			if doc, ok = v[key]; !ok {
+				return "", false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			doc = v[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case nil:
+		return "", false
+	default:
+		data, _ := json.Marshal(v)
+		return string(data), true
+	}
+}
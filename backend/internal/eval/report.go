@@ -0,0 +1,298 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+)
+
+// DefaultK is the number of chunks retrieved and scored per question
+const DefaultK = 5
+
+// Report is the outcome of a run over a golden set. It holds no times or
+// other run-specific values, so that the reports of two runs can be diffed.
+type Report struct {
+	Model   string       `json:"model"`
+	K       int          `json:"k"`
+	Prompts []prompt.Ref `json:"prompts,omitempty"`
+	Summary Summary      `json:"summary"`
+	Cases   []CaseResult `json:"cases"`
+}
+
+// Summary averages each metric over the cases it applies to; a metric no
+// case applies to is nil
+type Summary struct {
+	Cases        int      `json:"cases"`
+	Failed       int      `json:"failed"`
+	Recall       *float64 `json:"recall"`
+	MRR          *float64 `json:"mrr"`
+	NDCG         *float64 `json:"ndcg"`
+	ExactMatch   *float64 `json:"exactMatch"`
+	Faithfulness *float64 `json:"faithfulness"`
+	Relevance    *float64 `json:"relevance"`
+}
+
+// CaseResult is the outcome of one question. Retrieval metrics are nil for
+// cases without labeled chunks, exact match for cases without expected
+// fields and the judged metrics when there is no judge.
+type CaseResult struct {
+	ID        string   `json:"id"`
+	Error     string   `json:"error,omitempty"`
+	Route     string   `json:"route,omitempty"`
+	Retrieved []string `json:"retrieved"`
+	Recall    *float64 `json:"recall,omitempty"`
+	MRR       *float64 `json:"mrr,omitempty"`
+	NDCG      *float64 `json:"ndcg,omitempty"`
+	// Fields reports which expected fields matched
+	Fields       map[string]bool `json:"fields,omitempty"`
+	ExactMatch   *float64        `json:"exactMatch,omitempty"`
+	Faithfulness *Grade          `json:"faithfulness,omitempty"`
+	Relevance    *Grade          `json:"relevance,omitempty"`
+	Answer       string          `json:"answer"`
+}
+
+// Runner runs golden sets through a pipeline
+type Runner struct {
+	Pipeline *rag.Pipeline
+	// Judge grades faithfulness and relevance; nil to skip them
+	Judge *Judge
+	// K is the number of chunks retrieved and scored; DefaultK when 0
+	K int
+	// Model names the model in the report, e.g. fake
+	Model string
+}
+
+// Run answers every case in order and scores the answers
+func (r *Runner) Run(ctx context.Context, cases []Case) *Report {
+	k := r.K
+	if k <= 0 {
+		k = DefaultK
+	}
+	report := &Report{Model: r.Model, K: k, Cases: make([]CaseResult, 0, len(cases))}
+	for _, c := range cases {
+		result, refs := r.run(ctx, c, k)
+		if refs != nil && report.Prompts == nil {
+			report.Prompts = refs
+		}
+		report.Cases = append(report.Cases, result)
+	}
+	report.Summary = summarize(report.Cases)
+	return report
+}
+
+// run answers and scores one case, returning the prompt versions used
+func (r *Runner) run(ctx context.Context, c Case, k int) (CaseResult, []prompt.Ref) {
+	result := CaseResult{ID: c.ID, Retrieved: []string{}}
+	history := make([]rag.Turn, 0, len(c.History))
+	for _, t := range c.History {
+		history = append(history, rag.Turn{Role: t.Role, Content: t.Content})
+	}
+	answer, err := r.Pipeline.Answer(ctx, rag.Request{Query: c.Question, History: history, OutputType: c.OutputType, Top: k})
+	if err != nil {
+		log.Printf("Eval case %s failed: %v", c.ID, err)
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Route, result.Answer = string(answer.Route.Intent), answer.Content
+	for _, chunk := range answer.Chunks {
+		result.Retrieved = append(result.Retrieved, chunk.ID)
+	}
+
+	if len(c.Relevant) > 0 {
+		result.Recall = score(RecallAt(result.Retrieved, c.Relevant, k))
+		result.MRR = score(ReciprocalRank(result.Retrieved, c.Relevant))
+		result.NDCG = score(NDCGAt(result.Retrieved, c.Relevant, k))
+	}
+	if len(c.Expected) > 0 {
+		result.Fields = MatchFields(c.Expected, answer.Content, answer.Data)
+		matched := 0
+		for _, ok := range result.Fields {
+			if ok {
+				matched++
+			}
+		}
+		result.ExactMatch = score(float64(matched) / float64(len(result.Fields)))
+	}
+	if r.Judge != nil {
+		// Faithfulness is judged against passages, which theory answers and
+		// structured answers from the catalog may not have
+		if len(answer.Chunks) > 0 {
+			if g, err := r.Judge.Faithfulness(ctx, answer.Content, answer.Chunks); err != nil {
+				log.Printf("Judging the faithfulness of case %s failed: %v", c.ID, err)
+			} else {
+				g.Score = *score(g.Score)
+				result.Faithfulness = &g
+			}
+		}
+		if g, err := r.Judge.Relevance(ctx, c.Question, answer.Content); err != nil {
+			log.Printf("Judging the relevance of case %s failed: %v", c.ID, err)
+		} else {
+			g.Score = *score(g.Score)
+			result.Relevance = &g
+		}
+	}
+	return result, answer.Prompts
+}
+
+// summarize averages the metrics of the cases
+func summarize(cases []CaseResult) Summary {
+	s := Summary{Cases: len(cases)}
+	for _, c := range cases {
+		if c.Error != "" {
+			s.Failed++
+		}
+	}
+	for _, m := range metrics {
+		var values []float64
+		for i := range cases {
+			if v := m.result(&cases[i]); v != nil {
+				values = append(values, *v)
+			}
+		}
+		if len(values) > 0 {
+			var sum float64
+			for _, v := range values {
+				sum += v
+			}
+			*m.summary(&s) = score(sum / float64(len(values)))
+		}
+	}
+	return s
+}
+
+// metric names a metric with where it is kept in summaries and results
+type metric struct {
+	name    string
+	summary func(*Summary) **float64
+	result  func(*CaseResult) *float64
+}
+
+var metrics = []metric{
+	{"recall@k", func(s *Summary) **float64 { return &s.Recall }, func(c *CaseResult) *float64 { return c.Recall }},
+	{"mrr", func(s *Summary) **float64 { return &s.MRR }, func(c *CaseResult) *float64 { return c.MRR }},
+	{"ndcg@k", func(s *Summary) **float64 { return &s.NDCG }, func(c *CaseResult) *float64 { return c.NDCG }},
+	{"exact", func(s *Summary) **float64 { return &s.ExactMatch }, func(c *CaseResult) *float64 { return c.ExactMatch }},
+	{"faithful", func(s *Summary) **float64 { return &s.Faithfulness }, func(c *CaseResult) *float64 { return gradeScore(c.Faithfulness) }},
+	{"relevant", func(s *Summary) **float64 { return &s.Relevance }, func(c *CaseResult) *float64 { return gradeScore(c.Relevance) }},
+}
+
+func gradeScore(g *Grade) *float64 {
+	if g == nil {
+		return nil
+	}
+	return &g.Score
+}
+
+// score rounds a metric to four decimals, which keeps reports stable across
+// platforms
+func score(v float64) *float64 {
+	rounded := math.Round(v*1e4) / 1e4
+	return &rounded
+}
+
+// WriteText writes the report as aligned text, a summary and then a line per
+// case, for reading and diffing
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	refs := make([]string, 0, len(r.Prompts))
+	for _, ref := range r.Prompts {
+		refs = append(refs, ref.String())
+	}
+	fmt.Fprintf(&b, "model %s, k %d, prompts %s\n", r.Model, r.K, strings.Join(refs, " "))
+	fmt.Fprintf(&b, "%d cases, %d failed\n\n", r.Summary.Cases, r.Summary.Failed)
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "%-10s %s\n", m.name, formatScore(*m.summary(&r.Summary)))
+	}
+
+	width := len("case")
+	for _, c := range r.Cases {
+		width = max(width, len(c.ID))
+	}
+	fmt.Fprintf(&b, "\n%-*s", width, "case")
+	for _, m := range metrics {
+		fmt.Fprintf(&b, " %9s", m.name)
+	}
+	b.WriteString("\n")
+	for i := range r.Cases {
+		c := &r.Cases[i]
+		fmt.Fprintf(&b, "%-*s", width, c.ID)
+		for _, m := range metrics {
+			fmt.Fprintf(&b, " %9s", formatScore(m.result(c)))
+		}
+		if c.Error != "" {
+			fmt.Fprintf(&b, "  error: %s", c.Error)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteDiff writes how the metrics of the report changed from a baseline
+// report, overall and for each case that changed
+func (r *Report) WriteDiff(w io.Writer, baseline *Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-10s %9s %9s %9s\n", "metric", "baseline", "current", "change")
+	for _, m := range metrics {
+		before, after := *m.summary(&baseline.Summary), *m.summary(&r.Summary)
+		fmt.Fprintf(&b, "%-10s %9s %9s %9s\n", m.name, formatScore(before), formatScore(after), formatChange(before, after))
+	}
+
+	previous := make(map[string]*CaseResult, len(baseline.Cases))
+	for i := range baseline.Cases {
+		previous[baseline.Cases[i].ID] = &baseline.Cases[i]
+	}
+	var changes []string
+	for i := range r.Cases {
+		c := &r.Cases[i]
+		old, ok := previous[c.ID]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("%s: new case", c.ID))
+			continue
+		}
+		var parts []string
+		for _, m := range metrics {
+			before, after := m.result(old), m.result(c)
+			if formatScore(before) != formatScore(after) {
+				parts = append(parts, fmt.Sprintf("%s %s -> %s", m.name, formatScore(before), formatScore(after)))
+			}
+		}
+		if old.Error != c.Error {
+			parts = append(parts, fmt.Sprintf("error %q -> %q", old.Error, c.Error))
+		}
+		if len(parts) > 0 {
+			changes = append(changes, fmt.Sprintf("%s: %s", c.ID, strings.Join(parts, ", ")))
+		}
+		delete(previous, c.ID)
+	}
+	for _, c := range baseline.Cases {
+		if _, ok := previous[c.ID]; ok {
+			changes = append(changes, fmt.Sprintf("%s: removed", c.ID))
+		}
+	}
+	if len(changes) > 0 {
+		b.WriteString("\n" + strings.Join(changes, "\n") + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatScore(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.4f", *v)
+}
+
+func formatChange(before, after *float64) string {
+	if before == nil || after == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.4f", *after-*before)
+}
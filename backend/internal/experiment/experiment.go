@@ -0,0 +1,439 @@
+// Package experiment runs A/B experiments on the chat pipeline. An
+// experiment enrolls a share of users and splits them between variants, each
+// answering with its own retrieval and prompt configuration. Users are
+// bucketed by a hash of their ID, so that they get the same variant on every
+// request and across restarts.
+package experiment
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+)
+
+var (
+	ErrNotFound = errors.New("experiment not found")
+	ErrInvalid  = errors.New("invalid experiment")
+	// ErrRunning is returned for changes a running experiment does not allow
+	ErrRunning = errors.New("experiment is running")
+)
+
+// States of an experiment
+const (
+	StatusDraft   = "draft"
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+// validID is an experiment or variant ID, e.g. keyword-search
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Experiment compares variants of the pipeline on live traffic. The first
+// variant is the control the others are compared with.
+type Experiment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Traffic is the share of users enrolled, from 0 to 1
+	Traffic   float64    `json:"traffic"`
+	Variants  []Variant  `json:"variants"`
+	Status    string     `json:"status"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	StoppedAt *time.Time `json:"stoppedAt,omitempty"`
+	UpdatedBy string     `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// Variant is one arm of an experiment
+type Variant struct {
+	Name string `json:"name"`
+	// Weight is the variant's share of the enrolled users relative to the
+	// other variants; 0 counts as 1
+	Weight int    `json:"weight,omitempty"`
+	Config Config `json:"config"`
+}
+
+// Config is how a variant answers. Unset fields keep the request's own
+// settings.
+type Config struct {
+	// Retriever names one of the pipeline's retrievers, e.g. hybrid or
+	// keyword
+	Retriever string `json:"retriever,omitempty"`
+	// Prompts pins answer prompts to versions, e.g. {"answer.system": 4}
+	Prompts map[string]int `json:"prompts,omitempty"`
+	// Top is the number of passages to retrieve
+	Top    int   `json:"top,omitempty"`
+	Expand *bool `json:"expand,omitempty"`
+	// Model is the chat deployment to answer with
+	Model string `json:"model,omitempty"`
+}
+
+// Assignment is the variant a user gets in an experiment
+type Assignment struct {
+	Experiment string
+	Variant    string
+	Config     Config
+}
+
+// Apply configures a request with the assignment's variant
+func (a *Assignment) Apply(req rag.Request) rag.Request {
+	req.Experiment, req.Variant = a.Experiment, a.Variant
+	c := a.Config
+	if c.Retriever != "" {
+		req.Retriever = c.Retriever
+	}
+	if len(c.Prompts) > 0 {
+		req.Prompts = maps.Clone(c.Prompts)
+	}
+	if c.Top > 0 {
+		req.Top = c.Top
+	}
+	if c.Expand != nil {
+		req.Expand = *c.Expand
+	}
+	if c.Model != "" {
+		req.Model = c.Model
+	}
+	return req
+}
+
+// assign returns the variant of a user, or nil when the user is not
+// enrolled. Enrollment and the variant are drawn from separate hashes, so
+// that raising the traffic keeps enrolled users in their variants.
+func (e *Experiment) assign(userID string) *Variant {
+	if bucket(e.ID, "traffic", userID) >= e.Traffic {
+		return nil
+	}
+	total := 0
+	for _, v := range e.Variants {
+		total += max(v.Weight, 1)
+	}
+	point := bucket(e.ID, "variant", userID) * float64(total)
+	cumulative := 0
+	for i := range e.Variants {
+		cumulative += max(e.Variants[i].Weight, 1)
+		if point < float64(cumulative) {
+			return &e.Variants[i]
+		}
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// bucket hashes a user to a number from 0 to 1 for one draw of an
+// experiment. Salting with the experiment keeps the draws of different
+// experiments independent.
+func bucket(experimentID, draw, userID string) float64 {
+	sum := sha256.Sum256([]byte(experimentID + "/" + draw + "/" + userID))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// validate checks the fields an experiment can be saved with
+func (e *Experiment) validate() error {
+	if !validID.MatchString(e.ID) {
+		return fmt.Errorf("%w: the ID must be lower-case letters, digits and dashes", ErrInvalid)
+	}
+	if strings.TrimSpace(e.Name) == "" {
+		return fmt.Errorf("%w: the name is empty", ErrInvalid)
+	}
+	if e.Traffic < 0 || e.Traffic > 1 {
+		return fmt.Errorf("%w: the traffic must be from 0 to 1", ErrInvalid)
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("%w: an experiment needs at least two variants", ErrInvalid)
+	}
+	seen := make(map[string]bool, len(e.Variants))
+	for _, v := range e.Variants {
+		if !validID.MatchString(v.Name) {
+			return fmt.Errorf("%w: variant names must be lower-case letters, digits and dashes", ErrInvalid)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("%w: variant %s appears twice", ErrInvalid, v.Name)
+		}
+		seen[v.Name] = true
+		if v.Weight < 0 {
+			return fmt.Errorf("%w: variant %s has a negative weight", ErrInvalid, v.Name)
+		}
+		if v.Config.Top < 0 {
+			return fmt.Errorf("%w: variant %s has a negative top", ErrInvalid, v.Name)
+		}
+		for name, version := range v.Config.Prompts {
+			if version < 1 {
+				return fmt.Errorf("%w: variant %s pins %s to version %d", ErrInvalid, v.Name, name, version)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Experiment) clone() *Experiment {
+	c := *e
+	c.Variants = make([]Variant, len(e.Variants))
+	for i, v := range e.Variants {
+		v.Config.Prompts = maps.Clone(v.Config.Prompts)
+		if v.Config.Expand != nil {
+			expand := *v.Config.Expand
+			v.Config.Expand = &expand
+		}
+		c.Variants[i] = v
+	}
+	if e.StartedAt != nil {
+		started := *e.StartedAt
+		c.StartedAt = &started
+	}
+	if e.StoppedAt != nil {
+		stopped := *e.StoppedAt
+		c.StoppedAt = &stopped
+	}
+	return &c
+}
+
+// Registry holds the experiments of this process
+type Registry struct {
+	mu          sync.RWMutex
+	experiments map[string]*Experiment
+	// path is where the registry is persisted, empty when persistence is
+	// disabled
+	path string
+}
+
+// RegistryInstance holds the experiments run on chat requests
+var RegistryInstance = NewRegistry("")
+
+// Init restores the experiments from EXPERIMENT_REGISTRY_PATH if set, so
+// that running experiments survive restarts
+func Init() {
+	RegistryInstance = NewRegistry(os.Getenv("EXPERIMENT_REGISTRY_PATH"))
+	if RegistryInstance.path == "" {
+		log.Println("EXPERIMENT_REGISTRY_PATH not set, experiments will not be persisted")
+		return
+	}
+	if err := RegistryInstance.load(); err != nil {
+		log.Fatalf("Failed to load experiments: %v", err)
+	}
+}
+
+// NewRegistry creates an empty registry, persisted to path or kept in memory
+// only if path is empty
+func NewRegistry(path string) *Registry {
+	return &Registry{experiments: make(map[string]*Experiment), path: path}
+}
+
+// List returns every experiment, by ID
+func (r *Registry) List() []*Experiment {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	experiments := make([]*Experiment, 0, len(r.experiments))
+	for _, e := range r.experiments {
+		experiments = append(experiments, e.clone())
+	}
+	sort.Slice(experiments, func(i, j int) bool { return experiments[i].ID < experiments[j].ID })
+	return experiments
+}
+
+// Get returns an experiment
+func (r *Registry) Get(id string) (*Experiment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.experiments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e.clone(), nil
+}
+
+// Put creates an experiment as a draft or replaces the definition of one
+// that is not running. Changing the traffic or the variants makes it a new
+// experiment: it is a draft again, and only its exposures from the next
+// start on count towards its results. It reports whether the experiment was
+// created.
+func (r *Registry) Put(e *Experiment, by string) (*Experiment, bool, error) {
+	if err := e.validate(); err != nil {
+		return nil, false, err
+	}
+	e = e.clone()
+	e.UpdatedBy, e.UpdatedAt = by, time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, ok := r.experiments[e.ID]
+	if ok && existing.Status == StatusRunning {
+		return nil, false, fmt.Errorf("%w: stop %s before changing it", ErrRunning, e.ID)
+	}
+	e.Status, e.StartedAt, e.StoppedAt = StatusDraft, nil, nil
+	if ok && e.Traffic == existing.Traffic && reflect.DeepEqual(e.Variants, existing.Variants) {
+		e.Status, e.StartedAt, e.StoppedAt = existing.Status, existing.StartedAt, existing.StoppedAt
+	}
+	r.experiments[e.ID] = e
+	r.persist()
+	return e.clone(), !ok, nil
+}
+
+// Start enrolls users in an experiment from the next request. A stopped
+// experiment resumes, keeping its start time.
+func (r *Registry) Start(id, by string) (*Experiment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.experiments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if e.Status == StatusRunning {
+		return nil, fmt.Errorf("%w: %s was already started", ErrRunning, id)
+	}
+	now := time.Now().UTC()
+	if e.StartedAt == nil {
+		e.StartedAt = &now
+	}
+	e.Status, e.StoppedAt = StatusRunning, nil
+	e.UpdatedBy, e.UpdatedAt = by, now
+	r.persist()
+	return e.clone(), nil
+}
+
+// Stop ends an experiment; its users get the default configuration again
+func (r *Registry) Stop(id, by string) (*Experiment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.experiments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if e.Status != StatusRunning {
+		return nil, fmt.Errorf("%w: %s is not running", ErrInvalid, id)
+	}
+	now := time.Now().UTC()
+	e.Status, e.StoppedAt = StatusStopped, &now
+	e.UpdatedBy, e.UpdatedAt = by, now
+	r.persist()
+	return e.clone(), nil
+}
+
+// Delete removes an experiment that is not running. Its exposures are kept.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.experiments[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.Status == StatusRunning {
+		return fmt.Errorf("%w: stop %s before deleting it", ErrRunning, id)
+	}
+	delete(r.experiments, id)
+	r.persist()
+	return nil
+}
+
+// Assign returns the variant a user gets, or nil when the user is in no
+// running experiment. Experiments are exclusive: a user is enrolled in the
+// first experiment, by start time, whose traffic includes them, so that
+// variants of different experiments never mix.
+func (r *Registry) Assign(userID string) *Assignment {
+	if userID == "" {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var running []*Experiment
+	for _, e := range r.experiments {
+		if e.Status == StatusRunning {
+			running = append(running, e)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		if !running[i].StartedAt.Equal(*running[j].StartedAt) {
+			return running[i].StartedAt.Before(*running[j].StartedAt)
+		}
+		return running[i].ID < running[j].ID
+	})
+	for _, e := range running {
+		if v := e.assign(userID); v != nil {
+			a := &Assignment{Experiment: e.ID, Variant: v.Name, Config: v.Config}
+			a.Config.Prompts = maps.Clone(v.Config.Prompts)
+			return a
+		}
+	}
+	return nil
+}
+
+// persist saves the registry if persistence is enabled. A failed save is
+// logged rather than failing the change, which stays in effect in memory.
+// Callers must hold the lock.
+func (r *Registry) persist() {
+	if r.path == "" {
+		return
+	}
+	if err := r.save(); err != nil {
+		log.Printf("Failed to persist experiments: %v", err)
+	}
+}
+
+// save writes all experiments to the snapshot file, replacing it atomically
+func (r *Registry) save() error {
+	experiments := make([]*Experiment, 0, len(r.experiments))
+	for _, e := range r.experiments {
+		experiments = append(experiments, e)
+	}
+	data, err := json.MarshalIndent(experiments, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal experiments: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".experiments-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create experiment snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write experiment snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write experiment snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// load restores the experiments saved to the snapshot file, if there is one
+func (r *Registry) load() error {
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		log.Printf("No experiment snapshot at %s, starting without experiments", r.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var experiments []*Experiment
+	if err := json.Unmarshal(data, &experiments); err != nil {
+		return fmt.Errorf("failed to decode experiment snapshot: %w", err)
+	}
+	loaded := make(map[string]*Experiment, len(experiments))
+	for _, e := range experiments {
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("experiment %s: %w", e.ID, err)
+		}
+		if e.Status == StatusRunning && e.StartedAt == nil {
+			return fmt.Errorf("experiment %s is running without a start time", e.ID)
+		}
+		loaded[e.ID] = e
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.experiments = loaded
+	log.Printf("Loaded %d experiments", len(experiments))
+	return nil
+}
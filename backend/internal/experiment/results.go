@@ -0,0 +1,221 @@
+package experiment
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+)
+
+// z95 is the normal quantile of two-sided 95% confidence intervals
+const z95 = 1.959964
+
+// Interval is an estimate with its 95% confidence interval
+type Interval struct {
+	Value float64 `json:"value"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+// Difference is how a variant differs from the control, variant minus
+// control
+type Difference struct {
+	Interval
+	// Significant reports whether the interval excludes no difference
+	Significant bool `json:"significant"`
+}
+
+// Results compares the variants of an experiment. Intervals treat answers as
+// independent, which holds roughly when each user asks a few questions.
+type Results struct {
+	Experiment string           `json:"experiment"`
+	Status     string           `json:"status"`
+	Control    string           `json:"control"`
+	Variants   []VariantResults `json:"variants"`
+}
+
+// VariantResults are the metrics of one variant. An interval is nil when
+// there is too little data for it.
+type VariantResults struct {
+	Name      string `json:"name"`
+	Exposures int    `json:"exposures"`
+	Users     int    `json:"users"`
+	// LatencyMs is the mean time to answer
+	LatencyMs    *Interval `json:"latencyMs"`
+	LatencyP50Ms int64     `json:"latencyP50Ms"`
+	LatencyP95Ms int64     `json:"latencyP95Ms"`
+	Ratings      int       `json:"ratings"`
+	Up           int       `json:"up"`
+	Down         int       `json:"down"`
+	// Satisfaction is the share of ratings that are up
+	Satisfaction *Interval `json:"satisfaction"`
+	// LatencyChange and SatisfactionChange compare the variant with the
+	// control; nil for the control itself
+	LatencyChange      *Difference `json:"latencyChange,omitempty"`
+	SatisfactionChange *Difference `json:"satisfactionChange,omitempty"`
+}
+
+// Compute joins the exposures of an experiment with the feedback on the
+// answers they produced and compares the variants with the control. Only
+// exposures since the experiment started count, since earlier ones belong
+// to a previous definition. Variants no longer in the definition are
+// reported after the others.
+func Compute(e *Experiment, exposures []*conversation.Exposure, feedback []*conversation.Feedback) *Results {
+	names := make([]string, 0, len(e.Variants))
+	for _, v := range e.Variants {
+		names = append(names, v.Name)
+	}
+	var extra []string
+	samples := make(map[string]*sample, len(names))
+	variantOf := make(map[string]string)
+	for _, x := range exposures {
+		if x.ExperimentID != e.ID || e.StartedAt == nil || x.CreatedAt.Before(*e.StartedAt) {
+			continue
+		}
+		s, ok := samples[x.Variant]
+		if !ok {
+			s = &sample{users: make(map[string]bool)}
+			samples[x.Variant] = s
+			if !slices.Contains(names, x.Variant) {
+				extra = append(extra, x.Variant)
+			}
+		}
+		s.users[x.UserID] = true
+		s.latencies = append(s.latencies, float64(x.LatencyMs))
+		if x.MessageID != "" {
+			variantOf[x.MessageID] = x.Variant
+		}
+	}
+	for _, f := range feedback {
+		variant, ok := variantOf[f.MessageID]
+		if !ok {
+			continue
+		}
+		if f.Rating == conversation.RatingUp {
+			samples[variant].up++
+		} else {
+			samples[variant].down++
+		}
+	}
+	sort.Strings(extra)
+
+	results := &Results{Experiment: e.ID, Status: e.Status, Control: names[0], Variants: []VariantResults{}}
+	control := samples[names[0]]
+	for i, name := range slices.Concat(names, extra) {
+		s := samples[name]
+		if s == nil {
+			s = &sample{}
+		}
+		v := s.results(name)
+		if i > 0 && control != nil {
+			v.LatencyChange = meanDifference(control.latencies, s.latencies)
+			v.SatisfactionChange = proportionDifference(control.up, control.up+control.down, s.up, s.up+s.down)
+		}
+		results.Variants = append(results.Variants, v)
+	}
+	return results
+}
+
+// sample is what was observed of a variant
+type sample struct {
+	users     map[string]bool
+	latencies []float64
+	up, down  int
+}
+
+func (s *sample) results(name string) VariantResults {
+	v := VariantResults{
+		Name:         name,
+		Exposures:    len(s.latencies),
+		Users:        len(s.users),
+		LatencyMs:    meanInterval(s.latencies),
+		Ratings:      s.up + s.down,
+		Up:           s.up,
+		Down:         s.down,
+		Satisfaction: wilson(s.up, s.up+s.down),
+	}
+	if len(s.latencies) > 0 {
+		sorted := slices.Sorted(slices.Values(s.latencies))
+		v.LatencyP50Ms, v.LatencyP95Ms = int64(percentile(sorted, 0.5)), int64(percentile(sorted, 0.95))
+	}
+	return v
+}
+
+// meanInterval returns the mean with its normal confidence interval, or nil
+// for fewer than two values
+func meanInterval(values []float64) *Interval {
+	if len(values) < 2 {
+		return nil
+	}
+	mean, variance := moments(values)
+	margin := z95 * math.Sqrt(variance/float64(len(values)))
+	return &Interval{Value: round(mean), Low: round(mean - margin), High: round(mean + margin)}
+}
+
+// wilson returns the share of successes in n trials with its Wilson score
+// interval, which stays within 0 and 1 for small samples, or nil for none
+func wilson(successes, n int) *Interval {
+	if n == 0 {
+		return nil
+	}
+	p, total := float64(successes)/float64(n), float64(n)
+	denominator := 1 + z95*z95/total
+	center := (p + z95*z95/(2*total)) / denominator
+	margin := z95 * math.Sqrt(p*(1-p)/total+z95*z95/(4*total*total)) / denominator
+	return &Interval{Value: round(p), Low: round(max(center-margin, 0)), High: round(min(center+margin, 1))}
+}
+
+// meanDifference compares the means of two samples with Welch's normal
+// approximation
+func meanDifference(control, variant []float64) *Difference {
+	if len(control) < 2 || len(variant) < 2 {
+		return nil
+	}
+	m0, v0 := moments(control)
+	m1, v1 := moments(variant)
+	margin := z95 * math.Sqrt(v0/float64(len(control))+v1/float64(len(variant)))
+	return difference(m1-m0, margin)
+}
+
+// proportionDifference compares two shares of successes with the normal
+// approximation
+func proportionDifference(s0, n0, s1, n1 int) *Difference {
+	if n0 == 0 || n1 == 0 {
+		return nil
+	}
+	p0, p1 := float64(s0)/float64(n0), float64(s1)/float64(n1)
+	margin := z95 * math.Sqrt(p0*(1-p0)/float64(n0)+p1*(1-p1)/float64(n1))
+	return difference(p1-p0, margin)
+}
+
+func difference(value, margin float64) *Difference {
+	d := &Difference{Interval: Interval{Value: round(value), Low: round(value - margin), High: round(value + margin)}}
+	d.Significant = value-margin > 0 || value+margin < 0
+	return d
+}
+
+// moments returns the mean and the sample variance of values
+func moments(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, squares / float64(len(values)-1)
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// round keeps four decimals, plenty for shares and milliseconds
+func round(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
@@ -0,0 +1,118 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Fact is a single catalog relation rendered as a sentence, used as grounded
+// context for the chat model
+type Fact struct {
+	RelationID string               `json:"relationId"`
+	Type       catalog.RelationType `json:"type"`
+	SubjectID  string               `json:"subjectId"`
+	ObjectID   string               `json:"objectId"`
+	Text       string               `json:"text"`
+}
+
+// relationPhrases is the verb phrase used to render each relation type
+var relationPhrases = map[catalog.RelationType]string{
+	catalog.RelPerformedBy: "is performed by",
+	catalog.RelComposedBy:  "was written by",
+	catalog.RelAppearsOn:   "appears on",
+	catalog.RelRecordingOf: "is a recording of",
+	catalog.RelReleasedBy:  "was released by",
+	catalog.RelCreditedTo:  "credits",
+	catalog.RelEditionOf:   "is an edition of",
+	catalog.RelMemberOf:    "is a member of",
+	catalog.RelPlayedOn:    "played on",
+	catalog.RelProduced:    "produced",
+	catalog.RelSampled:     "samples",
+	catalog.RelCovered:     "is a cover of",
+}
+
+// Facts returns up to limit facts about the direct neighbors of the given
+// entities. Entities are visited round-robin so that the first entity cannot
+// use up the whole budget.
+func (g *Graph) Facts(entityIDs []string, limit int) []Fact {
+	if limit <= 0 {
+		return nil
+	}
+	queues := make([][]*catalog.Relation, len(entityIDs))
+	for i, id := range entityIDs {
+		queues[i] = g.store.ListRelations(id)
+	}
+
+	facts := []Fact{}
+	seen := make(map[string]bool)
+	names := make(map[string]string)
+	for len(facts) < limit {
+		progressed := false
+		for i := range queues {
+			if len(facts) >= limit || len(queues[i]) == 0 {
+				continue
+			}
+			r := queues[i][0]
+			queues[i] = queues[i][1:]
+			progressed = true
+			if seen[r.ID] {
+				continue
+			}
+			seen[r.ID] = true
+			subject, object := g.label(names, r.SourceID), g.label(names, r.TargetID)
+			if subject == "" || object == "" {
+				continue
+			}
+			facts = append(facts, Fact{
+				RelationID: r.ID,
+				Type:       r.Type,
+				SubjectID:  r.SourceID,
+				ObjectID:   r.TargetID,
+				Text:       fmt.Sprintf("%s %s %s%s.", subject, relationPhrases[r.Type], object, qualifiers(r.Attributes)),
+			})
+		}
+		if !progressed {
+			break
+		}
+	}
+	return facts
+}
+
+// label renders an entity as "Name (type)", caching lookups in names. It
+// returns "" for entities that no longer exist.
+func (g *Graph) label(names map[string]string, id string) string {
+	if l, ok := names[id]; ok {
+		return l
+	}
+	l := ""
+	if e, err := g.store.GetEntity(id); err == nil {
+		l = fmt.Sprintf("%s (%s)", e.Name, e.Type)
+	}
+	names[id] = l
+	return l
+}
+
+// qualifiers renders relation attributes such as role or instrument
+func qualifiers(attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + ": " + attrs[k]
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
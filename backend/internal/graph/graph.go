@@ -0,0 +1,265 @@
+// Package graph answers relationship questions over the music catalog, such
+// as which musicians played on two albums or which recordings sampled a track.
+//
+// The catalog store already indexes every relation by both of its endpoints,
+// so the graph is a view over catalog.Store rather than a separate copy.
+package graph
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Direction selects which relations of an entity a step follows
+type Direction string
+
+const (
+	// Out follows relations whose source is the current entity
+	Out Direction = "out"
+	// In follows relations whose target is the current entity
+	In Direction = "in"
+	// Both follows relations in either direction
+	Both Direction = "both"
+)
+
+const (
+	// maxSteps bounds the length of a traversal query
+	maxSteps = 4
+	// maxStarts bounds the start entities of a query, each of which is
+	// traversed separately
+	maxStarts = 20
+	// maxFrontier bounds the number of entities visited per step so that hub
+	// entities such as "Various Artists" cannot blow up a traversal
+	maxFrontier = 5000
+	// maxPaths is the number of example paths kept per result entity
+	maxPaths = 3
+	// defaultLimit is the number of results returned when no limit is given
+	defaultLimit = 50
+)
+
+// Step is one hop of a traversal
+type Step struct {
+	Relations  []catalog.RelationType `json:"relations,omitempty"` // empty follows any relation
+	Direction  Direction              `json:"direction,omitempty"` // defaults to both
+	EntityType catalog.EntityType     `json:"entityType,omitempty"`
+}
+
+// Query walks the same steps from each start entity. With Intersect set only
+// entities reached from every start are returned, e.g. the musicians who
+// played on both of two albums.
+type Query struct {
+	Start     []string `json:"start" binding:"required"`
+	Steps     []Step   `json:"steps" binding:"required"`
+	Intersect bool     `json:"intersect"`
+	Limit     int      `json:"limit"`
+}
+
+// Edge is a relation seen from one of its endpoints
+type Edge struct {
+	Relation  *catalog.Relation `json:"relation"`
+	Direction Direction         `json:"direction"`
+	Neighbor  *catalog.Entity   `json:"neighbor"`
+}
+
+// Path is the sequence of relations leading from a start entity to a result
+type Path struct {
+	Start     string              `json:"start"`
+	Relations []*catalog.Relation `json:"relations"`
+}
+
+// Result is an entity reached by a traversal together with how it was reached
+type Result struct {
+	Entity *catalog.Entity `json:"entity"`
+	Starts []string        `json:"starts"` // start entities it was reached from
+	Paths  []Path          `json:"paths"`
+}
+
+// Graph traverses the relations of a catalog store
+type Graph struct {
+	store catalog.Store
+}
+
+// GraphInstance is the graph over the shared catalog store
+var GraphInstance *Graph
+
+// Init creates the graph over the catalog store. It must run after catalog.Init.
+func Init() {
+	GraphInstance = New(catalog.StoreInstance)
+}
+
+// New creates a graph over store
+func New(store catalog.Store) *Graph {
+	return &Graph{store: store}
+}
+
+// Neighbors returns the relations of an entity matching step, with the entity
+// at their other end
+func (g *Graph) Neighbors(entityID string, step Step, limit int) ([]Edge, error) {
+	if _, err := g.store.GetEntity(entityID); err != nil {
+		return nil, err
+	}
+	if err := step.validate(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	edges := []Edge{}
+	for _, r := range g.store.ListRelations(entityID) {
+		if len(edges) >= limit {
+			break
+		}
+		neighborID, dir, ok := step.follow(entityID, r)
+		if !ok {
+			continue
+		}
+		neighbor, err := g.store.GetEntity(neighborID)
+		if err != nil || (step.EntityType != "" && neighbor.Type != step.EntityType) {
+			continue
+		}
+		edges = append(edges, Edge{Relation: r, Direction: dir, Neighbor: neighbor})
+	}
+	return edges, nil
+}
+
+// Traverse runs a query and returns the entities reached after the last step,
+// ordered by the number of start entities reaching them and then by name
+func (g *Graph) Traverse(q Query) ([]Result, error) {
+	if len(q.Start) == 0 || len(q.Start) > maxStarts {
+		return nil, fmt.Errorf("%w: between 1 and %d start entities are required", catalog.ErrInvalid, maxStarts)
+	}
+	if len(q.Steps) == 0 || len(q.Steps) > maxSteps {
+		return nil, fmt.Errorf("%w: between 1 and %d steps are required", catalog.ErrInvalid, maxSteps)
+	}
+	for _, step := range q.Steps {
+		if err := step.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+
+	results := make(map[string]*Result)
+	for _, start := range q.Start {
+		if _, err := g.store.GetEntity(start); err != nil {
+			return nil, err
+		}
+		for id, paths := range g.walk(start, q.Steps) {
+			r, ok := results[id]
+			if !ok {
+				r = &Result{}
+				results[id] = r
+			}
+			if !slices.Contains(r.Starts, start) {
+				r.Starts = append(r.Starts, start)
+			}
+			for _, p := range paths {
+				if len(r.Paths) < maxPaths*len(q.Start) {
+					r.Paths = append(r.Paths, p)
+				}
+			}
+		}
+	}
+
+	out := make([]Result, 0, len(results))
+	for id, r := range results {
+		if q.Intersect && len(r.Starts) < len(q.Start) {
+			continue
+		}
+		// A start entity reached again through a round trip is not an answer
+		if slices.Contains(q.Start, id) {
+			continue
+		}
+		entity, err := g.store.GetEntity(id)
+		if err != nil {
+			continue
+		}
+		r.Entity = entity
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i].Starts) != len(out[j].Starts) {
+			return len(out[i].Starts) > len(out[j].Starts)
+		}
+		if out[i].Entity.Name != out[j].Entity.Name {
+			return out[i].Entity.Name < out[j].Entity.Name
+		}
+		return out[i].Entity.ID < out[j].Entity.ID
+	})
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+// walk follows steps breadth-first from start and returns the entities reached
+// after the last step with up to maxPaths example paths each
+func (g *Graph) walk(start string, steps []Step) map[string][]Path {
+	frontier := map[string][]Path{start: {{Start: start}}}
+	for _, step := range steps {
+		next := make(map[string][]Path)
+		for id, paths := range frontier {
+			for _, r := range g.store.ListRelations(id) {
+				neighborID, _, ok := step.follow(id, r)
+				if !ok {
+					continue
+				}
+				if _, seen := next[neighborID]; !seen {
+					if len(next) >= maxFrontier {
+						continue
+					}
+					if step.EntityType != "" {
+						neighbor, err := g.store.GetEntity(neighborID)
+						if err != nil || neighbor.Type != step.EntityType {
+							continue
+						}
+					}
+				}
+				for _, p := range paths {
+					if len(next[neighborID]) >= maxPaths {
+						break
+					}
+					next[neighborID] = append(next[neighborID], Path{
+						Start:     p.Start,
+						Relations: append(slices.Clip(p.Relations), r),
+					})
+				}
+			}
+		}
+		frontier = next
+	}
+	return frontier
+}
+
+func (s Step) validate() error {
+	switch s.Direction {
+	case "", Out, In, Both:
+	default:
+		return fmt.Errorf("%w: unknown direction %q", catalog.ErrInvalid, s.Direction)
+	}
+	for _, t := range s.Relations {
+		if !catalog.IsRelationType(t) {
+			return fmt.Errorf("%w: unknown relation type %q", catalog.ErrInvalid, t)
+		}
+	}
+	return nil
+}
+
+// follow reports whether the step follows relation r from entityID and, if
+// so, returns the entity at its other end and the direction it was followed in
+func (s Step) follow(entityID string, r *catalog.Relation) (string, Direction, bool) {
+	if len(s.Relations) > 0 && !slices.Contains(s.Relations, r.Type) {
+		return "", "", false
+	}
+	if r.SourceID == entityID && s.Direction != In {
+		return r.TargetID, Out, true
+	}
+	if r.TargetID == entityID && s.Direction != Out {
+		return r.SourceID, In, true
+	}
+	return "", "", false
+}
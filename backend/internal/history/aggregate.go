@@ -0,0 +1,180 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Groupings of Top
+const (
+	ByTrack  = "track"
+	ByArtist = "artist"
+	ByAlbum  = "album"
+)
+
+// Groupings lists the groupings Top accepts
+var Groupings = []string{ByTrack, ByArtist, ByAlbum}
+
+// Query selects and groups plays. Zero values do not filter.
+type Query struct {
+	From time.Time // inclusive
+	To   time.Time // exclusive
+	By   string    // one of Groupings, tracks by default
+	// Artist restricts the plays to an artist, matched by normalized name
+	Artist string
+	// Unsaved restricts the plays to tracks the user has not saved
+	Unsaved bool
+	Limit   int
+}
+
+// Row is the aggregate of the plays of a track, artist or album
+type Row struct {
+	Track       string    `json:"track,omitempty"`
+	Artist      string    `json:"artist"`
+	Album       string    `json:"album,omitempty"`
+	Plays       int       `json:"plays"`
+	MsPlayed    int64     `json:"msPlayed"`
+	Skips       int       `json:"skips"`
+	Saved       bool      `json:"saved,omitempty"` // tracks only
+	FirstPlayed time.Time `json:"firstPlayed"`
+	LastPlayed  time.Time `json:"lastPlayed"`
+}
+
+// Summary gives the totals of the plays a query selects
+type Summary struct {
+	Plays       int       `json:"plays"`
+	MsPlayed    int64     `json:"msPlayed"`
+	Tracks      int       `json:"tracks"`
+	Artists     int       `json:"artists"`
+	SavedTracks int       `json:"savedTracks"` // in the whole library, regardless of the query
+	FirstPlayed time.Time `json:"firstPlayed,omitzero"`
+	LastPlayed  time.Time `json:"lastPlayed,omitzero"`
+	// Years counts plays per calendar year
+	Years map[int]int `json:"years,omitempty"`
+}
+
+// Top returns the owner's most played tracks, artists or albums among the
+// plays q selects, by number of plays and then by time played
+func (s *Store) Top(ownerID string, q Query) ([]Row, error) {
+	var key func(p *Play) string
+	switch q.By {
+	case ByTrack, "":
+		key = func(p *Play) string { return trackKey(p.Artist, p.Track) }
+	case ByArtist:
+		key = func(p *Play) string { return catalog.NameKey(p.Artist) }
+	case ByAlbum:
+		key = func(p *Play) string { return catalog.NameKey(p.Artist) + "\x00" + catalog.NameKey(p.Album) }
+	default:
+		return nil, fmt.Errorf("%w: unknown grouping %q", ErrInvalid, q.By)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[ownerID]
+	if !ok {
+		return []Row{}, nil
+	}
+	rows := make(map[string]*Row)
+	u.each(q, func(p *Play) {
+		if q.By == ByAlbum && p.Album == "" {
+			return
+		}
+		k := key(p)
+		r, ok := rows[k]
+		if !ok {
+			r = &Row{Artist: p.Artist, FirstPlayed: p.PlayedAt}
+			switch q.By {
+			case ByTrack, "":
+				r.Track, r.Album = p.Track, p.Album
+				_, r.Saved = u.Saved[trackKey(p.Artist, p.Track)]
+			case ByAlbum:
+				r.Album = p.Album
+			}
+			rows[k] = r
+		}
+		r.Plays++
+		r.MsPlayed += p.MsPlayed
+		if p.Skipped {
+			r.Skips++
+		}
+		r.LastPlayed = p.PlayedAt
+	})
+
+	top := make([]Row, 0, len(rows))
+	for _, r := range rows {
+		top = append(top, *r)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Plays != top[j].Plays {
+			return top[i].Plays > top[j].Plays
+		}
+		if top[i].MsPlayed != top[j].MsPlayed {
+			return top[i].MsPlayed > top[j].MsPlayed
+		}
+		return top[i].LastPlayed.After(top[j].LastPlayed)
+	})
+	if q.Limit > 0 && len(top) > q.Limit {
+		top = top[:q.Limit]
+	}
+	return top, nil
+}
+
+// Summarize returns the totals of the owner's plays that q selects
+func (s *Store) Summarize(ownerID string, q Query) Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	summary := Summary{}
+	u, ok := s.users[ownerID]
+	if !ok {
+		return summary
+	}
+	summary.SavedTracks = len(u.Saved)
+	tracks := make(map[string]struct{})
+	artists := make(map[string]struct{})
+	years := make(map[int]int)
+	u.each(q, func(p *Play) {
+		if summary.Plays == 0 {
+			summary.FirstPlayed = p.PlayedAt
+		}
+		summary.LastPlayed = p.PlayedAt
+		summary.Plays++
+		summary.MsPlayed += p.MsPlayed
+		tracks[trackKey(p.Artist, p.Track)] = struct{}{}
+		artists[catalog.NameKey(p.Artist)] = struct{}{}
+		years[p.PlayedAt.Year()]++
+	})
+	summary.Tracks, summary.Artists = len(tracks), len(artists)
+	if len(years) > 0 {
+		summary.Years = years
+	}
+	return summary
+}
+
+// each calls fn for the plays that q selects, oldest first. Callers must
+// hold the store's lock.
+func (u *userHistory) each(q Query, fn func(p *Play)) {
+	// Plays are sorted, so the period is found by binary search
+	start := 0
+	if !q.From.IsZero() {
+		start = sort.Search(len(u.Plays), func(i int) bool { return !u.Plays[i].PlayedAt.Before(q.From) })
+	}
+	artist := catalog.NameKey(q.Artist)
+	for i := start; i < len(u.Plays); i++ {
+		p := &u.Plays[i]
+		if !q.To.IsZero() && !p.PlayedAt.Before(q.To) {
+			break
+		}
+		if artist != "" && catalog.NameKey(p.Artist) != artist {
+			continue
+		}
+		if q.Unsaved {
+			if _, saved := u.Saved[trackKey(p.Artist, p.Track)]; saved {
+				continue
+			}
+		}
+		fn(p)
+	}
+}
@@ -0,0 +1,106 @@
+// Package history keeps each user's listening history, imported from the
+// data exports of streaming services, and answers aggregation queries over
+// it such as the most played tracks of a year.
+//
+// Listening history is personal data: it is only ever read back for the user
+// who imported it.
+package history
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Export formats
+const (
+	// FormatSpotify is a streaming history file of a Spotify data export,
+	// either the extended history (Streaming_History_Audio_*.json) or the
+	// account data summary (StreamingHistory*.json)
+	FormatSpotify = "spotify"
+	// FormatSpotifyLibrary is the saved tracks file of a Spotify data export
+	// (YourLibrary.json)
+	FormatSpotifyLibrary = "spotify-library"
+	// FormatLastfm is a CSV export of Last.fm scrobbles
+	FormatLastfm = "lastfm"
+)
+
+// minPlayMs is the shortest play counted. Spotify does not count a stream
+// until 30 seconds have been played.
+const minPlayMs = 30000
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported listening history format")
+	ErrInvalid           = errors.New("invalid listening history")
+)
+
+// Play is a single listen of a track
+type Play struct {
+	PlayedAt time.Time `json:"playedAt"`
+	Track    string    `json:"track"`
+	Artist   string    `json:"artist"`
+	Album    string    `json:"album,omitempty"`
+	// MsPlayed is how long the track played, 0 when the source does not say,
+	// as for Last.fm scrobbles
+	MsPlayed int64  `json:"msPlayed,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Source   string `json:"source"`
+	URI      string `json:"uri,omitempty"` // e.g. spotify:track:...
+}
+
+// SavedTrack is a track the user saved to their library on a streaming service
+type SavedTrack struct {
+	Track  string `json:"track"`
+	Artist string `json:"artist"`
+	Album  string `json:"album,omitempty"`
+	URI    string `json:"uri,omitempty"`
+}
+
+// Export is the content of an export file: plays, saved tracks or both
+type Export struct {
+	Plays []Play
+	Saved []SavedTrack
+	// Skipped counts records that are not track plays, such as podcast
+	// episodes, or that were too short to count
+	Skipped int
+}
+
+// Parse reads an export file in the given format
+func Parse(format string, data []byte) (*Export, error) {
+	switch format {
+	case FormatSpotify, FormatSpotifyLibrary:
+		return parseSpotify(data)
+	case FormatLastfm:
+		return parseLastfm(data)
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupportedFormat, format)
+}
+
+// DetectFormat guesses the format of an export file from its name and content
+func DetectFormat(name string, data []byte) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".csv"):
+		return FormatLastfm, nil
+	case strings.HasPrefix(strings.TrimSpace(string(data[:min(len(data), 64)])), "{"):
+		return FormatSpotifyLibrary, nil
+	case strings.HasSuffix(lower, ".json"), strings.HasPrefix(strings.TrimSpace(string(data[:min(len(data), 64)])), "["):
+		return FormatSpotify, nil
+	}
+	return "", fmt.Errorf("%w: cannot tell the format of %q", ErrUnsupportedFormat, name)
+}
+
+// trackKey identifies a track by its artist and title, regardless of case
+// and punctuation
+func trackKey(artist, track string) string {
+	return catalog.NameKey(artist) + "\x00" + catalog.NameKey(track)
+}
+
+// playKey identifies a play, so that importing the same export twice does not
+// count it twice
+func playKey(p *Play) string {
+	return p.PlayedAt.UTC().Format(time.RFC3339) + "\x00" + trackKey(p.Artist, p.Track)
+}
@@ -0,0 +1,113 @@
+package history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastfmTimeLayouts are the date formats of Last.fm CSV exporters
+var lastfmTimeLayouts = []string{
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006, 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z",
+}
+
+// lastfmColumns are the column indices of the fields of a scrobble
+type lastfmColumns struct {
+	artist, album, track, date, uts int
+}
+
+// parseLastfm reads a CSV export of Last.fm scrobbles. Exports with a header
+// row, such as "uts,utc_time,artist,artist_mbid,album,album_mbid,track,
+// track_mbid", are read by column name; those without one are taken to be
+// artist, album, track and date, as the common lastfm-to-csv exporter writes.
+func parseLastfm(data []byte) (*Export, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	cols := lastfmColumns{artist: 0, album: 1, track: 2, date: 3, uts: -1}
+	export := &Export{}
+	first := true
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if first {
+			first = false
+			if header, ok := lastfmHeader(row); ok {
+				cols = header
+				continue
+			}
+		}
+		play, ok := cols.play(row)
+		if !ok {
+			export.Skipped++
+			continue
+		}
+		export.Plays = append(export.Plays, play)
+	}
+	if len(export.Plays) == 0 && export.Skipped > 0 {
+		return nil, fmt.Errorf("%w: no scrobbles could be read", ErrInvalid)
+	}
+	return export, nil
+}
+
+// lastfmHeader reads the columns of a header row, reporting false if row is
+// not a header
+func lastfmHeader(row []string) (lastfmColumns, bool) {
+	cols := lastfmColumns{artist: -1, album: -1, track: -1, date: -1, uts: -1}
+	for i, name := range row {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "artist", "artist_name":
+			cols.artist = i
+		case "album", "album_name":
+			cols.album = i
+		case "track", "track_name", "title", "name":
+			cols.track = i
+		case "date", "utc_time", "time":
+			cols.date = i
+		case "uts", "timestamp":
+			cols.uts = i
+		}
+	}
+	return cols, cols.artist >= 0 && cols.track >= 0 && (cols.date >= 0 || cols.uts >= 0)
+}
+
+func (cols lastfmColumns) play(row []string) (Play, bool) {
+	field := func(i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	p := Play{Source: FormatLastfm, Artist: field(cols.artist), Album: field(cols.album), Track: field(cols.track)}
+	if p.Artist == "" || p.Track == "" {
+		return Play{}, false
+	}
+	if uts, err := strconv.ParseInt(field(cols.uts), 10, 64); err == nil && uts > 0 {
+		p.PlayedAt = time.Unix(uts, 0).UTC()
+		return p, true
+	}
+	date := field(cols.date)
+	for _, layout := range lastfmTimeLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			p.PlayedAt = t.UTC()
+			return p, true
+		}
+	}
+	// Tracks scrobbled while Last.fm's clock was unset carry no date
+	return Play{}, false
+}
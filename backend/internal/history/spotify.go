@@ -0,0 +1,102 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// spotifyStream is a record of either Spotify streaming history: the extended
+// history names fields after the track metadata, the account data summary
+// uses endTime, artistName and trackName
+type spotifyStream struct {
+	// Extended streaming history
+	TS       string  `json:"ts"` // when the stream ended
+	MsPlayed int64   `json:"ms_played"`
+	Track    *string `json:"master_metadata_track_name"`
+	Artist   *string `json:"master_metadata_album_artist_name"`
+	Album    *string `json:"master_metadata_album_album_name"`
+	URI      *string `json:"spotify_track_uri"`
+	Skipped  *bool   `json:"skipped"`
+
+	// Account data summary
+	EndTime    string `json:"endTime"` // minute precision, UTC
+	ArtistName string `json:"artistName"`
+	TrackName  string `json:"trackName"`
+	MsPlayedV1 int64  `json:"msPlayed"`
+}
+
+// spotifyLibrary is YourLibrary.json
+type spotifyLibrary struct {
+	Tracks []struct {
+		Artist string `json:"artist"`
+		Album  string `json:"album"`
+		Track  string `json:"track"`
+		URI    string `json:"uri"`
+	} `json:"tracks"`
+}
+
+func parseSpotify(data []byte) (*Export, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	export := &Export{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var library spotifyLibrary
+		if err := json.Unmarshal(data, &library); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		for _, t := range library.Tracks {
+			if t.Track == "" || t.Artist == "" {
+				export.Skipped++
+				continue
+			}
+			export.Saved = append(export.Saved, SavedTrack{Track: t.Track, Artist: t.Artist, Album: t.Album, URI: t.URI})
+		}
+		return export, nil
+	}
+
+	var streams []spotifyStream
+	if err := json.Unmarshal(data, &streams); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for _, s := range streams {
+		play, ok := s.play()
+		if !ok {
+			export.Skipped++
+			continue
+		}
+		export.Plays = append(export.Plays, play)
+	}
+	return export, nil
+}
+
+// play converts a stream to a play, reporting false for podcast episodes,
+// records without a time and streams too short to count
+func (s *spotifyStream) play() (Play, bool) {
+	p := Play{Source: FormatSpotify}
+	var end time.Time
+	var err error
+	if s.TS != "" {
+		end, err = time.Parse(time.RFC3339, s.TS)
+		p.Track, p.Artist, p.Album, p.URI = deref(s.Track), deref(s.Artist), deref(s.Album), deref(s.URI)
+		p.MsPlayed = s.MsPlayed
+		p.Skipped = s.Skipped != nil && *s.Skipped
+	} else {
+		end, err = time.Parse("2006-01-02 15:04", s.EndTime)
+		p.Track, p.Artist, p.MsPlayed = s.TrackName, s.ArtistName, s.MsPlayedV1
+	}
+	if err != nil || p.Track == "" || p.Artist == "" || p.MsPlayed < minPlayMs {
+		return Play{}, false
+	}
+	// Spotify records when a stream ended; a play is dated by when it started
+	p.PlayedAt = end.Add(-time.Duration(p.MsPlayed) * time.Millisecond).UTC()
+	return p, true
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return strings.TrimSpace(*s)
+}
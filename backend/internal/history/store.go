@@ -0,0 +1,192 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store keeps the listening history of every user. Every method is scoped to
+// one owner, and nothing is shared between users.
+type Store struct {
+	mu    sync.RWMutex
+	users map[string]*userHistory
+	// path is where the store is persisted, empty when persistence is disabled
+	path string
+}
+
+// userHistory is one user's plays, oldest first, and saved tracks
+type userHistory struct {
+	Plays []Play                `json:"plays"`
+	Saved map[string]SavedTrack `json:"saved"` // by trackKey
+	// seen holds the playKey of every play, to skip plays imported before
+	seen map[string]struct{}
+}
+
+// ImportResult counts what an import added
+type ImportResult struct {
+	Plays      int `json:"plays"`
+	Duplicates int `json:"duplicates"` // plays already imported
+	Saved      int `json:"saved"`      // saved tracks not known before
+	Skipped    int `json:"skipped"`
+}
+
+// StoreInstance holds the listening history of this process
+var StoreInstance *Store
+
+// Init creates the listening history store, restoring it from
+// HISTORY_SNAPSHOT_PATH if set
+func Init() {
+	StoreInstance = NewStore(os.Getenv("HISTORY_SNAPSHOT_PATH"))
+	if StoreInstance.path == "" {
+		log.Println("HISTORY_SNAPSHOT_PATH not set, listening history will not be persisted")
+		return
+	}
+	if err := StoreInstance.load(); err != nil {
+		log.Fatalf("Failed to load listening history: %v", err)
+	}
+}
+
+// NewStore creates an empty store persisted to path, or kept in memory only
+// if path is empty
+func NewStore(path string) *Store {
+	return &Store{users: make(map[string]*userHistory), path: path}
+}
+
+// Import adds the plays and saved tracks of an export to the owner's history.
+// Plays already in the history are skipped, so exports that overlap, or the
+// same export imported twice, are counted once.
+func (s *Store) Import(ownerID string, export *Export) (ImportResult, error) {
+	if ownerID == "" {
+		return ImportResult{}, fmt.Errorf("%w: listening history has no owner", ErrInvalid)
+	}
+	result := ImportResult{Skipped: export.Skipped}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(ownerID)
+	for _, p := range export.Plays {
+		key := playKey(&p)
+		if _, ok := u.seen[key]; ok {
+			result.Duplicates++
+			continue
+		}
+		u.seen[key] = struct{}{}
+		u.Plays = append(u.Plays, p)
+		result.Plays++
+	}
+	for _, t := range export.Saved {
+		key := trackKey(t.Artist, t.Track)
+		if _, ok := u.Saved[key]; !ok {
+			result.Saved++
+		}
+		u.Saved[key] = t
+	}
+	if result.Plays > 0 {
+		sort.SliceStable(u.Plays, func(i, j int) bool { return u.Plays[i].PlayedAt.Before(u.Plays[j].PlayedAt) })
+	}
+	if result.Plays > 0 || result.Saved > 0 {
+		s.persist()
+	}
+	return result, nil
+}
+
+// Delete erases the owner's whole listening history
+func (s *Store) Delete(ownerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[ownerID]; ok {
+		delete(s.users, ownerID)
+		s.persist()
+	}
+}
+
+// Has reports whether the owner has imported any listening history
+func (s *Store) Has(ownerID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[ownerID]
+	return ok && (len(u.Plays) > 0 || len(u.Saved) > 0)
+}
+
+// user returns the owner's history, creating it if needed. Callers must hold
+// the write lock.
+func (s *Store) user(ownerID string) *userHistory {
+	u, ok := s.users[ownerID]
+	if !ok {
+		u = &userHistory{Saved: make(map[string]SavedTrack), seen: make(map[string]struct{})}
+		s.users[ownerID] = u
+	}
+	return u
+}
+
+// persist saves the store if persistence is enabled. A failed save is logged
+// rather than failing the change, which stays in effect in memory. Callers
+// must hold the lock.
+func (s *Store) persist() {
+	if s.path == "" {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Failed to persist listening history: %v", err)
+	}
+}
+
+// save writes every user's history to the snapshot file, replacing it
+// atomically
+func (s *Store) save() error {
+	data, err := json.Marshal(s.users)
+	if err != nil {
+		return fmt.Errorf("failed to marshal listening history: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".history-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create listening history snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write listening history snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write listening history snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// load restores the listening history saved to the snapshot file, if there
+// is one
+func (s *Store) load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		log.Printf("No listening history snapshot at %s, starting empty", s.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var users map[string]*userHistory
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("failed to decode listening history snapshot: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plays := 0
+	for id, u := range users {
+		if u.Saved == nil {
+			u.Saved = make(map[string]SavedTrack)
+		}
+		u.seen = make(map[string]struct{}, len(u.Plays))
+		for i := range u.Plays {
+			u.seen[playKey(&u.Plays[i])] = struct{}{}
+		}
+		s.users[id] = u
+		plays += len(u.Plays)
+	}
+	log.Printf("Loaded listening history of %d users (%d plays)", len(users), plays)
+	return nil
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint records how far an import has progressed through a dump file so
+// an interrupted import can resume where it stopped
+type Checkpoint struct {
+	Offset    int64     `json:"offset"` // byte offset of the next unread record
+	Records   int64     `json:"records"`
+	Imported  int64     `json:"imported"`
+	Size      int64     `json:"size"`    // dump size when checkpointed, to detect a replaced file
+	ModTime   time.Time `json:"modTime"` // dump modification time when checkpointed
+	Done      bool      `json:"done"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CheckpointPath returns where the checkpoint for a dump file is stored
+func CheckpointPath(dumpPath string) string {
+	return dumpPath + ".checkpoint.json"
+}
+
+// LoadCheckpoint reads the checkpoint of a dump file. It returns nil when there
+// is no checkpoint or when the dump has changed since it was written.
+func LoadCheckpoint(dumpPath string, info os.FileInfo) (*Checkpoint, error) {
+	data, err := os.ReadFile(CheckpointPath(dumpPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	if cp.Size != info.Size() || !cp.ModTime.Equal(info.ModTime()) {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+// SaveCheckpoint atomically writes the checkpoint of a dump file
+func SaveCheckpoint(dumpPath string, cp Checkpoint) error {
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	path := CheckpointPath(dumpPath)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".checkpoint-*")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
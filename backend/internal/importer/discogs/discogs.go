@@ -0,0 +1,530 @@
+// Package discogs imports the Discogs monthly XML data dumps into the catalog.
+//
+// Each dump (artists, labels, masters, releases) may be imported as plain XML
+// or gzip-compressed as published. Entities are deduplicated against the
+// existing catalog, e.g. MusicBrainz imports, first by identifier and then by
+// fuzzy name matching, so Discogs data enriches entities rather than
+// duplicating them.
+package discogs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer"
+)
+
+// Dump kinds supported by the importer
+const (
+	KindArtists  = "artists"
+	KindLabels   = "labels"
+	KindMasters  = "masters"
+	KindReleases = "releases"
+)
+
+// Source is the name of this importer in import jobs
+const Source = "discogs"
+
+// matchThreshold is the minimum name similarity for a fuzzy match
+const matchThreshold = 0.93
+
+var (
+	// duplicateSuffix matches the numeric suffix Discogs uses to tell apart artists with the same name
+	duplicateSuffix = regexp.MustCompile(`\s+\(\d+\)$`)
+
+	// performerRoles are credit roles mapped to played-on rather than credited-to
+	performerRoles = []string{
+		"vocals", "voice", "performer", "featuring", "guitar", "bass", "drums", "percussion",
+		"piano", "keyboards", "synthesizer", "organ", "saxophone", "trumpet", "trombone",
+		"violin", "viola", "cello", "double bass", "flute", "clarinet", "harmonica", "strings",
+		"horns", "turntables", "instruments", "rapper", "mc", "choir", "orchestra",
+	}
+)
+
+// Importer maps Discogs records onto catalog entities
+type Importer struct {
+	Store catalog.Store
+}
+
+// Run returns an import job function for the given dump kind
+func Run(kind string, store catalog.Store, opts importer.Options) (importer.RunFunc, error) {
+	im := &Importer{Store: store}
+	switch kind {
+	case KindArtists:
+		return func(ctx context.Context, job *importer.Job) error {
+			return importer.ReadXML(ctx, job, opts, im.importArtist)
+		}, nil
+	case KindLabels:
+		return func(ctx context.Context, job *importer.Job) error {
+			return importer.ReadXML(ctx, job, opts, im.importLabel)
+		}, nil
+	case KindMasters:
+		return func(ctx context.Context, job *importer.Job) error {
+			return importer.ReadXML(ctx, job, opts, im.importMaster)
+		}, nil
+	case KindReleases:
+		return func(ctx context.Context, job *importer.Job) error {
+			return importer.ReadXML(ctx, job, opts, im.importRelease)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported Discogs dump kind %q", kind)
+}
+
+func (im *Importer) importArtist(rec *artistXML) error {
+	aliases := append([]string{}, rec.NameVariations...)
+	if rec.RealName != "" {
+		aliases = append(aliases, rec.RealName)
+	}
+	for _, a := range rec.Aliases {
+		aliases = append(aliases, stripSuffix(a.Name))
+	}
+	artist, err := im.resolve(&catalog.Entity{
+		Type:        catalog.TypeArtist,
+		Name:        stripSuffix(rec.Name),
+		Aliases:     aliases,
+		Identifiers: catalog.Identifiers{Discogs: discogsID("artist", rec.ID)},
+		Artist:      &catalog.ArtistInfo{},
+	}, hasSuffix(rec.Name), nil)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range rec.Members {
+		member, err := im.ensure(catalog.TypeArtist, "artist", m.ID, m.Name)
+		if err != nil {
+			return err
+		}
+		if err := im.relate(catalog.RelMemberOf, member.ID, artist.ID, nil); err != nil {
+			return err
+		}
+	}
+	for _, g := range rec.Groups {
+		group, err := im.ensure(catalog.TypeArtist, "artist", g.ID, g.Name)
+		if err != nil {
+			return err
+		}
+		if err := im.relate(catalog.RelMemberOf, artist.ID, group.ID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *Importer) importLabel(rec *labelXML) error {
+	_, err := im.resolve(&catalog.Entity{
+		Type:        catalog.TypeLabel,
+		Name:        stripSuffix(rec.Name),
+		Identifiers: catalog.Identifiers{Discogs: discogsID("label", rec.ID)},
+		Label:       &catalog.LabelInfo{Profile: strings.TrimSpace(rec.Profile), ContactInfo: strings.TrimSpace(rec.ContactInfo)},
+	}, hasSuffix(rec.Name), nil)
+	return err
+}
+
+func (im *Importer) importMaster(rec *masterXML) error {
+	artists, err := im.creditedArtists(rec.Artists)
+	if err != nil {
+		return err
+	}
+	info := &catalog.ReleaseInfo{}
+	if rec.Year > 0 {
+		info.Date = strconv.Itoa(rec.Year)
+	}
+	master, err := im.resolve(&catalog.Entity{
+		Type:        catalog.TypeRelease,
+		Name:        rec.Title,
+		Identifiers: catalog.Identifiers{Discogs: discogsID("master", rec.ID)},
+		Release:     info,
+	}, false, artists)
+	if err != nil {
+		return err
+	}
+	return im.relateAll(catalog.RelPerformedBy, master.ID, artists, nil)
+}
+
+func (im *Importer) importRelease(rec *releaseXML) error {
+	if rec.Status != "" && rec.Status != "Accepted" {
+		return importer.ErrSkipped
+	}
+	artists, err := im.creditedArtists(rec.Artists)
+	if err != nil {
+		return err
+	}
+
+	info := &catalog.ReleaseInfo{
+		Kind:    releaseKind(rec.Formats),
+		Date:    strings.TrimSuffix(strings.TrimSuffix(rec.Released, "-00"), "-00"),
+		Country: rec.Country,
+		Format:  formatString(rec.Formats),
+	}
+	if len(rec.Labels) > 0 {
+		info.Label = stripSuffix(rec.Labels[0].Name)
+		info.CatalogNumber = rec.Labels[0].CatNo
+	}
+	var barcodes []string
+	for _, id := range rec.Identifiers {
+		if id.Type != "Barcode" {
+			continue
+		}
+		if v, err := catalog.NormalizeBarcode(id.Value); err == nil {
+			barcodes = append(barcodes, v)
+		}
+	}
+
+	// A release is one specific pressing; only masters are fuzzy-matched
+	// against existing releases, pressings are deduplicated by identifier
+	release, err := im.resolve(&catalog.Entity{
+		Type:        catalog.TypeRelease,
+		Name:        rec.Title,
+		Identifiers: catalog.Identifiers{Discogs: discogsID("release", rec.ID), Barcodes: barcodes},
+		Release:     info,
+	}, true, artists)
+	if err != nil {
+		return err
+	}
+	if err := im.relateAll(catalog.RelPerformedBy, release.ID, artists, nil); err != nil {
+		return err
+	}
+	if err := im.linkCredits(release.ID, rec.ExtraArtists); err != nil {
+		return err
+	}
+
+	for _, l := range rec.Labels {
+		label, err := im.ensure(catalog.TypeLabel, "label", l.ID, l.Name)
+		if err != nil {
+			return err
+		}
+		if err := im.relate(catalog.RelReleasedBy, release.ID, label.ID, map[string]string{"catalogNumber": l.CatNo}); err != nil {
+			return err
+		}
+	}
+	if rec.MasterID > 0 {
+		master, err := im.ensure(catalog.TypeRelease, "master", rec.MasterID, rec.Title)
+		if err != nil {
+			return err
+		}
+		if master.ID != release.ID {
+			if err := im.relate(catalog.RelEditionOf, release.ID, master.ID, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, track := range flattenTracks(rec.Tracklist) {
+		if err := im.importTrack(release.ID, artists, track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importTrack maps a tracklist entry to a recording appearing on the release
+func (im *Importer) importTrack(releaseID string, releaseArtists []string, track trackXML) error {
+	artists, err := im.creditedArtists(track.Artists)
+	if err != nil {
+		return err
+	}
+	if len(artists) == 0 {
+		artists = releaseArtists
+	}
+
+	incoming := &catalog.Entity{
+		Type:      catalog.TypeRecording,
+		Name:      track.Title,
+		Recording: &catalog.RecordingInfo{DurationMs: parseDuration(track.Duration)},
+	}
+	recording, err := im.matchRecording(incoming, releaseID, artists)
+	if err != nil {
+		return err
+	}
+	if recording == nil {
+		if recording, err = im.Store.CreateEntity(incoming); err != nil {
+			return err
+		}
+	}
+
+	if err := im.relate(catalog.RelAppearsOn, recording.ID, releaseID, map[string]string{"position": track.Position}); err != nil {
+		return err
+	}
+	if err := im.relateAll(catalog.RelPerformedBy, recording.ID, artists, nil); err != nil {
+		return err
+	}
+	return im.linkCredits(recording.ID, track.ExtraArtists)
+}
+
+// matchRecording finds an existing recording with a similar title that is
+// already on the release or performed by one of the artists, and fills in
+// missing data from incoming
+func (im *Importer) matchRecording(incoming *catalog.Entity, releaseID string, artists []string) (*catalog.Entity, error) {
+	related := append([]string{releaseID}, artists...)
+	for _, m := range im.Store.FindByName(catalog.TypeRecording, incoming.Name, matchThreshold) {
+		if !im.relatedTo(m.Entity.ID, related) {
+			continue
+		}
+		catalog.FillMissing(m.Entity, incoming)
+		return im.Store.UpdateEntity(m.Entity)
+	}
+	return nil, nil
+}
+
+// linkCredits relates an entity to the contributors in extra artist credits
+func (im *Importer) linkCredits(entityID string, credits []creditXML) error {
+	for _, credit := range credits {
+		if credit.Role == "" {
+			continue
+		}
+		artist, err := im.ensure(catalog.TypeArtist, "artist", credit.ID, credit.Name)
+		if err != nil {
+			return err
+		}
+		attrs := map[string]string{"role": credit.Role}
+		switch {
+		case isProducerRole(credit.Role):
+			err = im.relate(catalog.RelProduced, artist.ID, entityID, attrs)
+		case isPerformerRole(credit.Role):
+			err = im.relate(catalog.RelPlayedOn, artist.ID, entityID, attrs)
+		default:
+			err = im.relate(catalog.RelCreditedTo, entityID, artist.ID, attrs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// creditedArtists resolves the main artist credits to catalog entity IDs
+func (im *Importer) creditedArtists(credits []creditXML) ([]string, error) {
+	var ids []string
+	for _, credit := range credits {
+		// Discogs uses artist 194 "Various" for compilations
+		if credit.ID == 194 {
+			continue
+		}
+		artist, err := im.ensure(catalog.TypeArtist, "artist", credit.ID, credit.Name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, artist.ID)
+	}
+	return ids, nil
+}
+
+// resolve deduplicates an incoming entity against the catalog and stores it.
+// Matching is by Discogs ID, then barcode, then fuzzy name. Fuzzy matching is
+// skipped for names Discogs itself marks as ambiguous, and for releases the
+// candidate must share an artist with the incoming release.
+func (im *Importer) resolve(incoming *catalog.Entity, ambiguous bool, artists []string) (*catalog.Entity, error) {
+	if incoming.Name == "" {
+		return nil, fmt.Errorf("%s %s has no name: %w", incoming.Type, incoming.Identifiers.Discogs, importer.ErrSkipped)
+	}
+	existing, err := im.matchIdentifiers(incoming)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil && !ambiguous {
+		existing = im.matchName(incoming, artists)
+	}
+	if existing == nil {
+		return im.Store.CreateEntity(incoming)
+	}
+	catalog.FillMissing(existing, incoming)
+	return im.Store.UpdateEntity(existing)
+}
+
+func (im *Importer) matchIdentifiers(incoming *catalog.Entity) (*catalog.Entity, error) {
+	lookups := [][2]string{{catalog.SchemeDiscogs, incoming.Identifiers.Discogs}}
+	for _, b := range incoming.Identifiers.Barcodes {
+		lookups = append(lookups, [2]string{catalog.SchemeBarcode, b})
+	}
+	for _, l := range lookups {
+		if l[1] == "" {
+			continue
+		}
+		e, err := im.Store.FindByIdentifier(l[0], l[1])
+		if errors.Is(err, catalog.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == incoming.Type {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+// matchName returns the single best fuzzy match for incoming, or nil when
+// there is none or the best score is shared by several candidates
+func (im *Importer) matchName(incoming *catalog.Entity, artists []string) *catalog.Entity {
+	var candidates []catalog.NameMatch
+	for _, m := range im.Store.FindByName(incoming.Type, incoming.Name, matchThreshold) {
+		// An entity already carrying another Discogs ID is a different Discogs entity
+		if m.Entity.Identifiers.Discogs != "" && m.Entity.Identifiers.Discogs != incoming.Identifiers.Discogs {
+			continue
+		}
+		if incoming.Type == catalog.TypeRelease && !im.relatedTo(m.Entity.ID, artists) {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+	if len(candidates) == 0 || (len(candidates) > 1 && candidates[1].Score == candidates[0].Score) {
+		return nil
+	}
+	return candidates[0].Entity
+}
+
+// relatedTo reports whether the entity has a relation to any of ids
+func (im *Importer) relatedTo(entityID string, ids []string) bool {
+	for _, r := range im.Store.ListRelations(entityID) {
+		for _, id := range ids {
+			if r.TargetID == id || r.SourceID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ensure returns the entity for a Discogs reference, matching the catalog the
+// same way as resolve and creating a stub when nothing matches
+func (im *Importer) ensure(typ catalog.EntityType, kind string, id int, name string) (*catalog.Entity, error) {
+	stub := &catalog.Entity{Type: typ, Name: stripSuffix(name)}
+	if id > 0 {
+		stub.Identifiers.Discogs = discogsID(kind, id)
+	}
+	if stub.Name == "" {
+		stub.Name = stub.Identifiers.Discogs
+	}
+	return im.resolve(stub, hasSuffix(name) || typ == catalog.TypeRelease, nil)
+}
+
+func (im *Importer) relate(typ catalog.RelationType, sourceID, targetID string, attrs map[string]string) error {
+	_, err := im.Store.CreateRelation(&catalog.Relation{
+		Type:       typ,
+		SourceID:   sourceID,
+		TargetID:   targetID,
+		Attributes: attrs,
+	})
+	return err
+}
+
+func (im *Importer) relateAll(typ catalog.RelationType, sourceID string, targetIDs []string, attrs map[string]string) error {
+	for _, id := range targetIDs {
+		if err := im.relate(typ, sourceID, id, attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flattenTracks expands index tracks into their sub-tracks and drops headings
+func flattenTracks(tracks []trackXML) []trackXML {
+	var out []trackXML
+	for _, t := range tracks {
+		if len(t.SubTracks) > 0 {
+			out = append(out, flattenTracks(t.SubTracks)...)
+			continue
+		}
+		if t.Position == "" && t.Duration == "" {
+			continue
+		}
+		if strings.TrimSpace(t.Title) != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// parseDuration converts "m:ss" or "h:mm:ss" to milliseconds, returning 0 if unparseable
+func parseDuration(s string) int64 {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0
+	}
+	var seconds int64
+	for _, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds * 1000
+}
+
+// formatString renders formats like `2 × Vinyl (12", 33 ⅓ RPM, Album) + CD (Album)`
+func formatString(formats []formatXML) string {
+	parts := make([]string, 0, len(formats))
+	for _, f := range formats {
+		s := f.Name
+		if qty, err := strconv.Atoi(f.Qty); err == nil && qty > 1 {
+			s = fmt.Sprintf("%d × %s", qty, s)
+		}
+		details := append([]string{}, f.Descriptions...)
+		if f.Text != "" {
+			details = append(details, f.Text)
+		}
+		if len(details) > 0 {
+			s += " (" + strings.Join(details, ", ") + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " + ")
+}
+
+// releaseKind derives the release kind from format descriptions
+func releaseKind(formats []formatXML) string {
+	for _, f := range formats {
+		for _, d := range f.Descriptions {
+			switch d {
+			case "Album", "LP":
+				return "album"
+			case "Single", "Maxi-Single":
+				return "single"
+			case "EP", "Mini-Album":
+				return "ep"
+			case "Compilation":
+				return "compilation"
+			}
+		}
+	}
+	return ""
+}
+
+func isPerformerRole(role string) bool {
+	role = strings.ToLower(role)
+	for _, r := range performerRoles {
+		if strings.Contains(role, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProducerRole reports whether a credit role is a production credit such as
+// "Producer" or "Co-producer". Executive producers are kept as plain credits.
+func isProducerRole(role string) bool {
+	role = strings.ToLower(role)
+	return strings.Contains(role, "produc") && !strings.Contains(role, "executive")
+}
+
+func discogsID(kind string, id int) string {
+	if id <= 0 {
+		return ""
+	}
+	return kind + "/" + strconv.Itoa(id)
+}
+
+func stripSuffix(name string) string {
+	return duplicateSuffix.ReplaceAllString(strings.TrimSpace(name), "")
+}
+
+func hasSuffix(name string) bool {
+	return duplicateSuffix.MatchString(strings.TrimSpace(name))
+}
@@ -0,0 +1,83 @@
+package discogs
+
+// The structures below mirror the elements of the Discogs monthly XML dumps
+// that are mapped onto the catalog; everything else is ignored while decoding.
+
+type ref struct {
+	ID   int    `xml:"id,attr"`
+	Name string `xml:",chardata"`
+}
+
+type artistXML struct {
+	ID             int      `xml:"id"`
+	Name           string   `xml:"name"`
+	RealName       string   `xml:"realname"`
+	NameVariations []string `xml:"namevariations>name"`
+	Aliases        []ref    `xml:"aliases>name"`
+	Members        []ref    `xml:"members>name"`
+	Groups         []ref    `xml:"groups>name"`
+}
+
+type labelXML struct {
+	ID          int    `xml:"id"`
+	Name        string `xml:"name"`
+	ContactInfo string `xml:"contactinfo"`
+	Profile     string `xml:"profile"`
+}
+
+// creditXML is an artist credit on a master, release or track
+type creditXML struct {
+	ID   int    `xml:"id"`
+	Name string `xml:"name"`
+	ANV  string `xml:"anv"` // artist name variation used on this credit
+	Role string `xml:"role"`
+}
+
+type masterXML struct {
+	ID      int         `xml:"id,attr"`
+	Title   string      `xml:"title"`
+	Year    int         `xml:"year"`
+	Artists []creditXML `xml:"artists>artist"`
+}
+
+type releaseLabelXML struct {
+	ID    int    `xml:"id,attr"`
+	Name  string `xml:"name,attr"`
+	CatNo string `xml:"catno,attr"`
+}
+
+type formatXML struct {
+	Name         string   `xml:"name,attr"`
+	Qty          string   `xml:"qty,attr"`
+	Text         string   `xml:"text,attr"`
+	Descriptions []string `xml:"descriptions>description"`
+}
+
+type identifierXML struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type trackXML struct {
+	Position     string      `xml:"position"`
+	Title        string      `xml:"title"`
+	Duration     string      `xml:"duration"`
+	Artists      []creditXML `xml:"artists>artist"`
+	ExtraArtists []creditXML `xml:"extraartists>artist"`
+	SubTracks    []trackXML  `xml:"sub_tracks>track"`
+}
+
+type releaseXML struct {
+	ID           int               `xml:"id,attr"`
+	Status       string            `xml:"status,attr"`
+	Title        string            `xml:"title"`
+	Artists      []creditXML       `xml:"artists>artist"`
+	ExtraArtists []creditXML       `xml:"extraartists>artist"`
+	Labels       []releaseLabelXML `xml:"labels>label"`
+	Formats      []formatXML       `xml:"formats>format"`
+	Country      string            `xml:"country"`
+	Released     string            `xml:"released"`
+	MasterID     int               `xml:"master_id"`
+	Tracklist    []trackXML        `xml:"tracklist>track"`
+	Identifiers  []identifierXML   `xml:"identifiers>identifier"`
+}
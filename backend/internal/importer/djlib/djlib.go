@@ -0,0 +1,237 @@
+// Package djlib maps the tracks of DJ library exports, such as Rekordbox XML
+// and Traktor NML collections, onto catalog recordings together with the DJ
+// software's analysis: tempo, key, beat grid, cue points and rating.
+//
+// Tracks are matched to existing recordings by ISRC, then by title among the
+// recordings of the same artist. The analysis of a matched recording is
+// replaced, since the DJ software measured the actual audio file, while names
+// and other metadata only fill in what the catalog is missing.
+package djlib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer"
+)
+
+// Kind is the only dump kind of DJ library importers: the whole collection
+const Kind = "collection"
+
+// matchThreshold is the minimum name similarity for a fuzzy match
+const matchThreshold = 0.93
+
+// artistSeparator splits a track's artist credit into the individual artists
+var artistSeparator = regexp.MustCompile(`(?i)\s*(?:,|;|&|\bfeat\.?|\bft\.?|\bfeaturing\b|\bvs\.?)\s*`)
+
+// Track is a collection entry of a DJ library
+type Track struct {
+	Title      string
+	Artist     string // the credit as written, e.g. "Artist A feat. Artist B"
+	Album      string
+	Label      string
+	Year       string
+	ISRC       string
+	DurationMs int64
+	BPM        float64
+	Key        string
+	Rating     int // stars, see Stars
+	BeatGrid   []catalog.BeatMarker
+	Cues       []catalog.CuePoint
+}
+
+// Stars converts the 0 to 255 rating that Rekordbox and Traktor store, 51
+// per star, to 0 to 5 stars
+func Stars(value int) int {
+	return min(max((value+25)/51, 0), 5)
+}
+
+// Importer maps DJ library tracks onto catalog entities
+type Importer struct {
+	Store catalog.Store
+}
+
+// Import stores a track as a recording performed by its artists and
+// appearing on its album
+func (im *Importer) Import(t *Track) error {
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" {
+		return fmt.Errorf("track has no title: %w", importer.ErrSkipped)
+	}
+
+	artists, err := im.artists(t.Artist)
+	if err != nil {
+		return err
+	}
+
+	incoming := &catalog.Entity{
+		Type: catalog.TypeRecording,
+		Name: t.Title,
+		Recording: &catalog.RecordingInfo{
+			DurationMs: t.DurationMs,
+			Key:        t.Key,
+			BPM:        t.BPM,
+			Rating:     t.Rating,
+			BeatGrid:   t.BeatGrid,
+			Cues:       t.Cues,
+		},
+	}
+	if isrc, err := catalog.NormalizeISRC(t.ISRC); err == nil {
+		incoming.Identifiers.ISRCs = []string{isrc}
+	}
+	recording, err := im.matchRecording(incoming, artists)
+	if err != nil {
+		return err
+	}
+	if recording == nil {
+		recording, err = im.Store.CreateEntity(incoming)
+	} else {
+		catalog.FillMissing(recording, incoming)
+		applyAnalysis(recording.Recording, incoming.Recording)
+		recording, err = im.Store.UpdateEntity(recording)
+	}
+	if err != nil {
+		return err
+	}
+	for _, id := range artists {
+		if err := im.relate(catalog.RelPerformedBy, recording.ID, id); err != nil {
+			return err
+		}
+	}
+
+	if album := strings.TrimSpace(t.Album); album != "" {
+		info := &catalog.ReleaseInfo{Label: strings.TrimSpace(t.Label)}
+		if len(t.Year) >= 4 && t.Year != "0" {
+			info.Date = t.Year
+		}
+		release, err := im.ensureRelease(album, artists, info)
+		if err != nil {
+			return err
+		}
+		if err := im.relate(catalog.RelAppearsOn, recording.ID, release.ID); err != nil {
+			return err
+		}
+		for _, id := range artists {
+			if err := im.relate(catalog.RelPerformedBy, release.ID, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyAnalysis overwrites the tempo, key, rating, beat grid and cues of r
+// with those the DJ software set
+func applyAnalysis(r, in *catalog.RecordingInfo) {
+	if in.BPM > 0 {
+		r.BPM = in.BPM
+	}
+	if in.Key != "" {
+		r.Key = in.Key
+	}
+	if in.Rating > 0 {
+		r.Rating = in.Rating
+	}
+	if len(in.BeatGrid) > 0 {
+		r.BeatGrid = in.BeatGrid
+	}
+	if len(in.Cues) > 0 {
+		r.Cues = in.Cues
+	}
+}
+
+// matchRecording finds the recording a track is, by ISRC or else by a
+// similar title among recordings performed by one of its artists
+func (im *Importer) matchRecording(incoming *catalog.Entity, artists []string) (*catalog.Entity, error) {
+	for _, isrc := range incoming.Identifiers.ISRCs {
+		e, err := im.Store.FindByIdentifier(catalog.SchemeISRC, isrc)
+		if errors.Is(err, catalog.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == catalog.TypeRecording {
+			return e, nil
+		}
+	}
+	if len(artists) == 0 {
+		return nil, nil
+	}
+	for _, m := range im.Store.FindByName(catalog.TypeRecording, incoming.Name, matchThreshold) {
+		if im.performedBy(m.Entity.ID, artists) {
+			return m.Entity, nil
+		}
+	}
+	return nil, nil
+}
+
+// artists resolves an artist credit to catalog artist IDs. A credit naming a
+// known artist, such as "Simon & Garfunkel", is kept whole; otherwise it is
+// split into the individual artists.
+func (im *Importer) artists(credit string) ([]string, error) {
+	credit = strings.TrimSpace(credit)
+	if credit == "" {
+		return nil, nil
+	}
+	if matches := im.Store.FindByName(catalog.TypeArtist, credit, 1); len(matches) > 0 {
+		return []string{matches[0].Entity.ID}, nil
+	}
+	var ids []string
+	for _, name := range artistSeparator.Split(credit, -1) {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		artist, err := im.ensureArtist(name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, artist.ID)
+	}
+	return ids, nil
+}
+
+// ensureArtist returns the artist with a name similar to name, creating it
+// when there is none
+func (im *Importer) ensureArtist(name string) (*catalog.Entity, error) {
+	if matches := im.Store.FindByName(catalog.TypeArtist, name, matchThreshold); len(matches) > 0 {
+		return matches[0].Entity, nil
+	}
+	return im.Store.CreateEntity(&catalog.Entity{Type: catalog.TypeArtist, Name: name})
+}
+
+// ensureRelease returns the release with a title similar to title that
+// shares an artist with the track, creating it when there is none. Album
+// titles such as "Greatest Hits" are far from unique, so the title alone is
+// not enough.
+func (im *Importer) ensureRelease(title string, artists []string, info *catalog.ReleaseInfo) (*catalog.Entity, error) {
+	for _, m := range im.Store.FindByName(catalog.TypeRelease, title, matchThreshold) {
+		if im.performedBy(m.Entity.ID, artists) {
+			return m.Entity, nil
+		}
+	}
+	return im.Store.CreateEntity(&catalog.Entity{Type: catalog.TypeRelease, Name: title, Release: info})
+}
+
+// performedBy reports whether the entity is performed by any of artists
+func (im *Importer) performedBy(entityID string, artists []string) bool {
+	for _, r := range im.Store.ListRelations(entityID) {
+		if r.Type != catalog.RelPerformedBy || r.SourceID != entityID {
+			continue
+		}
+		for _, id := range artists {
+			if r.TargetID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (im *Importer) relate(typ catalog.RelationType, sourceID, targetID string) error {
+	_, err := im.Store.CreateRelation(&catalog.Relation{Type: typ, SourceID: sourceID, TargetID: targetID})
+	return err
+}
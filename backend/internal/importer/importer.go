@@ -0,0 +1,225 @@
+package importer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the lifecycle state of an import job
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+var (
+	ErrNotFound    = errors.New("import job not found")
+	ErrInvalidPath = errors.New("import path must be inside the import data directory")
+)
+
+// Progress counters of a running job. All fields are updated atomically.
+type Progress struct {
+	BytesRead  atomic.Int64
+	TotalBytes atomic.Int64
+	Records    atomic.Int64
+	Imported   atomic.Int64
+	Skipped    atomic.Int64
+	Failed     atomic.Int64
+}
+
+// Job is a single import run over one dump file
+type Job struct {
+	ID         string
+	Source     string
+	Kind       string
+	Path       string
+	StartedAt  time.Time
+	Progress   Progress
+	cancel     context.CancelFunc
+	mu         sync.Mutex
+	status     Status
+	err        string
+	finishedAt *time.Time
+}
+
+// JobStatus is a point-in-time view of a job for API responses
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Source     string     `json:"source"`
+	Kind       string     `json:"kind"`
+	Path       string     `json:"path"`
+	Status     Status     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	BytesRead  int64      `json:"bytesRead"`
+	TotalBytes int64      `json:"totalBytes"`
+	Percent    float64    `json:"percent"`
+	Records    int64      `json:"records"`
+	Imported   int64      `json:"imported"`
+	Skipped    int64      `json:"skipped"`
+	Failed     int64      `json:"failed"`
+	PerSecond  float64    `json:"recordsPerSecond"`
+}
+
+// Status returns a snapshot of the job state and progress
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	s := JobStatus{
+		ID:         j.ID,
+		Source:     j.Source,
+		Kind:       j.Kind,
+		Path:       j.Path,
+		Status:     j.status,
+		Error:      j.err,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.finishedAt,
+	}
+	j.mu.Unlock()
+
+	s.BytesRead = j.Progress.BytesRead.Load()
+	s.TotalBytes = j.Progress.TotalBytes.Load()
+	s.Records = j.Progress.Records.Load()
+	s.Imported = j.Progress.Imported.Load()
+	s.Skipped = j.Progress.Skipped.Load()
+	s.Failed = j.Progress.Failed.Load()
+	if s.TotalBytes > 0 {
+		s.Percent = float64(s.BytesRead) / float64(s.TotalBytes) * 100
+	}
+	end := time.Now()
+	if s.FinishedAt != nil {
+		end = *s.FinishedAt
+	}
+	if elapsed := end.Sub(s.StartedAt).Seconds(); elapsed > 0 {
+		s.PerSecond = float64(s.Records) / elapsed
+	}
+	return s
+}
+
+// Cancel stops a running job at the next record boundary
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// RunFunc performs the import for a job and returns when it is done
+type RunFunc func(ctx context.Context, job *Job) error
+
+// Manager starts import jobs in the background and keeps track of them
+type Manager struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// ManagerInstance tracks the import jobs of this process
+var ManagerInstance = NewManager()
+
+// NewManager creates an empty Manager
+func NewManager() *Manager {
+	return &Manager{jobs: make(map[string]*Job)}
+}
+
+// Start launches run in the background and returns the new job
+func (m *Manager) Start(source, kind, path string, run RunFunc) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        newJobID(),
+		Source:    source,
+		Kind:      kind,
+		Path:      path,
+		StartedAt: time.Now().UTC(),
+		cancel:    cancel,
+		status:    StatusRunning,
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		log.Printf("Import %s started: %s %s from %s", job.ID, source, kind, path)
+		err := run(ctx, job)
+
+		job.mu.Lock()
+		now := time.Now().UTC()
+		job.finishedAt = &now
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.status = StatusCancelled
+		case err != nil:
+			job.status = StatusFailed
+			job.err = err.Error()
+		default:
+			job.status = StatusCompleted
+		}
+		job.mu.Unlock()
+		log.Printf("Import %s %s: %d imported, %d skipped, %d failed", job.ID, job.status,
+			job.Progress.Imported.Load(), job.Progress.Skipped.Load(), job.Progress.Failed.Load())
+	}()
+	return job
+}
+
+// Get returns a job by ID
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// List returns the status of every job, newest first
+func (m *Manager) List() []JobStatus {
+	m.mu.RLock()
+	statuses := make([]JobStatus, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		statuses = append(statuses, job.Status())
+	}
+	m.mu.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartedAt.After(statuses[j].StartedAt)
+	})
+	return statuses
+}
+
+// ResolvePath maps a path relative to IMPORT_DATA_DIR to an absolute path,
+// rejecting anything that escapes the directory
+func ResolvePath(rel string) (string, error) {
+	dir := os.Getenv("IMPORT_DATA_DIR")
+	if dir == "" {
+		return "", fmt.Errorf("IMPORT_DATA_DIR is not configured")
+	}
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve import data directory: %w", err)
+	}
+	path := filepath.Join(base, filepath.Clean("/"+rel))
+	if path != base && !strings.HasPrefix(path, base+string(filepath.Separator)) {
+		return "", ErrInvalidPath
+	}
+	return path, nil
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate id: %v", err))
+	}
+	return "imp_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// maxLineBytes bounds the memory used for a single record
+const maxLineBytes = 64 << 20
+
+// ReadLines streams a line-delimited dump file, calling handle for each
+// non-empty line. Progress is reported on the job and a checkpoint is written
+// periodically, on cancellation and at the end so the import can be resumed.
+// Memory use is bounded by the longest line, which is capped at maxLineBytes.
+func ReadLines(ctx context.Context, job *Job, opts Options, handle func(line []byte) error) error {
+	s, err := openSession(job, opts)
+	if s == nil || err != nil {
+		return err
+	}
+	defer s.close()
+	if _, err := s.file.Seek(s.cp.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to checkpoint: %w", err)
+	}
+	job.Progress.BytesRead.Store(s.cp.Offset)
+
+	reader := bufio.NewReaderSize(s.file, 1<<20)
+	var buf []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return s.interrupt(err)
+		}
+
+		line, n, tooLong, readErr := readLine(reader, buf[:0])
+		buf = line
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("failed to read dump: %w", readErr)
+		}
+		s.cp.Offset += int64(n)
+		job.Progress.BytesRead.Store(s.cp.Offset)
+
+		if tooLong {
+			s.record(fmt.Errorf("record exceeds %d bytes", maxLineBytes))
+		} else if line = bytes.TrimSpace(line); len(line) > 0 {
+			s.record(handle(line))
+		}
+
+		if readErr == io.EOF {
+			return s.checkpoint(true)
+		}
+		if err := s.maybeCheckpoint(); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads one line into buf, returning the line, the number of bytes
+// consumed and whether the line was dropped for exceeding maxLineBytes
+func readLine(r *bufio.Reader, buf []byte) ([]byte, int, bool, error) {
+	n := 0
+	tooLong := false
+	for {
+		frag, err := r.ReadSlice('\n')
+		n += len(frag)
+		if !tooLong {
+			if len(buf)+len(frag) > maxLineBytes {
+				tooLong = true
+				buf = buf[:0]
+			} else {
+				buf = append(buf, frag...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return buf, n, tooLong, err
+	}
+}
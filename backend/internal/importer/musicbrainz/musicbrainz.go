@@ -0,0 +1,452 @@
+// Package musicbrainz imports the MusicBrainz JSON data dumps into the catalog.
+//
+// The dumps are published as tar.xz archives containing one file per entity
+// type (mbdump/artist, mbdump/work, ...) with one JSON document per line. The
+// archives must be extracted before import. For the best linking, import in
+// the order artist, work, release-group, recording: referenced entities that
+// are not imported yet are created as stubs and filled in later.
+package musicbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer"
+)
+
+// Dump kinds supported by the importer
+const (
+	KindArtist       = "artist"
+	KindReleaseGroup = "release-group"
+	KindRecording    = "recording"
+	KindWork         = "work"
+)
+
+// Source is the name of this importer in import jobs
+const Source = "musicbrainz"
+
+type lifeSpan struct {
+	Begin string `json:"begin"`
+	End   string `json:"end"`
+}
+
+type alias struct {
+	Name string `json:"name"`
+}
+
+type artistRef struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	SortName string `json:"sort-name"`
+}
+
+type artistCredit struct {
+	Name   string    `json:"name"`
+	Artist artistRef `json:"artist"`
+}
+
+type titleRef struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type relation struct {
+	Type       string     `json:"type"`
+	TargetType string     `json:"target-type"`
+	Direction  string     `json:"direction"` // forward when the record is the subject of the relation
+	Attributes []string   `json:"attributes"`
+	Artist     *artistRef `json:"artist"`
+	Work       *titleRef  `json:"work"`
+	Recording  *titleRef  `json:"recording"`
+}
+
+type artistRecord struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	SortName       string     `json:"sort-name"`
+	Type           string     `json:"type"`
+	Country        string     `json:"country"`
+	Disambiguation string     `json:"disambiguation"`
+	LifeSpan       lifeSpan   `json:"life-span"`
+	Aliases        []alias    `json:"aliases"`
+	Relations      []relation `json:"relations"`
+}
+
+type releaseGroupRecord struct {
+	ID               string         `json:"id"`
+	Title            string         `json:"title"`
+	PrimaryType      string         `json:"primary-type"`
+	FirstReleaseDate string         `json:"first-release-date"`
+	Disambiguation   string         `json:"disambiguation"`
+	ArtistCredit     []artistCredit `json:"artist-credit"`
+	Aliases          []alias        `json:"aliases"`
+}
+
+type recordingRecord struct {
+	ID             string         `json:"id"`
+	Title          string         `json:"title"`
+	Length         int64          `json:"length"`
+	Disambiguation string         `json:"disambiguation"`
+	ISRCs          []string       `json:"isrcs"`
+	ArtistCredit   []artistCredit `json:"artist-credit"`
+	Relations      []relation     `json:"relations"`
+	Aliases        []alias        `json:"aliases"`
+	Releases       []struct {
+		ReleaseGroup *titleRef `json:"release-group"`
+	} `json:"releases"`
+}
+
+type workRecord struct {
+	ID             string     `json:"id"`
+	Title          string     `json:"title"`
+	Type           string     `json:"type"`
+	Languages      []string   `json:"languages"`
+	Disambiguation string     `json:"disambiguation"`
+	ISWCs          []string   `json:"iswcs"`
+	Relations      []relation `json:"relations"`
+	Aliases        []alias    `json:"aliases"`
+}
+
+// artistRelations maps recording-artist relationship types to catalog relations.
+// Performers are credited on the recording; session musicians and producers
+// are the subject of their relation.
+var artistRelations = map[string]catalog.RelationType{
+	"performer":  catalog.RelPerformedBy,
+	"instrument": catalog.RelPlayedOn,
+	"vocal":      catalog.RelPlayedOn,
+	"producer":   catalog.RelProduced,
+}
+
+// composerRelations are work-artist relationship types mapped to composed-by
+var composerRelations = map[string]bool{
+	"composer": true,
+	"lyricist": true,
+	"writer":   true,
+	"arranger": true,
+}
+
+// Importer maps MusicBrainz records onto catalog entities
+type Importer struct {
+	Store catalog.Store
+}
+
+// Run returns an import job function for the given dump kind
+func Run(kind string, store catalog.Store, opts importer.Options) (importer.RunFunc, error) {
+	im := &Importer{Store: store}
+	var handle func([]byte) error
+	switch kind {
+	case KindArtist:
+		handle = im.importArtist
+	case KindReleaseGroup:
+		handle = im.importReleaseGroup
+	case KindRecording:
+		handle = im.importRecording
+	case KindWork:
+		handle = im.importWork
+	default:
+		return nil, fmt.Errorf("unsupported MusicBrainz dump kind %q", kind)
+	}
+	return func(ctx context.Context, job *importer.Job) error {
+		return importer.ReadLines(ctx, job, opts, handle)
+	}, nil
+}
+
+func (im *Importer) importArtist(line []byte) error {
+	var rec artistRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return fmt.Errorf("failed to decode artist: %w", err)
+	}
+	artist, err := im.upsert(&catalog.Entity{
+		Type:           catalog.TypeArtist,
+		Name:           rec.Name,
+		SortName:       rec.SortName,
+		Aliases:        aliasNames(rec.Aliases),
+		Disambiguation: rec.Disambiguation,
+		Identifiers:    catalog.Identifiers{MBID: rec.ID},
+		Artist: &catalog.ArtistInfo{
+			Kind:      strings.ToLower(rec.Type),
+			Country:   rec.Country,
+			BeginDate: rec.LifeSpan.Begin,
+			EndDate:   rec.LifeSpan.End,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range rec.Relations {
+		if rel.TargetType != "artist" || rel.Type != "member of band" || rel.Artist == nil {
+			continue
+		}
+		other, err := im.ensure(catalog.TypeArtist, rel.Artist.ID, rel.Artist.Name)
+		if err != nil {
+			return err
+		}
+		member, group := artist.ID, other.ID
+		if rel.Direction == "backward" {
+			member, group = group, member
+		}
+		var attrs map[string]string
+		if len(rel.Attributes) > 0 {
+			attrs = map[string]string{"instrument": strings.Join(rel.Attributes, ", ")}
+		}
+		if err := im.relate(catalog.RelMemberOf, member, group, attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *Importer) importReleaseGroup(line []byte) error {
+	var rec releaseGroupRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return fmt.Errorf("failed to decode release group: %w", err)
+	}
+	release, err := im.upsert(&catalog.Entity{
+		Type:           catalog.TypeRelease,
+		Name:           rec.Title,
+		Aliases:        aliasNames(rec.Aliases),
+		Disambiguation: rec.Disambiguation,
+		Identifiers:    catalog.Identifiers{MBID: rec.ID},
+		Release: &catalog.ReleaseInfo{
+			Kind: strings.ToLower(rec.PrimaryType),
+			Date: rec.FirstReleaseDate,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return im.linkCredits(release.ID, rec.ArtistCredit)
+}
+
+func (im *Importer) importRecording(line []byte) error {
+	var rec recordingRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return fmt.Errorf("failed to decode recording: %w", err)
+	}
+	recording, err := im.upsert(&catalog.Entity{
+		Type:           catalog.TypeRecording,
+		Name:           rec.Title,
+		Aliases:        aliasNames(rec.Aliases),
+		Disambiguation: rec.Disambiguation,
+		Identifiers:    catalog.Identifiers{MBID: rec.ID, ISRCs: validOnly(rec.ISRCs, catalog.NormalizeISRC)},
+		Recording:      &catalog.RecordingInfo{DurationMs: rec.Length},
+	})
+	if err != nil {
+		return err
+	}
+	if err := im.linkCredits(recording.ID, rec.ArtistCredit); err != nil {
+		return err
+	}
+
+	for _, rel := range rec.Relations {
+		switch {
+		case rel.TargetType == "work" && rel.Type == "performance" && rel.Work != nil:
+			work, err := im.ensure(catalog.TypeWork, rel.Work.ID, rel.Work.Title)
+			if err != nil {
+				return err
+			}
+			if err := im.relate(catalog.RelRecordingOf, recording.ID, work.ID, nil); err != nil {
+				return err
+			}
+			// MusicBrainz marks covers with an attribute on the performance relation
+			if slices.Contains(rel.Attributes, "cover") {
+				if err := im.relate(catalog.RelCovered, recording.ID, work.ID, nil); err != nil {
+					return err
+				}
+			}
+		case rel.TargetType == "artist" && artistRelations[rel.Type] != "" && rel.Artist != nil:
+			artist, err := im.ensure(catalog.TypeArtist, rel.Artist.ID, rel.Artist.Name)
+			if err != nil {
+				return err
+			}
+			attrs := map[string]string{"role": rel.Type}
+			if len(rel.Attributes) > 0 {
+				attrs["instrument"] = strings.Join(rel.Attributes, ", ")
+			}
+			relType := artistRelations[rel.Type]
+			source, target := artist.ID, recording.ID
+			if relType == catalog.RelPerformedBy {
+				source, target = target, source
+			}
+			if err := im.relate(relType, source, target, attrs); err != nil {
+				return err
+			}
+		case rel.TargetType == "recording" && rel.Type == "samples material" && rel.Recording != nil:
+			other, err := im.ensure(catalog.TypeRecording, rel.Recording.ID, rel.Recording.Title)
+			if err != nil {
+				return err
+			}
+			sampler, sampled := recording.ID, other.ID
+			if rel.Direction == "backward" {
+				sampler, sampled = sampled, sampler
+			}
+			if err := im.relate(catalog.RelSampled, sampler, sampled, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range rec.Releases {
+		if r.ReleaseGroup == nil {
+			continue
+		}
+		release, err := im.ensure(catalog.TypeRelease, r.ReleaseGroup.ID, r.ReleaseGroup.Title)
+		if err != nil {
+			return err
+		}
+		if err := im.relate(catalog.RelAppearsOn, recording.ID, release.ID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *Importer) importWork(line []byte) error {
+	var rec workRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return fmt.Errorf("failed to decode work: %w", err)
+	}
+	info := &catalog.WorkInfo{Kind: strings.ToLower(rec.Type)}
+	if len(rec.Languages) > 0 {
+		info.Language = rec.Languages[0]
+	}
+	work, err := im.upsert(&catalog.Entity{
+		Type:           catalog.TypeWork,
+		Name:           rec.Title,
+		Aliases:        aliasNames(rec.Aliases),
+		Disambiguation: rec.Disambiguation,
+		Identifiers:    catalog.Identifiers{MBID: rec.ID, ISWCs: validOnly(rec.ISWCs, catalog.NormalizeISWC)},
+		Work:           info,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range rec.Relations {
+		if rel.TargetType != "artist" || !composerRelations[rel.Type] || rel.Artist == nil {
+			continue
+		}
+		artist, err := im.ensure(catalog.TypeArtist, rel.Artist.ID, rel.Artist.Name)
+		if err != nil {
+			return err
+		}
+		if err := im.relate(catalog.RelComposedBy, work.ID, artist.ID, map[string]string{"role": rel.Type}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkCredits relates an entity to every artist in its artist credit
+func (im *Importer) linkCredits(entityID string, credits []artistCredit) error {
+	for i, credit := range credits {
+		name := credit.Artist.Name
+		if name == "" {
+			name = credit.Name
+		}
+		artist, err := im.ensure(catalog.TypeArtist, credit.Artist.ID, name)
+		if err != nil {
+			return err
+		}
+		attrs := map[string]string{"credit": credit.Name, "position": fmt.Sprint(i)}
+		if err := im.relate(catalog.RelPerformedBy, entityID, artist.ID, attrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsert creates the entity or merges it into the entity with the same MBID.
+// Identifiers already owned by a different entity are dropped, since
+// MusicBrainz legitimately shares e.g. ISRCs between recordings.
+func (im *Importer) upsert(e *catalog.Entity) (*catalog.Entity, error) {
+	if e.Name == "" {
+		return nil, fmt.Errorf("%s %s has no name: %w", e.Type, e.Identifiers.MBID, importer.ErrSkipped)
+	}
+	existing, err := im.Store.FindByIdentifier(catalog.SchemeMBID, e.Identifiers.MBID)
+	if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+		return nil, err
+	}
+	ownerID := ""
+	if existing != nil {
+		ownerID = existing.ID
+	}
+	e.Identifiers.ISRCs = im.unowned(catalog.SchemeISRC, e.Identifiers.ISRCs, ownerID)
+	e.Identifiers.ISWCs = im.unowned(catalog.SchemeISWC, e.Identifiers.ISWCs, ownerID)
+
+	if existing == nil {
+		return im.Store.CreateEntity(e)
+	}
+	if existing.Name == existing.Identifiers.MBID {
+		// Stub created without a name, see ensure
+		existing.Name = ""
+	}
+	catalog.MergeInto(existing, e)
+	return im.Store.UpdateEntity(existing)
+}
+
+// ensure returns the entity with the given MBID, creating a stub if it has not been imported yet
+func (im *Importer) ensure(typ catalog.EntityType, mbid, name string) (*catalog.Entity, error) {
+	existing, err := im.Store.FindByIdentifier(catalog.SchemeMBID, mbid)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, catalog.ErrNotFound) {
+		return nil, err
+	}
+	stub := &catalog.Entity{Type: typ, Name: name, Identifiers: catalog.Identifiers{MBID: mbid}}
+	if stub.Name == "" {
+		stub.Name = mbid
+	}
+	return im.Store.CreateEntity(stub)
+}
+
+func (im *Importer) relate(typ catalog.RelationType, sourceID, targetID string, attrs map[string]string) error {
+	_, err := im.Store.CreateRelation(&catalog.Relation{
+		Type:       typ,
+		SourceID:   sourceID,
+		TargetID:   targetID,
+		Attributes: attrs,
+	})
+	return err
+}
+
+// unowned filters out identifiers that belong to an entity other than ownerID
+func (im *Importer) unowned(scheme string, values []string, ownerID string) []string {
+	var out []string
+	for _, v := range values {
+		owner, err := im.Store.FindByIdentifier(scheme, v)
+		if err == nil && owner.ID != ownerID {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// validOnly drops identifiers that fail validation
+func validOnly(values []string, normalize func(string) (string, error)) []string {
+	var out []string
+	for _, v := range values {
+		if n, err := normalize(v); err == nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func aliasNames(aliases []alias) []string {
+	names := make([]string, 0, len(aliases))
+	for _, a := range aliases {
+		if a.Name != "" {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,122 @@
+// Package rekordbox imports the collection of a Rekordbox XML export
+// (File > Export Collection in xml format) into the catalog, keeping the
+// tempo, key, rating, beat grid and cue points Rekordbox analyzed.
+package rekordbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer/djlib"
+)
+
+// Source is the name of this importer in import jobs
+const Source = "rekordbox"
+
+// Position mark types
+const (
+	markCue     = 0
+	markFadeIn  = 1
+	markFadeOut = 2
+	markLoad    = 3
+	markLoop    = 4
+)
+
+// trackXML is a TRACK of the COLLECTION. Times are in seconds.
+type trackXML struct {
+	Name       string     `xml:"Name,attr"`
+	Artist     string     `xml:"Artist,attr"`
+	Album      string     `xml:"Album,attr"`
+	Label      string     `xml:"Label,attr"`
+	Year       string     `xml:"Year,attr"`
+	ISRC       string     `xml:"ISRC,attr"`
+	TotalTime  int64      `xml:"TotalTime,attr"`
+	AverageBpm float64    `xml:"AverageBpm,attr"`
+	Tonality   string     `xml:"Tonality,attr"`
+	Rating     int        `xml:"Rating,attr"`
+	Tempo      []tempoXML `xml:"TEMPO"`
+	Marks      []markXML  `xml:"POSITION_MARK"`
+}
+
+type tempoXML struct {
+	Inizio  float64 `xml:"Inizio,attr"`
+	Bpm     float64 `xml:"Bpm,attr"`
+	Metro   string  `xml:"Metro,attr"`
+	Battito int     `xml:"Battito,attr"`
+}
+
+type markXML struct {
+	Name  string   `xml:"Name,attr"`
+	Type  int      `xml:"Type,attr"`
+	Start float64  `xml:"Start,attr"`
+	End   *float64 `xml:"End,attr"`
+	Num   int      `xml:"Num,attr"`
+}
+
+// Run returns an import job function for the collection of an export
+func Run(kind string, store catalog.Store, opts importer.Options) (importer.RunFunc, error) {
+	if kind != djlib.Kind {
+		return nil, fmt.Errorf("unsupported Rekordbox dump kind %q", kind)
+	}
+	im := &djlib.Importer{Store: store}
+	return func(ctx context.Context, job *importer.Job) error {
+		return importer.ReadXMLIn(ctx, job, opts, "COLLECTION", func(rec *trackXML) error {
+			return im.Import(track(rec))
+		})
+	}, nil
+}
+
+func track(rec *trackXML) *djlib.Track {
+	t := &djlib.Track{
+		Title:      rec.Name,
+		Artist:     rec.Artist,
+		Album:      rec.Album,
+		Label:      rec.Label,
+		Year:       rec.Year,
+		ISRC:       rec.ISRC,
+		DurationMs: rec.TotalTime * 1000,
+		BPM:        rec.AverageBpm,
+		Key:        rec.Tonality,
+		Rating:     djlib.Stars(rec.Rating),
+	}
+	for _, tempo := range rec.Tempo {
+		t.BeatGrid = append(t.BeatGrid, catalog.BeatMarker{
+			PositionMs: tempo.Inizio * 1000,
+			BPM:        tempo.Bpm,
+			Meter:      tempo.Metro,
+			Beat:       tempo.Battito,
+		})
+	}
+	for _, mark := range rec.Marks {
+		cue := catalog.CuePoint{Name: mark.Name, PositionMs: mark.Start * 1000}
+		switch mark.Type {
+		case markCue:
+			// Num is the hot cue pad from 0, or -1 for a memory cue
+			cue.Kind = catalog.CueMemory
+			if mark.Num >= 0 {
+				cue.Kind = catalog.CueHot
+				cue.Hotcue = mark.Num + 1
+			}
+		case markFadeIn:
+			cue.Kind = catalog.CueFadeIn
+		case markFadeOut:
+			cue.Kind = catalog.CueFadeOut
+		case markLoad:
+			cue.Kind = catalog.CueLoad
+		case markLoop:
+			cue.Kind = catalog.CueLoop
+			if mark.Num >= 0 {
+				cue.Hotcue = mark.Num + 1
+			}
+			if mark.End != nil && *mark.End > mark.Start {
+				cue.LengthMs = (*mark.End - mark.Start) * 1000
+			}
+		default:
+			continue
+		}
+		t.Cues = append(t.Cues, cue)
+	}
+	return t
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+const (
+	// defaultCheckpointEvery is the number of records between checkpoints
+	defaultCheckpointEvery = 10000
+	// maxLoggedFailures limits how many record failures are logged per job
+	maxLoggedFailures = 20
+)
+
+// ErrSkipped is returned by a record handler for records it deliberately ignores
+var ErrSkipped = errors.New("record skipped")
+
+// Options control how a dump file is read
+type Options struct {
+	// Restart ignores any existing checkpoint and reads the file from the start
+	Restart bool
+	// CheckpointEvery is the number of records between checkpoints
+	CheckpointEvery int
+	// Flush is called before each checkpoint is written so that everything
+	// imported up to the checkpoint is durable
+	Flush func() error
+}
+
+// session tracks record counts and checkpoints during one pass over a dump file
+type session struct {
+	job             *Job
+	opts            Options
+	file            *os.File
+	cp              Checkpoint
+	resumed         bool
+	sinceCheckpoint int
+}
+
+// openSession opens the dump of a job and loads its checkpoint. It returns a
+// nil session when the dump has already been fully imported.
+func openSession(job *Job, opts Options) (*session, error) {
+	f, err := os.Open(job.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dump: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat dump: %w", err)
+	}
+	job.Progress.TotalBytes.Store(info.Size())
+	if opts.CheckpointEvery <= 0 {
+		opts.CheckpointEvery = defaultCheckpointEvery
+	}
+
+	s := &session{job: job, opts: opts, file: f, cp: Checkpoint{Size: info.Size(), ModTime: info.ModTime()}}
+	if opts.Restart {
+		return s, nil
+	}
+	existing, err := LoadCheckpoint(job.Path, info)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if existing == nil {
+		return s, nil
+	}
+	if existing.Done {
+		log.Printf("Import %s: %s already fully imported, nothing to do", job.ID, job.Path)
+		job.Progress.BytesRead.Store(info.Size())
+		f.Close()
+		return nil, nil
+	}
+	s.cp = *existing
+	s.resumed = true
+	log.Printf("Import %s: resuming at offset %d after %d records", job.ID, s.cp.Offset, s.cp.Records)
+	return s, nil
+}
+
+func (s *session) close() {
+	s.file.Close()
+}
+
+// record counts one record according to the error returned by its handler
+func (s *session) record(err error) {
+	s.cp.Records++
+	s.job.Progress.Records.Add(1)
+	s.sinceCheckpoint++
+	switch {
+	case err == nil:
+		s.cp.Imported++
+		s.job.Progress.Imported.Add(1)
+	case errors.Is(err, ErrSkipped):
+		s.job.Progress.Skipped.Add(1)
+	default:
+		if failed := s.job.Progress.Failed.Add(1); failed <= maxLoggedFailures {
+			log.Printf("Import %s: record %d failed: %v", s.job.ID, s.cp.Records, err)
+		}
+	}
+}
+
+// checkpoint flushes imported data and then saves the checkpoint
+func (s *session) checkpoint(done bool) error {
+	if s.opts.Flush != nil {
+		if err := s.opts.Flush(); err != nil {
+			return fmt.Errorf("failed to flush imported data: %w", err)
+		}
+	}
+	s.cp.Done = done
+	s.sinceCheckpoint = 0
+	return SaveCheckpoint(s.job.Path, s.cp)
+}
+
+// maybeCheckpoint saves a checkpoint once enough records have been read
+func (s *session) maybeCheckpoint() error {
+	if s.sinceCheckpoint < s.opts.CheckpointEvery {
+		return nil
+	}
+	return s.checkpoint(false)
+}
+
+// interrupt saves a checkpoint after cancellation and returns the cause
+func (s *session) interrupt(cause error) error {
+	if err := s.checkpoint(false); err != nil {
+		log.Printf("Import %s: %v", s.job.ID, err)
+	}
+	return cause
+}
@@ -0,0 +1,128 @@
+// Package traktor imports the collection of a Traktor NML file
+// (collection.nml) into the catalog, keeping the tempo, key, ranking, beat
+// grid and cue points Traktor analyzed.
+package traktor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer"
+	"github.com/One-Frequency/MusicRAG/backend/internal/importer/djlib"
+)
+
+// Source is the name of this importer in import jobs
+const Source = "traktor"
+
+// Cue types
+const (
+	cueCue     = 0
+	cueFadeIn  = 1
+	cueFadeOut = 2
+	cueLoad    = 3
+	cueGrid    = 4
+	cueLoop    = 5
+)
+
+// musicalKeys are the keys of MUSICAL_KEY values: major keys from C, then
+// minor keys from C
+var musicalKeys = [24]string{
+	"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B",
+	"Cm", "C#m", "Dm", "D#m", "Em", "Fm", "F#m", "Gm", "G#m", "Am", "A#m", "Bm",
+}
+
+// entryXML is an ENTRY of the COLLECTION. Times are in seconds, except cue
+// positions which are in milliseconds.
+type entryXML struct {
+	Title  string `xml:"TITLE,attr"`
+	Artist string `xml:"ARTIST,attr"`
+	Album  struct {
+		Title string `xml:"TITLE,attr"`
+	} `xml:"ALBUM"`
+	Info struct {
+		Playtime    int64  `xml:"PLAYTIME,attr"`
+		Ranking     int    `xml:"RANKING,attr"`
+		Label       string `xml:"LABEL,attr"`
+		ReleaseDate string `xml:"RELEASE_DATE,attr"` // e.g. 2019/6/14
+		Key         string `xml:"KEY,attr"`          // Open Key, e.g. 8m
+	} `xml:"INFO"`
+	Tempo struct {
+		BPM float64 `xml:"BPM,attr"`
+	} `xml:"TEMPO"`
+	MusicalKey *struct {
+		Value int `xml:"VALUE,attr"`
+	} `xml:"MUSICAL_KEY"`
+	Cues []cueXML `xml:"CUE_V2"`
+}
+
+type cueXML struct {
+	Name   string  `xml:"NAME,attr"`
+	Type   int     `xml:"TYPE,attr"`
+	Start  float64 `xml:"START,attr"`
+	Len    float64 `xml:"LEN,attr"`
+	Hotcue int     `xml:"HOTCUE,attr"` // pad from 0, -1 when none
+}
+
+// Run returns an import job function for the collection of an NML file
+func Run(kind string, store catalog.Store, opts importer.Options) (importer.RunFunc, error) {
+	if kind != djlib.Kind {
+		return nil, fmt.Errorf("unsupported Traktor dump kind %q", kind)
+	}
+	im := &djlib.Importer{Store: store}
+	return func(ctx context.Context, job *importer.Job) error {
+		return importer.ReadXMLIn(ctx, job, opts, "COLLECTION", func(rec *entryXML) error {
+			return im.Import(track(rec))
+		})
+	}, nil
+}
+
+func track(rec *entryXML) *djlib.Track {
+	t := &djlib.Track{
+		Title:      rec.Title,
+		Artist:     rec.Artist,
+		Album:      rec.Album.Title,
+		Label:      rec.Info.Label,
+		DurationMs: rec.Info.Playtime * 1000,
+		BPM:        rec.Tempo.BPM,
+		Key:        rec.Info.Key,
+		Rating:     djlib.Stars(rec.Info.Ranking),
+	}
+	if year, _, _ := strings.Cut(rec.Info.ReleaseDate, "/"); year != "" {
+		t.Year = year
+	}
+	if k := rec.MusicalKey; k != nil && k.Value >= 0 && k.Value < len(musicalKeys) {
+		t.Key = musicalKeys[k.Value]
+	}
+	for _, c := range rec.Cues {
+		cue := catalog.CuePoint{Name: c.Name, PositionMs: c.Start}
+		if c.Hotcue >= 0 {
+			cue.Hotcue = c.Hotcue + 1
+		}
+		switch c.Type {
+		case cueCue:
+			cue.Kind = catalog.CueMemory
+			if c.Hotcue >= 0 {
+				cue.Kind = catalog.CueHot
+			}
+		case cueFadeIn:
+			cue.Kind = catalog.CueFadeIn
+		case cueFadeOut:
+			cue.Kind = catalog.CueFadeOut
+		case cueLoad:
+			cue.Kind = catalog.CueLoad
+		case cueGrid:
+			// A grid marker anchors the beat grid at the track's tempo
+			t.BeatGrid = append(t.BeatGrid, catalog.BeatMarker{PositionMs: c.Start, BPM: t.BPM, Meter: "4/4", Beat: 1})
+			continue
+		case cueLoop:
+			cue.Kind = catalog.CueLoop
+			cue.LengthMs = c.Len
+		default:
+			continue
+		}
+		t.Cues = append(t.Cues, cue)
+	}
+	return t
+}
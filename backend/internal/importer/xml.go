@@ -0,0 +1,154 @@
+package importer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// ReadXML streams an XML dump whose records are the direct children of the
+// root element, e.g. <artists><artist/><artist/></artists>, decoding each
+// record into a new T and passing it to handle. Files ending in .gz are
+// decompressed on the fly. Progress, checkpoints and resumption work as in
+// ReadLines; checkpoint offsets count uncompressed bytes.
+func ReadXML[T any](ctx context.Context, job *Job, opts Options, handle func(rec *T) error) error {
+	return ReadXMLIn(ctx, job, opts, "", handle)
+}
+
+// ReadXMLIn is ReadXML for files whose records are the children of the
+// container element below the root, such as the tracks in
+// <DJ_PLAYLISTS><COLLECTION><TRACK/></COLLECTION><PLAYLISTS/></DJ_PLAYLISTS>.
+// Other children of the root are skipped. An empty container reads the
+// children of the root.
+func ReadXMLIn[T any](ctx context.Context, job *Job, opts Options, container string, handle func(rec *T) error) error {
+	s, err := openSession(job, opts)
+	if s == nil || err != nil {
+		return err
+	}
+	defer s.close()
+
+	resumeAt := s.cp.Offset
+	var base int64 // uncompressed offset of the first byte seen by the decoder
+	var src io.Reader
+	gzipped := strings.HasSuffix(job.Path, ".gz")
+	if s.resumed && !gzipped {
+		// Seek straight to the next record and re-open the root element
+		// synthetically so the decoder sees a balanced document
+		root, err := rootElement(s.file)
+		if err != nil {
+			return err
+		}
+		if _, err := s.file.Seek(resumeAt, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to checkpoint: %w", err)
+		}
+		job.Progress.BytesRead.Store(resumeAt)
+		prefix := "<" + root + ">"
+		if container != "" {
+			prefix += "<" + container + ">"
+		}
+		base = resumeAt - int64(len(prefix))
+		counted := &countingReader{r: s.file, n: &job.Progress.BytesRead}
+		src = io.MultiReader(strings.NewReader(prefix), bufio.NewReaderSize(counted, 1<<20))
+		resumeAt = 0
+	} else {
+		job.Progress.BytesRead.Store(0)
+		src = bufio.NewReaderSize(&countingReader{r: s.file, n: &job.Progress.BytesRead}, 1<<20)
+		if gzipped {
+			gz, err := gzip.NewReader(src)
+			if err != nil {
+				return fmt.Errorf("failed to open gzip stream: %w", err)
+			}
+			defer gz.Close()
+			src = gz
+		}
+	}
+
+	dec := xml.NewDecoder(src)
+	// Records start at recordDepth: below the root, or below the container
+	recordDepth := 1
+	if container != "" {
+		recordDepth = 2
+	}
+	depth := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return s.interrupt(err)
+		}
+
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return s.checkpoint(true)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse dump: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth < recordDepth {
+				if depth == 1 && t.Name.Local != container {
+					if err := dec.Skip(); err != nil {
+						return fmt.Errorf("failed to parse dump: %w", err)
+					}
+					continue
+				}
+				depth++
+				continue
+			}
+			// A compressed dump cannot be seeked, so records before the
+			// checkpoint are parsed and skipped
+			if base+dec.InputOffset() <= resumeAt {
+				if err := dec.Skip(); err != nil {
+					return fmt.Errorf("failed to parse dump: %w", err)
+				}
+				continue
+			}
+			rec := new(T)
+			if err := dec.DecodeElement(rec, &t); err != nil {
+				return fmt.Errorf("failed to parse dump: %w", err)
+			}
+			s.cp.Offset = base + dec.InputOffset()
+			s.record(handle(rec))
+			if err := s.maybeCheckpoint(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// rootElement returns the name of the document element of an XML file
+func rootElement(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to seek dump: %w", err)
+	}
+	dec := xml.NewDecoder(bufio.NewReader(r))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to find root element: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// countingReader adds the number of bytes read to n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,223 @@
+// Package linking detects mentions of artists, works and albums in free-text
+// queries and resolves them to catalog entities, so that nicknames such as
+// "Bird", "Trane" or "the Fab Four" reach the right documents.
+package linking
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+const (
+	// maxSpanTokens is the longest mention considered, in words
+	maxSpanTokens = 6
+	// fuzzyThreshold is the minimum name similarity for a fuzzy match
+	fuzzyThreshold = 0.92
+	// minFuzzyLength is the minimum mention length, in letters, that may be
+	// matched fuzzily; shorter mentions must match a name or alias exactly
+	minFuzzyLength = 6
+	// ExactScore is the score of a mention matching a name or alias exactly
+	ExactScore = 1.0
+)
+
+// linkedTypes are the entity types mentions are resolved to
+var linkedTypes = []catalog.EntityType{catalog.TypeArtist, catalog.TypeWork, catalog.TypeRelease}
+
+var (
+	// wordPattern matches a word of the query, keeping inner apostrophes,
+	// ampersands and dots so that "AC/DC" style names survive as one span
+	wordPattern = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}'’&./-]*`)
+
+	// stopwords never start or end a mention on their own
+	stopwords = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+		"by": true, "did": true, "do": true, "does": true, "for": true, "from": true,
+		"has": true, "have": true, "he": true, "her": true, "his": true, "how": true,
+		"i": true, "in": true, "is": true, "it": true, "me": true, "my": true, "of": true,
+		"on": true, "or": true, "she": true, "so": true, "that": true, "the": true,
+		"their": true, "them": true, "they": true, "this": true, "to": true, "was": true,
+		"were": true, "what": true, "when": true, "where": true, "which": true, "who": true,
+		"why": true, "with": true, "you": true, "album": true, "albums": true, "song": true,
+		"songs": true, "track": true, "tracks": true, "band": true, "music": true,
+	}
+)
+
+// Mention is a span of the query resolved to a catalog entity. Start and End
+// are byte offsets into the query.
+type Mention struct {
+	Text   string          `json:"text"`
+	Start  int             `json:"start"`
+	End    int             `json:"end"`
+	Entity *catalog.Entity `json:"entity"`
+	Score  float64         `json:"score"`
+}
+
+// Linker resolves query mentions against a catalog store
+type Linker struct {
+	store catalog.Store
+}
+
+// LinkerInstance is the linker over the shared catalog store
+var LinkerInstance *Linker
+
+// Init creates the linker over the catalog store. It must run after catalog.Init.
+func Init() {
+	LinkerInstance = New(catalog.StoreInstance)
+}
+
+// New creates a linker over store
+func New(store catalog.Store) *Linker {
+	return &Linker{store: store}
+}
+
+type word struct {
+	text       string
+	start, end int
+}
+
+// Link returns the non-overlapping entity mentions in query in order of
+// appearance. Longer mentions win over shorter ones they overlap, so "Kind of
+// Blue" is linked as an album rather than "Blue" on its own.
+func (l *Linker) Link(query string) []Mention {
+	var words []word
+	for _, loc := range wordPattern.FindAllStringIndex(query, -1) {
+		text := strings.TrimRight(query[loc[0]:loc[1]], "'’.-/")
+		// Possessives link to the owner: "Bird's solos" mentions Bird
+		for _, suffix := range []string{"'s", "’s"} {
+			text = strings.TrimSuffix(text, suffix)
+		}
+		if text != "" {
+			words = append(words, word{text: text, start: loc[0], end: loc[0] + len(text)})
+		}
+	}
+
+	type candidate struct {
+		Mention
+		tokens int
+	}
+	var candidates []candidate
+	for i := range words {
+		if stopwords[strings.ToLower(words[i].text)] {
+			continue
+		}
+		for n := 1; n <= maxSpanTokens && i+n <= len(words); n++ {
+			last := words[i+n-1]
+			if stopwords[strings.ToLower(last.text)] {
+				continue
+			}
+			start := words[i].start
+			entity, score := l.resolve(query[start:last.end])
+			if entity == nil {
+				continue
+			}
+			// Band names absorb a leading article: "the Fab Four" is one mention
+			if i > 0 && entity.Type == catalog.TypeArtist && strings.EqualFold(words[i-1].text, "the") {
+				start = words[i-1].start
+			}
+			candidates = append(candidates, candidate{
+				Mention: Mention{Text: query[start:last.end], Start: start, End: last.end, Entity: entity, Score: score},
+				tokens:  n,
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].tokens != candidates[j].tokens {
+			return candidates[i].tokens > candidates[j].tokens
+		}
+		return candidates[i].Score > candidates[j].Score
+	})
+	mentions := []Mention{}
+	for _, c := range candidates {
+		overlaps := false
+		for _, m := range mentions {
+			if c.Start < m.End && m.Start < c.End {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			mentions = append(mentions, c.Mention)
+		}
+	}
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].Start < mentions[j].Start })
+	return mentions
+}
+
+// resolve finds the best entity for a mention. Ties between equally similar
+// entities go to the one with the most relations, a cheap popularity prior
+// that prefers "Charlie Parker" over an obscure namesake.
+func (l *Linker) resolve(text string) (*catalog.Entity, float64) {
+	key := catalog.NameKey(text)
+	if len([]rune(key)) < 2 {
+		return nil, 0
+	}
+	threshold := ExactScore
+	if letters(key) >= minFuzzyLength {
+		threshold = fuzzyThreshold
+	}
+
+	var best *catalog.Entity
+	bestScore, bestDegree := 0.0, -1
+	for _, m := range l.store.FindByName("", text, threshold) {
+		if m.Score < bestScore {
+			break
+		}
+		if !slices.Contains(linkedTypes, m.Entity.Type) {
+			continue
+		}
+		degree := len(l.store.ListRelations(m.Entity.ID))
+		if m.Score > bestScore || degree > bestDegree {
+			best, bestScore, bestDegree = m.Entity, m.Score, degree
+		}
+	}
+	return best, bestScore
+}
+
+// Canonicalize rewrites query with each mention replaced by the canonical
+// name of its entity, e.g. "Trane's sheets of sound" becomes "John
+// Coltrane's sheets of sound". Mentions must come from Link on the same query.
+func Canonicalize(query string, mentions []Mention) string {
+	var b strings.Builder
+	pos := 0
+	for _, m := range mentions {
+		b.WriteString(query[pos:m.Start])
+		b.WriteString(m.Entity.Name)
+		pos = m.End
+		// "the Fab Four's" becomes "The Beatles'" rather than "The Beatles's"
+		if strings.HasSuffix(m.Entity.Name, "s") && strings.HasPrefix(query[pos:], "'s") {
+			b.WriteString("'")
+			pos += 2
+		}
+	}
+	b.WriteString(query[pos:])
+	return b.String()
+}
+
+// EntityIDs returns the distinct entity IDs of mentions
+func EntityIDs(mentions []Mention) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, m := range mentions {
+		if !seen[m.Entity.ID] {
+			seen[m.Entity.ID] = true
+			ids = append(ids, m.Entity.ID)
+		}
+	}
+	return ids
+}
+
+func letters(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n
+}
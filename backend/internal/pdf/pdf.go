@@ -0,0 +1,272 @@
+// Package pdf writes simple text documents as PDF: paragraphs wrapped to the
+// page in the standard Helvetica and Courier fonts, paginated on A4. The
+// standard fonts are built into every PDF reader, so nothing is embedded and
+// no external renderer is needed.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Font is one of the standard fonts
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+	Italic
+	Mono
+)
+
+var fontNames = [...]string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Courier"}
+
+// A4 page geometry in points
+const (
+	pageWidth  = 595.28
+	pageHeight = 841.89
+	margin     = 56.7 // 2 cm
+	lineFactor = 1.35 // line height as a multiple of the font size
+)
+
+// Style is how a paragraph is set
+type Style struct {
+	Font Font
+	Size float64
+	// Indent is the left indent in points
+	Indent float64
+	// Gray is the text color from 0 (black) to 1 (white)
+	Gray float64
+}
+
+// Document is a PDF being written, one page at a time
+type Document struct {
+	title string
+	pages []*bytes.Buffer
+	y     float64 // baseline of the next line on the current page
+}
+
+// New starts a document with a title, which readers show in their window
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// Text sets text as a paragraph, wrapping it at word boundaries and breaking
+// pages as needed. Every line break of text starts a new line.
+func (d *Document) Text(text string, s Style) {
+	width := pageWidth - 2*margin - s.Indent
+	lineHeight := s.Size * lineFactor
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		for _, wrapped := range wrap(encode(line), s.Font, s.Size, width) {
+			page := d.reserve(lineHeight)
+			fmt.Fprintf(page, "BT %.2f g /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+				s.Gray, int(s.Font)+1, s.Size, margin+s.Indent, d.y-s.Size, escape(wrapped))
+			d.y -= lineHeight
+		}
+	}
+}
+
+// Space leaves vertical space, unless at the top of a page
+func (d *Document) Space(points float64) {
+	if len(d.pages) > 0 && d.y < pageHeight-margin {
+		d.y -= points
+	}
+}
+
+// Rule draws a thin horizontal line across the text width
+func (d *Document) Rule() {
+	page := d.reserve(8)
+	y := d.y - 4
+	fmt.Fprintf(page, "0.75 G 0.5 w %.2f %.2f m %.2f %.2f l S 0 G\n", margin, y, pageWidth-margin, y)
+	d.y -= 8
+}
+
+// reserve returns the page to draw the next height points on, starting a
+// new page when the current one is full
+func (d *Document) reserve(height float64) *bytes.Buffer {
+	if len(d.pages) == 0 || d.y-height < margin {
+		d.pages = append(d.pages, &bytes.Buffer{})
+		d.y = pageHeight - margin
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// WriteTo writes the document as a PDF file
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.reserve(0)
+	}
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1 and 2 are the catalog and page tree, then come the fonts,
+	// each page and its content stream, and the document information
+	fontBase := 3
+	pageBase := fontBase + len(fontNames)
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageBase+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	var fonts []string
+	for i, name := range fontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", i+1, fontBase+i))
+	}
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, strings.Join(fonts, " "), pageBase+2*i+1))
+		var stream bytes.Buffer
+		zw := zlib.NewWriter(&stream)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return 0, err
+		}
+		if err := zw.Close(); err != nil {
+			return 0, err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()))
+	}
+	object(fmt.Sprintf("<< /Title (%s) /Producer (MusicRAG) >>", escape(encode(d.title))))
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, len(offsets), xref)
+	n, err := w.Write(out.Bytes())
+	return int64(n), err
+}
+
+// wrap breaks an encoded line into lines that fit width, at spaces where
+// possible and within words that are wider than a line
+func wrap(line []byte, font Font, size, width float64) [][]byte {
+	if len(line) == 0 {
+		return [][]byte{nil}
+	}
+	var lines [][]byte
+	for len(line) > 0 {
+		fit, lastSpace := 0, -1
+		var w float64
+		for fit < len(line) {
+			w += glyphWidth(font, line[fit]) * size / 1000
+			if w > width && fit > 0 {
+				break
+			}
+			if line[fit] == ' ' {
+				lastSpace = fit
+			}
+			fit++
+		}
+		if fit < len(line) && lastSpace > 0 {
+			fit = lastSpace
+		}
+		lines = append(lines, bytes.TrimRight(line[:fit], " "))
+		line = bytes.TrimLeft(line[fit:], " ")
+	}
+	return lines
+}
+
+// glyphWidth is the advance of a WinAnsi character in thousandths of the
+// font size
+func glyphWidth(font Font, c byte) float64 {
+	switch {
+	case font == Mono:
+		return 600
+	case c < 32:
+		return 0
+	case c > 126:
+		// Accented letters and typographic marks are close to the digits
+		return 556
+	case font == Bold:
+		return float64(boldWidths[c-32])
+	}
+	return float64(helveticaWidths[c-32])
+}
+
+// winAnsi maps the characters of Windows-1252 outside Latin-1 to their codes
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// substitutes spell characters the standard fonts lack, such as the
+// accidentals of chord names
+var substitutes = map[rune]string{
+	'♯': "#", '♭': "b", '♮': "nat", '→': "->", '←': "<-", '≈': "~", '≤': "<=", '≥': ">=",
+	'−': "-", '\t': "    ",
+}
+
+// encode converts text to WinAnsi, substituting or replacing with ? the
+// characters it cannot represent
+func encode(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		text = text[size:]
+		if sub, ok := substitutes[r]; ok {
+			out = append(out, sub...)
+			continue
+		}
+		switch {
+		case r >= 32 && r < 127, r >= 0xa0 && r <= 0xff:
+			out = append(out, byte(r))
+		case winAnsi[r] != 0:
+			out = append(out, winAnsi[r])
+		case r < 32:
+			// Control characters have no glyph
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// escape writes an encoded string as the body of a PDF literal string
+func escape(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		case c < 32 || c > 126:
+			fmt.Fprintf(&s, "\\%03o", c)
+		default:
+			s.WriteByte(c)
+		}
+	}
+	return s.String()
+}
+
+// Glyph widths of printable ASCII from the Adobe font metrics of Helvetica,
+// which Helvetica-Oblique shares, and Helvetica-Bold
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var boldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
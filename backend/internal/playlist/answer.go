@@ -0,0 +1,73 @@
+package playlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// listItem matches a bulleted or numbered line of an answer
+	listItem = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s+(.+)$`)
+
+	// byCredit matches "Title" by Artist, with or without quotes
+	byCredit = regexp.MustCompile(`^["“']?(.+?)["”']?\s+by\s+(.+?)$`)
+
+	// dashCredit matches Artist - Title with any kind of dash
+	dashCredit = regexp.MustCompile(`^(.+?)\s+[-–—]\s+(.+)$`)
+
+	// answerMarkup is what answers wrap track names in: emphasis, citation
+	// markers and trailing years such as (1959)
+	answerMarkup = regexp.MustCompile(`\*\*|__|\*|\s*\[\d+(?:\s*,\s*\d+)*\]|\s*\((?:\d{4}|\d+:\d{2})\)`)
+
+	// description matches the commentary that follows a track in an answer,
+	// as in "So What by Miles Davis: the modal opener"
+	description = regexp.MustCompile(`\s*(?::\s|\s[–—]\s|\s-\s).*$`)
+)
+
+// FromAnswer extracts the tracks listed in a chat answer, one per bulleted or
+// numbered line written as "Title" by Artist or Artist - Title. Lines that
+// name no track are ignored, so an answer without a list yields no entries.
+func FromAnswer(answer string) []Entry {
+	entries := []Entry{}
+	for _, line := range strings.Split(answer, "\n") {
+		m := listItem.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		item := strings.TrimSpace(answerMarkup.ReplaceAllString(m[1], ""))
+		if credit := byCredit.FindStringSubmatch(item); credit != nil {
+			title := strings.Trim(credit[1], `"“”' `)
+			artist := strings.TrimSpace(description.ReplaceAllString(credit[2], ""))
+			entries = append(entries, Entry{Title: title, Artist: strings.TrimRight(artist, ".,;")})
+			continue
+		}
+		if credit := dashCredit.FindStringSubmatch(item); credit != nil {
+			title := strings.TrimSpace(description.ReplaceAllString(credit[2], ""))
+			// Either order is common; matching tries the other if this fails
+			entries = append(entries, Entry{Title: strings.Trim(title, `"“”'.,; `), Artist: strings.Trim(credit[1], `"“”' `), swappable: true})
+		}
+	}
+	return entries
+}
+
+// FromTracks reads the data of a structured answer of the tracks output
+// type, or any JSON array of objects with a title and an artist
+func FromTracks(data json.RawMessage) ([]Entry, error) {
+	var tracks []struct {
+		Title  string `json:"title"`
+		Artist string `json:"artist"`
+		Album  string `json:"album"`
+	}
+	if err := json.Unmarshal(data, &tracks); err != nil {
+		return nil, fmt.Errorf("%w: tracks must be an array of objects with a title and an artist: %v", ErrInvalid, err)
+	}
+	entries := make([]Entry, 0, len(tracks))
+	for _, t := range tracks {
+		if strings.TrimSpace(t.Title) != "" {
+			entries = append(entries, Entry{Title: strings.TrimSpace(t.Title), Artist: strings.TrimSpace(t.Artist), Album: strings.TrimSpace(t.Album)})
+		}
+	}
+	return entries, nil
+}
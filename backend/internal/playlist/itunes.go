@@ -0,0 +1,221 @@
+package playlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// parseITunes reads an Apple Music or iTunes Library XML export and returns
+// its user playlists. The library itself, built-in playlists such as Music or
+// Podcasts, folders and hidden playlists are skipped.
+func parseITunes(data []byte) ([]*Playlist, error) {
+	root, err := decodePlist(data)
+	if err != nil {
+		return nil, err
+	}
+	library, ok := root.(map[string]any)
+	if !ok {
+		return nil, errors.New("library XML does not contain a dictionary")
+	}
+	tracks, _ := library["Tracks"].(map[string]any)
+
+	playlists := []*Playlist{}
+	items, _ := library["Playlists"].([]any)
+	for _, item := range items {
+		dict, ok := item.(map[string]any)
+		if !ok || dict["Master"] == true || dict["Folder"] == true || dict["Visible"] == false {
+			continue
+		}
+		if _, builtIn := dict["Distinguished Kind"]; builtIn {
+			continue
+		}
+		name, _ := dict["Name"].(string)
+		p := &Playlist{Name: name, Entries: []Entry{}}
+		refs, _ := dict["Playlist Items"].([]any)
+		for _, ref := range refs {
+			refDict, _ := ref.(map[string]any)
+			id, ok := refDict["Track ID"].(int64)
+			if !ok {
+				continue
+			}
+			track, ok := tracks[strconv.FormatInt(id, 10)].(map[string]any)
+			if !ok {
+				continue
+			}
+			p.Entries = append(p.Entries, iTunesEntry(track))
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, nil
+}
+
+func iTunesEntry(track map[string]any) Entry {
+	text := func(key string) string {
+		s, _ := track[key].(string)
+		return strings.TrimSpace(s)
+	}
+	e := Entry{
+		Title:    text("Name"),
+		Artist:   text("Artist"),
+		Album:    text("Album"),
+		Location: text("Location"),
+	}
+	if e.Artist == "" {
+		e.Artist = text("Album Artist")
+	}
+	if ms, ok := track["Total Time"].(int64); ok {
+		e.DurationMs = ms
+	}
+	return e
+}
+
+// decodePlist decodes an XML property list into maps, slices, strings,
+// int64, float64 and bool values. Dates and data are returned as strings.
+func decodePlist(data []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("no property list found: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "plist" {
+			for {
+				tok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				if start, ok := tok.(xml.StartElement); ok {
+					return plistValue(dec, start)
+				}
+			}
+		}
+	}
+}
+
+// plistValue decodes the value whose start element was just read
+func plistValue(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		key := ""
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := dec.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := plistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = v
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		array := []any{}
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				v, err := plistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, v)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := dec.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	}
+	return text, nil
+}
+
+// writeITunes writes p as a library XML file holding its tracks and the one
+// playlist, which Apple Music imports with File > Library > Import Playlist
+func writeITunes(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(plistHeader)
+	bw.WriteString("<dict>\n")
+	plistInt(bw, 1, "Major Version", 1)
+	plistInt(bw, 1, "Minor Version", 1)
+	plistString(bw, 1, "Application Version", "1.0")
+	bw.WriteString("\t<key>Tracks</key>\n\t<dict>\n")
+	for i, e := range p.Entries {
+		id := i + 1
+		fmt.Fprintf(bw, "\t\t<key>%d</key>\n\t\t<dict>\n", id)
+		plistInt(bw, 3, "Track ID", int64(id))
+		plistString(bw, 3, "Name", e.Title)
+		plistString(bw, 3, "Artist", e.Artist)
+		plistString(bw, 3, "Album", e.Album)
+		if e.DurationMs > 0 {
+			plistInt(bw, 3, "Total Time", e.DurationMs)
+		}
+		plistString(bw, 3, "Location", e.Location)
+		bw.WriteString("\t\t</dict>\n")
+	}
+	bw.WriteString("\t</dict>\n")
+	bw.WriteString("\t<key>Playlists</key>\n\t<array>\n\t\t<dict>\n")
+	plistString(bw, 3, "Name", p.Name)
+	plistInt(bw, 3, "Playlist ID", 1)
+	bw.WriteString("\t\t\t<key>All Items</key><true/>\n")
+	bw.WriteString("\t\t\t<key>Playlist Items</key>\n\t\t\t<array>\n")
+	for i := range p.Entries {
+		fmt.Fprintf(bw, "\t\t\t\t<dict>\n\t\t\t\t\t<key>Track ID</key><integer>%d</integer>\n\t\t\t\t</dict>\n", i+1)
+	}
+	bw.WriteString("\t\t\t</array>\n\t\t</dict>\n\t</array>\n")
+	bw.WriteString("</dict>\n</plist>\n")
+	return bw.Flush()
+}
+
+func plistInt(w *bufio.Writer, depth int, key string, v int64) {
+	fmt.Fprintf(w, "%s<key>%s</key><integer>%d</integer>\n", strings.Repeat("\t", depth), key, v)
+}
+
+// plistString writes a string entry, omitting empty values as Apple does
+func plistString(w *bufio.Writer, depth int, key, v string) {
+	if v == "" {
+		return
+	}
+	fmt.Fprintf(w, "%s<key>%s</key><string>", strings.Repeat("\t", depth), key)
+	xml.EscapeText(w, []byte(v))
+	w.WriteString("</string>\n")
+}
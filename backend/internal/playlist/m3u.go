@@ -0,0 +1,132 @@
+package playlist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseM3U reads a simple or extended M3U playlist. Plain .m3u files are
+// often Latin-1, so latin1 decodes them as such unless they are valid UTF-8.
+func parseM3U(data []byte, latin1 bool) ([]*Playlist, error) {
+	if latin1 && !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		data = []byte(string(runes))
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	p := &Playlist{Entries: []Entry{}}
+	var next Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line == "#EXTM3U":
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			next = parseExtinf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			next.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#EXTART:"):
+			next.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+			next.swappable = false
+		case strings.HasPrefix(line, "#"):
+			// Other directives and comments
+		default:
+			next.Location = line
+			if next.Title == "" {
+				next.setDisplayName(locationName(line))
+			}
+			p.Entries = append(p.Entries, next)
+			next = Entry{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return []*Playlist{p}, nil
+}
+
+// parseExtinf reads the duration and "Artist - Title" of an #EXTINF line.
+// Attributes between the duration and the comma, as in IPTV lists, are skipped.
+func parseExtinf(info string) Entry {
+	var e Entry
+	head, name, found := strings.Cut(info, ",")
+	if !found {
+		name = ""
+	}
+	if fields := strings.Fields(head); len(fields) > 0 {
+		if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil && seconds > 0 {
+			e.DurationMs = int64(seconds * 1000)
+		}
+	}
+	e.setDisplayName(name)
+	return e
+}
+
+// splitDisplayName splits "Artist - Title"; names without the separator are titles
+func splitDisplayName(name string) (artist, title string) {
+	name = strings.TrimSpace(name)
+	if artist, title, ok := strings.Cut(name, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", name
+}
+
+// setDisplayName sets the artist and title of an entry from "Artist - Title".
+// Files also name tracks "Title - Artist", so a split name is swappable.
+func (e *Entry) setDisplayName(name string) {
+	e.Artist, e.Title = splitDisplayName(name)
+	e.swappable = e.Artist != ""
+}
+
+// locationName returns the file name of a path or URL without its extension
+func locationName(location string) string {
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		location = u.Path
+	}
+	base := path.Base(strings.ReplaceAll(location, `\`, "/"))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// writeM3U writes an extended M3U playlist in UTF-8. Entries without a known
+// location are written as "Artist - Title", which players that match by name
+// can still resolve.
+func writeM3U(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	if p.Name != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(p.Name))
+	}
+	for _, e := range p.Entries {
+		seconds := int64(-1)
+		if e.DurationMs > 0 {
+			seconds = (e.DurationMs + 500) / 1000
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", seconds, oneLine(e.displayName()))
+		if e.Album != "" {
+			fmt.Fprintf(bw, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		location := e.Location
+		if location == "" {
+			location = e.displayName()
+		}
+		fmt.Fprintln(bw, oneLine(location))
+	}
+	return bw.Flush()
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,314 @@
+package playlist
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+const (
+	// titleThreshold is the minimum title similarity of a candidate recording
+	titleThreshold = 0.85
+	// matchThreshold is the minimum combined score for an entry to be linked.
+	// An exact title without an artist scores 0.9, so it links on its own
+	// when no other recording has that title.
+	matchThreshold = 0.85
+	// durationSlackMs is how far the durations of a file and a recording may
+	// differ before the candidate is penalized, covering fades and silences
+	durationSlackMs = 10_000
+)
+
+var (
+	// versionSuffix matches edition notes that catalog titles leave out, such
+	// as "(Remastered 2009)", "[Live]" or " - 2011 Remaster"
+	versionSuffix = regexp.MustCompile(`(?i)\s*(?:[(\[][^)\]]*\b(?:remaster(?:ed)?|live|mono|stereo|version|edit|mix|feat\.?|ft\.?|with)\b[^)\]]*[)\]]|\s-\s.*\b(?:remaster(?:ed)?|live|mono|stereo|version|edit|mix)\b.*)$`)
+
+	// featuring splits a credit into its main artist and featured artists
+	featuring = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring|with|&|and|x)\s+|\s*,\s*`)
+)
+
+// Matcher links playlist entries to catalog recordings
+type Matcher struct {
+	store catalog.Store
+}
+
+// MatcherInstance matches against the shared catalog store
+var MatcherInstance *Matcher
+
+// NewMatcher creates a matcher over store
+func NewMatcher(store catalog.Store) *Matcher {
+	return &Matcher{store: store}
+}
+
+// Match links each entry to the catalog recording it most likely is, by
+// MusicBrainz ID or ISRC when the file has one, otherwise by title, artist
+// and duration. Entries without a confident match are left unlinked.
+func (m *Matcher) Match(entries []Entry) {
+	for i := range entries {
+		e := &entries[i]
+		e.RecordingID, e.MatchScore = m.match(*e)
+		if e.RecordingID == "" && e.swappable {
+			swapped := *e
+			swapped.Title, swapped.Artist = e.Artist, e.Title
+			if id, score := m.match(swapped); id != "" {
+				*e = swapped
+				e.RecordingID, e.MatchScore = id, score
+			}
+		}
+	}
+}
+
+// Complete fills in what the catalog knows about matched entries and the
+// file does not, such as durations and identifiers for export
+func (m *Matcher) Complete(entries []Entry) {
+	for i := range entries {
+		e := &entries[i]
+		if e.RecordingID == "" {
+			continue
+		}
+		recording, err := m.store.GetEntity(e.RecordingID)
+		if err != nil {
+			continue
+		}
+		if e.Title == "" {
+			e.Title = recording.Name
+		}
+		if e.Artist == "" {
+			e.Artist = strings.Join(m.artists(recording.ID), ", ")
+		}
+		if e.DurationMs == 0 && recording.Recording != nil {
+			e.DurationMs = recording.Recording.DurationMs
+		}
+		if e.ISRC == "" && len(recording.Identifiers.ISRCs) > 0 {
+			e.ISRC = recording.Identifiers.ISRCs[0]
+		}
+		if e.MBID == "" {
+			e.MBID = recording.Identifiers.MBID
+		}
+	}
+}
+
+func (m *Matcher) match(e Entry) (string, float64) {
+	for _, id := range []struct{ scheme, value string }{{catalog.SchemeMBID, e.MBID}, {catalog.SchemeISRC, e.ISRC}} {
+		if id.value == "" {
+			continue
+		}
+		if entity, err := m.store.FindByIdentifier(id.scheme, id.value); err == nil && entity.Type == catalog.TypeRecording {
+			return entity.ID, 1
+		}
+	}
+	if e.Title == "" {
+		return "", 0
+	}
+
+	type candidate struct {
+		id    string
+		score float64
+		gap   int64 // duration difference in milliseconds, -1 when unknown
+	}
+	var candidates []candidate
+	// titled counts the candidates whose title alone would link them
+	titled := 0
+	seen := make(map[string]bool)
+	for _, title := range titleVariants(e.Title) {
+		for _, match := range m.store.FindByName(catalog.TypeRecording, title, titleThreshold) {
+			if seen[match.Entity.ID] {
+				continue
+			}
+			seen[match.Entity.ID] = true
+			c := candidate{id: match.Entity.ID, score: 0.9 * match.Score, gap: -1}
+			if c.score >= matchThreshold {
+				titled++
+			}
+			if e.Artist != "" {
+				c.score = 0.6*match.Score + 0.4*m.artistScore(e.Artist, match.Entity.ID)
+			}
+			if r := match.Entity.Recording; r != nil && r.DurationMs > 0 && e.DurationMs > 0 {
+				c.gap = max(r.DurationMs-e.DurationMs, e.DurationMs-r.DurationMs)
+				if c.gap > durationSlackMs {
+					c.score *= 0.9
+				}
+			}
+			candidates = append(candidates, c)
+		}
+	}
+	// Without an artist a common title such as "Intro" says too little to
+	// choose between the recordings that have it
+	if len(candidates) == 0 || (e.Artist == "" && titled > 1) {
+		return "", 0
+	}
+	// Among equally good candidates prefer the closest duration, then the ID
+	// so that results are stable
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if (a.gap >= 0) != (b.gap >= 0) {
+			return a.gap >= 0
+		}
+		if a.gap != b.gap {
+			return a.gap < b.gap
+		}
+		return a.id < b.id
+	})
+	if best := candidates[0]; best.score >= matchThreshold {
+		return best.id, best.score
+	}
+	return "", 0
+}
+
+// titleVariants returns the title and, if it carries an edition note, the
+// title without it
+func titleVariants(title string) []string {
+	variants := []string{title}
+	if stripped := strings.TrimSpace(versionSuffix.ReplaceAllString(title, "")); stripped != "" && stripped != title {
+		variants = append(variants, stripped)
+	}
+	return variants
+}
+
+// artistScore is the best similarity between the entry's credited artists
+// and the recording's performers. A recording without known performers
+// scores 0.5, neither confirming nor ruling out the match.
+func (m *Matcher) artistScore(credit, recordingID string) float64 {
+	performers := m.artists(recordingID)
+	if len(performers) == 0 {
+		return 0.5
+	}
+	names := append([]string{credit}, featuring.Split(credit, -1)...)
+	best := 0.0
+	for _, name := range names {
+		for _, performer := range performers {
+			best = max(best, catalog.NameSimilarity(name, performer))
+		}
+	}
+	return best
+}
+
+// artists returns the names of the artists performing a recording
+func (m *Matcher) artists(recordingID string) []string {
+	var names []string
+	for _, r := range m.store.ListRelations(recordingID) {
+		if r.Type == catalog.RelPerformedBy && r.SourceID == recordingID {
+			if artist, err := m.store.GetEntity(r.TargetID); err == nil {
+				names = append(names, artist.Name)
+			}
+		}
+	}
+	return names
+}
+
+// Describe renders a playlist with what the catalog knows about its tracks
+// as a context document, with the aggregates precomputed: the model is far
+// better at reading an average tempo than at computing one.
+func (m *Matcher) Describe(p *Playlist) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The user's playlist %q", p.Name)
+	if p.Format != "" {
+		fmt.Fprintf(&b, " (imported from %s)", p.Format)
+	}
+	fmt.Fprintf(&b, ": %d tracks, %d matched to the catalog.", len(p.Entries), p.Matched())
+
+	var totalMs, bpmSum, energySum float64
+	var tempos, energies int
+	minBPM, maxBPM := 0.0, 0.0
+	keys := make(map[string]int)
+	artists := make(map[string]int)
+	var lines []string
+	for i, e := range p.Entries {
+		durationMs := e.DurationMs
+		var details []string
+		if e.RecordingID != "" {
+			if recording, err := m.store.GetEntity(e.RecordingID); err == nil && recording.Recording != nil {
+				r := recording.Recording
+				if durationMs == 0 {
+					durationMs = r.DurationMs
+				}
+				if r.BPM > 0 {
+					bpmSum += r.BPM
+					tempos++
+					if minBPM == 0 || r.BPM < minBPM {
+						minBPM = r.BPM
+					}
+					maxBPM = max(maxBPM, r.BPM)
+					details = append(details, fmt.Sprintf("%.0f BPM", r.BPM))
+				}
+				if r.Key != "" {
+					keys[r.Key]++
+					details = append(details, "key "+r.Key)
+				}
+				if r.Energy > 0 {
+					energySum += r.Energy
+					energies++
+					details = append(details, fmt.Sprintf("energy %.2f", r.Energy))
+				}
+			}
+		}
+		if e.Artist != "" {
+			artists[e.Artist]++
+		}
+		totalMs += float64(durationMs)
+		if durationMs > 0 {
+			details = append([]string{formatDuration(durationMs)}, details...)
+		}
+		line := fmt.Sprintf("%d. %s", i+1, e.displayName())
+		if len(details) > 0 {
+			line += " (" + strings.Join(details, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	if totalMs > 0 {
+		fmt.Fprintf(&b, "\nTotal length of the tracks with a known duration: %s.", formatDuration(int64(totalMs)))
+	}
+	if tempos > 0 {
+		fmt.Fprintf(&b, "\nAverage tempo: %.1f BPM over the %d tracks with a known tempo (range %.0f to %.0f BPM).", bpmSum/float64(tempos), tempos, minBPM, maxBPM)
+	} else {
+		b.WriteString("\nNo track has a known tempo.")
+	}
+	if energies > 0 {
+		fmt.Fprintf(&b, "\nAverage energy: %.2f on a scale of 0 to 1, over %d tracks.", energySum/float64(energies), energies)
+	}
+	if len(keys) > 0 {
+		b.WriteString("\nKeys:")
+		for _, k := range byCount(keys) {
+			fmt.Fprintf(&b, " %s (%d);", k, keys[k])
+		}
+	}
+	if len(artists) > 0 {
+		b.WriteString("\nMost frequent artists:")
+		for _, a := range byCount(artists)[:min(len(artists), 5)] {
+			fmt.Fprintf(&b, " %s (%d);", a, artists[a])
+		}
+	}
+	b.WriteString("\nTracks:\n")
+	b.WriteString(strings.Join(lines, "\n"))
+	return b.String()
+}
+
+// byCount returns the keys of counts, most frequent first
+func byCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func formatDuration(ms int64) string {
+	seconds := (ms + 500) / 1000
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
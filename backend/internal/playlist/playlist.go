@@ -0,0 +1,168 @@
+// Package playlist reads and writes playlists in the common interchange
+// formats, matches their entries to catalog recordings and keeps each user's
+// imported playlists, so that questions can be grounded in them and answers
+// taken back out as playlists.
+package playlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats a playlist can be read from and written to
+const (
+	FormatM3U    = "m3u"
+	FormatM3U8   = "m3u8"
+	FormatXSPF   = "xspf"
+	FormatITunes = "itunes" // Apple Music / iTunes Library XML
+)
+
+// Formats lists the supported formats in the order they are documented
+var Formats = []string{FormatM3U, FormatM3U8, FormatXSPF, FormatITunes}
+
+var (
+	ErrNotFound          = errors.New("playlist not found")
+	ErrUnsupportedFormat = errors.New("unsupported playlist format")
+	ErrInvalid           = errors.New("invalid playlist")
+)
+
+// Entry is a single track of a playlist as the file describes it, together
+// with the catalog recording it was matched to
+type Entry struct {
+	Title      string `json:"title"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Location   string `json:"location,omitempty"` // file path or URL
+	ISRC       string `json:"isrc,omitempty"`
+	MBID       string `json:"mbid,omitempty"` // MusicBrainz recording ID
+
+	RecordingID string  `json:"recordingId,omitempty"`
+	MatchScore  float64 `json:"matchScore,omitempty"`
+
+	// swappable is set when the file does not say which of Title and Artist
+	// is which, so matching may try them the other way round
+	swappable bool
+}
+
+// Playlist is a named, ordered list of tracks owned by one user
+type Playlist struct {
+	ID        string    `json:"id"`
+	OwnerID   string    `json:"ownerId"`
+	Name      string    `json:"name"`
+	Format    string    `json:"format,omitempty"` // format the playlist was imported from
+	Entries   []Entry   `json:"entries"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Matched returns the number of entries matched to a catalog recording
+func (p *Playlist) Matched() int {
+	n := 0
+	for _, e := range p.Entries {
+		if e.RecordingID != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Clone returns a deep copy of the playlist
+func (p *Playlist) Clone() *Playlist {
+	c := *p
+	c.Entries = append([]Entry(nil), p.Entries...)
+	return &c
+}
+
+// DetectFormat picks the format of a playlist file from its extension,
+// falling back to sniffing its content
+func DetectFormat(filename string, data []byte) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".m3u":
+		return FormatM3U, nil
+	case ".m3u8":
+		return FormatM3U8, nil
+	case ".xspf":
+		return FormatXSPF, nil
+	}
+	head := string(data[:min(len(data), 1024)])
+	switch {
+	case strings.Contains(head, "<playlist") && strings.Contains(head, "xspf.org"):
+		return FormatXSPF, nil
+	case strings.Contains(head, "<plist"):
+		return FormatITunes, nil
+	case strings.HasPrefix(strings.TrimPrefix(head, "\xef\xbb\xbf"), "#EXTM3U"):
+		return FormatM3U8, nil
+	}
+	return "", fmt.Errorf("%w: cannot tell the format of %q", ErrUnsupportedFormat, filename)
+}
+
+// Parse reads the playlists in data. M3U and XSPF files hold one playlist; a
+// library XML file holds all of the library's user playlists. Entries are
+// not matched to the catalog.
+func Parse(format string, data []byte) ([]*Playlist, error) {
+	var (
+		playlists []*Playlist
+		err       error
+	)
+	switch format {
+	case FormatM3U, FormatM3U8:
+		playlists, err = parseM3U(data, format == FormatM3U)
+	case FormatXSPF:
+		playlists, err = parseXSPF(data)
+	case FormatITunes:
+		playlists, err = parseITunes(data)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for _, p := range playlists {
+		p.Format = format
+	}
+	return playlists, nil
+}
+
+// Write encodes p in format
+func Write(w io.Writer, format string, p *Playlist) error {
+	switch format {
+	case FormatM3U, FormatM3U8:
+		return writeM3U(w, p)
+	case FormatXSPF:
+		return writeXSPF(w, p)
+	case FormatITunes:
+		return writeITunes(w, p)
+	}
+	return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+}
+
+// Encode returns p encoded in format with the file extension and MIME type
+// to serve it with
+func Encode(format string, p *Playlist) (data []byte, ext, contentType string, err error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, format, p); err != nil {
+		return nil, "", "", err
+	}
+	switch format {
+	case FormatM3U:
+		return buf.Bytes(), ".m3u", "audio/x-mpegurl", nil
+	case FormatM3U8:
+		return buf.Bytes(), ".m3u8", "application/vnd.apple.mpegurl", nil
+	case FormatXSPF:
+		return buf.Bytes(), ".xspf", "application/xspf+xml", nil
+	}
+	return buf.Bytes(), ".xml", "application/xml", nil
+}
+
+// displayName returns "Artist - Title", or the title alone
+func (e Entry) displayName() string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
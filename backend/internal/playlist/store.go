@@ -0,0 +1,176 @@
+package playlist
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Store keeps the playlists of every user. Playlists are private: every
+// lookup is scoped to the owner, and other users' playlists are reported as
+// not found.
+type Store struct {
+	mu        sync.RWMutex
+	playlists map[string]*Playlist
+	// path is where the store is persisted, empty when persistence is disabled
+	path string
+}
+
+// StoreInstance holds the playlists of this process
+var StoreInstance *Store
+
+// Init creates the playlist store, restoring it from PLAYLIST_SNAPSHOT_PATH
+// if set, and the matcher over the catalog. It must run after catalog.Init.
+func Init() {
+	MatcherInstance = NewMatcher(catalog.StoreInstance)
+	StoreInstance = NewStore(os.Getenv("PLAYLIST_SNAPSHOT_PATH"))
+	if StoreInstance.path == "" {
+		log.Println("PLAYLIST_SNAPSHOT_PATH not set, playlists will not be persisted")
+		return
+	}
+	if err := StoreInstance.load(); err != nil {
+		log.Fatalf("Failed to load playlists: %v", err)
+	}
+}
+
+// NewStore creates an empty store persisted to path, or kept in memory only
+// if path is empty
+func NewStore(path string) *Store {
+	return &Store{playlists: make(map[string]*Playlist), path: path}
+}
+
+// Create stores a copy of p under a new ID
+func (s *Store) Create(p *Playlist) (*Playlist, error) {
+	if p.OwnerID == "" {
+		return nil, fmt.Errorf("%w: playlist has no owner", ErrInvalid)
+	}
+	p = p.Clone()
+	p.ID = newID()
+	p.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playlists[p.ID] = p
+	s.persist()
+	return p.Clone(), nil
+}
+
+// Get returns the owner's playlist with the given ID
+func (s *Store) Get(ownerID, id string) (*Playlist, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.playlists[id]
+	if !ok || p.OwnerID != ownerID {
+		return nil, ErrNotFound
+	}
+	return p.Clone(), nil
+}
+
+// List returns the owner's playlists, most recent first
+func (s *Store) List(ownerID string) []*Playlist {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	playlists := []*Playlist{}
+	for _, p := range s.playlists {
+		if p.OwnerID == ownerID {
+			playlists = append(playlists, p.Clone())
+		}
+	}
+	sort.Slice(playlists, func(i, j int) bool {
+		if !playlists[i].CreatedAt.Equal(playlists[j].CreatedAt) {
+			return playlists[i].CreatedAt.After(playlists[j].CreatedAt)
+		}
+		return playlists[i].ID < playlists[j].ID
+	})
+	return playlists
+}
+
+// Delete removes the owner's playlist with the given ID
+func (s *Store) Delete(ownerID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[id]
+	if !ok || p.OwnerID != ownerID {
+		return ErrNotFound
+	}
+	delete(s.playlists, id)
+	s.persist()
+	return nil
+}
+
+// persist saves the store if persistence is enabled. A failed save is logged
+// rather than failing the change, which stays in effect in memory. Callers
+// must hold the lock.
+func (s *Store) persist() {
+	if s.path == "" {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Failed to persist playlists: %v", err)
+	}
+}
+
+// save writes all playlists to the snapshot file, replacing it atomically
+func (s *Store) save() error {
+	playlists := make([]*Playlist, 0, len(s.playlists))
+	for _, p := range s.playlists {
+		playlists = append(playlists, p)
+	}
+	data, err := json.Marshal(playlists)
+	if err != nil {
+		return fmt.Errorf("failed to marshal playlists: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".playlists-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create playlist snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write playlist snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write playlist snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// load restores the playlists saved to the snapshot file, if there is one
+func (s *Store) load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		log.Printf("No playlist snapshot at %s, starting empty", s.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var playlists []*Playlist
+	if err := json.Unmarshal(data, &playlists); err != nil {
+		return fmt.Errorf("failed to decode playlist snapshot: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range playlists {
+		s.playlists[p.ID] = p
+	}
+	log.Printf("Loaded %d playlists", len(playlists))
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate id: %v", err))
+	}
+	return "pls_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+package playlist
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+// musicBrainzRecording prefixes MusicBrainz recording identifiers in XSPF
+const musicBrainzRecording = "https://musicbrainz.org/recording/"
+
+type xspfPlaylist struct {
+	XMLName xml.Name `xml:"playlist"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Version string   `xml:"version,attr"`
+	Title   string   `xml:"title,omitempty"`
+	List    xspfList `xml:"trackList"`
+}
+
+// xspfList is a separate element so that empty playlists still have the
+// required trackList
+type xspfList struct {
+	Tracks []xspfTrack `xml:"track"`
+}
+
+type xspfTrack struct {
+	Locations   []string `xml:"location,omitempty"`
+	Identifiers []string `xml:"identifier,omitempty"`
+	Title       string   `xml:"title,omitempty"`
+	Creator     string   `xml:"creator,omitempty"`
+	Album       string   `xml:"album,omitempty"`
+	Duration    int64    `xml:"duration,omitempty"` // milliseconds
+}
+
+// parseXSPF reads an XSPF playlist. ISRCs and MusicBrainz recording IDs are
+// taken from the track identifiers, which makes matching exact.
+func parseXSPF(data []byte) ([]*Playlist, error) {
+	var doc xspfPlaylist
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	p := &Playlist{Name: strings.TrimSpace(doc.Title), Entries: make([]Entry, 0, len(doc.List.Tracks))}
+	for _, t := range doc.List.Tracks {
+		e := Entry{
+			Title:      strings.TrimSpace(t.Title),
+			Artist:     strings.TrimSpace(t.Creator),
+			Album:      strings.TrimSpace(t.Album),
+			DurationMs: t.Duration,
+		}
+		if len(t.Locations) > 0 {
+			e.Location = strings.TrimSpace(t.Locations[0])
+		}
+		for _, id := range t.Identifiers {
+			id = strings.TrimSpace(id)
+			switch {
+			case strings.HasPrefix(strings.ToLower(id), "isrc:"):
+				e.ISRC = id[len("isrc:"):]
+			case strings.HasPrefix(id, musicBrainzRecording):
+				e.MBID = strings.TrimPrefix(id, musicBrainzRecording)
+			}
+		}
+		if e.Title == "" && e.Location != "" {
+			e.setDisplayName(locationName(e.Location))
+		}
+		p.Entries = append(p.Entries, e)
+	}
+	return []*Playlist{p}, nil
+}
+
+// writeXSPF writes p as an XSPF version 1 playlist
+func writeXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{Xmlns: xspfNamespace, Version: "1", Title: p.Name}
+	for _, e := range p.Entries {
+		t := xspfTrack{Title: e.Title, Creator: e.Artist, Album: e.Album, Duration: e.DurationMs}
+		if e.Location != "" {
+			t.Locations = []string{e.Location}
+		}
+		if e.ISRC != "" {
+			t.Identifiers = append(t.Identifiers, "isrc:"+e.ISRC)
+		}
+		if e.MBID != "" {
+			t.Identifiers = append(t.Identifiers, musicBrainzRecording+e.MBID)
+		}
+		doc.List.Tracks = append(doc.List.Tracks, t)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,33 @@
+package profile
+
+// builtins are the profiles offered until admins change them
+var builtins = []*Profile{
+	{
+		ID:           "theory-tutor",
+		Name:         "Theory tutor",
+		Description:  "Explains harmony, form and notation step by step, from the documents only",
+		Persona:      "a patient music theory tutor",
+		Instructions: "Explain concepts step by step with short examples in chord symbols or notation, and end with a question that checks understanding.",
+		Collections:  []string{"azure"},
+		Tools:        []string{},
+		Style:        "detailed",
+	},
+	{
+		ID:           "catalog-analyst",
+		Name:         "Catalog analyst",
+		Description:  "Answers credit, release and rights questions from the catalog",
+		Persona:      "an analyst of the music catalog",
+		Instructions: "Answer with catalog facts such as names, dates, credits and identifiers. Say when the catalog has no data rather than guessing.",
+		Collections:  []string{"catalog"},
+		Style:        "bulleted",
+		Groups:       []string{"Administrators", "Premium"},
+	},
+	{
+		ID:           "dj-assistant",
+		Name:         "DJ assistant",
+		Description:  "Digs for tracks that mix well, with their tempo and key",
+		Persona:      "a DJ's crate-digging assistant",
+		Instructions: "Suggest tracks with their tempo and key where known, and favour harmonic mixing.",
+		Style:        "concise",
+	},
+}
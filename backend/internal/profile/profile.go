@@ -0,0 +1,307 @@
+// Package profile keeps the assistant profiles users pick per conversation.
+// A profile bundles who the assistant answers as, the collections and
+// catalog entities it searches, the tools it may call, the chat deployment
+// and the style of its answers. Admins decide which groups may use each.
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("profile not found")
+	ErrInvalid  = errors.New("invalid profile")
+	// ErrForbidden is returned for profiles none of the user's groups may use
+	ErrForbidden = errors.New("profile not allowed")
+)
+
+// Collections are the sources a profile can search: the document index and
+// the catalog
+var Collections = []string{"azure", "catalog"}
+
+// Styles are the answer styles a profile can ask for, with the instruction
+// added to the system prompt for each
+var Styles = map[string]string{
+	"concise":  "Keep answers short, a few sentences at most.",
+	"detailed": "Answer thoroughly, explaining your reasoning with examples.",
+	"bulleted": "Answer as a bulleted list.",
+}
+
+// validID is a profile ID, e.g. theory-tutor
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Profile is an assistant configuration users can pick for a conversation
+type Profile struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Persona describes who the assistant answers as, e.g. "a music theory
+	// tutor"
+	Persona string `json:"persona,omitempty"`
+	// Instructions are added to the answer system prompt
+	Instructions string `json:"instructions,omitempty"`
+	// Collections limits retrieval to these of Collections; empty for all
+	Collections []string `json:"collections,omitempty"`
+	Filters     Filters  `json:"filters"`
+	// Tools are the names of the tools the assistant may call; nil for all
+	// and empty for none
+	Tools []string `json:"tools"`
+	// Model is the chat deployment to answer with; empty for the default
+	Model string `json:"model,omitempty"`
+	// Style is one of Styles; empty for the model's own
+	Style string `json:"style,omitempty"`
+	// Groups may use the profile; empty for everyone
+	Groups    []string  `json:"groups,omitempty"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Filters narrow what a profile retrieves
+type Filters struct {
+	// EntityIDs keeps the passages about these catalog entities or their
+	// direct neighbors, e.g. a label and its roster
+	EntityIDs []string `json:"entityIds,omitempty"`
+}
+
+// StyleInstruction returns the instruction for the profile's answer style,
+// or "" for none
+func (p *Profile) StyleInstruction() string {
+	return Styles[p.Style]
+}
+
+// Allows reports whether a user in groups may use the profile
+func (p *Profile) Allows(groups []string) bool {
+	if len(p.Groups) == 0 {
+		return true
+	}
+	for _, g := range groups {
+		if slices.Contains(p.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the fields a profile can be saved with
+func (p *Profile) validate() error {
+	if !validID.MatchString(p.ID) {
+		return fmt.Errorf("%w: the ID must be lower-case letters, digits and dashes", ErrInvalid)
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("%w: the name is empty", ErrInvalid)
+	}
+	for _, c := range p.Collections {
+		if !slices.Contains(Collections, c) {
+			return fmt.Errorf("%w: unknown collection %q, expected one of %v", ErrInvalid, c, Collections)
+		}
+	}
+	if _, ok := Styles[p.Style]; p.Style != "" && !ok {
+		return fmt.Errorf("%w: unknown answer style %q", ErrInvalid, p.Style)
+	}
+	return nil
+}
+
+func (p *Profile) clone() *Profile {
+	c := *p
+	c.Collections = slices.Clone(p.Collections)
+	c.Filters.EntityIDs = slices.Clone(p.Filters.EntityIDs)
+	c.Tools = slices.Clone(p.Tools)
+	c.Groups = slices.Clone(p.Groups)
+	return &c
+}
+
+// Registry holds the profiles of this process
+type Registry struct {
+	mu       sync.RWMutex
+	profiles map[string]*Profile
+	// path is where the registry is persisted, empty when persistence is
+	// disabled
+	path string
+}
+
+// RegistryInstance holds the profiles offered to users
+var RegistryInstance = NewRegistry("")
+
+// Init restores the profiles from PROFILE_REGISTRY_PATH if set, so that
+// admins' changes survive restarts
+func Init() {
+	RegistryInstance = NewRegistry(os.Getenv("PROFILE_REGISTRY_PATH"))
+	if RegistryInstance.path == "" {
+		log.Println("PROFILE_REGISTRY_PATH not set, profile changes will not be persisted")
+		return
+	}
+	if err := RegistryInstance.load(); err != nil {
+		log.Fatalf("Failed to load profiles: %v", err)
+	}
+}
+
+// NewRegistry creates a registry with the built-in profiles, persisted to
+// path or kept in memory only if path is empty
+func NewRegistry(path string) *Registry {
+	r := &Registry{profiles: make(map[string]*Profile), path: path}
+	for _, p := range builtins {
+		r.profiles[p.ID] = p.clone()
+	}
+	return r
+}
+
+// List returns every profile, by name
+func (r *Registry) List() []*Profile {
+	return r.list(func(*Profile) bool { return true })
+}
+
+// Available returns the profiles a user in groups may use, by name
+func (r *Registry) Available(groups []string) []*Profile {
+	return r.list(func(p *Profile) bool { return p.Allows(groups) })
+}
+
+func (r *Registry) list(keep func(*Profile) bool) []*Profile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	profiles := []*Profile{}
+	for _, p := range r.profiles {
+		if keep(p) {
+			profiles = append(profiles, p.clone())
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Name != profiles[j].Name {
+			return profiles[i].Name < profiles[j].Name
+		}
+		return profiles[i].ID < profiles[j].ID
+	})
+	return profiles
+}
+
+// Get returns a profile
+func (r *Registry) Get(id string) (*Profile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.profiles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p.clone(), nil
+}
+
+// Put creates a profile or replaces the one with its ID. It reports whether
+// the profile was created.
+func (r *Registry) Put(p *Profile, by string) (*Profile, bool, error) {
+	if err := p.validate(); err != nil {
+		return nil, false, err
+	}
+	p = p.clone()
+	p.UpdatedBy, p.UpdatedAt = by, time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, exists := r.profiles[p.ID]
+	r.profiles[p.ID] = p
+	r.persist()
+	return p.clone(), !exists, nil
+}
+
+// SetGroups changes the groups that may use a profile; none opens it to
+// everyone
+func (r *Registry) SetGroups(id string, groups []string, by string) (*Profile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.profiles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	p.Groups = slices.Compact(slices.Sorted(slices.Values(groups)))
+	p.UpdatedBy, p.UpdatedAt = by, time.Now().UTC()
+	r.persist()
+	return p.clone(), nil
+}
+
+// Delete removes a profile. Conversations using it fall back to the plain
+// assistant.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.profiles[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.profiles, id)
+	r.persist()
+	return nil
+}
+
+// persist saves the registry if persistence is enabled. A failed save is
+// logged rather than failing the change, which stays in effect in memory.
+// Callers must hold the lock.
+func (r *Registry) persist() {
+	if r.path == "" {
+		return
+	}
+	if err := r.save(); err != nil {
+		log.Printf("Failed to persist profiles: %v", err)
+	}
+}
+
+// save writes all profiles to the snapshot file, replacing it atomically
+func (r *Registry) save() error {
+	profiles := make([]*Profile, 0, len(r.profiles))
+	for _, p := range r.profiles {
+		profiles = append(profiles, p)
+	}
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal profiles: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".profiles-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create profile snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write profile snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write profile snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// load replaces the built-in profiles with those saved to the snapshot file,
+// if there is one, so that deleted built-ins stay deleted
+func (r *Registry) load() error {
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		log.Printf("No profile snapshot at %s, using the built-in profiles", r.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var profiles []*Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("failed to decode profile snapshot: %w", err)
+	}
+	loaded := make(map[string]*Profile, len(profiles))
+	for _, p := range profiles {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("profile %s: %w", p.ID, err)
+		}
+		loaded[p.ID] = p
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.profiles = loaded
+	log.Printf("Loaded %d profiles", len(profiles))
+	return nil
+}
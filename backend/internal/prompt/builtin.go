@@ -0,0 +1,39 @@
+package prompt
+
+// Prompts used by the chat pipeline
+const (
+	// AnswerSystem is the system message of an answer
+	AnswerSystem = "answer.system"
+	// AnswerUser is the user message of an answer, the question with its
+	// sources
+	AnswerUser = "answer.user"
+)
+
+// builtins are the first versions of the prompts, which the registry starts
+// from
+var builtins = []struct {
+	name        string
+	description string
+	template    string
+}{
+	{
+		name:        AnswerSystem,
+		description: "The system message of every answer; the citation instructions apply when sources were retrieved",
+		template: `You are a helpful assistant{{if .Persona}}, {{.Persona}}{{end}}.{{if .Locale}} Answer in the language of the locale {{.Locale}}.{{end}}{{if .Style}} {{.Style}}{{end}}{{if .Instructions}}
+
+{{.Instructions}}{{end}}{{if .Context}}
+
+Answer from the numbered sources provided with the question.
+After each statement, cite the sources that support it by number in square brackets, e.g. [1] or [2, 3].
+Only cite numbers that appear in the list of sources. If the sources do not contain the answer, say so.{{end}}`,
+	},
+	{
+		name:        AnswerUser,
+		description: "The question of every answer, after its numbered sources if any",
+		template: `{{if .Context}}Sources:
+
+{{.Context}}
+
+Question: {{end}}{{.Query}}`,
+	},
+}
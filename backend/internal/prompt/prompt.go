@@ -0,0 +1,401 @@
+// Package prompt keeps the prompts the chat pipeline sends to the model as
+// named, versioned templates. Admins add versions and roll them out or back
+// while the server runs, and every answer records the versions that
+// produced it.
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("prompt not found")
+	ErrInvalid  = errors.New("invalid prompt")
+)
+
+// Vars are the variables a template can use, e.g. {{.Persona}} or
+// {{if .Context}}…{{end}}
+type Vars struct {
+	// Tier is the tier of the user asking, e.g. standard or premium
+	Tier string
+	// Persona describes who the assistant should answer as; empty for none
+	Persona string
+	// Locale is the user's locale, e.g. de-DE; empty when unknown
+	Locale string
+	// Style is an instruction on the form of the answer and Instructions
+	// the system prompt of the assistant profile in use; empty for none
+	Style        string
+	Instructions string
+	// Context is the numbered sources retrieved for the question; empty when
+	// there are none
+	Context string
+	Query   string
+}
+
+// sampleVars fill every variable, to check that new templates render
+var sampleVars = Vars{Tier: "standard", Persona: "a music teacher", Locale: "en-US", Style: "Answer briefly.",
+	Instructions: "Use examples.", Context: "[1] Source\nText", Query: "Question?"}
+
+// Version is one version of a named prompt
+type Version struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Template    string    `json:"template"`
+	Description string    `json:"description,omitempty"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	tmpl *template.Template
+}
+
+// Rollout records a change of a prompt's active version
+type Rollout struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Rollback marks the undoing of an earlier rollout
+	Rollback bool      `json:"rollback,omitempty"`
+	By       string    `json:"by,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Prompt is a named prompt with all its versions, oldest first, and the
+// history of its rollouts
+type Prompt struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Active      int        `json:"active"` // the version in use
+	Versions    []*Version `json:"versions"`
+	Rollouts    []Rollout  `json:"rollouts"`
+}
+
+// Ref identifies the version of a prompt that produced an answer
+type Ref struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// String renders a reference as name@vN, e.g. answer.system@v3
+func (r Ref) String() string {
+	return fmt.Sprintf("%s@v%d", r.Name, r.Version)
+}
+
+// Registry holds the prompts of this process. Only the prompts the pipeline
+// uses, which have built-in first versions, can be given new versions.
+type Registry struct {
+	mu      sync.RWMutex
+	prompts map[string]*Prompt
+	// path is where the registry is persisted, empty when persistence is
+	// disabled
+	path string
+}
+
+// RegistryInstance holds the prompts used by the chat pipeline
+var RegistryInstance = NewRegistry("")
+
+// Init restores the prompt registry from PROMPT_REGISTRY_PATH if set, so
+// that rollouts survive restarts
+func Init() {
+	RegistryInstance = NewRegistry(os.Getenv("PROMPT_REGISTRY_PATH"))
+	if RegistryInstance.path == "" {
+		log.Println("PROMPT_REGISTRY_PATH not set, prompt versions will not be persisted")
+		return
+	}
+	if err := RegistryInstance.load(); err != nil {
+		log.Fatalf("Failed to load prompts: %v", err)
+	}
+}
+
+// NewRegistry creates a registry with the built-in prompts, persisted to path
+// or kept in memory only if path is empty
+func NewRegistry(path string) *Registry {
+	r := &Registry{prompts: make(map[string]*Prompt), path: path}
+	for _, d := range builtins {
+		v := &Version{Name: d.name, Version: 1, Template: d.template, Description: "Built in"}
+		if err := v.compile(); err != nil {
+			panic(fmt.Sprintf("built-in prompt %s: %v", d.name, err))
+		}
+		r.prompts[d.name] = &Prompt{Name: d.name, Description: d.description, Active: 1, Versions: []*Version{v}, Rollouts: []Rollout{}}
+	}
+	return r
+}
+
+// List returns every prompt, by name
+func (r *Registry) List() []*Prompt {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	prompts := make([]*Prompt, 0, len(r.prompts))
+	for _, p := range r.prompts {
+		prompts = append(prompts, p.clone())
+	}
+	sort.Slice(prompts, func(i, j int) bool { return prompts[i].Name < prompts[j].Name })
+	return prompts
+}
+
+// Get returns a prompt with its versions
+func (r *Registry) Get(name string) (*Prompt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.prompts[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p.clone(), nil
+}
+
+// Add stores a new version of a prompt, which is not used until it is rolled
+// out. The template must render with every variable set.
+func (r *Registry) Add(name, text, description, by string) (*Version, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("%w: the template is empty", ErrInvalid)
+	}
+	v := &Version{Name: name, Template: text, Description: description, CreatedBy: by, CreatedAt: time.Now().UTC()}
+	if err := v.compile(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.prompts[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	v.Version = p.Versions[len(p.Versions)-1].Version + 1
+	p.Versions = append(p.Versions, v)
+	r.persist()
+	copied := *v
+	return &copied, nil
+}
+
+// Rollout makes a version of a prompt the one in use, from the next request
+// on. Rolling out an earlier version rolls the prompt back.
+func (r *Registry) Rollout(name string, version int, by string) (*Prompt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.prompts[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if p.version(version) == nil {
+		return nil, fmt.Errorf("%w: %s has no version %d", ErrNotFound, name, version)
+	}
+	if version != p.Active {
+		r.activate(p, version, false, by)
+	}
+	return p.clone(), nil
+}
+
+// Rollback undoes the last rollout of a prompt that has not been undone yet,
+// restoring the version that was in use before it. Repeated rollbacks go
+// further back through the rollouts.
+func (r *Registry) Rollback(name, by string) (*Prompt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.prompts[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Each rollback undid the latest rollout not undone before it
+	undone := 0
+	for i := len(p.Rollouts) - 1; i >= 0; i-- {
+		switch rollout := p.Rollouts[i]; {
+		case rollout.Rollback:
+			undone++
+		case undone > 0:
+			undone--
+		default:
+			r.activate(p, rollout.From, true, by)
+			return p.clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s has no rollout left to roll back", ErrInvalid, name)
+}
+
+// activate makes a version the one in use and records the change. Callers
+// must hold the lock.
+func (r *Registry) activate(p *Prompt, version int, rollback bool, by string) {
+	p.Rollouts = append(p.Rollouts, Rollout{From: p.Active, To: version, Rollback: rollback, By: by, At: time.Now().UTC()})
+	p.Active = version
+	r.persist()
+}
+
+// Set is the versions of some prompts in use when a request started, so that
+// a rollout during the request does not mix versions
+type Set map[string]*Version
+
+// Active returns the versions of the named prompts now in use
+func (r *Registry) Active(names ...string) (Set, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := make(Set, len(names))
+	for _, name := range names {
+		p, ok := r.prompts[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		set[name] = p.version(p.Active)
+	}
+	return set, nil
+}
+
+// Version returns one version of a prompt, 0 being the active one, as a set
+// of its own for previews
+func (r *Registry) Version(name string, version int) (Set, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.prompts[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if version == 0 {
+		version = p.Active
+	}
+	v := p.version(version)
+	if v == nil {
+		return nil, fmt.Errorf("%w: %s has no version %d", ErrNotFound, name, version)
+	}
+	return Set{name: v}, nil
+}
+
+// Render fills in the template of a prompt of the set
+func (s Set) Render(name string, vars Vars) (string, error) {
+	v, ok := s[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	var b strings.Builder
+	if err := v.tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %w", Ref{v.Name, v.Version}, err)
+	}
+	return b.String(), nil
+}
+
+// Refs returns the versions of the set, by name
+func (s Set) Refs() []Ref {
+	refs := make([]Ref, 0, len(s))
+	for _, v := range s {
+		refs = append(refs, Ref{Name: v.Name, Version: v.Version})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs
+}
+
+// compile parses the template and checks that it renders
+func (v *Version) compile() error {
+	tmpl, err := template.New(v.Name).Option("missingkey=error").Parse(v.Template)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := tmpl.Execute(&strings.Builder{}, sampleVars); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	v.tmpl = tmpl
+	return nil
+}
+
+func (p *Prompt) version(n int) *Version {
+	for _, v := range p.Versions {
+		if v.Version == n {
+			return v
+		}
+	}
+	return nil
+}
+
+func (p *Prompt) clone() *Prompt {
+	c := *p
+	c.Versions = make([]*Version, len(p.Versions))
+	for i, v := range p.Versions {
+		copied := *v
+		c.Versions[i] = &copied
+	}
+	c.Rollouts = append([]Rollout{}, p.Rollouts...)
+	return &c
+}
+
+// persist saves the registry if persistence is enabled. A failed save is
+// logged rather than failing the change, which stays in effect in memory.
+// Callers must hold the lock.
+func (r *Registry) persist() {
+	if r.path == "" {
+		return
+	}
+	if err := r.save(); err != nil {
+		log.Printf("Failed to persist prompts: %v", err)
+	}
+}
+
+// save writes all prompts to the snapshot file, replacing it atomically
+func (r *Registry) save() error {
+	prompts := make([]*Prompt, 0, len(r.prompts))
+	for _, p := range r.prompts {
+		prompts = append(prompts, p)
+	}
+	data, err := json.MarshalIndent(prompts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal prompts: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".prompts-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create prompt snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write prompt snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write prompt snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// load restores the versions and rollouts saved to the snapshot file, if
+// there is one. Prompts the pipeline no longer uses are dropped.
+func (r *Registry) load() error {
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		log.Printf("No prompt snapshot at %s, using the built-in prompts", r.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var prompts []*Prompt
+	if err := json.Unmarshal(data, &prompts); err != nil {
+		return fmt.Errorf("failed to decode prompt snapshot: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range prompts {
+		builtin, ok := r.prompts[p.Name]
+		if !ok {
+			log.Printf("Dropping unknown prompt %s from the snapshot", p.Name)
+			continue
+		}
+		for _, v := range p.Versions {
+			if err := v.compile(); err != nil {
+				return fmt.Errorf("prompt %s version %d: %w", p.Name, v.Version, err)
+			}
+		}
+		if p.version(p.Active) == nil {
+			return fmt.Errorf("prompt %s: active version %d does not exist", p.Name, p.Active)
+		}
+		p.Description = builtin.Description
+		if p.Rollouts == nil {
+			p.Rollouts = []Rollout{}
+		}
+		r.prompts[p.Name] = p
+	}
+	log.Printf("Loaded %d prompts", len(prompts))
+	return nil
+}
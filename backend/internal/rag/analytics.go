@@ -0,0 +1,70 @@
+package rag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// entityTypes lists the catalog entity types in the order they are reported
+var entityTypes = []catalog.EntityType{
+	catalog.TypeArtist, catalog.TypeWork, catalog.TypeRecording, catalog.TypeRelease, catalog.TypeLabel,
+}
+
+// catalogStatistics renders aggregate figures about the catalog and the
+// linked entities as a context document for analytics questions. The model
+// is far better at reading precomputed counts than at counting passages.
+func catalogStatistics(store catalog.Store, entityIDs []string) string {
+	var b strings.Builder
+	b.WriteString("Catalog statistics:")
+	counts := store.CountEntities()
+	for _, typ := range entityTypes {
+		fmt.Fprintf(&b, "\n- %d %s entities", counts[typ], typ)
+	}
+
+	for _, id := range entityIDs {
+		entity, err := store.GetEntity(id)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n\nStatistics for %s (%s):", entity.Name, entity.Type)
+		relations := make(map[string]int)
+		years := make(map[string]int)
+		for _, r := range store.ListRelations(id) {
+			other, direction := r.TargetID, "outgoing"
+			if r.TargetID == id {
+				other, direction = r.SourceID, "incoming"
+			}
+			neighbor, err := store.GetEntity(other)
+			if err != nil {
+				continue
+			}
+			relations[fmt.Sprintf("%s entities via %s (%s)", neighbor.Type, r.Type, direction)]++
+			// Releases credited to an artist, counted per year
+			if r.Type == catalog.RelPerformedBy && neighbor.Release != nil && len(neighbor.Release.Date) >= 4 {
+				years[neighbor.Release.Date[:4]]++
+			}
+		}
+		for _, key := range sortedKeys(relations) {
+			fmt.Fprintf(&b, "\n- %s: %d", key, relations[key])
+		}
+		if len(years) > 0 {
+			b.WriteString("\n- releases per year:")
+			for _, year := range sortedKeys(years) {
+				fmt.Fprintf(&b, " %s: %d;", year, years[year])
+			}
+		}
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,376 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+)
+
+const (
+	// defaultCacheThreshold is the cosine similarity from which a question
+	// reuses the answer of a cached one
+	defaultCacheThreshold = 0.95
+	defaultCacheTTL       = 24 * time.Hour
+	defaultCacheEntries   = 1000
+)
+
+// Keys of what a cached answer depends on, invalidated when it changes
+const (
+	// depAllCollections is the dependency of answers that searched every
+	// collection
+	depAllCollections = "collection:*"
+	// depCatalogWide is the dependency of answers grounded on statistics or
+	// listings of the whole catalog, or on tool results, which say nothing of
+	// the entities they were computed from
+	depCatalogWide = "catalog:*"
+)
+
+// EmbedFunc returns the embedding of each text
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float64, error)
+
+// Cache reuses the answers of questions that mean the same, such as "what's
+// the key of Autumn Leaves?" and "which key is Autumn Leaves in?". Answers
+// are only reused within the same scope: the collections and entities
+// searched, the prompt versions and every other setting that shapes an
+// answer. Follow-ups, which depend on their conversation, and questions
+// about the user's own playlists or listening are never cached.
+type Cache struct {
+	Embed EmbedFunc
+	// Model is the embedding model; it is part of every scope, so that
+	// vectors of different models are never compared
+	Model     string
+	Threshold float64
+	TTL       time.Duration
+	// MaxEntries bounds the cache, evicting the oldest entries first
+	MaxEntries int
+
+	mu sync.Mutex
+	// entries are kept oldest first
+	entries []*cacheEntry
+	// deps indexes the entries by what they depend on, e.g. the documents
+	// their passages came from
+	deps                      map[string]map[*cacheEntry]bool
+	hits, misses, invalidated int
+}
+
+type cacheEntry struct {
+	scope   string
+	vector  []float64
+	result  *Result
+	deps    []string
+	created time.Time
+}
+
+// CacheHit marks an answer reused from the cache. The cached question is
+// left out, since it may have been asked by another user.
+type CacheHit struct {
+	Similarity float64   `json:"similarity"`
+	CachedAt   time.Time `json:"cachedAt"`
+}
+
+// CacheStats describes the contents and use of the cache
+type CacheStats struct {
+	Enabled     bool    `json:"enabled"`
+	Entries     int     `json:"entries"`
+	Threshold   float64 `json:"threshold,omitempty"`
+	TTLSeconds  int     `json:"ttlSeconds,omitempty"`
+	Hits        int     `json:"hits"`
+	Misses      int     `json:"misses"`
+	Invalidated int     `json:"invalidated"`
+}
+
+// NewCacheFromEnv configures a cache from RAG_CACHE (true to enable),
+// RAG_CACHE_THRESHOLD, RAG_CACHE_TTL (e.g. 12h) and RAG_CACHE_MAX_ENTRIES.
+// It returns nil when the cache is disabled or there is no embedding model.
+func NewCacheFromEnv(embed EmbedFunc, model string) *Cache {
+	if os.Getenv("RAG_CACHE") != "true" {
+		return nil
+	}
+	if model == "" {
+		log.Println("AZURE_OPENAI_DEPLOYMENT_EMBEDDING not set, the answer cache is disabled")
+		return nil
+	}
+	c := &Cache{Embed: embed, Model: model, Threshold: defaultCacheThreshold, TTL: defaultCacheTTL, MaxEntries: defaultCacheEntries}
+	if t, err := strconv.ParseFloat(os.Getenv("RAG_CACHE_THRESHOLD"), 64); err == nil {
+		c.Threshold = min(max(t, 0), 1)
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("RAG_CACHE_TTL")); err == nil && ttl > 0 {
+		c.TTL = ttl
+	}
+	if n, err := strconv.Atoi(os.Getenv("RAG_CACHE_MAX_ENTRIES")); err == nil && n > 0 {
+		c.MaxEntries = n
+	}
+	return c
+}
+
+// cacheable reports whether the answer to a request may be shared with
+// other requests
+func cacheable(req Request) bool {
+	return len(req.History) == 0 && !personalWord.MatchString(req.Query) &&
+		len(referencedPlaylists(req.Query, req.Playlists)) == 0
+}
+
+// answerCached answers a request from the cache, or else runs the pipeline
+// and caches the answer. A failure to embed the question skips the cache.
+func (p *Pipeline) answerCached(ctx context.Context, req Request) (*Result, error) {
+	prompts, err := p.newAnswerPrompt(req)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := p.Cache.Embed(ctx, []string{req.Query})
+	if err != nil {
+		log.Printf("Embedding the question for the answer cache failed, answering without it: %v", err)
+		return p.answer(ctx, req)
+	}
+	if cached := p.Cache.lookup(p.Cache.scope(req, prompts.set.Refs()), vectors[0]); cached != nil {
+		cached.Profile, cached.Experiment, cached.Variant = req.Profile, req.Experiment, req.Variant
+		// What was derived from the wording of the cached question is
+		// derived again from this one, which was not searched for
+		cached.Debug = &Debug{Query: req.Query, StandaloneQuery: req.Query, RetrievedBy: map[string][]string{}}
+		cached.Mentions = nil
+		if p.Linker != nil {
+			cached.Mentions = p.Linker.Link(req.Query)
+		}
+		return cached, nil
+	}
+	result, err := p.answer(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// The prompt versions may have been rolled out meanwhile, so the answer
+	// is cached under those it was actually asked with
+	p.Cache.store(p.Cache.scope(req, result.Prompts), vectors[0], req.Collections, result)
+	return result, nil
+}
+
+// scope keys the settings of a request that shape its answer
+func (c *Cache) scope(req Request, prompts []prompt.Ref) string {
+	key, err := json.Marshal(struct {
+		Embedding    string
+		Collections  []string
+		Scope        []string
+		Prompts      []prompt.Ref
+		Model        string
+		Retriever    string
+		Top          int
+		Expand       bool
+		Schema       json.RawMessage
+		OutputType   string
+		Tier         string
+		Persona      string
+		Locale       string
+		Style        string
+		Instructions string
+		Tools        []string
+	}{
+		c.Model, slices.Sorted(slices.Values(req.Collections)), slices.Sorted(slices.Values(req.Scope)), prompts,
+		req.Model, req.Retriever, req.Top, req.Expand, req.Schema, req.OutputType,
+		req.Tier, req.Persona, req.Locale, req.Style, req.Instructions, req.Tools,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to marshal cache scope: %v", err))
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns a copy of the cached answer whose question is most similar
+// to vector within scope, marked as a cache hit, or nil if none is similar
+// enough
+func (c *Cache) lookup(scope string, vector []float64) *Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	var best *cacheEntry
+	bestSimilarity := c.Threshold
+	for _, e := range c.entries {
+		if e.scope != scope {
+			continue
+		}
+		if s := cosine(e.vector, vector); s >= bestSimilarity {
+			best, bestSimilarity = e, s
+		}
+	}
+	if best == nil {
+		c.misses++
+		return nil
+	}
+	c.hits++
+	result := *best.result
+	result.Cache = &CacheHit{Similarity: math.Round(bestSimilarity*1e4) / 1e4, CachedAt: best.created}
+	return &result
+}
+
+// store caches an answer with what it depends on: the documents and
+// entities of its passages and graph facts, the collections it searched and,
+// for answers from catalog statistics, listings or tools, the whole catalog
+func (c *Cache) store(scope string, vector []float64, collections []string, result *Result) {
+	e := &cacheEntry{scope: scope, vector: vector, result: result, created: time.Now().UTC()}
+	deps := map[string]bool{depAllCollections: len(collections) == 0}
+	for _, name := range collections {
+		deps["collection:"+name] = true
+	}
+	for _, chunk := range result.Chunks {
+		deps["document:"+chunk.Source+"/"+chunk.DocumentID] = true
+		for _, id := range chunk.EntityIDs {
+			deps["entity:"+id] = true
+		}
+	}
+	for _, f := range result.Facts {
+		deps["entity:"+f.SubjectID] = true
+		deps["entity:"+f.ObjectID] = true
+	}
+	for _, s := range result.Sources {
+		if s.ID == "catalog:statistics" || s.ID == "catalog:library" || strings.HasPrefix(s.ID, "tool:") {
+			deps[depCatalogWide] = true
+		}
+	}
+	for dep, ok := range deps {
+		if ok {
+			e.deps = append(e.deps, dep)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deps == nil {
+		c.deps = make(map[string]map[*cacheEntry]bool)
+	}
+	c.entries = append(c.entries, e)
+	for _, dep := range e.deps {
+		if c.deps[dep] == nil {
+			c.deps[dep] = make(map[*cacheEntry]bool)
+		}
+		c.deps[dep][e] = true
+	}
+	c.expire()
+	if excess := len(c.entries) - c.MaxEntries; c.MaxEntries > 0 && excess > 0 {
+		c.remove(c.entries[:excess])
+	}
+}
+
+// InvalidateDocuments drops the answers grounded on passages of documents
+// of a collection, e.g. after they were re-indexed. Without document IDs it
+// drops every answer that searched the collection. It returns the number of
+// answers dropped.
+func (c *Cache) InvalidateDocuments(collection string, documentIDs []string) int {
+	deps := []string{"collection:" + collection, depAllCollections}
+	if len(documentIDs) > 0 {
+		deps = deps[:0]
+		for _, id := range documentIDs {
+			deps = append(deps, "document:"+collection+"/"+id)
+		}
+	}
+	return c.invalidate(deps...)
+}
+
+// InvalidateEntity drops the answers grounded on a catalog entity or on the
+// catalog as a whole, after the entity changed
+func (c *Cache) InvalidateEntity(id string) int {
+	return c.invalidate("document:catalog/"+id, "entity:"+id, depCatalogWide)
+}
+
+// Clear drops every cached answer
+func (c *Cache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.remove(c.entries)
+	c.invalidated += n
+	return n
+}
+
+// Stats describes the contents and use of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	return CacheStats{
+		Enabled:     true,
+		Entries:     len(c.entries),
+		Threshold:   c.Threshold,
+		TTLSeconds:  int(c.TTL.Seconds()),
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Invalidated: c.invalidated,
+	}
+}
+
+func (c *Cache) invalidate(deps ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stale []*cacheEntry
+	for _, dep := range deps {
+		for e := range c.deps[dep] {
+			if !slices.Contains(stale, e) {
+				stale = append(stale, e)
+			}
+		}
+	}
+	c.remove(stale)
+	c.invalidated += len(stale)
+	return len(stale)
+}
+
+// expire drops the entries older than the TTL. Callers must hold the lock.
+func (c *Cache) expire() {
+	if c.TTL <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-c.TTL)
+	n := 0
+	for n < len(c.entries) && c.entries[n].created.Before(cutoff) {
+		n++
+	}
+	if n > 0 {
+		c.remove(c.entries[:n])
+	}
+}
+
+// remove drops entries from the cache. Callers must hold the lock.
+func (c *Cache) remove(entries []*cacheEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	stale := make(map[*cacheEntry]bool, len(entries))
+	for _, e := range entries {
+		stale[e] = true
+		for _, dep := range e.deps {
+			delete(c.deps[dep], e)
+			if len(c.deps[dep]) == 0 {
+				delete(c.deps, dep)
+			}
+		}
+	}
+	c.entries = slices.DeleteFunc(c.entries, func(e *cacheEntry) bool { return stale[e] })
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 if they differ
+// in length or either is zero
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
@@ -0,0 +1,186 @@
+package rag
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+)
+
+var (
+	// citationMarker matches inline citations such as [2] or [1, 3]
+	citationMarker = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+	// spacedMarker is a citation marker with the whitespace in front of it
+	spacedMarker = regexp.MustCompile(`\s*` + citationMarker.String())
+
+	// sentenceEnd matches the end of a sentence or line
+	sentenceEnd = regexp.MustCompile(`[.!?](?:\s+|$)|\n+`)
+
+	// quoteStopwords are ignored when matching claims to quotes
+	quoteStopwords = map[string]bool{
+		"the": true, "and": true, "of": true, "in": true, "on": true, "to": true, "it": true, "is": true, "by": true, "as": true, "at": true, "an": true, "be": true, "has": true, "had": true, "was": true, "were": true, "for": true, "with": true,
+		"that": true, "this": true, "are": true, "from": true, "his": true, "her": true, "their": true,
+	}
+)
+
+// Source is a numbered context document supplied to the model. The number
+// is what the answer cites; the ID stays the same for the same chunk across
+// requests.
+type Source struct {
+	ID         string             `json:"id"`
+	Number     int                `json:"number"`
+	DocumentID string             `json:"documentId,omitempty"`
+	Title      string             `json:"title"`
+	Locator    *retrieval.Locator `json:"locator,omitempty"`
+	Cited      bool               `json:"cited"`
+	Quotes     []string           `json:"quotes,omitempty"` // spans of the source quoted by citations
+
+	content string
+}
+
+// Citation is an inline [n] marker in the answer resolved to its source.
+// Start and End are byte offsets of the marker in the validated answer.
+type Citation struct {
+	Number   int    `json:"number"`
+	SourceID string `json:"sourceId"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Claim    string `json:"claim"` // the answer text the marker supports
+	Quote    string `json:"quote"` // the span of the source that best supports the claim
+}
+
+// chunkSource makes a source from a retrieved chunk
+func chunkSource(chunk retrieval.Chunk) Source {
+	title := chunk.Title
+	if title == "" {
+		title = chunk.DocumentID
+	}
+	return Source{
+		ID:         chunk.Source + ":" + chunk.ID,
+		DocumentID: chunk.DocumentID,
+		Title:      title,
+		Locator:    chunk.Locator,
+		content:    chunk.Content,
+	}
+}
+
+// validateCitations checks every [n] marker in answer against the supplied
+// sources. Numbers without a source are removed from their marker, and
+// markers left empty are dropped; the removed numbers are returned. Each
+// remaining number becomes a citation quoting the source span that best
+// supports the claim before the marker.
+func validateCitations(answer string, sources []Source) (string, []Citation, []int) {
+	byNumber := make(map[int]*Source, len(sources))
+	for i := range sources {
+		byNumber[sources[i].Number] = &sources[i]
+	}
+
+	var invalid []int
+	cleaned := spacedMarker.ReplaceAllStringFunc(answer, func(marker string) string {
+		space, marker := marker[:strings.Index(marker, "[")], marker[strings.Index(marker, "["):]
+		var valid []string
+		for _, n := range markerNumbers(marker) {
+			if byNumber[n] == nil {
+				if !slices.Contains(invalid, n) {
+					invalid = append(invalid, n)
+				}
+				continue
+			}
+			valid = append(valid, strconv.Itoa(n))
+		}
+		// Dropped markers take their leading space with them
+		if len(valid) == 0 {
+			return ""
+		}
+		return space + "[" + strings.Join(valid, ", ") + "]"
+	})
+
+	citations := []Citation{}
+	for _, loc := range citationMarker.FindAllStringIndex(cleaned, -1) {
+		claim := claimBefore(cleaned, loc[0])
+		for _, n := range markerNumbers(cleaned[loc[0]:loc[1]]) {
+			source := byNumber[n]
+			quote := bestQuote(source.content, claim)
+			source.Cited = true
+			if quote != "" && !slices.Contains(source.Quotes, quote) {
+				source.Quotes = append(source.Quotes, quote)
+			}
+			citations = append(citations, Citation{
+				Number:   n,
+				SourceID: source.ID,
+				Start:    loc[0],
+				End:      loc[1],
+				Claim:    claim,
+				Quote:    quote,
+			})
+		}
+	}
+	return cleaned, citations, invalid
+}
+
+func markerNumbers(marker string) []int {
+	var numbers []int
+	for _, part := range strings.Split(strings.Trim(marker, "[]"), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// claimBefore returns the sentence of text that ends at offset, skipping
+// markers directly in front of it as in "... on both albums [1][2]"
+func claimBefore(text string, offset int) string {
+	head := strings.TrimRight(text[:offset], " ")
+	for {
+		loc := citationMarker.FindAllStringIndex(head, -1)
+		if len(loc) == 0 || loc[len(loc)-1][1] != len(head) {
+			break
+		}
+		head = strings.TrimRight(head[:loc[len(loc)-1][0]], " ")
+	}
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(head, -1) {
+		if loc[1] < len(head) {
+			start = loc[1]
+		}
+	}
+	claim := citationMarker.ReplaceAllString(head[start:], "")
+	return strings.TrimSpace(claim)
+}
+
+// bestQuote returns the sentence of content sharing the most words with
+// claim, verbatim, or "" when no sentence shares any word
+func bestQuote(content, claim string) string {
+	words := make(map[string]bool)
+	for _, w := range retrieval.Tokenize(claim) {
+		if len(w) > 1 && !quoteStopwords[w] {
+			words[w] = true
+		}
+	}
+	best, bestOverlap := "", 0
+	start := 0
+	ends := append(sentenceEnd.FindAllStringIndex(content, -1), []int{len(content), len(content)})
+	for _, loc := range ends {
+		sentence := strings.TrimSpace(content[start:loc[1]])
+		start = loc[1]
+		overlap := 0
+		seen := make(map[string]bool)
+		for _, w := range retrieval.Tokenize(sentence) {
+			if words[w] && !seen[w] {
+				seen[w] = true
+				overlap++
+			}
+		}
+		if overlap > bestOverlap {
+			best, bestOverlap = sentence, overlap
+		}
+		if start >= len(content) {
+			break
+		}
+	}
+	return best
+}
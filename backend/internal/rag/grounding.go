@@ -0,0 +1,265 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+)
+
+// Actions taken on an answer whose groundedness is below the threshold
+const (
+	GroundingNone    = "none"
+	GroundingCaveat  = "caveat"
+	GroundingAbstain = "abstain"
+)
+
+// Methods used to decide whether a claim is supported
+const (
+	SupportByOverlap = "overlap"
+	SupportByModel   = "model"
+)
+
+const (
+	// defaultGroundingThreshold is the groundedness below which answers are
+	// caveated or withheld, unless RAG_GROUNDING_THRESHOLD says otherwise
+	defaultGroundingThreshold = 0.5
+	// claimSupportThreshold is the share of a claim's content words that a
+	// single source must contain for the claim to count as supported
+	claimSupportThreshold = 0.6
+	// minClaimWords is the number of content words a sentence needs to be checked
+	minClaimWords = 2
+)
+
+const caveatMessage = "Note: parts of this answer could not be verified against the retrieved sources and may come from general knowledge. Please check them before relying on them."
+
+const abstainMessage = "I couldn't find enough support in the available sources to answer this reliably."
+
+const judgePrompt = `You check whether claims are supported by numbered sources.
+For each numbered claim, decide whether the sources state it or directly imply it. Claims that are plausible but not in the sources are unsupported.
+Reply with JSON only: [{"claim": <claim number>, "supported": <true or false>}, ...]`
+
+// refusalPattern matches sentences in which the answer says the sources do
+// not cover the question; they assert nothing and are not checked
+var refusalPattern = regexp.MustCompile(`(?i)\b(?:sources?|context|documents?|provided information)\b.*\b(?:do not|does not|don't|doesn't|did not|didn't)\b.*\b(?:contain|mention|say|include|cover|provide|specify)|\bI (?:do not|don't) know\b`)
+
+// Claim is a sentence of the answer checked against the sources. Start and
+// End are byte offsets into the answer before any caveat was added.
+type Claim struct {
+	Text      string  `json:"text"`
+	Start     int     `json:"start"`
+	End       int     `json:"end"`
+	Cited     []int   `json:"cited,omitempty"` // source numbers cited by the claim
+	Support   float64 `json:"support"`         // share of content words found in the best source
+	Supported bool    `json:"supported"`
+	SourceID  string  `json:"sourceId,omitempty"` // the source that supports the claim best
+	Method    string  `json:"method"`             // overlap or model
+}
+
+// Grounding reports how well an answer is supported by its sources
+type Grounding struct {
+	Score     float64 `json:"score"` // share of claims that are supported
+	Threshold float64 `json:"threshold"`
+	Action    string  `json:"action"` // none, caveat or abstain
+	Claims    []Claim `json:"claims"`
+}
+
+// Verifier checks answers against the sources they were generated from
+type Verifier struct {
+	// Judge optionally asks the language model to confirm each claim; without
+	// it support is decided by word overlap alone
+	Judge     ChatFunc
+	Threshold float64
+	// Abstain withholds poorly grounded answers instead of caveating them
+	Abstain bool
+}
+
+// NewVerifierFromEnv configures a verifier from RAG_GROUNDING_THRESHOLD,
+// RAG_GROUNDING_ACTION (caveat or abstain) and RAG_GROUNDING_JUDGE
+func NewVerifierFromEnv(chat ChatFunc) *Verifier {
+	v := &Verifier{
+		Threshold: defaultGroundingThreshold,
+		Abstain:   os.Getenv("RAG_GROUNDING_ACTION") == GroundingAbstain,
+	}
+	if t, err := strconv.ParseFloat(os.Getenv("RAG_GROUNDING_THRESHOLD"), 64); err == nil {
+		v.Threshold = min(max(t, 0), 1)
+	}
+	if os.Getenv("RAG_GROUNDING_JUDGE") == "true" {
+		v.Judge = chat
+	}
+	return v
+}
+
+// Verify splits answer into claims and checks each against sources. The
+// groundedness score is the share of claims that are supported; an answer
+// that asserts nothing, such as "the sources do not say", scores 1. When the
+// score is below the threshold Action says whether to caveat or abstain;
+// applying it is left to the caller.
+func (v *Verifier) Verify(ctx context.Context, answer string, sources []Source) (*Grounding, error) {
+	claims := splitClaims(answer)
+	for i := range claims {
+		checkClaim(&claims[i], sources)
+	}
+	if v.Judge != nil && len(claims) > 0 && len(sources) > 0 {
+		if err := v.judge(ctx, claims, sources); err != nil {
+			return nil, err
+		}
+	}
+
+	grounding := &Grounding{Score: 1, Threshold: v.Threshold, Action: GroundingNone, Claims: claims}
+	if len(claims) > 0 {
+		supported := 0
+		for _, c := range claims {
+			if c.Supported {
+				supported++
+			}
+		}
+		grounding.Score = float64(supported) / float64(len(claims))
+	}
+	if grounding.Score < v.Threshold {
+		grounding.Action = GroundingCaveat
+		if v.Abstain {
+			grounding.Action = GroundingAbstain
+		}
+	}
+	return grounding, nil
+}
+
+// splitClaims returns the sentences of answer that assert something
+func splitClaims(answer string) []Claim {
+	var claims []Claim
+	start := 0
+	ends := append(sentenceEnd.FindAllStringIndex(answer, -1), []int{len(answer), len(answer)})
+	for _, loc := range ends {
+		if loc[1] <= start {
+			continue
+		}
+		raw := answer[start:loc[1]]
+		offset := start
+		start = loc[1]
+
+		// A marker after the full stop belongs to the sentence before it
+		if m := citationMarker.FindStringIndex(answer[start:]); m != nil && strings.TrimSpace(answer[start:start+m[0]]) == "" {
+			raw += answer[start : start+m[1]]
+			start += m[1]
+		}
+		text := strings.TrimSpace(raw)
+		if text == "" {
+			continue
+		}
+		var cited []int
+		for _, marker := range citationMarker.FindAllString(text, -1) {
+			cited = append(cited, markerNumbers(marker)...)
+		}
+		text = strings.TrimSpace(spacedMarker.ReplaceAllString(text, ""))
+		if len(contentWords(text)) < minClaimWords || refusalPattern.MatchString(text) {
+			continue
+		}
+		// Skip the leading whitespace so that Start points at the sentence
+		offset += len(raw) - len(strings.TrimLeft(raw, " \t\r\n"))
+		claims = append(claims, Claim{Text: text, Start: offset, End: offset + len(strings.TrimSpace(raw)), Cited: cited})
+	}
+	return claims
+}
+
+// checkClaim measures the claim's support as the share of its content words
+// contained in the best single source. Cited sources are preferred; other
+// sources count too, since an uncited but supported claim is still grounded.
+func checkClaim(claim *Claim, sources []Source) {
+	claim.Method = SupportByOverlap
+	words := contentWords(claim.Text)
+	for _, s := range sources {
+		terms := make(map[string]bool)
+		for _, t := range retrieval.Tokenize(s.Title + " " + s.content) {
+			terms[t] = true
+		}
+		found := 0
+		for _, w := range words {
+			if terms[w] {
+				found++
+			}
+		}
+		support := float64(found) / float64(len(words))
+		cited := false
+		for _, n := range claim.Cited {
+			cited = cited || n == s.Number
+		}
+		if support > claim.Support || (cited && support == claim.Support) {
+			claim.Support = support
+			claim.SourceID = s.ID
+		}
+	}
+	claim.Supported = claim.Support >= claimSupportThreshold
+}
+
+// judge asks the language model which claims the sources support and
+// overrides the overlap decision for every claim it rules on
+func (v *Verifier) judge(ctx context.Context, claims []Claim, sources []Source) error {
+	var b strings.Builder
+	b.WriteString("Sources:\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "\n[%d] %s\n%s\n", s.Number, s.Title, s.content)
+	}
+	b.WriteString("\nClaims:\n")
+	for i, c := range claims {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, c.Text)
+	}
+
+	reply, err := v.Judge(ctx, []azure.ChatMessage{
+		{Role: "system", Content: judgePrompt},
+		{Role: "user", Content: b.String()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify claims: %w", err)
+	}
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return fmt.Errorf("no JSON array in verifier reply %q", reply)
+	}
+	var verdicts []struct {
+		Claim     int  `json:"claim"`
+		Supported bool `json:"supported"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &verdicts); err != nil {
+		return fmt.Errorf("failed to parse verifier reply %q: %w", reply, err)
+	}
+	for _, verdict := range verdicts {
+		if verdict.Claim < 1 || verdict.Claim > len(claims) {
+			continue
+		}
+		claim := &claims[verdict.Claim-1]
+		claim.Supported = verdict.Supported
+		claim.Method = SupportByModel
+	}
+	return nil
+}
+
+// contentWords returns the distinct words of text that carry meaning,
+// keeping numbers since dates, percentages and amounts matter most
+func contentWords(text string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, w := range retrieval.Tokenize(text) {
+		if seen[w] || quoteStopwords[w] || (len(w) < 2 && !isDigits(w)) {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	return words
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
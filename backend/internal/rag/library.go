@@ -0,0 +1,151 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// maxLibraryTracks is the number of matching recordings listed as context
+const maxLibraryTracks = 50
+
+var (
+	// bpmRange matches "124-128 BPM", "between 124 and 128 bpm" and "124 to 128 BPM"
+	bpmRange = regexp.MustCompile(`(?i)\b(\d{2,3}(?:\.\d+)?)\s*(?:-|–|to|and)\s*(\d{2,3}(?:\.\d+)?)\s*bpm\b`)
+	// bpmBound matches "over 140 BPM", "under 100 bpm" and "at 128 BPM"
+	bpmBound = regexp.MustCompile(`(?i)\b(over|above|faster than|at least|under|below|slower than|at most|at)?\s*(\d{2,3}(?:\.\d+)?)\s*bpm\b`)
+	// camelotKey matches a Camelot code such as 8A
+	camelotKey = regexp.MustCompile(`\b(1[0-2]|[1-9])[ABab]\b`)
+	// noteKey matches a key name such as "A minor", "F#m" or "in the key of C".
+	// A bare note letter is too ambiguous to count without "key of".
+	noteKey = regexp.MustCompile(`\b(?:key of\s+([A-G][#b♯♭]?(?:\s*(?:major|minor|maj|min|m))?)|([A-G][#b♯♭]?\s*(?:major|minor|maj|min)|[A-G][#b♯♭]?m))(?:\s|[.,;:?!]|$)`)
+	// minRating matches "rated 4+", "rating of at least 4", "4+ stars" and "5 stars"
+	minRating = regexp.MustCompile(`(?i)\b(?:rated|rating(?: of)?)\s*(?:at least\s*)?([1-5])\b|\b([1-5])\s*\+?\s*stars?\b`)
+)
+
+// libraryFilter reads the tempo, key and rating constraints of a question
+// such as "what's in my library between 124-128 BPM in 8A that I've rated
+// 4+?". It reports false when the question has none.
+func libraryFilter(query string) (catalog.ListFilter, bool) {
+	filter := catalog.ListFilter{Type: catalog.TypeRecording}
+	if m := bpmRange.FindStringSubmatch(query); m != nil {
+		low, _ := strconv.ParseFloat(m[1], 64)
+		high, _ := strconv.ParseFloat(m[2], 64)
+		filter.MinBPM, filter.MaxBPM = min(low, high), max(low, high)
+	} else if m := bpmBound.FindStringSubmatch(query); m != nil {
+		bpm, _ := strconv.ParseFloat(m[2], 64)
+		switch strings.ToLower(m[1]) {
+		case "over", "above", "faster than", "at least":
+			filter.MinBPM = bpm
+		case "under", "below", "slower than", "at most":
+			filter.MaxBPM = bpm
+		default:
+			// Analyzed tempos are fractional, so "128 BPM" means about 128
+			filter.MinBPM, filter.MaxBPM = bpm-0.5, bpm+0.5
+		}
+	}
+
+	if m := camelotKey.FindString(query); m != "" {
+		filter.Key = strings.ToUpper(m)
+	} else if m := noteKey.FindStringSubmatch(query); m != nil {
+		name := m[1]
+		if name == "" {
+			name = m[2]
+		}
+		if _, err := catalog.ParseKey(name); err == nil {
+			filter.Key = name
+		}
+	}
+
+	if m := minRating.FindStringSubmatch(query); m != nil {
+		stars := m[1]
+		if stars == "" {
+			stars = m[2]
+		}
+		filter.MinRating, _ = strconv.Atoi(stars)
+	}
+	return filter, filter.MinBPM > 0 || filter.MaxBPM > 0 || filter.Key != "" || filter.MinRating > 0
+}
+
+// libraryTracks renders the catalog recordings that match filter, with their
+// DJ attributes and artists, as a context document
+func libraryTracks(store catalog.Store, filter catalog.ListFilter) string {
+	filter.Limit = maxLibraryTracks
+	recordings, total := store.ListEntities(filter)
+
+	var constraints []string
+	switch {
+	case filter.MinBPM > 0 && filter.MaxBPM > 0:
+		constraints = append(constraints, fmt.Sprintf("%g to %g BPM", filter.MinBPM, filter.MaxBPM))
+	case filter.MinBPM > 0:
+		constraints = append(constraints, fmt.Sprintf("at least %g BPM", filter.MinBPM))
+	case filter.MaxBPM > 0:
+		constraints = append(constraints, fmt.Sprintf("at most %g BPM", filter.MaxBPM))
+	}
+	if key, err := catalog.ParseKey(filter.Key); err == nil {
+		constraints = append(constraints, fmt.Sprintf("key %s (%s)", key.Camelot(), key.Name()))
+	}
+	if filter.MinRating > 0 {
+		constraints = append(constraints, fmt.Sprintf("rated %d stars or more", filter.MinRating))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Library recordings with %s: %d in total", strings.Join(constraints, ", "), total)
+	if total > len(recordings) {
+		fmt.Fprintf(&b, ", the first %d listed", len(recordings))
+	}
+	for _, r := range recordings {
+		b.WriteString("\n- ")
+		b.WriteString(describeRecording(store, r))
+	}
+	return b.String()
+}
+
+// describeRecording renders a recording with its artists and audio attributes,
+// e.g. "Opus by Eric Prydz (126.0 BPM, 8A Am, 5 stars)"
+func describeRecording(store catalog.Store, e *catalog.Entity, extra ...string) string {
+	text := e.Name
+	if artists := performers(store, e.ID); len(artists) > 0 {
+		text += " by " + strings.Join(artists, ", ")
+	}
+	var attrs []string
+	if r := e.Recording; r != nil {
+		if r.BPM > 0 {
+			attrs = append(attrs, fmt.Sprintf("%.1f BPM", r.BPM))
+		}
+		if key, err := catalog.ParseKey(r.Key); err == nil {
+			attrs = append(attrs, key.Camelot()+" "+key.Name())
+		}
+		if r.Energy > 0 {
+			attrs = append(attrs, fmt.Sprintf("energy %.2f", r.Energy))
+		}
+		if r.Loudness != 0 {
+			attrs = append(attrs, fmt.Sprintf("%.1f LUFS", r.Loudness))
+		}
+		if r.Rating > 0 {
+			attrs = append(attrs, fmt.Sprintf("%d stars", r.Rating))
+		}
+	}
+	attrs = append(attrs, extra...)
+	if len(attrs) > 0 {
+		text += " (" + strings.Join(attrs, ", ") + ")"
+	}
+	return text
+}
+
+// performers returns the names of the artists an entity is performed by
+func performers(store catalog.Store, id string) []string {
+	var names []string
+	for _, r := range store.ListRelations(id) {
+		if r.Type != catalog.RelPerformedBy || r.SourceID != id {
+			continue
+		}
+		if artist, err := store.GetEntity(r.TargetID); err == nil {
+			names = append(names, artist.Name)
+		}
+	}
+	return names
+}
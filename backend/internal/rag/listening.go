@@ -0,0 +1,199 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/history"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+)
+
+const (
+	// listeningTop is the number of most played tracks, artists or albums
+	// added as context
+	listeningTop = 25
+	// listeningArtists is the number of most played artists added alongside
+	// the most played tracks
+	listeningArtists = 10
+)
+
+var (
+	// personalWord and listenWord together mark questions about the user's
+	// own listening, such as "what did I play most in 2023?"
+	personalWord = regexp.MustCompile(`(?i)\b(i|i've|i'd|my|me)\b`)
+	listenWord   = regexp.MustCompile(`(?i)\b(play(ed|s|ing)?|listen(ed|s|ing)?|stream(ed|s|ing)?|scrobbl\w*|heard|on repeat|spun|top|most played|listening history)\b`)
+
+	yearPattern     = regexp.MustCompile(`\b((?:19|20)\d{2})\b`)
+	monthPattern    = regexp.MustCompile(`(?i)\b(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?\s+((?:19|20)\d{2})\b`)
+	relativePattern = regexp.MustCompile(`(?i)\b(this|last|past)\s+(?:(\d+)\s+)?(year|month|week|day)s?\b`)
+	unsavedPattern  = regexp.MustCompile(`(?i)\b(?:never|not|didn't|did not|haven't|have not|don't|do not)\s+(?:\w+\s+)?(?:saved?|liked?|added)\b|\bunsaved\b`)
+	artistWord      = regexp.MustCompile(`(?i)\b(artists?|bands?|musicians?|singers?)\b`)
+	albumWord       = regexp.MustCompile(`(?i)\b(albums?|records|LPs?)\b`)
+
+	months = map[string]time.Month{
+		"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+		"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+		"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+	}
+)
+
+// listeningQuery reads a question about the user's own listening into a
+// history query: the period, as in "in 2023", "in March 2023" or "last 30
+// days", the grouping, an artist the question names, and whether only
+// unsaved tracks count. It reports false for other questions.
+func listeningQuery(query string, now time.Time, mentions []linking.Mention) (history.Query, string, bool) {
+	if !personalWord.MatchString(query) || !listenWord.MatchString(query) {
+		return history.Query{}, "", false
+	}
+	q := history.Query{By: history.ByTrack, Limit: listeningTop, Unsaved: unsavedPattern.MatchString(query)}
+	switch {
+	case artistWord.MatchString(query):
+		q.By = history.ByArtist
+	case albumWord.MatchString(query):
+		q.By = history.ByAlbum
+	}
+	for _, m := range mentions {
+		if m.Entity != nil && m.Entity.Type == catalog.TypeArtist && q.By != history.ByArtist {
+			q.Artist = m.Entity.Name
+			break
+		}
+	}
+
+	now = now.UTC()
+	period := "over the whole history"
+	if m := monthPattern.FindStringSubmatch(query); m != nil {
+		year, _ := strconv.Atoi(m[2])
+		q.From = time.Date(year, months[strings.ToLower(m[1])], 1, 0, 0, 0, 0, time.UTC)
+		q.To = q.From.AddDate(0, 1, 0)
+		period = "in " + q.From.Format("January 2006")
+	} else if m := yearPattern.FindStringSubmatch(query); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		q.From = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		q.To = q.From.AddDate(1, 0, 0)
+		period = "in " + m[1]
+	} else if m := relativePattern.FindStringSubmatch(query); m != nil {
+		n := 1
+		if m[2] != "" {
+			n, _ = strconv.Atoi(m[2])
+		}
+		unit := strings.ToLower(m[3])
+		relation := strings.ToLower(m[1])
+		switch relation {
+		case "this":
+			// The calendar year or month so far
+			switch unit {
+			case "year":
+				q.From = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+			case "month":
+				q.From = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+			default:
+				q.From = now.AddDate(0, 0, -7)
+			}
+			period = "this " + unit
+		case "last", "past":
+			// "last year" and "last month" are the previous calendar ones
+			if m[2] == "" && relation == "last" && unit == "year" {
+				q.From = time.Date(now.Year()-1, time.January, 1, 0, 0, 0, 0, time.UTC)
+				q.To = q.From.AddDate(1, 0, 0)
+				period = "in " + strconv.Itoa(now.Year()-1)
+				break
+			}
+			if m[2] == "" && relation == "last" && unit == "month" {
+				q.To = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+				q.From = q.To.AddDate(0, -1, 0)
+				period = "in " + q.From.Format("January 2006")
+				break
+			}
+			switch unit {
+			case "year":
+				q.From = now.AddDate(-n, 0, 0)
+			case "month":
+				q.From = now.AddDate(0, -n, 0)
+			case "week":
+				q.From = now.AddDate(0, 0, -7*n)
+			default:
+				q.From = now.AddDate(0, 0, -n)
+			}
+			period = fmt.Sprintf("in the last %d %ss", n, unit)
+			if n == 1 {
+				period = "in the last " + unit
+			}
+		}
+	}
+	return q, period, true
+}
+
+// listeningHistory renders the user's listening statistics for a history
+// query as a context document: the totals of the period and the most played
+// tracks, artists or albums
+func listeningHistory(store *history.Store, userID string, q history.Query, period string) string {
+	var b strings.Builder
+	scope := ""
+	if q.Artist != "" {
+		scope = " of " + q.Artist
+	}
+	summary := store.Summarize(userID, q)
+	fmt.Fprintf(&b, "The user's listening history%s %s:", scope, period)
+	if summary.Plays == 0 {
+		b.WriteString("\n- no plays recorded")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n- %d plays of %d tracks by %d artists", summary.Plays, summary.Tracks, summary.Artists)
+	if summary.MsPlayed > 0 {
+		fmt.Fprintf(&b, "\n- %s listened", formatListening(summary.MsPlayed))
+	}
+	fmt.Fprintf(&b, "\n- from %s to %s", summary.FirstPlayed.Format("2 January 2006"), summary.LastPlayed.Format("2 January 2006"))
+	fmt.Fprintf(&b, "\n- %d tracks saved to the library", summary.SavedTracks)
+
+	writeTop := func(by string, limit int) {
+		q := q
+		q.By, q.Limit = by, limit
+		rows, err := store.Top(userID, q)
+		if err != nil || len(rows) == 0 {
+			return
+		}
+		title := "Most played " + by + "s"
+		if q.Unsaved {
+			title += " that the user has not saved"
+		}
+		fmt.Fprintf(&b, "\n\n%s:", title)
+		for i, r := range rows {
+			switch by {
+			case history.ByTrack:
+				fmt.Fprintf(&b, "\n%d. %s by %s", i+1, r.Track, r.Artist)
+			case history.ByAlbum:
+				fmt.Fprintf(&b, "\n%d. %s by %s", i+1, r.Album, r.Artist)
+			default:
+				fmt.Fprintf(&b, "\n%d. %s", i+1, r.Artist)
+			}
+			fmt.Fprintf(&b, ": %d plays", r.Plays)
+			if r.MsPlayed > 0 {
+				fmt.Fprintf(&b, ", %s", formatListening(r.MsPlayed))
+			}
+			if r.Skips > 0 {
+				fmt.Fprintf(&b, ", skipped %d times", r.Skips)
+			}
+			if r.Saved {
+				b.WriteString(", saved")
+			}
+		}
+	}
+	writeTop(q.By, q.Limit)
+	if q.By == history.ByTrack && q.Artist == "" {
+		writeTop(history.ByArtist, listeningArtists)
+	}
+	return b.String()
+}
+
+// formatListening renders a listening time in hours or minutes
+func formatListening(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d >= time.Hour {
+		return fmt.Sprintf("%.1f hours", d.Hours())
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
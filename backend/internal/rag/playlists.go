@@ -0,0 +1,37 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/playlist"
+)
+
+// maxPlaylistSources is the number of playlists added as context when the
+// query mentions playlists without naming one
+const maxPlaylistSources = 3
+
+// playlistWord matches queries about the user's playlists
+var playlistWord = regexp.MustCompile(`(?i)\bplaylists?\b`)
+
+// referencedPlaylists returns the user's playlists the query is about: those
+// it names, as in "my running playlist" for a playlist called Running, or
+// else the most recent ones when it only says "my playlist". Playlists are
+// given most recent first.
+func referencedPlaylists(query string, playlists []*playlist.Playlist) []*playlist.Playlist {
+	if len(playlists) == 0 {
+		return nil
+	}
+	padded := " " + catalog.NameKey(query) + " "
+	var named []*playlist.Playlist
+	for _, p := range playlists {
+		if key := catalog.NameKey(p.Name); key != "" && strings.Contains(padded, " "+key+" ") {
+			named = append(named, p)
+		}
+	}
+	if len(named) > 0 || !playlistWord.MatchString(query) {
+		return named
+	}
+	return playlists[:min(len(playlists), maxPlaylistSources)]
+}
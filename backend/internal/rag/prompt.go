@@ -0,0 +1,87 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+)
+
+// answerPrompt renders the messages of an answer from the prompt versions
+// in use when the request started
+type answerPrompt struct {
+	set  prompt.Set
+	vars prompt.Vars
+	// history is the latest previous turns of the conversation, so that
+	// answers to follow-ups can build on the exchanges before them
+	history []azure.ChatMessage
+}
+
+// newAnswerPrompt resolves the answer prompts for a request, the active
+// versions unless it pins others
+func (p *Pipeline) newAnswerPrompt(req Request) (*answerPrompt, error) {
+	registry := p.Prompts
+	if registry == nil {
+		registry = prompt.RegistryInstance
+	}
+	set, err := registry.Active(prompt.AnswerSystem, prompt.AnswerUser)
+	if err != nil {
+		return nil, err
+	}
+	for name, version := range req.Prompts {
+		if _, ok := set[name]; !ok {
+			return nil, fmt.Errorf("%w: %s is not an answer prompt", prompt.ErrNotFound, name)
+		}
+		pinned, err := registry.Version(name, version)
+		if err != nil {
+			return nil, err
+		}
+		set[name] = pinned[name]
+	}
+	vars := prompt.Vars{Tier: req.Tier, Persona: req.Persona, Locale: req.Locale, Style: req.Style, Instructions: req.Instructions}
+	var history []azure.ChatMessage
+	for _, t := range req.History[max(0, len(req.History)-maxHistoryTurns):] {
+		// Clients send the history, so it cannot add system messages
+		if t.Role == "user" || t.Role == "assistant" {
+			history = append(history, azure.ChatMessage{Role: t.Role, Content: clipTurn(t.Content)})
+		}
+	}
+	return &answerPrompt{set: set, vars: vars, history: history}, nil
+}
+
+// messages assembles the chat messages for a question and its numbered
+// sources, after the previous turns of the conversation
+func (a *answerPrompt) messages(query string, sources []Source) ([]azure.ChatMessage, error) {
+	vars := a.vars
+	vars.Query = query
+	vars.Context = renderSources(sources)
+	system, err := a.set.Render(prompt.AnswerSystem, vars)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.set.Render(prompt.AnswerUser, vars)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]azure.ChatMessage, 0, len(a.history)+2)
+	messages = append(messages, azure.ChatMessage{Role: "system", Content: system})
+	messages = append(messages, a.history...)
+	return append(messages, azure.ChatMessage{Role: "user", Content: user}), nil
+}
+
+// renderSources lists numbered sources with their titles, locators and
+// content, or returns "" when there are none
+func renderSources(sources []Source) string {
+	var blocks []string
+	for _, s := range sources {
+		var b strings.Builder
+		fmt.Fprintf(&b, "[%d] %s", s.Number, s.Title)
+		if s.Locator != nil {
+			fmt.Fprintf(&b, " (%s)", s.Locator)
+		}
+		fmt.Fprintf(&b, "\n%s", s.content)
+		blocks = append(blocks, b.String())
+	}
+	return strings.Join(blocks, "\n\n")
+}
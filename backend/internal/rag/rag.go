@@ -0,0 +1,524 @@
+// Package rag implements the retrieval-augmented chat pipeline: it gathers
+// grounded context from passage retrieval and the music knowledge graph and
+// asks the language model for an answer.
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/graph"
+	"github.com/One-Frequency/MusicRAG/backend/internal/history"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+	"github.com/One-Frequency/MusicRAG/backend/internal/playlist"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+	"github.com/One-Frequency/MusicRAG/backend/internal/similarity"
+)
+
+const (
+	// searchTop is the number of passages retrieved for each request, unless
+	// it asks for up to maxSearchTop
+	searchTop    = 5
+	maxSearchTop = 20
+	// graphSeeds is the number of retrieved entities whose neighbors are added as context
+	graphSeeds = 3
+	// graphFacts is the maximum number of knowledge graph facts added as context
+	graphFacts = 20
+)
+
+var (
+	ErrSearch = errors.New("search failed")
+	// ErrUnknownModel is returned for requests naming a chat deployment that
+	// is not offered
+	ErrUnknownModel = errors.New("unknown model")
+	// ErrUnknownRetriever is returned for requests naming a retriever the
+	// pipeline does not have
+	ErrUnknownRetriever = errors.New("unknown retriever")
+)
+
+// Request is a single chat turn
+type Request struct {
+	Query   string
+	History []Turn
+	// Expand retrieves with paraphrased sub-queries in addition to the query
+	Expand bool
+	// Schema or OutputType, one of OutputTypes, asks for a JSON answer
+	// instead of prose
+	Schema     json.RawMessage
+	OutputType string
+	// Playlists are the user's own playlists; those the query refers to are
+	// added as context
+	Playlists []*playlist.Playlist
+	// UserID identifies the user asking, whose listening history answers
+	// questions about their own listening; empty for anonymous requests
+	UserID string
+	// Top is the number of passages to retrieve; 0 for the default
+	Top int
+	// Model is the chat deployment to answer with, one of
+	// azure.ChatDeployments; empty for the default
+	Model string
+	// Tier, Persona, Locale, Style and Instructions fill in the variables of
+	// the answer prompts
+	Tier         string
+	Persona      string
+	Locale       string
+	Style        string
+	Instructions string
+	// Collections and Scope restrict retrieval as in retrieval.Query
+	Collections []string
+	Scope       []string
+	// Tools names the pipeline's tools the model may call; nil for all
+	Tools []string
+	// Profile is the assistant profile the request was configured from, if
+	// any, recorded with the result
+	Profile string
+	// Retriever names one of the pipeline's Retrievers to search documents
+	// with; empty for Retriever
+	Retriever string
+	// Prompts pins answer prompts to versions other than the active ones,
+	// e.g. {"answer.system": 4}
+	Prompts map[string]int
+	// Experiment and Variant are the A/B experiment variant the request was
+	// configured by, if any, recorded with the result
+	Experiment string
+	Variant    string
+}
+
+// Result is the answer to a request together with the context it was grounded on
+type Result struct {
+	Content string
+	// Data is the validated JSON answer of a structured request; Content then
+	// holds the same JSON as text
+	Data      json.RawMessage
+	Chunks    []retrieval.Chunk
+	Sources   []Source
+	Citations []Citation
+	// Grounding is how well the answer is supported by its sources; it is nil
+	// for theory questions, which are answered without retrieval
+	Grounding *Grounding
+	// InvalidCitations are the numbers the model cited without a matching
+	// source; they have been removed from Content
+	InvalidCitations []int
+	Facts            []graph.Fact
+	Entities         []catalog.Aggregate
+	Mentions         []linking.Mention
+	Route            Route
+	Debug            *Debug
+	// Model is the chat deployment that answered
+	Model string
+	// Prompts are the versions of the prompts the answer was asked with
+	Prompts []prompt.Ref
+	// Profile is the assistant profile of the request
+	Profile string
+	// Experiment and Variant are the experiment variant of the request
+	Experiment string
+	Variant    string
+	// Cache is set when the answer was reused from the cache
+	Cache *CacheHit
+}
+
+// Debug records how a request was turned into search queries, so that it is
+// possible to see why a chunk was retrieved
+type Debug struct {
+	Query           string              `json:"query"`
+	StandaloneQuery string              `json:"standaloneQuery"`
+	SearchQuery     string              `json:"searchQuery"` // standalone query with canonical entity names
+	SubQueries      []string            `json:"subQueries,omitempty"`
+	RetrievedBy     map[string][]string `json:"retrievedBy"` // chunk ID to the queries that returned it
+}
+
+// Pipeline answers chat requests
+type Pipeline struct {
+	Retriever retrieval.Retriever
+	// Retrievers are alternatives to Retriever that requests may name, e.g.
+	// to compare search strategies in experiments
+	Retrievers map[string]retrieval.Retriever
+	// CatalogRetriever searches the catalog only, for metadata and analytics questions
+	CatalogRetriever retrieval.Retriever
+	Rewriter         *Rewriter
+	Router           *Router
+	Linker           *linking.Linker
+	Graph            *graph.Graph
+	Store            catalog.Store
+	Chat             ChatFunc
+	// JSONChat is Chat in the model's JSON mode, used for structured answers
+	JSONChat ChatFunc
+	Verifier *Verifier
+	// Cache reuses answers to questions that mean the same; nil to always
+	// answer afresh
+	Cache *Cache
+	// Playlists describes the user's playlists with catalog data
+	Playlists *playlist.Matcher
+	// Listening holds the users' listening histories
+	Listening *history.Store
+	// ToolChat is Chat with tool calling; when set, prose answers may call Tools
+	ToolChat ToolChatFunc
+	Tools    []Tool
+	// Prompts holds the answer prompts; prompt.RegistryInstance when nil
+	Prompts *prompt.Registry
+
+	// ExpandQueries enables multi-query expansion for every request
+	ExpandQueries bool
+}
+
+// PipelineInstance is the pipeline used by the chat API
+var PipelineInstance *Pipeline
+
+// Init wires the pipeline to the shared retriever, graph, prompts and model.
+// It must run after retrieval.Init, linking.Init, graph.Init, playlist.Init,
+// history.Init, similarity.Init and prompt.Init.
+func Init() {
+	PipelineInstance = &Pipeline{
+		Retriever: retrieval.RetrieverInstance,
+		Retrievers: map[string]retrieval.Retriever{
+			"hybrid":  retrieval.RetrieverInstance,
+			"keyword": retrieval.KeywordRetrieverInstance,
+		},
+		CatalogRetriever: &retrieval.EntityRetriever{
+			Retriever: retrieval.CatalogIndex,
+			Store:     catalog.StoreInstance,
+		},
+		Rewriter:      &Rewriter{Chat: azure.Chat},
+		Router:        &Router{Chat: azure.Chat},
+		Linker:        linking.LinkerInstance,
+		Graph:         graph.GraphInstance,
+		Store:         catalog.StoreInstance,
+		Chat:          azure.Chat,
+		JSONChat:      azure.ChatJSON,
+		Verifier:      NewVerifierFromEnv(azure.Chat),
+		Playlists:     playlist.MatcherInstance,
+		Listening:     history.StoreInstance,
+		ToolChat:      azure.ChatWithTools,
+		Tools:         []Tool{SimilarTracksTool(similarity.IndexInstance, catalog.StoreInstance)},
+		ExpandQueries: os.Getenv("RAG_QUERY_EXPANSION") == "true",
+		Prompts:       prompt.RegistryInstance,
+		Cache:         NewCacheFromEnv(azure.Embed, azure.EmbeddingDeployment()),
+	}
+	// Answers grounded on a catalog entity go stale when it, its relations or
+	// its document links change
+	if cache := PipelineInstance.Cache; cache != nil {
+		catalog.StoreInstance.Subscribe(func(e *catalog.Entity, deleted bool) {
+			cache.InvalidateEntity(e.ID)
+		})
+	}
+}
+
+// Answer runs the pipeline for a request, reusing the cached answer of a
+// question that means the same when there is one. Retrieval failures are
+// wrapped in ErrSearch, bad schemas in ErrInvalidSchema and answers that
+// never match the schema in ErrInvalidOutput.
+func (p *Pipeline) Answer(ctx context.Context, req Request) (*Result, error) {
+	if p.Cache != nil && cacheable(req) {
+		return p.answerCached(ctx, req)
+	}
+	return p.answer(ctx, req)
+}
+
+// answer runs the pipeline for a request
+func (p *Pipeline) answer(ctx context.Context, req Request) (*Result, error) {
+	if req.Model != "" {
+		if !slices.Contains(azure.ChatDeployments(), req.Model) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownModel, req.Model)
+		}
+		ctx = azure.WithChatDeployment(ctx, req.Model)
+	}
+	documents := p.Retriever
+	if req.Retriever != "" {
+		var ok bool
+		if documents, ok = p.Retrievers[req.Retriever]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownRetriever, req.Retriever)
+		}
+	}
+	var schema *outputSchema
+	if len(req.Schema) > 0 || req.OutputType != "" {
+		var err error
+		if schema, err = compileOutputSchema(req.Schema, req.OutputType); err != nil {
+			return nil, err
+		}
+	}
+	prompts, err := p.newAnswerPrompt(req)
+	if err != nil {
+		return nil, err
+	}
+	debug := &Debug{Query: req.Query, StandaloneQuery: req.Query, RetrievedBy: map[string][]string{}}
+
+	// Condense follow-ups such as "and their second album?" into a standalone
+	// query. A failed rewrite degrades to searching for the raw question.
+	if p.Rewriter != nil {
+		standalone, err := p.Rewriter.Condense(ctx, req.History, req.Query)
+		if err != nil {
+			log.Printf("Query rewriting failed, using the raw question: %v", err)
+		} else {
+			debug.StandaloneQuery = standalone
+		}
+	}
+
+	// Resolve artist, work and album mentions so that nicknames reach the
+	// right documents. Retrieval is only restricted to the linked entities
+	// when every mention matched a name or alias exactly.
+	query := retrieval.Query{Text: debug.StandaloneQuery, Top: searchTop, Collections: req.Collections, Scope: req.Scope}
+	if req.Top > 0 {
+		query.Top = min(req.Top, maxSearchTop)
+	}
+	var mentions []linking.Mention
+	if p.Linker != nil {
+		mentions = p.Linker.Link(debug.StandaloneQuery)
+		query.Text = linking.Canonicalize(debug.StandaloneQuery, mentions)
+		query.EntityIDs = linking.EntityIDs(mentions)
+		query.FilterEntities = len(mentions) > 0
+		for _, m := range mentions {
+			query.FilterEntities = query.FilterEntities && m.Score >= linking.ExactScore
+		}
+	}
+	debug.SearchQuery = query.Text
+
+	// Route the request: theory questions need no retrieval, metadata and
+	// analytics questions are answered from the catalog rather than documents
+	route := Route{Intent: IntentDocuments, Method: RouteByDefault}
+	if p.Router != nil {
+		route = p.Router.Route(ctx, debug.StandaloneQuery, mentions)
+	}
+	log.Printf("Routed query to %s (confidence %.2f, by %s)", route.Intent, route.Confidence, route.Method)
+
+	var chunks []retrieval.Chunk
+	var facts []graph.Fact
+	sources := []Source{}
+	if route.Intent != IntentTheory {
+		if p.Rewriter != nil && (req.Expand || p.ExpandQueries) {
+			expanded, err := p.Rewriter.Expand(ctx, query.Text, subQueries)
+			if err != nil {
+				log.Printf("Query expansion failed, searching with a single query: %v", err)
+			}
+			for _, q := range expanded {
+				if !strings.EqualFold(q, debug.StandaloneQuery) {
+					debug.SubQueries = append(debug.SubQueries, q)
+				}
+			}
+		}
+
+		// Retrieve supporting passages from the search index and the catalog
+		retriever := documents
+		if (route.Intent == IntentCatalog || route.Intent == IntentAnalytics) && p.CatalogRetriever != nil {
+			retriever = p.CatalogRetriever
+		}
+		var err error
+		chunks, err = p.retrieve(ctx, retriever, query, debug)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			sources = append(sources, chunkSource(chunk))
+		}
+
+		// Add the graph neighborhood of the retrieved entities, which answers
+		// relationship questions that passages rarely spell out
+		if p.Graph != nil {
+			facts = p.Graph.Facts(p.seeds(query.EntityIDs, chunks), graphFacts)
+			if len(facts) > 0 {
+				sources = append(sources, Source{ID: "graph:facts", Title: "Music knowledge graph", content: renderFacts(facts)})
+			}
+		}
+		if route.Intent == IntentAnalytics {
+			sources = append(sources, Source{ID: "catalog:statistics", Title: "Catalog statistics", content: catalogStatistics(p.Store, query.EntityIDs)})
+		}
+	}
+	// The user's own playlists ground questions such as "what's the average
+	// tempo of my running playlist?", whatever the route
+	if p.Playlists != nil {
+		for _, pl := range referencedPlaylists(debug.StandaloneQuery, req.Playlists) {
+			sources = append(sources, Source{ID: "playlist:" + pl.ID, Title: "Your playlist " + pl.Name, content: p.Playlists.Describe(pl)})
+		}
+	}
+	// Questions about the user's own listening, such as "what did I play
+	// most in 2023 that I never saved?", are answered from their history only
+	if p.Listening != nil && req.UserID != "" && p.Listening.Has(req.UserID) {
+		if q, period, ok := listeningQuery(debug.StandaloneQuery, time.Now(), mentions); ok {
+			sources = append(sources, Source{ID: "history:listening", Title: "Your listening history", content: listeningHistory(p.Listening, req.UserID, q, period)})
+		}
+	}
+	// Tempo, key and rating constraints, as in "what's in my library between
+	// 124-128 BPM in 8A rated 4+?", are answered from the DJ attributes of
+	// the catalog's recordings
+	if p.Store != nil {
+		if filter, ok := libraryFilter(debug.StandaloneQuery); ok {
+			sources = append(sources, Source{ID: "catalog:library", Title: "Your library", content: libraryTracks(p.Store, filter)})
+		}
+	}
+	for i := range sources {
+		sources[i].Number = i + 1
+	}
+	hits := make([]catalog.DocumentHit, 0, len(chunks))
+	for _, chunk := range chunks {
+		hits = append(hits, catalog.DocumentHit{DocumentID: chunk.DocumentID, EntityIDs: chunk.EntityIDs, Score: chunk.Score})
+	}
+
+	// Structured answers are data rather than prose, so there are no inline
+	// citations or claims to check
+	if schema != nil {
+		messages, err := prompts.messages(debug.StandaloneQuery, sources)
+		if err != nil {
+			return nil, err
+		}
+		data, err := completeStructured(ctx, p.JSONChat, messages, schema)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{
+			Content:    string(data),
+			Data:       data,
+			Chunks:     chunks,
+			Sources:    sources,
+			Citations:  []Citation{},
+			Facts:      facts,
+			Entities:   catalog.AggregateHits(p.Store, hits),
+			Mentions:   mentions,
+			Route:      route,
+			Debug:      debug,
+			Model:      azure.ChatDeploymentFor(ctx),
+			Prompts:    prompts.set.Refs(),
+			Profile:    req.Profile,
+			Experiment: req.Experiment,
+			Variant:    req.Variant,
+		}, nil
+	}
+
+	// Get a completion from the language model, which may call tools whose
+	// results become further sources, and check that every [n] it cites
+	// refers to a source it was actually given
+	var completion string
+	if tools := p.tools(req.Tools); p.ToolChat != nil && len(tools) > 0 {
+		completion, sources, err = p.completeWithTools(ctx, tools, prompts, debug.StandaloneQuery, sources)
+	} else {
+		var messages []azure.ChatMessage
+		if messages, err = prompts.messages(debug.StandaloneQuery, sources); err == nil {
+			completion, err = p.Chat(ctx, messages)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, citations, invalid := validateCitations(completion, sources)
+	if len(invalid) > 0 {
+		log.Printf("Removed citations of unknown sources %v from answer", invalid)
+	}
+
+	// Check the answer's claims against the sources, so that answers made up
+	// from the model's own memory are caveated or withheld
+	var grounding *Grounding
+	if p.Verifier != nil && route.Intent != IntentTheory {
+		grounding, err = p.Verifier.Verify(ctx, content, sources)
+		if err != nil {
+			log.Printf("Grounding verification failed: %v", err)
+		}
+	}
+	if grounding != nil {
+		switch grounding.Action {
+		case GroundingCaveat:
+			content += "\n\n" + caveatMessage
+		case GroundingAbstain:
+			log.Printf("Withheld answer with groundedness %.2f below %.2f", grounding.Score, grounding.Threshold)
+			content, citations = abstainMessage, []Citation{}
+			for i := range sources {
+				sources[i].Cited, sources[i].Quotes = false, nil
+			}
+		}
+	}
+
+	return &Result{
+		Content:          content,
+		Chunks:           chunks,
+		Sources:          sources,
+		Citations:        citations,
+		Grounding:        grounding,
+		InvalidCitations: invalid,
+		Facts:            facts,
+		Entities:         catalog.AggregateHits(p.Store, hits),
+		Mentions:         mentions,
+		Route:            route,
+		Debug:            debug,
+		Model:            azure.ChatDeploymentFor(ctx),
+		Prompts:          prompts.set.Refs(),
+		Profile:          req.Profile,
+		Experiment:       req.Experiment,
+		Variant:          req.Variant,
+	}, nil
+}
+
+// retrieve runs the search query and any sub-queries and merges their
+// rankings, recording which queries returned each chunk
+func (p *Pipeline) retrieve(ctx context.Context, retriever retrieval.Retriever, query retrieval.Query, debug *Debug) ([]retrieval.Chunk, error) {
+	texts := append([]string{query.Text}, debug.SubQueries...)
+	var rankings [][]retrieval.Chunk
+	var failure error
+	for _, text := range texts {
+		q := query
+		q.Text = text
+		chunks, err := retriever.Retrieve(ctx, q)
+		if err != nil {
+			failure = err
+			log.Printf("Retrieval failed for query %q: %v", text, err)
+			continue
+		}
+		for _, chunk := range chunks {
+			debug.RetrievedBy[chunk.ID] = append(debug.RetrievedBy[chunk.ID], text)
+		}
+		rankings = append(rankings, chunks)
+	}
+	if len(rankings) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrSearch, failure)
+	}
+	if len(rankings) == 1 {
+		return rankings[0], nil
+	}
+
+	merged := retrieval.FuseRankings(rankings, query.Top)
+	kept := make(map[string][]string, len(merged))
+	for _, chunk := range merged {
+		kept[chunk.ID] = debug.RetrievedBy[chunk.ID]
+	}
+	debug.RetrievedBy = kept
+	return merged, nil
+}
+
+// seeds returns up to graphSeeds distinct entities to expand in the graph:
+// the entities linked in the query first, then those of the best chunks
+func (p *Pipeline) seeds(linked []string, chunks []retrieval.Chunk) []string {
+	ids := slices.Clone(linked[:min(len(linked), graphSeeds)])
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, chunk := range chunks {
+		for _, id := range append(p.Store.DocumentEntities(chunk.DocumentID), chunk.EntityIDs...) {
+			if len(ids) >= graphSeeds {
+				return ids
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// renderFacts formats graph facts as a single context document
+func renderFacts(facts []graph.Fact) string {
+	var b strings.Builder
+	b.WriteString("Facts from the music knowledge graph:")
+	for _, f := range facts {
+		b.WriteString("\n- ")
+		b.WriteString(f.Text)
+	}
+	return b.String()
+}
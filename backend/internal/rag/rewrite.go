@@ -0,0 +1,109 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+)
+
+const (
+	// maxHistoryTurns is the number of previous turns considered when
+	// condensing a follow-up and when answering it
+	maxHistoryTurns = 6
+	// maxTurnChars truncates long previous answers in those prompts
+	maxTurnChars = 1000
+	// subQueries is the number of paraphrases generated by query expansion
+	subQueries = 3
+)
+
+const condensePrompt = `Rewrite the user's latest question as a single standalone search query for a music knowledge base.
+Resolve pronouns and references such as "their", "that album" or "the second one" using the conversation.
+Keep artist, album and song names exactly as written. Do not answer the question.
+Reply with the rewritten query only.`
+
+const expandPrompt = `Generate %d alternative search queries for the question below, each on its own line.
+Vary the wording and use synonyms or related music terms, but keep the meaning and every artist, album and song name.
+Reply with the queries only, without numbering.`
+
+// listMarker matches a leading bullet or list number such as "- ", "2." or "3)"
+var listMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s*`)
+
+// ChatFunc sends messages to the language model and returns its reply
+type ChatFunc func(ctx context.Context, messages []azure.ChatMessage) (string, error)
+
+// Turn is a previous message of the conversation
+type Turn struct {
+	Role    string // user or assistant
+	Content string
+}
+
+// Rewriter turns conversational questions into search queries
+type Rewriter struct {
+	Chat ChatFunc
+}
+
+// Condense rewrites question into a standalone query using the conversation
+// history. Without history the question is already standalone and is
+// returned unchanged without calling the model.
+func (r *Rewriter) Condense(ctx context.Context, history []Turn, question string) (string, error) {
+	if len(history) == 0 {
+		return question, nil
+	}
+	history = history[max(0, len(history)-maxHistoryTurns):]
+
+	var b strings.Builder
+	b.WriteString("Conversation:\n")
+	for _, t := range history {
+		fmt.Fprintf(&b, "%s: %s\n", t.Role, clipTurn(t.Content))
+	}
+	fmt.Fprintf(&b, "\nLatest question: %s", question)
+
+	reply, err := r.Chat(ctx, []azure.ChatMessage{
+		{Role: "system", Content: condensePrompt},
+		{Role: "user", Content: b.String()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to condense query: %w", err)
+	}
+	standalone := strings.Trim(strings.TrimSpace(reply), `"`)
+	if standalone == "" {
+		return question, nil
+	}
+	return standalone, nil
+}
+
+// clipTurn truncates a previous turn to maxTurnChars characters
+func clipTurn(content string) string {
+	if runes := []rune(content); len(runes) > maxTurnChars {
+		return string(runes[:maxTurnChars]) + "..."
+	}
+	return content
+}
+
+// Expand generates up to n paraphrases of query for multi-query retrieval.
+// The query itself is not included.
+func (r *Rewriter) Expand(ctx context.Context, query string, n int) ([]string, error) {
+	reply, err := r.Chat(ctx, []azure.ChatMessage{
+		{Role: "system", Content: fmt.Sprintf(expandPrompt, n)},
+		{Role: "user", Content: query},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand query: %w", err)
+	}
+	var queries []string
+	for _, line := range strings.Split(reply, "\n") {
+		// Models tend to number or bullet the list despite the instructions
+		line = strings.Trim(listMarker.ReplaceAllString(line, ""), "\" \t\r")
+		if line == "" || strings.EqualFold(line, query) {
+			continue
+		}
+		queries = append(queries, line)
+		if len(queries) == n {
+			break
+		}
+	}
+	return queries, nil
+}
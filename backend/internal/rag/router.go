@@ -0,0 +1,186 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+)
+
+// Intent is the class of question a request belongs to
+type Intent string
+
+const (
+	// IntentCatalog is a metadata lookup answered from the catalog and knowledge graph
+	IntentCatalog Intent = "catalog"
+	// IntentTheory is a general music theory question answered without retrieval
+	IntentTheory Intent = "theory"
+	// IntentDocuments is a question about uploaded and indexed documents
+	IntentDocuments Intent = "documents"
+	// IntentAnalytics is an aggregate question over structured data
+	IntentAnalytics Intent = "analytics"
+)
+
+// Routing methods recorded on a Route
+const (
+	RouteByRules   = "rules"
+	RouteByModel   = "model"
+	RouteByDefault = "default"
+)
+
+// routeThreshold is the rule confidence below which the model is asked
+const routeThreshold = 0.6
+
+// mentionWeight is the rule score a linked catalog entity adds to IntentCatalog
+const mentionWeight = 0.5
+
+// Route is a routing decision
+type Route struct {
+	Intent     Intent  `json:"intent"`
+	Confidence float64 `json:"confidence"`
+	Method     string  `json:"method"` // rules, model or default
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// intentRules are the patterns that vote for each intent; every matching
+// pattern adds one to the intent's score
+var intentRules = map[Intent][]*regexp.Regexp{
+	IntentCatalog: compileAll(
+		`\bwho (?:played|plays|produced|wrote|composed|sang|sings|performed|engineered|mixed)\b`,
+		`\b(?:released|release date|came out|what year|which year)\b`,
+		`\b(?:record )?label\b`, `\bproduc(?:er|ed)\b`, `\bmembers?\b`, `\bline-?up\b`,
+		`\bdiscography\b`, `\btrack ?list(?:ing)?\b`, `\bcatalog(?:ue)? number\b`, `\b(?:isrc|iswc|upc|ean|barcode)\b`,
+		`\bsampled?\b`, `\bcover(?:ed)? (?:of|by)\b`, `\bhow long is\b`, `\bduration\b`, `\bsession musicians?\b`,
+	),
+	IntentTheory: compileAll(
+		`\bchords?\b`, `\bscales?\b`, `\bmodes?\b`, `\bintervals?\b`, `\bprogressions?\b`, `\bcadences?\b`,
+		`\bharmon(?:y|ic|ize)\b`, `\bvoicings?\b`, `\bcircle of fifths\b`, `\bmodulat(?:e|ion)\b`, `\btritone\b`,
+		`\b(?:dominant|diminished|augmented|suspended)\b`, `\btime signatures?\b`, `\bcounterpoint\b`,
+		`\barpeggios?\b`, `\bpentatonic\b`, `\b(?:ionian|dorian|phrygian|lydian|mixolydian|aeolian|locrian)\b`,
+		`\bii-v-i\b`, `\binversions?\b`, `\b(?:major|minor) (?:key|third|seventh|sixth)\b`,
+	),
+	IntentDocuments: compileAll(
+		`\b(?:uploaded?|document|documents|pdf|file|files|attachment)\b`, `\bmy notes\b`, `\baccording to\b`,
+		`\bsheet music\b`, `\blyrics\b`, `\bsummari[sz]e\b`, `\bthis (?:paper|article|essay|score|transcription)\b`,
+		`\bliner notes\b`, `\binterview\b`,
+	),
+	IntentAnalytics: compileAll(
+		`\bhow many\b`, `\bcount\b`, `\bnumber of\b`, `\bmost (?:played|common|frequent|popular)\b`, `\btop \d+\b`,
+		`\baverage\b`, `\bper (?:year|decade|month|artist|album)\b`, `\btrends?\b`, `\bstatistics\b`, `\bstats\b`,
+		`\bpercent(?:age)?\b`, `\brank(?:ing|ed)?\b`, `\blistened\b`, `\bplay ?counts?\b`,
+	),
+}
+
+// intentOrder breaks ties between equally scored intents, most specific first
+var intentOrder = []Intent{IntentAnalytics, IntentCatalog, IntentTheory, IntentDocuments}
+
+const routePrompt = `Classify the user's question about music into exactly one intent:
+- catalog: metadata lookups such as who played on, produced or wrote a recording, release dates, labels, band members, samples and covers
+- theory: general music theory such as chords, scales, harmony and rhythm that needs no lookup
+- documents: questions about uploaded documents, notes, lyrics, scores or articles
+- analytics: counts, rankings, averages and trends over structured data such as the catalog or listening history
+Reply with JSON only: {"intent": "<intent>", "confidence": <number between 0 and 1>}`
+
+// Router classifies requests into intents with keyword rules, asking the
+// language model only when the rules are not confident
+type Router struct {
+	Chat ChatFunc // optional; without it low-confidence rule decisions are kept
+}
+
+// Route classifies a standalone query. It never fails: when neither the rules
+// nor the model decide, the request is routed to document Q&A, which is what
+// every request did before routing existed.
+func (r *Router) Route(ctx context.Context, query string, mentions []linking.Mention) Route {
+	route := routeByRules(query, mentions)
+	if route.Confidence >= routeThreshold || r.Chat == nil {
+		return route
+	}
+	modelRoute, err := r.routeByModel(ctx, query)
+	if err != nil {
+		log.Printf("Intent classification by model failed, keeping %s route: %v", route.Method, err)
+		return route
+	}
+	return modelRoute
+}
+
+// routeByRules scores each intent by its matching rules. The confidence is
+// the winning score divided by the total score plus a half, so a single
+// unopposed match gives 0.67, two give 0.8 and a tie gives at most 0.4.
+func routeByRules(query string, mentions []linking.Mention) Route {
+	text := strings.ToLower(query)
+	scores := make(map[Intent]float64)
+	var matched []string
+	for _, intent := range intentOrder {
+		for _, rule := range intentRules[intent] {
+			if m := rule.FindString(text); m != "" {
+				scores[intent]++
+				matched = append(matched, fmt.Sprintf("%s:%q", intent, m))
+			}
+		}
+	}
+	if len(mentions) > 0 {
+		scores[IntentCatalog] += mentionWeight
+		matched = append(matched, "catalog:linked entity")
+	}
+
+	total := 0.0
+	best := intentOrder[0]
+	for _, intent := range intentOrder {
+		total += scores[intent]
+		if scores[intent] > scores[best] {
+			best = intent
+		}
+	}
+	if total == 0 {
+		return Route{Intent: IntentDocuments, Method: RouteByDefault, Reason: "no rule matched"}
+	}
+	return Route{
+		Intent:     best,
+		Confidence: scores[best] / (total + 0.5),
+		Method:     RouteByRules,
+		Reason:     strings.Join(matched, ", "),
+	}
+}
+
+func (r *Router) routeByModel(ctx context.Context, query string) (Route, error) {
+	reply, err := r.Chat(ctx, []azure.ChatMessage{
+		{Role: "system", Content: routePrompt},
+		{Role: "user", Content: query},
+	})
+	if err != nil {
+		return Route{}, err
+	}
+	// Tolerate prose or code fences around the JSON object
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return Route{}, fmt.Errorf("no JSON object in reply %q", reply)
+	}
+	var decision struct {
+		Intent     Intent  `json:"intent"`
+		Confidence float64 `json:"confidence"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &decision); err != nil {
+		return Route{}, fmt.Errorf("failed to parse reply %q: %w", reply, err)
+	}
+	if _, ok := intentRules[decision.Intent]; !ok {
+		return Route{}, fmt.Errorf("unknown intent %q", decision.Intent)
+	}
+	return Route{
+		Intent:     decision.Intent,
+		Confidence: min(max(decision.Confidence, 0), 1),
+		Method:     RouteByModel,
+	}, nil
+}
+
+func compileAll(patterns ...string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		compiled[i] = regexp.MustCompile(p)
+	}
+	return compiled
+}
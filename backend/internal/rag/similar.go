@@ -0,0 +1,123 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/similarity"
+)
+
+const (
+	// similarTitleScore is the minimum title similarity for the tool to
+	// resolve a track name
+	similarTitleScore = 0.8
+	// maxSimilarTracks is the most similar tracks the tool returns
+	maxSimilarTracks = 25
+)
+
+// similarTracksParameters is the JSON schema of the find_similar_tracks arguments
+const similarTracksParameters = `{
+  "type": "object",
+  "properties": {
+    "track": {"type": "string", "description": "Title of the track to start from"},
+    "artist": {"type": "string", "description": "Artist of the track, to tell apart tracks with the same title"},
+    "limit": {"type": "integer", "minimum": 1, "maximum": 25, "description": "Number of similar tracks, 10 by default"},
+    "compatibleKeys": {"type": "boolean", "description": "Only return tracks whose key mixes harmonically with the track's"},
+    "bpmWindow": {"type": "number", "minimum": 0, "description": "Only return tracks within this many BPM of the track's tempo"}
+  },
+  "required": ["track"]
+}`
+
+// similarTracksArgs are the arguments of find_similar_tracks
+type similarTracksArgs struct {
+	Track          string  `json:"track"`
+	Artist         string  `json:"artist"`
+	Limit          int     `json:"limit"`
+	CompatibleKeys bool    `json:"compatibleKeys"`
+	BPMWindow      float64 `json:"bpmWindow"`
+}
+
+// SimilarTracksTool lets the model find tracks in the library that sound
+// like a given one, by the audio features of the similarity index
+func SimilarTracksTool(index *similarity.Index, store catalog.Store) Tool {
+	return Tool{
+		Name: "find_similar_tracks",
+		Description: "Find tracks in the user's library that sound like a given track, by tempo, key, energy, loudness and timbre. " +
+			"Optionally keep only harmonically compatible keys or tempos within a BPM window, e.g. for mixing.",
+		Parameters: json.RawMessage(similarTracksParameters),
+		Call: func(ctx context.Context, raw json.RawMessage) (string, string, error) {
+			var args similarTracksArgs
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return "", "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			seed, err := resolveTrack(index, store, args.Track, args.Artist)
+			if err != nil {
+				return "", "", err
+			}
+			matches, err := index.Similar(seed.ID, similarity.Options{
+				Limit:          min(args.Limit, maxSimilarTracks),
+				CompatibleKeys: args.CompatibleKeys,
+				BPMWindow:      args.BPMWindow,
+			})
+			if err != nil {
+				return "", "", err
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "Tracks that sound like %s", describeRecording(store, seed))
+			var constraints []string
+			if args.CompatibleKeys {
+				constraints = append(constraints, "in a harmonically compatible key")
+			}
+			if args.BPMWindow > 0 {
+				constraints = append(constraints, fmt.Sprintf("within %g BPM", args.BPMWindow))
+			}
+			if len(constraints) > 0 {
+				fmt.Fprintf(&b, ", %s", strings.Join(constraints, " and "))
+			}
+			b.WriteString(", most similar first:")
+			if len(matches) == 0 {
+				b.WriteString("\n- none found")
+			}
+			for i, m := range matches {
+				e, err := store.GetEntity(m.ID)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(&b, "\n%d. %s", i+1, describeRecording(store, e, fmt.Sprintf("similarity %.2f", m.Score)))
+			}
+			return "Tracks similar to " + seed.Name, b.String(), nil
+		},
+	}
+}
+
+// resolveTrack finds the analyzed recording a track title, and optionally an
+// artist, refers to
+func resolveTrack(index *similarity.Index, store catalog.Store, title, artist string) (*catalog.Entity, error) {
+	for _, m := range store.FindByName(catalog.TypeRecording, title, similarTitleScore) {
+		if !index.Has(m.Entity.ID) {
+			continue
+		}
+		if artist != "" && !containsName(performers(store, m.Entity.ID), artist) {
+			continue
+		}
+		return m.Entity, nil
+	}
+	if artist != "" {
+		return nil, fmt.Errorf("no analyzed track %q by %s in the library", title, artist)
+	}
+	return nil, fmt.Errorf("no analyzed track %q in the library", title)
+}
+
+// containsName reports whether any of names is similar to name
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if catalog.NameSimilarity(n, name) >= similarTitleScore {
+			return true
+		}
+	}
+	return false
+}
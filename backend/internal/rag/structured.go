@@ -0,0 +1,174 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// structuredRetries is the number of times the model is asked to correct a
+// reply that does not match the schema
+const structuredRetries = 2
+
+// outputSchemaURL identifies request schemas to the compiler; it is not fetched
+const outputSchemaURL = "urn:musicrag:output"
+
+var (
+	// ErrInvalidSchema is returned for an unknown output type or a schema that does not compile
+	ErrInvalidSchema = errors.New("invalid output schema")
+	// ErrInvalidOutput is returned when the model keeps replying with JSON that violates the schema
+	ErrInvalidOutput = errors.New("model output does not match the schema")
+)
+
+// OutputTypes are the named output schemas a request may ask for instead of
+// supplying its own
+var OutputTypes = map[string]json.RawMessage{
+	"tracks": json.RawMessage(`{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"properties": {
+				"title": {"type": "string"},
+				"artist": {"type": "string"},
+				"key": {"type": ["string", "null"], "description": "musical key, e.g. F minor"},
+				"bpm": {"type": ["number", "null"], "minimum": 0}
+			},
+			"required": ["title", "artist", "key", "bpm"]
+		}
+	}`),
+	"chordProgression": json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"key": {"type": "string"},
+			"timeSignature": {"type": "string"},
+			"chords": {
+				"type": "array",
+				"items": {"type": "string", "description": "chord symbol, e.g. Dm7"},
+				"minItems": 1
+			},
+			"romanNumerals": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["key", "chords"]
+	}`),
+	"albums": json.RawMessage(`{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"properties": {
+				"title": {"type": "string"},
+				"artist": {"type": "string"},
+				"year": {"type": ["integer", "null"]},
+				"label": {"type": ["string", "null"]}
+			},
+			"required": ["title", "artist", "year"]
+		}
+	}`),
+}
+
+const structuredInstructions = `Reply with a JSON object of the form {"data": <value>} and nothing else, where <value> conforms to this JSON Schema:
+%s
+Use null for values the sources do not give, unless the schema forbids it.`
+
+// outputSchema is a compiled schema for a structured answer
+type outputSchema struct {
+	raw      json.RawMessage
+	compiled *jsonschema.Schema
+}
+
+// compileOutputSchema resolves a request's schema or named output type
+func compileOutputSchema(schema json.RawMessage, outputType string) (*outputSchema, error) {
+	if outputType != "" {
+		if len(schema) > 0 {
+			return nil, fmt.Errorf("%w: give either a schema or an output type", ErrInvalidSchema)
+		}
+		var ok bool
+		if schema, ok = OutputTypes[outputType]; !ok {
+			return nil, fmt.Errorf("%w: unknown output type %q", ErrInvalidSchema, outputType)
+		}
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(noLoader{})
+	if err := compiler.AddResource(outputSchemaURL, doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	compiled, err := compiler.Compile(outputSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, schema); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	return &outputSchema{raw: compact.Bytes(), compiled: compiled}, nil
+}
+
+// noLoader refuses to load any URL, so that request schemas can only refer
+// within themselves rather than make the server read files or fetch URLs
+type noLoader struct{}
+
+func (noLoader) Load(url string) (any, error) {
+	return nil, errors.New("external references are not supported")
+}
+
+// completeStructured asks the model for JSON in JSON mode and validates the
+// reply against schema. Replies that fail to parse or validate are sent back
+// with the error for correction, up to structuredRetries times. The value is
+// wrapped in {"data": ...} because JSON mode only produces objects.
+func completeStructured(ctx context.Context, chat ChatFunc, messages []azure.ChatMessage, schema *outputSchema) (json.RawMessage, error) {
+	messages = append([]azure.ChatMessage{}, messages...)
+	messages[0].Content += "\n\n" + fmt.Sprintf(structuredInstructions, schema.raw)
+
+	var violation error
+	for attempt := 0; attempt <= structuredRetries; attempt++ {
+		reply, err := chat(ctx, messages)
+		if err != nil {
+			return nil, err
+		}
+		data, err := schema.validate(reply)
+		if err == nil {
+			return data, nil
+		}
+		violation = err
+		messages = append(messages,
+			azure.ChatMessage{Role: "assistant", Content: reply},
+			azure.ChatMessage{Role: "user", Content: fmt.Sprintf("That reply is invalid: %v\nReply again with corrected JSON only.", err)},
+		)
+	}
+	return nil, fmt.Errorf("%w after %d attempts: %v", ErrInvalidOutput, structuredRetries+1, violation)
+}
+
+// validate parses a {"data": ...} reply and checks the value against the schema
+func (s *outputSchema) validate(reply string) (json.RawMessage, error) {
+	reply = strings.TrimSpace(reply)
+	// Tolerate code fences, which some deployments add even in JSON mode
+	reply = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(reply, "```json"), "```"), "```")
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(reply), &envelope); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+	if len(envelope.Data) == 0 {
+		return nil, errors.New(`missing "data" property`)
+	}
+	value, err := jsonschema.UnmarshalJSON(bytes.NewReader(envelope.Data))
+	if err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+	if err := s.compiled.Validate(value); err != nil {
+		return nil, err
+	}
+	return envelope.Data, nil
+}
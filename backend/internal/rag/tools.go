@@ -0,0 +1,99 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+)
+
+// maxToolRounds is the number of times the model may call tools before it
+// must answer
+const maxToolRounds = 3
+
+// ToolChatFunc sends messages to the model with tools it may call, and
+// returns its reply: an answer or a request for tool calls
+type ToolChatFunc func(ctx context.Context, messages []azure.ChatMessage, tools []azure.Tool) (azure.ChatMessage, error)
+
+// Tool is a capability the model may call while answering. Its result is
+// added to the answer's sources, so the model cites it like any other.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments
+	Parameters json.RawMessage
+	// Call runs the tool and returns the title and content of its result
+	Call func(ctx context.Context, args json.RawMessage) (title, content string, err error)
+}
+
+// tools returns the pipeline's tools with the given names, or all of them
+// when names is nil
+func (p *Pipeline) tools(names []string) []Tool {
+	if names == nil {
+		return p.Tools
+	}
+	var tools []Tool
+	for _, t := range p.Tools {
+		if slices.Contains(names, t.Name) {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// completeWithTools asks the model for an answer, running the tools it calls
+// and passing their results back until it answers. Each result becomes a
+// numbered source appended to sources; failures are passed back to the model
+// as the result, so that it can explain or try otherwise.
+func (p *Pipeline) completeWithTools(ctx context.Context, tools []Tool, prompts *answerPrompt, query string, sources []Source) (string, []Source, error) {
+	messages, err := prompts.messages(query, sources)
+	if err != nil {
+		return "", sources, err
+	}
+	specs := make([]azure.Tool, 0, len(tools))
+	byName := make(map[string]Tool, len(tools))
+	for _, t := range tools {
+		specs = append(specs, azure.Tool{Type: "function", Function: azure.FunctionSpec{Name: t.Name, Description: t.Description, Parameters: t.Parameters}})
+		byName[t.Name] = t
+	}
+
+	for round := 0; ; round++ {
+		// Once the rounds are used up the model answers from the sources
+		// gathered so far, tool results included
+		if round == maxToolRounds {
+			final, err := prompts.messages(query, sources)
+			if err != nil {
+				return "", sources, err
+			}
+			completion, err := p.Chat(ctx, final)
+			return completion, sources, err
+		}
+		reply, err := p.ToolChat(ctx, messages, specs)
+		if err != nil {
+			return "", sources, err
+		}
+		if len(reply.ToolCalls) == 0 {
+			return reply.Content, sources, nil
+		}
+
+		messages = append(messages, azure.ChatMessage{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls})
+		for _, call := range reply.ToolCalls {
+			var result string
+			tool, ok := byName[call.Function.Name]
+			if !ok {
+				result = fmt.Sprintf("Error: there is no tool named %q.", call.Function.Name)
+			} else if title, content, err := tool.Call(ctx, json.RawMessage(call.Function.Arguments)); err != nil {
+				log.Printf("Tool %s failed: %v", tool.Name, err)
+				result = "Error: " + err.Error()
+			} else {
+				source := Source{ID: "tool:" + tool.Name + ":" + call.ID, Number: len(sources) + 1, Title: title, content: content}
+				sources = append(sources, source)
+				result = fmt.Sprintf("Source [%d] %s\n%s", source.Number, title, content)
+			}
+			messages = append(messages, azure.ChatMessage{Role: "tool", ToolCallID: call.ID, Content: result})
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package retrieval
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// EntityChunk renders a catalog entity as a searchable chunk. The chunk is
+// linked back to the entity so answers can be aggregated per song.
+func EntityChunk(e *catalog.Entity) Chunk {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)", e.Name, e.Type)
+	if e.Disambiguation != "" {
+		fmt.Fprintf(&b, ", %s", e.Disambiguation)
+	}
+	b.WriteString(".")
+	if len(e.Aliases) > 0 {
+		fmt.Fprintf(&b, " Also known as: %s.", strings.Join(e.Aliases, ", "))
+	}
+	switch {
+	case e.Artist != nil:
+		writeFields(&b, "Kind", e.Artist.Kind, "Country", e.Artist.Country, "Active from", e.Artist.BeginDate, "Active until", e.Artist.EndDate)
+	case e.Work != nil:
+		writeFields(&b, "Kind", e.Work.Kind, "Language", e.Work.Language)
+	case e.Recording != nil && e.Recording.DurationMs > 0:
+		d := e.Recording.DurationMs / 1000
+		fmt.Fprintf(&b, " Duration: %d:%02d.", d/60, d%60)
+	case e.Release != nil:
+		writeFields(&b, "Kind", e.Release.Kind, "Date", e.Release.Date, "Country", e.Release.Country,
+			"Label", e.Release.Label, "Catalog number", e.Release.CatalogNumber, "Format", e.Release.Format)
+	case e.Label != nil:
+		writeFields(&b, "Profile", e.Label.Profile)
+	}
+	ids := e.Identifiers
+	writeFields(&b, "ISRC", strings.Join(ids.ISRCs, ", "), "ISWC", strings.Join(ids.ISWCs, ", "),
+		"Barcode", strings.Join(ids.Barcodes, ", "), "MusicBrainz ID", ids.MBID, "Discogs ID", ids.Discogs)
+
+	return Chunk{
+		ID:         e.ID,
+		DocumentID: e.ID,
+		Title:      e.Name,
+		Content:    b.String(),
+		EntityIDs:  []string{e.ID},
+	}
+}
+
+// writeFields appends "Label: value." for each non-empty label/value pair
+func writeFields(b *strings.Builder, pairs ...string) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			fmt.Fprintf(b, " %s: %s.", pairs[i], pairs[i+1])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package retrieval
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+const (
+	// entityOverfetch widens the candidate pool so that boosting and
+	// filtering by entity still leave Top results
+	entityOverfetch = 3
+	// linkedBoost is the relative score boost of a chunk about a linked entity
+	linkedBoost = 1.0
+	// neighborBoost is the relative score boost of a chunk about a direct
+	// neighbor of a linked entity, e.g. a recording by a linked artist
+	neighborBoost = 0.5
+)
+
+// EntityRetriever boosts and optionally filters the results of another
+// retriever by the catalog entities linked in the query, and restricts them
+// to the query's collections and scope
+type EntityRetriever struct {
+	Retriever Retriever
+	Store     catalog.Store
+}
+
+func (r *EntityRetriever) Retrieve(ctx context.Context, query Query) ([]Chunk, error) {
+	if len(query.EntityIDs) == 0 && len(query.Collections) == 0 && len(query.Scope) == 0 {
+		return r.Retriever.Retrieve(ctx, query)
+	}
+	wide := query
+	if query.Top > 0 {
+		wide.Top = query.Top * entityOverfetch
+	}
+	chunks, err := r.Retriever.Retrieve(ctx, wide)
+	if err != nil {
+		return nil, err
+	}
+	chunks = r.restrict(chunks, query)
+
+	boosts := r.boosts(query.EntityIDs)
+	kept := make([]Chunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		boost := 0.0
+		for _, id := range append(r.Store.DocumentEntities(chunk.DocumentID), chunk.EntityIDs...) {
+			boost = max(boost, boosts[id])
+		}
+		if boost == 0 && query.FilterEntities {
+			continue
+		}
+		chunk.Score *= 1 + boost
+		kept = append(kept, chunk)
+	}
+	if len(kept) == 0 && len(chunks) > 0 {
+		log.Printf("No chunks about linked entities %v, falling back to unfiltered results", query.EntityIDs)
+		kept = chunks
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Score > kept[j].Score })
+	if query.Top > 0 && len(kept) > query.Top {
+		kept = kept[:query.Top]
+	}
+	return kept, nil
+}
+
+// restrict drops the chunks outside the query's collections and scope
+func (r *EntityRetriever) restrict(chunks []Chunk, query Query) []Chunk {
+	if len(query.Collections) == 0 && len(query.Scope) == 0 {
+		return chunks
+	}
+	scope := r.boosts(query.Scope)
+	kept := make([]Chunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if len(query.Collections) > 0 && !slices.Contains(query.Collections, chunk.Source) {
+			continue
+		}
+		if len(scope) > 0 && !slices.ContainsFunc(append(r.Store.DocumentEntities(chunk.DocumentID), chunk.EntityIDs...), func(id string) bool { return scope[id] > 0 }) {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+	return kept
+}
+
+// boosts returns the score boost of each entity related to the linked ones
+func (r *EntityRetriever) boosts(entityIDs []string) map[string]float64 {
+	boosts := make(map[string]float64)
+	for _, id := range entityIDs {
+		boosts[id] = linkedBoost
+		for _, rel := range r.Store.ListRelations(id) {
+			other := rel.TargetID
+			if other == id {
+				other = rel.SourceID
+			}
+			boosts[other] = max(boosts[other], neighborBoost)
+		}
+	}
+	return boosts
+}
@@ -0,0 +1,141 @@
+package retrieval
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 tuning parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// LocalIndex is an in-memory BM25 keyword index
+type LocalIndex struct {
+	name     string
+	mu       sync.RWMutex
+	chunks   map[string]Chunk
+	terms    map[string][]string       // chunk ID -> indexed terms
+	postings map[string]map[string]int // term -> chunk ID -> term frequency
+	totalLen int
+}
+
+// NewLocalIndex creates an empty index; name is reported as the Source of its chunks
+func NewLocalIndex(name string) *LocalIndex {
+	return &LocalIndex{
+		name:     name,
+		chunks:   make(map[string]Chunk),
+		terms:    make(map[string][]string),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// Tokenize lower-cases text and splits it into letter and digit runs
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Add indexes a chunk, replacing any chunk with the same ID
+func (idx *LocalIndex) Add(chunk Chunk) {
+	chunk.Source = idx.name
+	tokens := Tokenize(chunk.Title + " " + chunk.Content)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(chunk.ID)
+	idx.chunks[chunk.ID] = chunk
+	idx.terms[chunk.ID] = tokens
+	idx.totalLen += len(tokens)
+	for _, t := range tokens {
+		if idx.postings[t] == nil {
+			idx.postings[t] = make(map[string]int)
+		}
+		idx.postings[t][chunk.ID]++
+	}
+}
+
+// Remove drops a chunk from the index
+func (idx *LocalIndex) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+// Len returns the number of indexed chunks
+func (idx *LocalIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.chunks)
+}
+
+// remove unindexes a chunk. Callers must hold the write lock.
+func (idx *LocalIndex) remove(id string) {
+	tokens, ok := idx.terms[id]
+	if !ok {
+		return
+	}
+	for _, t := range tokens {
+		delete(idx.postings[t], id)
+		if len(idx.postings[t]) == 0 {
+			delete(idx.postings, t)
+		}
+	}
+	idx.totalLen -= len(tokens)
+	delete(idx.terms, id)
+	delete(idx.chunks, id)
+}
+
+func (idx *LocalIndex) Retrieve(ctx context.Context, query Query) ([]Chunk, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	n := len(idx.chunks)
+	if n == 0 {
+		return nil, nil
+	}
+	avgLen := float64(idx.totalLen) / float64(n)
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, t := range Tokenize(query.Text) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		postings := idx.postings[t]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(n)-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for id, tf := range postings {
+			docLen := float64(len(idx.terms[id]))
+			f := float64(tf)
+			scores[id] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*docLen/avgLen))
+		}
+	}
+
+	results := make([]Chunk, 0, len(scores))
+	for id, score := range scores {
+		chunk := idx.chunks[id]
+		chunk.Score = score
+		results = append(results, chunk)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if query.Top > 0 && len(results) > query.Top {
+		results = results[:query.Top]
+	}
+	return results, nil
+}
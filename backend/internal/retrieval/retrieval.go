@@ -0,0 +1,208 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Chunk is a retrieved passage of text with its provenance
+type Chunk struct {
+	ID         string   `json:"id"`
+	DocumentID string   `json:"documentId"`
+	Title      string   `json:"title,omitempty"`
+	Content    string   `json:"content"`
+	EntityIDs  []string `json:"entityIds,omitempty"`
+	Locator    *Locator `json:"locator,omitempty"`
+	Source     string   `json:"source"` // name of the retriever that produced the chunk
+	Score      float64  `json:"score"`
+}
+
+// Locator points at the position of a chunk within its document
+type Locator struct {
+	Page      int    `json:"page,omitempty"`
+	Measures  string `json:"measures,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// String renders the locator for people, e.g. "page 3, measures 17-24"
+func (l *Locator) String() string {
+	var parts []string
+	if l.Page > 0 {
+		parts = append(parts, fmt.Sprintf("page %d", l.Page))
+	}
+	if l.Measures != "" {
+		parts = append(parts, "measures "+l.Measures)
+	}
+	if l.Timestamp != "" {
+		parts = append(parts, "at "+l.Timestamp)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Query describes a retrieval request
+type Query struct {
+	Text string
+	Top  int
+
+	// EntityIDs are catalog entities linked in the query text. Chunks about
+	// them, or about their direct neighbors, are ranked higher.
+	EntityIDs []string
+	// FilterEntities drops chunks unrelated to EntityIDs, unless that would
+	// leave no results at all
+	FilterEntities bool
+
+	// Collections keeps only the chunks of these sources, e.g. azure for the
+	// document index or catalog; empty for all
+	Collections []string
+	// Scope keeps only the chunks about these catalog entities or their
+	// direct neighbors, e.g. a label and its roster. Unlike FilterEntities it
+	// never falls back to unrelated chunks.
+	Scope []string
+}
+
+// Retriever returns the chunks most relevant to a query, best first
+type Retriever interface {
+	Retrieve(ctx context.Context, query Query) ([]Chunk, error)
+}
+
+var (
+	// CatalogIndex holds one searchable chunk per catalog entity
+	CatalogIndex *LocalIndex
+
+	// RetrieverInstance is the retriever used by the chat pipeline
+	RetrieverInstance Retriever
+
+	// KeywordRetrieverInstance searches the Azure index alone, without
+	// fusing in the catalog index
+	KeywordRetrieverInstance Retriever
+)
+
+// Init builds the catalog index, keeps it in sync with the catalog store and
+// combines it with the Azure search index. It must run after azure.Init and catalog.Init.
+func Init() {
+	CatalogIndex = NewLocalIndex("catalog")
+	entities, _ := catalog.StoreInstance.ListEntities(catalog.ListFilter{})
+	for _, e := range entities {
+		CatalogIndex.Add(EntityChunk(e))
+	}
+	catalog.StoreInstance.Subscribe(func(e *catalog.Entity, deleted bool) {
+		if deleted {
+			CatalogIndex.Remove(e.ID)
+			return
+		}
+		CatalogIndex.Add(EntityChunk(e))
+	})
+
+	RetrieverInstance = &EntityRetriever{
+		Retriever: NewFusion(&AzureRetriever{Client: azure.SearchClientInstance}, CatalogIndex),
+		Store:     catalog.StoreInstance,
+	}
+	KeywordRetrieverInstance = &EntityRetriever{
+		Retriever: &AzureRetriever{Client: azure.SearchClientInstance},
+		Store:     catalog.StoreInstance,
+	}
+	log.Printf("Catalog index built with %d entities", len(entities))
+}
+
+// AzureRetriever adapts the Azure search index to the Retriever interface
+type AzureRetriever struct {
+	Client *azure.SearchClient
+}
+
+func (r *AzureRetriever) Retrieve(ctx context.Context, query Query) ([]Chunk, error) {
+	results, err := r.Client.Search(ctx, query.Text, query.Top)
+	if err != nil {
+		return nil, fmt.Errorf("azure search failed: %w", err)
+	}
+	chunks := make([]Chunk, 0, len(results))
+	for _, res := range results {
+		var locator *Locator
+		if res.Page > 0 || res.Measures != "" || res.Timestamp != "" {
+			locator = &Locator{Page: res.Page, Measures: res.Measures, Timestamp: res.Timestamp}
+		}
+		chunks = append(chunks, Chunk{
+			ID:         res.ID,
+			DocumentID: res.DocumentID,
+			Title:      res.Title,
+			Content:    res.Content,
+			EntityIDs:  res.EntityIDs,
+			Locator:    locator,
+			Source:     "azure",
+			Score:      res.Score,
+		})
+	}
+	return chunks, nil
+}
+
+// Fusion queries several retrievers and merges their rankings with
+// reciprocal rank fusion, since their raw scores are not comparable
+type Fusion struct {
+	retrievers []Retriever
+}
+
+// rrfK dampens the influence of top ranks in reciprocal rank fusion
+const rrfK = 60
+
+// NewFusion creates a retriever that merges the results of retrievers
+func NewFusion(retrievers ...Retriever) *Fusion {
+	return &Fusion{retrievers: retrievers}
+}
+
+func (f *Fusion) Retrieve(ctx context.Context, query Query) ([]Chunk, error) {
+	var rankings [][]Chunk
+	var failures []error
+	for _, r := range f.retrievers {
+		results, err := r.Retrieve(ctx, query)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		rankings = append(rankings, results)
+	}
+	// Only fail when every retriever failed; partial results are still useful
+	if len(failures) > 0 && len(failures) == len(f.retrievers) {
+		return nil, failures[0]
+	}
+	for _, err := range failures {
+		log.Printf("Retriever failed, continuing with partial results: %v", err)
+	}
+	return FuseRankings(rankings, query.Top), nil
+}
+
+// FuseRankings merges several rankings of chunks with reciprocal rank fusion
+// and returns the top results. A chunk is identified by its source and ID.
+func FuseRankings(rankings [][]Chunk, top int) []Chunk {
+	scores := make(map[string]float64)
+	chunks := make(map[string]Chunk)
+	for _, ranking := range rankings {
+		for rank, chunk := range ranking {
+			key := chunk.Source + "/" + chunk.ID
+			scores[key] += 1.0 / float64(rrfK+rank+1)
+			if _, ok := chunks[key]; !ok {
+				chunks[key] = chunk
+			}
+		}
+	}
+
+	merged := make([]Chunk, 0, len(chunks))
+	for key, chunk := range chunks {
+		chunk.Score = scores[key]
+		merged = append(merged, chunk)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Score != merged[j].Score {
+			return merged[i].Score > merged[j].Score
+		}
+		return merged[i].ID < merged[j].ID
+	})
+	if top > 0 && len(merged) > top {
+		merged = merged[:top]
+	}
+	return merged
+}
@@ -0,0 +1,82 @@
+package setlist
+
+import "github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+
+// Harmonic rule sets for consecutive songs
+const (
+	// HarmonicCamelot allows the same key, the relative major or minor and a
+	// step either way around the Camelot wheel
+	HarmonicCamelot = "camelot"
+	// HarmonicRelaxed also allows energy boosts two steps up and diagonal moves
+	HarmonicRelaxed = "relaxed"
+	// HarmonicNone allows any key change, though clashes still cost more
+	HarmonicNone = "none"
+)
+
+// KeyRelation describes how the key of one song relates to the next
+type KeyRelation string
+
+const (
+	KeySame     KeyRelation = "same"
+	KeyRelative KeyRelation = "relative"
+	KeyUp       KeyRelation = "up-fifth"
+	KeyDown     KeyRelation = "down-fifth"
+	KeyBoost    KeyRelation = "boost"
+	KeyDiagonal KeyRelation = "diagonal"
+	KeyClash    KeyRelation = "clash"
+	KeyUnknown  KeyRelation = "unknown"
+)
+
+// relate returns how key b follows key a on the wheel
+func relate(a, b catalog.Key) KeyRelation {
+	step := (b.Number - a.Number + 12) % 12
+	switch {
+	case a == b:
+		return KeySame
+	case step == 0:
+		return KeyRelative
+	case a.Minor == b.Minor && step == 1:
+		return KeyUp
+	case a.Minor == b.Minor && step == 11:
+		return KeyDown
+	case a.Minor == b.Minor && step == 2:
+		return KeyBoost
+	case step == 1 || step == 11:
+		return KeyDiagonal
+	}
+	return KeyClash
+}
+
+// allowed reports whether rules permit a relation between consecutive songs
+func allowed(rules string, relation KeyRelation) bool {
+	switch relation {
+	case KeySame, KeyRelative, KeyUp, KeyDown, KeyUnknown:
+		return true
+	case KeyBoost, KeyDiagonal:
+		return rules != HarmonicCamelot
+	}
+	return rules == HarmonicNone
+}
+
+// describe explains a key relation in words for transition notes
+func describe(relation KeyRelation) string {
+	switch relation {
+	case KeySame:
+		return "stays in the same key"
+	case KeyRelative:
+		return "moves to the relative major or minor, a smooth change of mood"
+	case KeyUp:
+		return "steps up a fifth on the Camelot wheel, lifting the energy"
+	case KeyDown:
+		return "steps down a fifth on the Camelot wheel, easing off"
+	case KeyBoost:
+		return "jumps two steps up the wheel for an energy boost"
+	case KeyDiagonal:
+		return "moves diagonally on the wheel, a mood change with a shared chord"
+	case KeyClash:
+		return "changes to an unrelated key, so a break or count-in helps"
+	case KeyUnknown:
+		return "is unknown on one side, so harmony was not checked"
+	}
+	return string(relation)
+}
@@ -0,0 +1,740 @@
+// Package setlist plans live sets from catalog recordings that have a
+// duration, key and tempo, fitting a target length and energy curve while
+// keeping consecutive songs harmonically compatible.
+package setlist
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+const (
+	// defaultToleranceMinutes is how far the set may run over or under the target
+	defaultToleranceMinutes = 5
+	// defaultMaxBPMChange is the largest tempo jump allowed between consecutive songs
+	defaultMaxBPMChange = 12
+	// maxTracks bounds the songs a set is planned from, since every pair of
+	// them is compared before the search
+	maxTracks = 500
+	// beamWidth is the number of partial sets kept at each position
+	beamWidth = 200
+	// latenessWeight is the rank penalty per song a set is behind on its pins
+	latenessWeight = 2
+	// perEnding is the number of kept sets guaranteed to each last song
+	perEnding = 3
+	// energyWeight scales the squared distance from the energy curve
+	energyWeight = 4
+	// bpmWeight is the cost of a tempo change as large as the allowed maximum
+	bpmWeight = 0.5
+)
+
+// Pin positions with a special meaning
+const (
+	// PinAnywhere includes the song wherever it fits best
+	PinAnywhere = 0
+	// PinClosing makes the song the last of the set
+	PinClosing = -1
+)
+
+// ErrNoPlan is returned when no set satisfies the constraints
+var ErrNoPlan = errors.New("no setlist satisfies the constraints")
+
+// keyCosts is the cost of each key relation between consecutive songs;
+// relations that the harmonic rules forbid are never used
+var keyCosts = map[KeyRelation]float64{
+	KeyUp:       0,
+	KeyDown:     0,
+	KeyRelative: 0.1,
+	KeyBoost:    0.2,
+	KeySame:     0.3,
+	KeyDiagonal: 0.3,
+	KeyUnknown:  0.5,
+	KeyClash:    1,
+}
+
+// energyCurves are the named energy shapes; the points are spread evenly over
+// the set and interpolated linearly
+var energyCurves = map[string][]float64{
+	"flat":   {0.6},
+	"rising": {0.3, 0.9},
+	"peak":   {0.4, 0.7, 0.95, 0.6},
+	"upbeat": {0.85, 0.6, 0.7, 0.95},
+	"wave":   {0.5, 0.85, 0.5, 0.85, 0.6},
+}
+
+// Pin requires a song in the set. Position is the 1-based slot it must take,
+// PinAnywhere or PinClosing.
+type Pin struct {
+	TrackID  string `json:"trackId"`
+	Position int    `json:"position,omitempty"`
+}
+
+// Request describes the set to plan
+type Request struct {
+	TargetMinutes    float64 `json:"targetMinutes"`
+	ToleranceMinutes float64 `json:"toleranceMinutes,omitempty"`
+	// EnergyCurve names a curve shape: flat, rising, peak (default), upbeat or
+	// wave. EnergyPoints gives a custom curve instead.
+	EnergyCurve  string    `json:"energyCurve,omitempty"`
+	EnergyPoints []float64 `json:"energyPoints,omitempty"`
+	// Harmonic is the key rule set: camelot (default), relaxed or none
+	Harmonic     string  `json:"harmonic,omitempty"`
+	AvoidSameKey bool    `json:"avoidSameKey,omitempty"` // never play two songs in the same key back to back
+	MaxBPMChange float64 `json:"maxBpmChange,omitempty"`
+	// ArtistID and TrackIDs restrict the songs considered, e.g. to a band's repertoire
+	ArtistID string   `json:"artistId,omitempty"`
+	TrackIDs []string `json:"trackIds,omitempty"`
+	Pinned   []Pin    `json:"pinned,omitempty"`
+}
+
+// Track is a recording as seen by the planner
+type Track struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist,omitempty"`
+	DurationMs int64   `json:"durationMs"`
+	Key        string  `json:"key,omitempty"`
+	Camelot    string  `json:"camelot,omitempty"`
+	BPM        float64 `json:"bpm,omitempty"`
+	Energy     float64 `json:"energy"`
+	// EnergyEstimated is set when the catalog has no energy and it was derived from the tempo
+	EnergyEstimated bool `json:"energyEstimated,omitempty"`
+
+	key *catalog.Key
+}
+
+// Slot is a song at its place in the set
+type Slot struct {
+	Position     int     `json:"position"`
+	Track        *Track  `json:"track"`
+	StartMs      int64   `json:"startMs"`
+	TargetEnergy float64 `json:"targetEnergy"`
+	Pinned       bool    `json:"pinned,omitempty"`
+}
+
+// Transition explains the change from one slot to the next
+type Transition struct {
+	From         int         `json:"from"`
+	To           int         `json:"to"`
+	KeyRelation  KeyRelation `json:"keyRelation"`
+	BPMChange    float64     `json:"bpmChange"`
+	EnergyChange float64     `json:"energyChange"`
+	Explanation  string      `json:"explanation"`
+}
+
+// Plan is a planned set
+type Plan struct {
+	Slots       []Slot       `json:"slots"`
+	Transitions []Transition `json:"transitions"`
+	TotalMs     int64        `json:"totalMs"`
+	TargetMs    int64        `json:"targetMs"`
+	Cost        float64      `json:"cost"` // lower is better
+	// Exhaustive is false when the search had to drop candidate sets, so a
+	// better plan may exist
+	Exhaustive bool `json:"exhaustive"`
+}
+
+// Planner plans sets from catalog recordings
+type Planner struct {
+	store catalog.Store
+}
+
+// PlannerInstance is the planner over the shared catalog store
+var PlannerInstance *Planner
+
+// Init creates the planner over the catalog store. It must run after catalog.Init.
+func Init() {
+	PlannerInstance = New(catalog.StoreInstance)
+}
+
+// New creates a planner over store
+func New(store catalog.Store) *Planner {
+	return &Planner{store: store}
+}
+
+// Plan searches for the set that best follows the energy curve with the
+// smoothest transitions. Songs are only considered when the catalog has their
+// duration, key and tempo; pinned songs need a duration only.
+func (p *Planner) Plan(req Request) (*Plan, error) {
+	s, err := p.newSearch(req)
+	if err != nil {
+		return nil, err
+	}
+	s.run()
+	if s.best == nil {
+		return nil, fmt.Errorf("%w: try a longer tolerance, fewer pins or relaxed harmonic rules", ErrNoPlan)
+	}
+	return s.plan(), nil
+}
+
+// search is the state of a beam search over song orders
+type search struct {
+	rules        string
+	avoidSameKey bool
+	maxBPMChange float64
+	curve        []float64
+	targetMs     int64
+	minMs, maxMs int64
+
+	pool     []*Track
+	slotPins map[int]*Track // 0-based position -> song
+	anywhere []*Track
+	closer   *Track
+	// before lists the songs allowed to precede each song
+	before     map[*Track][]*Track
+	shortestMs int64
+	meanMs     int64
+	pins       []*Track
+	pinnedByID map[string]bool
+
+	best     []*Track
+	bestCost float64
+	pruned   bool // whether the beam ever dropped candidate sets
+}
+
+func (p *Planner) newSearch(req Request) (*search, error) {
+	if req.TargetMinutes <= 0 {
+		return nil, fmt.Errorf("%w: targetMinutes must be positive", catalog.ErrInvalid)
+	}
+	if len(req.TrackIDs) > maxTracks {
+		return nil, fmt.Errorf("%w: at most %d trackIds are allowed", catalog.ErrInvalid, maxTracks)
+	}
+	tolerance := req.ToleranceMinutes
+	if tolerance <= 0 {
+		tolerance = defaultToleranceMinutes
+	}
+	s := &search{
+		rules:        req.Harmonic,
+		avoidSameKey: req.AvoidSameKey,
+		maxBPMChange: req.MaxBPMChange,
+		targetMs:     minutes(req.TargetMinutes),
+		minMs:        minutes(max(req.TargetMinutes-tolerance, 0)),
+		maxMs:        minutes(req.TargetMinutes + tolerance),
+		slotPins:     make(map[int]*Track),
+		pinnedByID:   make(map[string]bool),
+		bestCost:     math.Inf(1),
+	}
+	if s.rules == "" {
+		s.rules = HarmonicCamelot
+	}
+	if s.rules != HarmonicCamelot && s.rules != HarmonicRelaxed && s.rules != HarmonicNone {
+		return nil, fmt.Errorf("%w: unknown harmonic rules %q", catalog.ErrInvalid, s.rules)
+	}
+	if s.maxBPMChange <= 0 {
+		s.maxBPMChange = defaultMaxBPMChange
+	}
+
+	s.curve = req.EnergyPoints
+	if len(s.curve) == 0 {
+		name := req.EnergyCurve
+		if name == "" {
+			name = "peak"
+		}
+		var ok bool
+		if s.curve, ok = energyCurves[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown energy curve %q", catalog.ErrInvalid, name)
+		}
+	}
+	for _, e := range s.curve {
+		if e < 0 || e > 1 {
+			return nil, fmt.Errorf("%w: energy points must be between 0 and 1", catalog.ErrInvalid)
+		}
+	}
+
+	for _, pin := range req.Pinned {
+		if s.pinnedByID[pin.TrackID] {
+			return nil, fmt.Errorf("%w: %s is pinned twice", catalog.ErrInvalid, pin.TrackID)
+		}
+		entity, err := p.store.GetEntity(pin.TrackID)
+		if err != nil {
+			return nil, err
+		}
+		track := p.track(entity)
+		if track == nil || track.DurationMs == 0 {
+			return nil, fmt.Errorf("%w: pinned %s is not a recording with a duration", catalog.ErrInvalid, pin.TrackID)
+		}
+		s.pinnedByID[pin.TrackID] = true
+		s.pins = append(s.pins, track)
+		switch {
+		case pin.Position == PinClosing && s.closer != nil:
+			return nil, fmt.Errorf("%w: only one song can close the set", catalog.ErrInvalid)
+		case pin.Position == PinClosing:
+			s.closer = track
+		case pin.Position == PinAnywhere:
+			s.anywhere = append(s.anywhere, track)
+			s.pool = append(s.pool, track)
+		case pin.Position > 0 && s.slotPins[pin.Position-1] == nil:
+			s.slotPins[pin.Position-1] = track
+		default:
+			return nil, fmt.Errorf("%w: invalid position %d for %s", catalog.ErrInvalid, pin.Position, pin.TrackID)
+		}
+	}
+
+	for _, track := range p.candidates(req) {
+		if !s.pinnedByID[track.ID] {
+			s.pool = append(s.pool, track)
+		}
+	}
+	if n := len(s.pool) + len(s.pins); n > maxTracks {
+		return nil, fmt.Errorf("%w: %d songs qualify, at most %d can be planned from; narrow them with artistId or trackIds", catalog.ErrInvalid, n, maxTracks)
+	}
+	// Deterministic order so that equal costs always give the same plan
+	sort.Slice(s.pool, func(i, j int) bool { return s.pool[i].ID < s.pool[j].ID })
+	s.link()
+	return s, nil
+}
+
+// candidates returns the recordings the request allows that have a
+// duration, a recognizable key and a tempo
+func (p *Planner) candidates(req Request) []*Track {
+	var entities []*catalog.Entity
+	if len(req.TrackIDs) > 0 {
+		for _, id := range req.TrackIDs {
+			if e, err := p.store.GetEntity(id); err == nil {
+				entities = append(entities, e)
+			}
+		}
+	} else if req.ArtistID != "" {
+		// The artist's relations are far fewer than the catalog's recordings
+		for _, r := range p.store.ListRelations(req.ArtistID) {
+			if r.Type != catalog.RelPerformedBy || r.TargetID != req.ArtistID {
+				continue
+			}
+			if e, err := p.store.GetEntity(r.SourceID); err == nil {
+				entities = append(entities, e)
+			}
+		}
+	} else {
+		entities, _ = p.store.ListEntities(catalog.ListFilter{Type: catalog.TypeRecording})
+	}
+
+	var tracks []*Track
+	for _, e := range entities {
+		if req.ArtistID != "" && !p.performedBy(e.ID, req.ArtistID) {
+			continue
+		}
+		if t := p.track(e); t != nil && t.DurationMs > 0 && t.key != nil && t.BPM > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+func (p *Planner) performedBy(recordingID, artistID string) bool {
+	for _, r := range p.store.ListRelations(recordingID) {
+		if r.Type == catalog.RelPerformedBy && r.SourceID == recordingID && r.TargetID == artistID {
+			return true
+		}
+	}
+	return false
+}
+
+// track converts a recording entity, returning nil for other entities
+func (p *Planner) track(e *catalog.Entity) *Track {
+	if e.Type != catalog.TypeRecording || e.Recording == nil {
+		return nil
+	}
+	r := e.Recording
+	t := &Track{ID: e.ID, Title: e.Name, DurationMs: r.DurationMs, Key: r.Key, BPM: r.BPM, Energy: r.Energy}
+	if key, err := catalog.ParseKey(r.Key); err == nil {
+		t.key = &key
+		t.Camelot = key.Camelot()
+	}
+	if t.Energy == 0 && t.BPM > 0 {
+		// Without an energy rating, faster songs are assumed to be more intense
+		t.Energy = min(max((t.BPM-60)/120, 0.1), 1)
+		t.EnergyEstimated = true
+	}
+	for _, rel := range p.store.ListRelations(e.ID) {
+		if rel.Type == catalog.RelPerformedBy && rel.SourceID == e.ID {
+			if artist, err := p.store.GetEntity(rel.TargetID); err == nil {
+				t.Artist = artist.Name
+				break
+			}
+		}
+	}
+	return t
+}
+
+// partial is a set under construction
+type partial struct {
+	path    []*Track
+	elapsed int64
+	cost    float64
+	rank    float64 // cost plus how far behind the set is on its way to the closer
+}
+
+func (b *partial) last() *Track {
+	if len(b.path) == 0 {
+		return nil
+	}
+	return b.path[len(b.path)-1]
+}
+
+func (b *partial) has(t *Track) bool {
+	return slices.Contains(b.path, t)
+}
+
+// run builds sets one position at a time. Every allowed next song extends
+// every kept set; of the extensions only the beamWidth cheapest survive, so
+// many different set orders stay in play until the target length is reached.
+func (s *search) run() {
+	beam := []*partial{{}}
+	for len(beam) > 0 {
+		var next []*partial
+		seen := make(map[string]bool)
+		for _, b := range beam {
+			s.finish(b)
+			for _, ext := range s.extend(b) {
+				// Sets with the same songs ending on the same song continue
+				// identically, so only the cheapest order is kept
+				key := setKey(ext)
+				if !seen[key] {
+					seen[key] = true
+					next = append(next, ext)
+				}
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool { return next[i].rank < next[j].rank })
+		s.pruned = s.pruned || len(next) > beamWidth
+		beam = diverse(next, beamWidth)
+	}
+}
+
+// diverse keeps the width best ranked sets, but first up to perEnding sets
+// ending on each song. Otherwise the beam fills with near-identical cheap
+// sets, and songs that are only needed late, such as the few that can precede
+// a pinned closer, drop out.
+func diverse(sets []*partial, width int) []*partial {
+	if len(sets) <= width {
+		return sets
+	}
+	kept := make([]*partial, 0, width)
+	taken := make([]bool, len(sets))
+	endings := make(map[*Track]int)
+	for i, b := range sets {
+		if len(kept) < width && endings[b.last()] < perEnding {
+			endings[b.last()]++
+			kept = append(kept, b)
+			taken[i] = true
+		}
+	}
+	for i, b := range sets {
+		if len(kept) < width && !taken[i] {
+			kept = append(kept, b)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].rank < kept[j].rank })
+	return kept
+}
+
+// extend returns b followed by each song that is allowed next and leaves
+// room for the pinned songs still to come
+func (s *search) extend(b *partial) []*partial {
+	reserve := int64(0)
+	for _, t := range s.pins {
+		if t != s.closer && !b.has(t) {
+			reserve += t.DurationMs
+		}
+	}
+	if s.closer != nil {
+		reserve += s.closer.DurationMs
+	}
+
+	var options []*Track
+	if pinned := s.slotPins[len(b.path)]; pinned != nil {
+		options = []*Track{pinned}
+	} else {
+		options = s.pool
+	}
+	goals := s.goals(b)
+	var extended []*partial
+	for _, t := range options {
+		if b.has(t) {
+			continue
+		}
+		need := reserve
+		if s.pinnedByID[t.ID] {
+			need -= t.DurationMs
+		}
+		if b.elapsed+t.DurationMs+need > s.maxMs {
+			continue
+		}
+		c, ok := s.step(b.last(), t, b.elapsed)
+		if !ok || b.cost+c >= s.bestCost || !s.inReach(goals, b, t, need) {
+			continue
+		}
+		ext := &partial{
+			path:    append(slices.Clip(b.path), t),
+			elapsed: b.elapsed + t.DurationMs,
+			cost:    b.cost + c,
+		}
+		ext.rank = ext.cost + s.lateness(goals, ext, need)
+		extended = append(extended, ext)
+	}
+	return extended
+}
+
+// link records which songs may precede each song, for finding paths to the
+// pinned songs
+func (s *search) link() {
+	songs := append(slices.Clone(s.pool), slices.Collect(maps.Values(s.slotPins))...)
+	targets := songs
+	if s.closer != nil {
+		targets = append(slices.Clip(songs), s.closer)
+	}
+	s.before = make(map[*Track][]*Track)
+	for _, u := range targets {
+		for _, t := range songs {
+			if _, ok := s.transition(t, u); ok && t != u {
+				s.before[u] = append(s.before[u], t)
+			}
+		}
+	}
+	s.shortestMs = math.MaxInt64
+	for _, t := range songs {
+		s.shortestMs = min(s.shortestMs, t.DurationMs)
+		s.meanMs += t.DurationMs / int64(len(songs))
+	}
+}
+
+// goal is a pinned song that a set has yet to reach, with how many
+// transitions each unused song is from it
+type goal struct {
+	song *Track
+	dist map[*Track]int
+}
+
+// goals returns the anywhere pins b has not placed and the closer
+func (s *search) goals(b *partial) []goal {
+	var goals []goal
+	for _, t := range s.anywhere {
+		if !b.has(t) {
+			goals = append(goals, goal{song: t, dist: s.distances(b, t)})
+		}
+	}
+	if s.closer != nil {
+		goals = append(goals, goal{song: s.closer, dist: s.distances(b, s.closer)})
+	}
+	return goals
+}
+
+// distances finds how many transitions each song not yet in b is from
+// target, by breadth-first search backwards from target through unused
+// songs. Songs missing from the result cannot reach it.
+func (s *search) distances(b *partial, target *Track) map[*Track]int {
+	dist := map[*Track]int{target: 0}
+	frontier := []*Track{target}
+	for d := 1; len(frontier) > 0; d++ {
+		var next []*Track
+		for _, u := range frontier {
+			for _, t := range s.before[u] {
+				if _, seen := dist[t]; !seen && !b.has(t) {
+					dist[t] = d
+					next = append(next, t)
+				}
+			}
+		}
+		frontier = next
+	}
+	return dist
+}
+
+// inReach reports whether t, following b, can still lead into every goal in
+// the time left: no more songs may lie between them than fit. Without this
+// check the beam fills with sets that have used up the songs on the way to a
+// pinned song.
+func (s *search) inReach(goals []goal, b *partial, t *Track, reserve int64) bool {
+	left := s.maxMs - b.elapsed - t.DurationMs - reserve
+	for _, g := range goals {
+		d, ok := g.dist[t]
+		if !ok {
+			return false
+		}
+		if int64(d-1) > left/s.shortestMs {
+			return false
+		}
+	}
+	return true
+}
+
+// lateness penalizes sets that fall behind on their pins. Anywhere pins
+// still to be placed count more the further the last song is from them and
+// the further the set has progressed, and songs between the last song and
+// the closer count beyond the number of average songs that fit in the time
+// left. Without it cheap sets drift away from songs that suit the energy
+// curve poorly, until no path leads back to them.
+func (s *search) lateness(goals []goal, b *partial, reserve int64) float64 {
+	progress := float64(b.elapsed) / float64(s.targetMs)
+	late := 0.0
+	for _, g := range goals {
+		d := g.dist[b.last()]
+		if g.song != s.closer {
+			late += progress * float64(d)
+			continue
+		}
+		left := float64(s.maxMs-b.elapsed-reserve) / float64(s.meanMs)
+		late += max(float64(d-1)-left, 0)
+	}
+	return latenessWeight * late
+}
+
+// finish records b, followed by the closing song if any, when it is a
+// complete set within the target length that beats the best found so far
+func (s *search) finish(b *partial) {
+	for position := range s.slotPins {
+		if position >= len(b.path) {
+			return
+		}
+	}
+	for _, t := range s.anywhere {
+		if !b.has(t) {
+			return
+		}
+	}
+	path, elapsed, cost := b.path, b.elapsed, b.cost
+	if s.closer != nil {
+		c, ok := s.step(b.last(), s.closer, elapsed)
+		if !ok {
+			return
+		}
+		path = append(slices.Clip(path), s.closer)
+		elapsed += s.closer.DurationMs
+		cost += c
+	}
+	if len(path) == 0 || elapsed < s.minMs || elapsed > s.maxMs || cost >= s.bestCost {
+		return
+	}
+	s.best, s.bestCost = path, cost
+}
+
+func setKey(b *partial) string {
+	ids := make([]string, 0, len(b.path))
+	for _, t := range b.path[:len(b.path)-1] {
+		ids = append(ids, t.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",") + ">" + b.last().ID
+}
+
+// step returns the cost of playing t after prev, starting at elapsed, and
+// whether the transition is allowed at all
+func (s *search) step(prev, t *Track, elapsed int64) (float64, bool) {
+	cost := energyWeight * math.Pow(t.Energy-s.targetEnergy(elapsed+t.DurationMs/2), 2)
+	if prev == nil {
+		return cost, true
+	}
+	transition, ok := s.transition(prev, t)
+	return cost + transition, ok
+}
+
+// transition returns the cost of following prev with t and whether the key
+// and tempo rules allow it
+func (s *search) transition(prev, t *Track) (float64, bool) {
+	relation := keyRelation(prev, t)
+	if !allowed(s.rules, relation) || (s.avoidSameKey && relation == KeySame) {
+		return 0, false
+	}
+	cost := keyCosts[relation]
+	if prev.BPM > 0 && t.BPM > 0 {
+		change := math.Abs(t.BPM - prev.BPM)
+		if change > s.maxBPMChange {
+			return 0, false
+		}
+		cost += bpmWeight * change / s.maxBPMChange
+	}
+	return cost, true
+}
+
+// targetEnergy interpolates the energy curve at a point in the set
+func (s *search) targetEnergy(atMs int64) float64 {
+	if len(s.curve) == 1 {
+		return s.curve[0]
+	}
+	x := min(max(float64(atMs)/float64(s.targetMs), 0), 1) * float64(len(s.curve)-1)
+	i := min(int(x), len(s.curve)-2)
+	return s.curve[i] + (s.curve[i+1]-s.curve[i])*(x-float64(i))
+}
+
+// plan lays out the best path found with explained transitions
+func (s *search) plan() *Plan {
+	plan := &Plan{
+		Slots:       []Slot{},
+		Transitions: []Transition{},
+		TargetMs:    s.targetMs,
+		Cost:        math.Round(s.bestCost*1000) / 1000,
+		Exhaustive:  !s.pruned,
+	}
+	for i, t := range s.best {
+		slot := Slot{
+			Position:     i + 1,
+			Track:        t,
+			StartMs:      plan.TotalMs,
+			TargetEnergy: math.Round(s.targetEnergy(plan.TotalMs+t.DurationMs/2)*100) / 100,
+			Pinned:       s.pinnedByID[t.ID],
+		}
+		plan.TotalMs += t.DurationMs
+		if i > 0 {
+			plan.Transitions = append(plan.Transitions, explain(plan.Slots[i-1], slot))
+		}
+		plan.Slots = append(plan.Slots, slot)
+	}
+	return plan
+}
+
+// explain describes why one song follows another
+func explain(from, to Slot) Transition {
+	a, b := from.Track, to.Track
+	tr := Transition{
+		From:         from.Position,
+		To:           to.Position,
+		KeyRelation:  keyRelation(a, b),
+		EnergyChange: math.Round((b.Energy-a.Energy)*100) / 100,
+	}
+	var parts []string
+	if a.key != nil && b.key != nil {
+		parts = append(parts, fmt.Sprintf("%s (%s) to %s (%s) %s", a.key.Name(), a.Camelot, b.key.Name(), b.Camelot, describe(tr.KeyRelation)))
+	} else {
+		parts = append(parts, "The key "+describe(tr.KeyRelation))
+	}
+	if a.BPM > 0 && b.BPM > 0 {
+		tr.BPMChange = math.Round((b.BPM-a.BPM)*10) / 10
+		switch {
+		case tr.BPMChange == 0:
+			parts = append(parts, fmt.Sprintf("the tempo holds at %g BPM", b.BPM))
+		default:
+			parts = append(parts, fmt.Sprintf("the tempo moves from %g to %g BPM (%+g)", a.BPM, b.BPM, tr.BPMChange))
+		}
+	}
+	direction := "holds"
+	switch {
+	case tr.EnergyChange > 0.05:
+		direction = "rises"
+	case tr.EnergyChange < -0.05:
+		direction = "drops"
+	}
+	parts = append(parts, fmt.Sprintf("energy %s from %.2f to %.2f against a target of %.2f", direction, a.Energy, b.Energy, to.TargetEnergy))
+	if to.Pinned {
+		parts = append(parts, fmt.Sprintf("%q was pinned", b.Title))
+	}
+	tr.Explanation = strings.Join(parts, "; ") + "."
+	return tr
+}
+
+func keyRelation(a, b *Track) KeyRelation {
+	if a.key == nil || b.key == nil {
+		return KeyUnknown
+	}
+	return relate(*a.key, *b.key)
+}
+
+func minutes(m float64) int64 {
+	return int64(m * 60 * 1000)
+}
@@ -0,0 +1,330 @@
+// Package similarity finds recordings that sound alike. Every analyzed
+// recording of the catalog is described by a feature vector of tempo, key,
+// energy, loudness and spectral features, and recordings are compared by the
+// weighted distance between their standardized vectors.
+//
+// The index is searched exhaustively, which is fast enough for libraries of
+// tens of thousands of recordings and needs no tuning.
+package similarity
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+)
+
+// Dimensions of the feature vector
+const (
+	dimTempoX = iota
+	dimTempoY
+	dimKeyX
+	dimKeyY
+	dimMode
+	dimEnergy
+	dimLoudness
+	dimCentroid
+	dimRolloff
+	dimFlatness
+	dimMFCC // first of maxMFCC coefficients
+
+	maxMFCC = 13
+	dims    = dimMFCC + maxMFCC
+)
+
+const (
+	// minSharedWeight is the total weight of the features two recordings
+	// must both have to be compared, so that a match on key alone does not
+	// count as sounding alike
+	minSharedWeight = 2.5
+	// DefaultLimit is the number of similar recordings returned by default
+	DefaultLimit = 10
+)
+
+// weights is the importance of each dimension. Tempo and key position are
+// spread over their two circle coordinates and MFCC over the coefficients.
+var weights = func() [dims]float64 {
+	var w [dims]float64
+	w[dimTempoX], w[dimTempoY] = 0.75, 0.75
+	w[dimKeyX], w[dimKeyY], w[dimMode] = 0.5, 0.5, 0.5
+	w[dimEnergy] = 1
+	w[dimLoudness] = 0.75
+	w[dimCentroid] = 1
+	w[dimRolloff] = 0.75
+	w[dimFlatness] = 0.5
+	for i := dimMFCC; i < dims; i++ {
+		w[i] = 2.0 / maxMFCC
+	}
+	return w
+}()
+
+var (
+	ErrNotAnalyzed = errors.New("recording has no analyzed audio features")
+	ErrNoKey       = errors.New("recording has no known key to match")
+	ErrNoTempo     = errors.New("recording has no known tempo to match")
+)
+
+// vector holds the raw features of a recording; present marks the dimensions
+// it has
+type vector struct {
+	values  [dims]float64
+	present [dims]bool
+	bpm     float64
+	key     *catalog.Key
+}
+
+// Options constrain a similarity search
+type Options struct {
+	Limit int
+	// CompatibleKeys keeps only recordings whose key mixes harmonically with
+	// the seed's
+	CompatibleKeys bool
+	// BPMWindow keeps only recordings within this many BPM of the seed's
+	// tempo; zero does not constrain the tempo
+	BPMWindow float64
+}
+
+// Match is a recording similar to the seed, with a score from 0 to 1
+type Match struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Index holds the feature vectors of the analyzed recordings
+type Index struct {
+	mu      sync.RWMutex
+	vectors map[string]*vector
+	// std scales each dimension to unit variance; it is recomputed after
+	// the vectors change
+	std   [dims]float64
+	stale bool
+}
+
+// IndexInstance is the index over the shared catalog
+var IndexInstance *Index
+
+// Init builds the index over the catalog's recordings and keeps it in sync
+// with the catalog. It must run after catalog.Init.
+func Init() {
+	IndexInstance = NewIndex()
+	recordings, _ := catalog.StoreInstance.ListEntities(catalog.ListFilter{Type: catalog.TypeRecording})
+	for _, e := range recordings {
+		IndexInstance.Add(e)
+	}
+	catalog.StoreInstance.Subscribe(func(e *catalog.Entity, deleted bool) {
+		if deleted {
+			IndexInstance.Remove(e.ID)
+			return
+		}
+		IndexInstance.Add(e)
+	})
+	log.Printf("Similarity index built with %d analyzed recordings", IndexInstance.Len())
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{vectors: make(map[string]*vector)}
+}
+
+// Add indexes a recording, replacing its previous features. Entities that
+// are not recordings or have no audio features are removed instead.
+func (x *Index) Add(e *catalog.Entity) {
+	v := features(e)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if v == nil {
+		if _, ok := x.vectors[e.ID]; ok {
+			delete(x.vectors, e.ID)
+			x.stale = true
+		}
+		return
+	}
+	x.vectors[e.ID] = v
+	x.stale = true
+}
+
+// Remove drops a recording from the index
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.vectors[id]; ok {
+		delete(x.vectors, id)
+		x.stale = true
+	}
+}
+
+// Len returns the number of indexed recordings
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.vectors)
+}
+
+// Has reports whether a recording is indexed
+func (x *Index) Has(id string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.vectors[id]
+	return ok
+}
+
+// Similar returns the recordings that sound most like the seed recording,
+// most similar first
+func (x *Index) Similar(id string, opts Options) ([]Match, error) {
+	x.refresh()
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	seed, ok := x.vectors[id]
+	if !ok {
+		return nil, ErrNotAnalyzed
+	}
+	if opts.CompatibleKeys && seed.key == nil {
+		return nil, ErrNoKey
+	}
+	if opts.BPMWindow > 0 && seed.bpm == 0 {
+		return nil, ErrNoTempo
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	matches := []Match{}
+	for other, v := range x.vectors {
+		if other == id {
+			continue
+		}
+		if opts.CompatibleKeys && (v.key == nil || !seed.key.Compatible(*v.key)) {
+			continue
+		}
+		if opts.BPMWindow > 0 && (v.bpm == 0 || math.Abs(v.bpm-seed.bpm) > opts.BPMWindow) {
+			continue
+		}
+		if d, ok := x.distance(seed, v); ok {
+			matches = append(matches, Match{ID: other, Score: math.Round(math.Exp(-d)*1000) / 1000})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// distance is the weighted root mean square difference of the standardized
+// features both vectors have. It reports false when they share too few.
+// Callers must hold the read lock.
+func (x *Index) distance(a, b *vector) (float64, bool) {
+	var sum, weight float64
+	for i := range dims {
+		if !a.present[i] || !b.present[i] {
+			continue
+		}
+		diff := (a.values[i] - b.values[i]) / x.std[i]
+		sum += weights[i] * diff * diff
+		weight += weights[i]
+	}
+	if weight < minSharedWeight {
+		return 0, false
+	}
+	return math.Sqrt(sum / weight), true
+}
+
+// refresh recomputes the standard deviation of every dimension if the
+// vectors changed
+func (x *Index) refresh() {
+	x.mu.RLock()
+	stale := x.stale
+	x.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var sum, sumSq, n [dims]float64
+	for _, v := range x.vectors {
+		for i := range dims {
+			if v.present[i] {
+				sum[i] += v.values[i]
+				sumSq[i] += v.values[i] * v.values[i]
+				n[i]++
+			}
+		}
+	}
+	for i := range dims {
+		x.std[i] = 1
+		if n[i] == 0 {
+			continue
+		}
+		// A dimension with no spread, e.g. a single recording, is left
+		// unscaled rather than divided by zero
+		mean := sum[i] / n[i]
+		if variance := sumSq[i]/n[i] - mean*mean; variance > 1e-12 {
+			x.std[i] = math.Sqrt(variance)
+		}
+	}
+	x.stale = false
+}
+
+// features extracts the feature vector of a recording, or nil if it has no
+// analyzed audio features. Key and energy alone are not enough, since they
+// are often entered by hand rather than measured.
+func features(e *catalog.Entity) *vector {
+	r := e.Recording
+	if e.Type != catalog.TypeRecording || r == nil || (r.BPM <= 0 && r.Loudness == 0 && r.Spectral == nil) {
+		return nil
+	}
+	v := &vector{bpm: r.BPM}
+	set := func(i int, value float64) {
+		v.values[i], v.present[i] = value, true
+	}
+	if r.BPM > 0 {
+		// Tempo is compared by feel, so half and double time are the same:
+		// tempo goes round a circle once per doubling, on which e.g. 159 and
+		// 81 BPM are neighbors
+		angle := 2 * math.Pi * math.Log2(r.BPM)
+		set(dimTempoX, math.Cos(angle))
+		set(dimTempoY, math.Sin(angle))
+	}
+	if key, err := catalog.ParseKey(r.Key); err == nil {
+		v.key = &key
+		angle := 2 * math.Pi * float64(key.Number-1) / 12
+		set(dimKeyX, math.Cos(angle))
+		set(dimKeyY, math.Sin(angle))
+		mode := 0.0
+		if key.Minor {
+			mode = 1
+		}
+		set(dimMode, mode)
+	}
+	if r.Energy > 0 {
+		set(dimEnergy, r.Energy)
+	}
+	if r.Loudness != 0 {
+		set(dimLoudness, r.Loudness)
+	}
+	if f := r.Spectral; f != nil {
+		if f.CentroidHz > 0 {
+			set(dimCentroid, math.Log2(f.CentroidHz))
+		}
+		if f.RolloffHz > 0 {
+			set(dimRolloff, math.Log2(f.RolloffHz))
+		}
+		if f.Flatness > 0 {
+			set(dimFlatness, f.Flatness)
+		}
+		for i, c := range f.MFCC[:min(len(f.MFCC), maxMFCC)] {
+			set(dimMFCC+i, c)
+		}
+	}
+	return v
+}
@@ -8,6 +8,19 @@ import (
 	"github.com/One-Frequency/MusicRAG/backend/internal/api"
 	"github.com/One-Frequency/MusicRAG/backend/internal/auth"
 	"github.com/One-Frequency/MusicRAG/backend/internal/azure"
+	"github.com/One-Frequency/MusicRAG/backend/internal/catalog"
+	"github.com/One-Frequency/MusicRAG/backend/internal/conversation"
+	"github.com/One-Frequency/MusicRAG/backend/internal/experiment"
+	"github.com/One-Frequency/MusicRAG/backend/internal/graph"
+	"github.com/One-Frequency/MusicRAG/backend/internal/history"
+	"github.com/One-Frequency/MusicRAG/backend/internal/linking"
+	"github.com/One-Frequency/MusicRAG/backend/internal/playlist"
+	"github.com/One-Frequency/MusicRAG/backend/internal/profile"
+	"github.com/One-Frequency/MusicRAG/backend/internal/prompt"
+	"github.com/One-Frequency/MusicRAG/backend/internal/rag"
+	"github.com/One-Frequency/MusicRAG/backend/internal/retrieval"
+	"github.com/One-Frequency/MusicRAG/backend/internal/setlist"
+	"github.com/One-Frequency/MusicRAG/backend/internal/similarity"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -23,11 +36,24 @@ func main() {
 	}
 
 	azure.Init()
+	catalog.Init()
+	retrieval.Init()
+	linking.Init()
+	graph.Init()
+	setlist.Init()
+	playlist.Init()
+	history.Init()
+	similarity.Init()
+	prompt.Init()
+	profile.Init()
+	experiment.Init()
+	rag.Init()
+	conversation.Init()
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5173", "https://app.onefrequency.ai"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -49,6 +75,108 @@ func main() {
 	protectedAPI.Use(auth.AuthMiddleware())
 	{
 		protectedAPI.POST("/chat", auth.RequirePermission("chat"), api.ChatHandler)
+		protectedAPI.GET("/chat/output-types", api.ListOutputTypesHandler)
+
+		// Music catalog: reads are open to all users, writes require admin
+		protectedAPI.GET("/catalog/entities", api.ListEntitiesHandler)
+		protectedAPI.GET("/catalog/entities/:id", api.GetEntityHandler)
+		protectedAPI.GET("/catalog/entities/:id/relations", api.ListRelationsHandler)
+		protectedAPI.GET("/catalog/entities/:id/documents", api.ListEntityDocumentsHandler)
+		protectedAPI.GET("/catalog/lookup", api.LookupEntityHandler)
+		protectedAPI.GET("/catalog/link", api.LinkQueryHandler)
+		protectedAPI.GET("/catalog/documents/:documentId/entities", api.GetDocumentLinksHandler)
+		protectedAPI.POST("/catalog/entities", auth.RequirePermission("admin"), api.CreateEntityHandler)
+		protectedAPI.PUT("/catalog/entities/:id", auth.RequirePermission("admin"), api.UpdateEntityHandler)
+		protectedAPI.DELETE("/catalog/entities/:id", auth.RequirePermission("admin"), api.DeleteEntityHandler)
+		protectedAPI.POST("/catalog/relations", auth.RequirePermission("admin"), api.CreateRelationHandler)
+		protectedAPI.DELETE("/catalog/relations/:id", auth.RequirePermission("admin"), api.DeleteRelationHandler)
+		protectedAPI.PUT("/catalog/documents/:documentId/entities", auth.RequirePermission("admin"), api.LinkDocumentHandler)
+
+		// Knowledge graph traversal over catalog relations
+		protectedAPI.GET("/graph/entities/:id/neighbors", api.ListNeighborsHandler)
+		protectedAPI.POST("/graph/query", api.GraphQueryHandler)
+
+		// Setlist planning
+		protectedAPI.POST("/setlists/plan", api.PlanSetlistHandler)
+
+		// Recordings that sound alike by their analyzed audio features
+		protectedAPI.GET("/tracks/:id/similar", api.SimilarTracksHandler)
+
+		// The current user's playlists, and chat answers exported as playlists
+		protectedAPI.POST("/playlists/import", api.ImportPlaylistHandler)
+		protectedAPI.POST("/playlists/export", api.ExportAnswerHandler)
+		protectedAPI.GET("/playlists", api.ListPlaylistsHandler)
+		protectedAPI.GET("/playlists/:id", api.GetPlaylistHandler)
+		protectedAPI.GET("/playlists/:id/export", api.ExportPlaylistHandler)
+		protectedAPI.DELETE("/playlists/:id", api.DeletePlaylistHandler)
+
+		// The current user's listening history, imported from streaming service exports
+		protectedAPI.POST("/history/import", api.ImportHistoryHandler)
+		protectedAPI.GET("/history/summary", api.GetHistorySummaryHandler)
+		protectedAPI.GET("/history/top", api.GetHistoryTopHandler)
+		protectedAPI.DELETE("/history", api.DeleteHistoryHandler)
+
+		// The current user's conversations, stored with their sources; chat
+		// requests naming a conversation continue it
+		protectedAPI.POST("/conversations", api.CreateConversationHandler)
+		protectedAPI.GET("/conversations", api.ListConversationsHandler)
+		protectedAPI.GET("/conversations/search", api.SearchConversationsHandler)
+		protectedAPI.GET("/conversations/:id", api.GetConversationHandler)
+		protectedAPI.PATCH("/conversations/:id", api.UpdateConversationHandler)
+		protectedAPI.DELETE("/conversations/:id", api.DeleteConversationHandler)
+		protectedAPI.GET("/conversations/:id/export", api.ExportConversationHandler)
+		// Editing a question or regenerating an answer starts a branch beside
+		// the original; switching branches picks which one continues
+		protectedAPI.PUT("/conversations/:id/branch", api.SwitchBranchHandler)
+		protectedAPI.POST("/conversations/:id/messages/:messageId/edit", api.EditMessageHandler)
+		protectedAPI.POST("/conversations/:id/messages/:messageId/regenerate", api.RegenerateMessageHandler)
+
+		// Ratings of answers in the current user's conversations
+		protectedAPI.POST("/messages/:id/feedback", api.SubmitFeedbackHandler)
+
+		// Assistant profiles the current user may pick for a conversation
+		protectedAPI.GET("/profiles", api.ListProfilesHandler)
+	}
+
+	// Admin API routes
+	adminAPI := protectedAPI.Group("/admin")
+	adminAPI.Use(auth.RequirePermission("admin"))
+	{
+		adminAPI.POST("/imports", api.StartImportHandler)
+		adminAPI.GET("/imports", api.ListImportsHandler)
+		adminAPI.GET("/imports/:id", api.GetImportHandler)
+		adminAPI.DELETE("/imports/:id", api.CancelImportHandler)
+
+		// Versioned prompts of the chat pipeline, rolled out and back live
+		adminAPI.GET("/prompts", api.ListPromptsHandler)
+		adminAPI.GET("/prompts/:name", api.GetPromptHandler)
+		adminAPI.POST("/prompts/:name/versions", api.AddPromptVersionHandler)
+		adminAPI.POST("/prompts/:name/rollout", api.RolloutPromptHandler)
+		adminAPI.POST("/prompts/:name/rollback", api.RollbackPromptHandler)
+		adminAPI.POST("/prompts/:name/preview", api.PreviewPromptHandler)
+
+		// The passages and documents of the worst rated answers
+		adminAPI.GET("/feedback/report", api.FeedbackReportHandler)
+
+		// Assistant profiles and the groups that may use them
+		adminAPI.GET("/profiles", api.ListAllProfilesHandler)
+		adminAPI.GET("/profiles/:id", api.GetProfileHandler)
+		adminAPI.PUT("/profiles/:id", api.PutProfileHandler)
+		adminAPI.PUT("/profiles/:id/groups", api.SetProfileGroupsHandler)
+		adminAPI.DELETE("/profiles/:id", api.DeleteProfileHandler)
+
+		// A/B experiments on retrieval and prompts, and their results
+		adminAPI.GET("/experiments", api.ListExperimentsHandler)
+		adminAPI.GET("/experiments/:id", api.GetExperimentHandler)
+		adminAPI.PUT("/experiments/:id", api.PutExperimentHandler)
+		adminAPI.POST("/experiments/:id/start", api.StartExperimentHandler)
+		adminAPI.POST("/experiments/:id/stop", api.StopExperimentHandler)
+		adminAPI.DELETE("/experiments/:id", api.DeleteExperimentHandler)
+		adminAPI.GET("/experiments/:id/results", api.ExperimentResultsHandler)
+
+		// The semantic answer cache; indexers report re-indexed documents
+		adminAPI.GET("/cache", api.CacheStatsHandler)
+		adminAPI.POST("/cache/invalidate", api.InvalidateCacheHandler)
 	}
 
 	// Development route for testing auth (optional auth)
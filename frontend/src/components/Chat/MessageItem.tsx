@@ -1,11 +1,27 @@
+import { azureRagService } from '@/services/AzureRAGService';
 import { Message } from '@/types';
 import React from 'react';
 
+// Answers with a bulleted or numbered list may list tracks worth exporting
+const LIST_ITEM = /^\s*(?:[-*•]|\d+[.)])\s+\S/m;
+
 interface MessageItemProps {
   message: Message;
 }
 
 export const MessageItem: React.FC<MessageItemProps> = ({ message }) => {
+  // Prefer the sources the answer actually cites
+  const cited = message.sources?.filter((source) => source.cited) ?? [];
+  const sources = cited.length > 0 ? cited : message.sources ?? [];
+  const exportable = message.type === 'assistant' && LIST_ITEM.test(message.content);
+
+  const exportPlaylist = () => {
+    azureRagService.exportPlaylist(message.content).catch((error) => {
+      console.error('Failed to export playlist:', error);
+      alert('No tracks could be exported from this answer.');
+    });
+  };
+
   return (
     <div className={`flex ${message.type === 'user' ? 'justify-end' : 'justify-start'}`}>
       <div className={`max-w-3xl rounded-lg px-4 py-3 ${
@@ -14,13 +30,25 @@ export const MessageItem: React.FC<MessageItemProps> = ({ message }) => {
           : 'bg-white border border-gray-200 text-gray-900'
       }`}>
         <p className="text-sm whitespace-pre-wrap">{message.content}</p>
-        {message.sources && message.sources.length > 0 && (
+        {sources.length > 0 && (
           <div className="mt-2 pt-2 border-t border-gray-100">
             <p className="text-xs text-gray-500">
-              Sources: {message.sources.join(', ')}
+              Sources:{' '}
+              {sources
+                .map((source) => `[${source.number}] ${source.title}`)
+                .join(', ')}
             </p>
           </div>
         )}
+        {exportable && (
+          <button
+            type="button"
+            onClick={exportPlaylist}
+            className="mt-2 text-xs text-purple-600 hover:text-purple-800"
+          >
+            Export as playlist
+          </button>
+        )}
         <p className={`text-xs mt-2 ${
           message.type === 'user' ? 'text-purple-200' : 'text-gray-400'
         }`}>
@@ -1,11 +1,11 @@
 // services/azureRagService.ts
 
-import { Message } from '@/types';
+import { Message, Source } from '@/types';
 import { fetchAuthSession } from 'aws-amplify/auth';
 
 export interface RagResponse {
   content: string;
-  sources: string[];
+  sources: Source[];
 }
 
 class AzureRagService {
@@ -76,6 +76,34 @@ class AzureRagService {
     return res.json();
   }
 
+  /**
+   * Export the tracks listed in an answer as a playlist file and download it
+   */
+  async exportPlaylist(content: string, format = 'm3u8'): Promise<void> {
+    const headers = await this.getAuthHeaders();
+
+    const apiUrl = import.meta.env.VITE_API_URL || 'http://localhost:8080';
+    const res = await fetch(`${apiUrl}/api/playlists/export`, {
+      method: 'POST',
+      headers,
+      body: JSON.stringify({ content, format }),
+    });
+
+    if (!res.ok) {
+      const errorText = await res.text();
+      throw new Error(`Playlist export failed: ${errorText}`);
+    }
+
+    const disposition = res.headers.get('Content-Disposition') ?? '';
+    const filename = /filename="([^"]+)"/.exec(disposition)?.[1] ?? `playlist.${format}`;
+    const url = URL.createObjectURL(await res.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = filename;
+    link.click();
+    URL.revokeObjectURL(url);
+  }
+
   /*
     OPTIONAL: If you need to adapt document upload/chunking functions,
     see notes below. Here, we keep them as stubs until your backend
@@ -1,9 +1,25 @@
+export interface SourceLocator {
+  page?: number;
+  measures?: string;
+  timestamp?: string;
+}
+
+export interface Source {
+  id: string;
+  number: number;
+  documentId?: string;
+  title: string;
+  locator?: SourceLocator;
+  cited: boolean;
+  quotes?: string[];
+}
+
 export interface Message {
   id: string;
   type: 'user' | 'assistant';
   content: string;
   timestamp: Date;
-  sources?: string[];
+  sources?: Source[];
 }
 
 export interface UploadedFile {